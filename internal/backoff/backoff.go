@@ -0,0 +1,14 @@
+// Package backoff computes the delays between the attempts of the retry
+// loops.
+package backoff
+
+import "time"
+
+// Exponential returns the delay after the given failed attempt, starting at
+// delay and doubling with every attempt up to maxDelay
+func Exponential(delay, maxDelay time.Duration, attempt int) time.Duration {
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxDelay)
+}
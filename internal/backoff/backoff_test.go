@@ -0,0 +1,31 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExponential(t *testing.T) {
+	tests := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 500 * time.Millisecond},
+		{100, 500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		if delay := Exponential(100*time.Millisecond, 500*time.Millisecond, tt.attempt); delay != tt.expected {
+			t.Errorf("Attempt %d: expected %v, got %v", tt.attempt, tt.expected, delay)
+		}
+	}
+
+	// The maximum delay caps the first delay too
+	if delay := Exponential(time.Second, 500*time.Millisecond, 1); delay != 500*time.Millisecond {
+		t.Errorf("Expected 500ms, got %v", delay)
+	}
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 
 	"gopkg.in/yaml.v3"
@@ -9,9 +10,11 @@ import (
 
 // Config represents the application configuration
 type Config struct {
-	Server ServerConfig `yaml:"server"`
-	Events EventsConfig `yaml:"events"`
-	Tasks  TasksConfig  `yaml:"tasks"`
+	Server   ServerConfig   `yaml:"server"`
+	Events   EventsConfig   `yaml:"events"`
+	Tasks    TasksConfig    `yaml:"tasks"`
+	Webhooks WebhooksConfig `yaml:"webhooks"`
+	Consumer ConsumerConfig `yaml:"consumer"`
 }
 
 // ServerConfig holds server configuration
@@ -20,22 +23,238 @@ type ServerConfig struct {
 	Port int    `yaml:"port"`
 }
 
-// EventsConfig holds event publisher configuration
+// EventsConfig holds event publisher configuration. When Publishers is set,
+// events fan out to every publisher listed there instead of the single
+// Publisher.
 type EventsConfig struct {
-	Publisher string      `yaml:"publisher"`
-	Kafka     KafkaConfig `yaml:"kafka"`
+	Publisher  string                 `yaml:"publisher"`
+	Kafka      KafkaConfig            `yaml:"kafka"`
+	Webhook    WebhookPublisherConfig `yaml:"webhook"`
+	NATS       NATSConfig             `yaml:"nats"`
+	Redis      RedisConfig            `yaml:"redis"`
+	Publishers []PublisherConfig      `yaml:"publishers"`
+	Stream     StreamConfig           `yaml:"stream"`
+	Outbox     OutboxConfig           `yaml:"outbox"`
 }
 
-// KafkaConfig holds Kafka-specific configuration
+// PublisherConfig holds the configuration of one of several publishers the
+// events fan out to. An event goes to the publisher if it matches one of the
+// Include rules, or there are none, and none of the Exclude rules. Every
+// publisher has its own queue and retries failed events on its own.
+type PublisherConfig struct {
+	Name        string                 `yaml:"name"`
+	Type        string                 `yaml:"type"`
+	Kafka       KafkaConfig            `yaml:"kafka"`
+	Webhook     WebhookPublisherConfig `yaml:"webhook"`
+	NATS        NATSConfig             `yaml:"nats"`
+	Redis       RedisConfig            `yaml:"redis"`
+	Include     []EventRule            `yaml:"include"`
+	Exclude     []EventRule            `yaml:"exclude"`
+	QueueSize   int                    `yaml:"queue_size"`
+	MaxAttempts int                    `yaml:"max_attempts"`
+	DelayMs     int                    `yaml:"delay_ms"`
+	MaxDelayMs  int                    `yaml:"max_delay_ms"`
+}
+
+// EventRule matches the events whose type matches one of Types, where
+// types may end with "*" to match a prefix, and whose data fields have the
+// values in Data. Empty conditions match all events.
+type EventRule struct {
+	Types []string          `yaml:"types"`
+	Data  map[string]string `yaml:"data"`
+}
+
+// OutboxConfig holds the configuration of the event outbox, which stores
+// events next to the tasks until the publisher accepted them. Unless set,
+// Enabled defaults to whether the task store is durable.
+type OutboxConfig struct {
+	Enabled               *bool `yaml:"enabled"`
+	BatchSize             int   `yaml:"batch_size"`
+	PollIntervalMs        int   `yaml:"poll_interval_ms"`
+	PublishTimeoutSeconds int   `yaml:"publish_timeout_seconds"`
+	MaxAttempts           int   `yaml:"max_attempts"`
+	DelayMs               int   `yaml:"delay_ms"`
+	MaxDelayMs            int   `yaml:"max_delay_ms"`
+}
+
+// IsEnabled reports whether the outbox is turned on
+func (c OutboxConfig) IsEnabled() bool {
+	return c.Enabled != nil && *c.Enabled
+}
+
+// StreamConfig holds the configuration of the server-sent events streams
+type StreamConfig struct {
+	BufferSize       int `yaml:"buffer_size"`
+	HeartbeatSeconds int `yaml:"heartbeat_seconds"`
+}
+
+// KafkaConfig holds Kafka-specific configuration. Format is "native",
+// "cloudevents" or "cloudevents-binary". RequiredAcks is "none", "leader" or
+// "all", and Compression "none", "gzip", "snappy", "lz4" or "zstd".
 type KafkaConfig struct {
-	Brokers []string `yaml:"brokers"`
-	Topic   string   `yaml:"topic"`
+	Brokers        []string        `yaml:"brokers"`
+	Topic          string          `yaml:"topic"`
+	Format         string          `yaml:"format"`
+	ClientID       string          `yaml:"client_id"`
+	RequiredAcks   string          `yaml:"required_acks"`
+	BatchSize      int             `yaml:"batch_size"`
+	BatchTimeoutMs int             `yaml:"batch_timeout_ms"`
+	Async          bool            `yaml:"async"`
+	Compression    string          `yaml:"compression"`
+	TLS            KafkaTLSConfig  `yaml:"tls"`
+	SASL           KafkaSASLConfig `yaml:"sasl"`
+}
+
+// KafkaTLSConfig holds the TLS configuration of the Kafka connections. The
+// CA file replaces the system roots, and the certificate and key files
+// authenticate the client.
+type KafkaTLSConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	CAFile   string `yaml:"ca_file"`
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// KafkaSASLConfig holds the SASL authentication of the Kafka connections.
+// Mechanism is "plain", "scram-sha-256" or "scram-sha-512".
+type KafkaSASLConfig struct {
+	Mechanism string `yaml:"mechanism"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+}
+
+// NATSConfig holds the configuration of the NATS event publisher. Subject is
+// a template where {category} is replaced by the first part of the event
+// type, {event} by the rest and {type} by the task type of the event. With
+// JetStream, every publish waits for the acknowledgement of the stream,
+// which must be Stream when set. Format is "native" or "cloudevents".
+type NATSConfig struct {
+	URLs            []string `yaml:"urls"`
+	Subject         string   `yaml:"subject"`
+	Format          string   `yaml:"format"`
+	JetStream       bool     `yaml:"jetstream"`
+	Stream          string   `yaml:"stream"`
+	AckTimeoutMs    int      `yaml:"ack_timeout_ms"`
+	ClientName      string   `yaml:"client_name"`
+	Username        string   `yaml:"username"`
+	Password        string   `yaml:"password"`
+	Token           string   `yaml:"token"`
+	CredentialsFile string   `yaml:"credentials_file"`
+	ReconnectWaitMs int      `yaml:"reconnect_wait_ms"`
+	MaxReconnects   int      `yaml:"max_reconnects"`
+}
+
+// RedisConfig holds the configuration of the Redis Streams event publisher.
+// URL is a redis:// or rediss:// URL, whose password is replaced by Password
+// when set. The stream is trimmed to about MaxLen entries (100000 when not
+// set), or exactly when Trim is "exact". Trim "none" never trims the stream,
+// leaving that to the consumers, for example with XTRIM or XDEL. Format is
+// "native" or "cloudevents".
+type RedisConfig struct {
+	URL      string `yaml:"url"`
+	Password string `yaml:"password"`
+	Stream   string `yaml:"stream"`
+	MaxLen   int    `yaml:"max_len"`
+	Trim     string `yaml:"trim"`
+	Format   string `yaml:"format"`
+}
+
+// WebhookPublisherConfig holds the configuration of the webhook event
+// publisher. Secret signs the requests to endpoints without a secret of
+// their own. Format is "native" or "cloudevents".
+type WebhookPublisherConfig struct {
+	Endpoints      []WebhookEndpoint `yaml:"endpoints"`
+	Format         string            `yaml:"format"`
+	Secret         string            `yaml:"secret"`
+	TimeoutSeconds int               `yaml:"timeout_seconds"`
+	BatchSize      int               `yaml:"batch_size"`
+	BatchTimeoutMs int               `yaml:"batch_timeout_ms"`
+	QueueSize      int               `yaml:"queue_size"`
+	MaxAttempts    int               `yaml:"max_attempts"`
+	DelayMs        int               `yaml:"delay_ms"`
+	MaxDelayMs     int               `yaml:"max_delay_ms"`
+}
+
+// WebhookEndpoint receives the events whose type matches one of its event
+// types; an empty list matches all
+type WebhookEndpoint struct {
+	URL            string   `yaml:"url"`
+	Secret         string   `yaml:"secret"`
+	EventTypes     []string `yaml:"event_types"`
+	TimeoutSeconds int      `yaml:"timeout_seconds"`
 }
 
 // TasksConfig holds task execution configuration
 type TasksConfig struct {
-	MaxConcurrent  int `yaml:"max_concurrent"`
-	TimeoutSeconds int `yaml:"timeout_seconds"`
+	MaxConcurrent               int                    `yaml:"max_concurrent"`
+	MaxQueueDepth               int                    `yaml:"max_queue_depth"`
+	PriorityAgingSeconds        int                    `yaml:"priority_aging_seconds"`
+	TimeoutSeconds              int                    `yaml:"timeout_seconds"`
+	MaxTimeoutSeconds           int                    `yaml:"max_timeout_seconds"`
+	IdempotencyRetentionSeconds int                    `yaml:"idempotency_retention_seconds"`
+	Store                       StoreConfig            `yaml:"store"`
+	Retry                       map[string]RetryConfig `yaml:"retry"`
+	Queues                      map[string]QueueConfig `yaml:"queues"`
+}
+
+// QueueConfig holds the configuration of a named task queue
+type QueueConfig struct {
+	Concurrency int      `yaml:"concurrency"`
+	MaxDepth    int      `yaml:"max_depth"`
+	TaskTypes   []string `yaml:"task_types"`
+}
+
+// StoreConfig holds task store configuration
+type StoreConfig struct {
+	Driver         string `yaml:"driver"`
+	Path           string `yaml:"path"`
+	RecoveryPolicy string `yaml:"recovery_policy"`
+}
+
+// RetryConfig holds the retry policy of a task type
+type RetryConfig struct {
+	MaxAttempts int      `yaml:"max_attempts"`
+	Backoff     string   `yaml:"backoff"`
+	DelayMs     int      `yaml:"delay_ms"`
+	MaxDelayMs  int      `yaml:"max_delay_ms"`
+	RetryOn     []string `yaml:"retry_on"`
+}
+
+// ConsumerConfig holds the configuration of the Kafka consumer creating
+// tasks from the task requests produced to Topic. Messages that cannot be
+// turned into a task are produced to DeadLetterTopic.
+type ConsumerConfig struct {
+	Enabled         bool            `yaml:"enabled"`
+	Brokers         []string        `yaml:"brokers"`
+	Topic           string          `yaml:"topic"`
+	GroupID         string          `yaml:"group_id"`
+	DeadLetterTopic string          `yaml:"dead_letter_topic"`
+	ClientID        string          `yaml:"client_id"`
+	DelayMs         int             `yaml:"delay_ms"`
+	MaxDelayMs      int             `yaml:"max_delay_ms"`
+	TLS             KafkaTLSConfig  `yaml:"tls"`
+	SASL            KafkaSASLConfig `yaml:"sasl"`
+}
+
+// WebhooksConfig holds the configuration of the webhooks posted when tasks
+// finish. Secret signs the deliveries to task callback URLs and to the
+// subscriptions without a secret of their own.
+type WebhooksConfig struct {
+	Secret         string                `yaml:"secret"`
+	TimeoutSeconds int                   `yaml:"timeout_seconds"`
+	MaxAttempts    int                   `yaml:"max_attempts"`
+	DelayMs        int                   `yaml:"delay_ms"`
+	MaxDelayMs     int                   `yaml:"max_delay_ms"`
+	Subscriptions  []WebhookSubscription `yaml:"subscriptions"`
+}
+
+// WebhookSubscription receives every finished task matching its task types
+// and statuses; empty lists match all
+type WebhookSubscription struct {
+	URL       string   `yaml:"url"`
+	Secret    string   `yaml:"secret"`
+	TaskTypes []string `yaml:"task_types"`
+	Statuses  []string `yaml:"statuses"`
 }
 
 // Load reads and parses the configuration file
@@ -60,12 +279,122 @@ func Load(filename string) (*Config, error) {
 	if config.Events.Publisher == "" {
 		config.Events.Publisher = "noop"
 	}
+	if config.Events.Stream.BufferSize == 0 {
+		config.Events.Stream.BufferSize = 1000
+	}
+	if config.Events.Stream.HeartbeatSeconds == 0 {
+		config.Events.Stream.HeartbeatSeconds = 15
+	}
 	if config.Tasks.MaxConcurrent == 0 {
 		config.Tasks.MaxConcurrent = 10
 	}
+	if config.Tasks.MaxQueueDepth == 0 {
+		config.Tasks.MaxQueueDepth = 1000
+	}
+	if config.Tasks.PriorityAgingSeconds == 0 {
+		config.Tasks.PriorityAgingSeconds = 30
+	}
 	if config.Tasks.TimeoutSeconds == 0 {
 		config.Tasks.TimeoutSeconds = 300
 	}
+	if config.Tasks.MaxTimeoutSeconds == 0 {
+		config.Tasks.MaxTimeoutSeconds = 3600
+	}
+	if config.Tasks.IdempotencyRetentionSeconds == 0 {
+		config.Tasks.IdempotencyRetentionSeconds = 86400
+	}
+	if config.Tasks.Store.Driver == "" {
+		config.Tasks.Store.Driver = "memory"
+	}
+	if config.Tasks.Store.Driver == "bolt" && config.Tasks.Store.Path == "" {
+		config.Tasks.Store.Path = "go-fred.db"
+	}
+	if config.Tasks.Store.RecoveryPolicy == "" {
+		config.Tasks.Store.RecoveryPolicy = "fail"
+	}
+	if config.Events.Outbox.Enabled == nil {
+		enabled := config.Tasks.Store.Driver == "bolt"
+		config.Events.Outbox.Enabled = &enabled
+	}
+
+	if config.Webhooks.TimeoutSeconds == 0 {
+		config.Webhooks.TimeoutSeconds = 10
+	}
+	if config.Webhooks.MaxAttempts == 0 {
+		config.Webhooks.MaxAttempts = 5
+	}
+	if config.Webhooks.DelayMs == 0 {
+		config.Webhooks.DelayMs = 1000
+	}
+	if config.Webhooks.MaxDelayMs == 0 {
+		config.Webhooks.MaxDelayMs = 60000
+	}
+
+	names := make(map[string]bool)
+	for i, publisher := range config.Events.Publishers {
+		if publisher.Name == "" {
+			publisher.Name = fmt.Sprintf("%s-%d", publisher.Type, i+1)
+			config.Events.Publishers[i] = publisher
+		}
+		if names[publisher.Name] {
+			return nil, fmt.Errorf("duplicate event publisher name: %s", publisher.Name)
+		}
+		names[publisher.Name] = true
+	}
+
+	// The outbox only removes an event once the publisher accepted it, which
+	// asynchronous Kafka writes do not wait for
+	if config.Events.Outbox.IsEnabled() {
+		if len(config.Events.Publishers) == 0 && config.Events.Publisher == "kafka" && config.Events.Kafka.Async {
+			return nil, fmt.Errorf("kafka async cannot be used with the event outbox")
+		}
+		for _, publisher := range config.Events.Publishers {
+			if publisher.Type == "kafka" && publisher.Kafka.Async {
+				return nil, fmt.Errorf("kafka async of event publisher %s cannot be used with the event outbox", publisher.Name)
+			}
+		}
+	}
+
+	for taskType, retry := range config.Tasks.Retry {
+		switch retry.Backoff {
+		case "fixed", "exponential", "jittered", "":
+		default:
+			return nil, fmt.Errorf("unsupported retry backoff for task type %s: %s", taskType, retry.Backoff)
+		}
+	}
+
+	for name, queue := range config.Tasks.Queues {
+		if queue.Concurrency < 0 {
+			return nil, fmt.Errorf("concurrency of queue %s must not be negative", name)
+		}
+		if queue.Concurrency == 0 {
+			queue.Concurrency = 1
+		}
+		if queue.MaxDepth == 0 {
+			queue.MaxDepth = config.Tasks.MaxQueueDepth
+		}
+		config.Tasks.Queues[name] = queue
+	}
+
+	for _, subscription := range config.Webhooks.Subscriptions {
+		parsed, err := url.Parse(subscription.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid webhook subscription URL: %q", subscription.URL)
+		}
+	}
+
+	if config.Consumer.Enabled {
+		switch {
+		case len(config.Consumer.Brokers) == 0:
+			return nil, fmt.Errorf("consumer brokers not configured")
+		case config.Consumer.Topic == "":
+			return nil, fmt.Errorf("consumer topic not configured")
+		case config.Consumer.GroupID == "":
+			return nil, fmt.Errorf("consumer group_id not configured")
+		case config.Consumer.DeadLetterTopic == config.Consumer.Topic:
+			return nil, fmt.Errorf("consumer dead_letter_topic must differ from its topic")
+		}
+	}
 
 	return &config, nil
 }
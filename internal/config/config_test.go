@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -17,6 +18,10 @@ events:
   kafka:
     brokers: ["localhost:9092"]
     topic: "test-topic"
+    format: "cloudevents-binary"
+  outbox:
+    enabled: true
+    batch_size: 50
 
 tasks:
   max_concurrent: 5
@@ -58,6 +63,12 @@ tasks:
 	if config.Events.Kafka.Topic != "test-topic" {
 		t.Errorf("Expected topic 'test-topic', got '%s'", config.Events.Kafka.Topic)
 	}
+	if config.Events.Kafka.Format != "cloudevents-binary" {
+		t.Errorf("Expected format 'cloudevents-binary', got '%s'", config.Events.Kafka.Format)
+	}
+	if config.Events.Outbox.Enabled == nil || !*config.Events.Outbox.Enabled || config.Events.Outbox.BatchSize != 50 {
+		t.Errorf("Expected an enabled outbox with batch_size 50, got %+v", config.Events.Outbox)
+	}
 
 	// Test tasks config
 	if config.Tasks.MaxConcurrent != 5 {
@@ -105,9 +116,65 @@ server:
 	if config.Tasks.MaxConcurrent != 10 {
 		t.Errorf("Expected default max_concurrent 10, got %d", config.Tasks.MaxConcurrent)
 	}
+	if config.Tasks.MaxQueueDepth != 1000 {
+		t.Errorf("Expected default max_queue_depth 1000, got %d", config.Tasks.MaxQueueDepth)
+	}
+	if config.Tasks.PriorityAgingSeconds != 30 {
+		t.Errorf("Expected default priority_aging_seconds 30, got %d", config.Tasks.PriorityAgingSeconds)
+	}
 	if config.Tasks.TimeoutSeconds != 300 {
 		t.Errorf("Expected default timeout_seconds 300, got %d", config.Tasks.TimeoutSeconds)
 	}
+	if config.Tasks.MaxTimeoutSeconds != 3600 {
+		t.Errorf("Expected default max_timeout_seconds 3600, got %d", config.Tasks.MaxTimeoutSeconds)
+	}
+	if config.Events.Stream.BufferSize != 1000 {
+		t.Errorf("Expected default stream buffer_size 1000, got %d", config.Events.Stream.BufferSize)
+	}
+	if config.Events.Stream.HeartbeatSeconds != 15 {
+		t.Errorf("Expected default stream heartbeat_seconds 15, got %d", config.Events.Stream.HeartbeatSeconds)
+	}
+	if config.Tasks.IdempotencyRetentionSeconds != 86400 {
+		t.Errorf("Expected default idempotency_retention_seconds 86400, got %d", config.Tasks.IdempotencyRetentionSeconds)
+	}
+	if config.Tasks.Store.Driver != "memory" {
+		t.Errorf("Expected default store driver 'memory', got '%s'", config.Tasks.Store.Driver)
+	}
+	if config.Tasks.Store.RecoveryPolicy != "fail" {
+		t.Errorf("Expected default recovery policy 'fail', got '%s'", config.Tasks.Store.RecoveryPolicy)
+	}
+	if config.Events.Outbox.Enabled == nil || *config.Events.Outbox.Enabled {
+		t.Errorf("Expected the outbox to be disabled with the memory store, got %v", config.Events.Outbox.Enabled)
+	}
+}
+
+func TestLoadOutboxDefault(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected bool
+	}{
+		{"bolt", "tasks:\n  store:\n    driver: \"bolt\"\n", true},
+		{"bolt disabled", "events:\n  outbox:\n    enabled: false\ntasks:\n  store:\n    driver: \"bolt\"\n", false},
+		{"memory enabled", "events:\n  outbox:\n    enabled: true\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+
+			config, err := Load(path)
+			if err != nil {
+				t.Fatalf("Failed to load config: %v", err)
+			}
+			if enabled := config.Events.Outbox.Enabled; enabled == nil || *enabled != tt.expected {
+				t.Errorf("Expected outbox enabled %v, got %v", tt.expected, enabled)
+			}
+		})
+	}
 }
 
 func TestLoadEmptyFile(t *testing.T) {
@@ -171,6 +238,442 @@ server:
 	}
 }
 
+func TestLoadRetryConfig(t *testing.T) {
+	configContent := `
+tasks:
+  retry:
+    sleep:
+      max_attempts: 3
+      backoff: "exponential"
+      delay_ms: 500
+      retry_on: ["connection refused"]
+`
+
+	tmpFile, err := os.CreateTemp("", "test-config-retry-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config content: %v", err)
+	}
+	tmpFile.Close()
+
+	config, err := Load(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	retry, ok := config.Tasks.Retry["sleep"]
+	if !ok {
+		t.Fatal("Expected retry policy for task type 'sleep'")
+	}
+	if retry.MaxAttempts != 3 {
+		t.Errorf("Expected max_attempts 3, got %d", retry.MaxAttempts)
+	}
+	if retry.Backoff != "exponential" {
+		t.Errorf("Expected backoff 'exponential', got '%s'", retry.Backoff)
+	}
+	if retry.DelayMs != 500 {
+		t.Errorf("Expected delay_ms 500, got %d", retry.DelayMs)
+	}
+	if len(retry.RetryOn) != 1 || retry.RetryOn[0] != "connection refused" {
+		t.Errorf("Expected retry_on ['connection refused'], got %v", retry.RetryOn)
+	}
+}
+
+func TestLoadQueueConfig(t *testing.T) {
+	configContent := `
+tasks:
+  max_queue_depth: 50
+  queues:
+    slow:
+      concurrency: 2
+      max_depth: 10
+      task_types: ["sleep"]
+    bulk: {}
+`
+
+	tmpFile, err := os.CreateTemp("", "test-config-queues-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config content: %v", err)
+	}
+	tmpFile.Close()
+
+	config, err := Load(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	slow, ok := config.Tasks.Queues["slow"]
+	if !ok {
+		t.Fatal("Expected queue 'slow'")
+	}
+	if slow.Concurrency != 2 || slow.MaxDepth != 10 {
+		t.Errorf("Expected concurrency 2 and max_depth 10, got %d and %d", slow.Concurrency, slow.MaxDepth)
+	}
+	if len(slow.TaskTypes) != 1 || slow.TaskTypes[0] != "sleep" {
+		t.Errorf("Expected task_types ['sleep'], got %v", slow.TaskTypes)
+	}
+
+	// Unset limits fall back to defaults
+	bulk := config.Tasks.Queues["bulk"]
+	if bulk.Concurrency != 1 {
+		t.Errorf("Expected default concurrency 1, got %d", bulk.Concurrency)
+	}
+	if bulk.MaxDepth != 50 {
+		t.Errorf("Expected max_depth to default to max_queue_depth 50, got %d", bulk.MaxDepth)
+	}
+}
+
+func TestLoadInvalidRetryBackoff(t *testing.T) {
+	configContent := `
+tasks:
+  retry:
+    sleep:
+      max_attempts: 3
+      backoff: "linear"
+`
+
+	tmpFile, err := os.CreateTemp("", "test-config-retry-invalid-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config content: %v", err)
+	}
+	tmpFile.Close()
+
+	_, err = Load(tmpFile.Name())
+	if err == nil {
+		t.Error("Expected error for unsupported retry backoff")
+	}
+}
+
+func TestLoadWebhooksConfig(t *testing.T) {
+	configContent := `
+webhooks:
+  secret: "global-secret"
+  max_attempts: 3
+  subscriptions:
+    - url: "https://hooks.example.com/tasks"
+      secret: "subscription-secret"
+      task_types: ["sleep"]
+      statuses: ["failed", "timed_out"]
+`
+
+	tmpFile, err := os.CreateTemp("", "test-config-webhooks-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config content: %v", err)
+	}
+	tmpFile.Close()
+
+	config, err := Load(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	webhooks := config.Webhooks
+	if webhooks.Secret != "global-secret" || webhooks.MaxAttempts != 3 {
+		t.Errorf("Expected secret 'global-secret' and max_attempts 3, got '%s' and %d", webhooks.Secret, webhooks.MaxAttempts)
+	}
+	if webhooks.TimeoutSeconds != 10 || webhooks.DelayMs != 1000 || webhooks.MaxDelayMs != 60000 {
+		t.Errorf("Expected default timeout and delays, got %+v", webhooks)
+	}
+	if len(webhooks.Subscriptions) != 1 {
+		t.Fatalf("Expected 1 subscription, got %d", len(webhooks.Subscriptions))
+	}
+	subscription := webhooks.Subscriptions[0]
+	if subscription.URL != "https://hooks.example.com/tasks" || subscription.Secret != "subscription-secret" {
+		t.Errorf("Unexpected subscription %+v", subscription)
+	}
+	if len(subscription.Statuses) != 2 || subscription.Statuses[1] != "timed_out" {
+		t.Errorf("Expected statuses ['failed', 'timed_out'], got %v", subscription.Statuses)
+	}
+}
+
+func TestLoadWebhookPublisherConfig(t *testing.T) {
+	configContent := `
+events:
+  publisher: "webhook"
+  webhook:
+    secret: "signing-secret"
+    batch_size: 50
+    endpoints:
+      - url: "https://alerts.example.com/events"
+        event_types: ["task.failed", "task.timed_out"]
+        timeout_seconds: 2
+`
+
+	tmpFile, err := os.CreateTemp("", "test-config-webhook-publisher-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config content: %v", err)
+	}
+	tmpFile.Close()
+
+	config, err := Load(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	webhook := config.Events.Webhook
+	if webhook.Secret != "signing-secret" || webhook.BatchSize != 50 {
+		t.Errorf("Expected secret 'signing-secret' and batch_size 50, got '%s' and %d", webhook.Secret, webhook.BatchSize)
+	}
+	if len(webhook.Endpoints) != 1 {
+		t.Fatalf("Expected 1 endpoint, got %d", len(webhook.Endpoints))
+	}
+	endpoint := webhook.Endpoints[0]
+	if endpoint.URL != "https://alerts.example.com/events" || endpoint.TimeoutSeconds != 2 {
+		t.Errorf("Unexpected endpoint %+v", endpoint)
+	}
+	if len(endpoint.EventTypes) != 2 || endpoint.EventTypes[0] != "task.failed" {
+		t.Errorf("Expected event_types ['task.failed', 'task.timed_out'], got %v", endpoint.EventTypes)
+	}
+}
+
+func TestLoadKafkaConfig(t *testing.T) {
+	configContent := `
+events:
+  publisher: "kafka"
+  kafka:
+    brokers: ["kafka-1:9093", "kafka-2:9093"]
+    topic: "events"
+    client_id: "go-fred"
+    required_acks: "leader"
+    batch_size: 500
+    batch_timeout_ms: 50
+    async: true
+    compression: "lz4"
+    tls:
+      enabled: true
+      ca_file: "/etc/kafka/ca.pem"
+    sasl:
+      mechanism: "scram-sha-256"
+      username: "fred"
+      password: "secret"
+`
+
+	tmpFile, err := os.CreateTemp("", "test-config-kafka-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config content: %v", err)
+	}
+	tmpFile.Close()
+
+	config, err := Load(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	kafka := config.Events.Kafka
+	if kafka.ClientID != "go-fred" || kafka.RequiredAcks != "leader" || kafka.Compression != "lz4" {
+		t.Errorf("Unexpected client ID, acks or compression: %+v", kafka)
+	}
+	if kafka.BatchSize != 500 || kafka.BatchTimeoutMs != 50 || !kafka.Async {
+		t.Errorf("Expected async batches of 500 within 50ms, got %+v", kafka)
+	}
+	if !kafka.TLS.Enabled || kafka.TLS.CAFile != "/etc/kafka/ca.pem" {
+		t.Errorf("Unexpected TLS config %+v", kafka.TLS)
+	}
+	if kafka.SASL.Mechanism != "scram-sha-256" || kafka.SASL.Username != "fred" || kafka.SASL.Password != "secret" {
+		t.Errorf("Unexpected SASL config %+v", kafka.SASL)
+	}
+}
+
+func TestLoadConsumerConfig(t *testing.T) {
+	configContent := `
+consumer:
+  enabled: true
+  brokers: ["localhost:9092"]
+  topic: "task-requests"
+  group_id: "go-fred"
+  dead_letter_topic: "task-requests-dlq"
+  delay_ms: 500
+`
+
+	tmpFile, err := os.CreateTemp("", "test-config-consumer-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config content: %v", err)
+	}
+	tmpFile.Close()
+
+	config, err := Load(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	consumer := config.Consumer
+	if !consumer.Enabled || consumer.Topic != "task-requests" || consumer.GroupID != "go-fred" {
+		t.Errorf("Unexpected consumer config %+v", consumer)
+	}
+	if consumer.DeadLetterTopic != "task-requests-dlq" || consumer.DelayMs != 500 {
+		t.Errorf("Expected dead-letter topic 'task-requests-dlq' and delay_ms 500, got %+v", consumer)
+	}
+}
+
+func TestLoadInvalidConsumerConfig(t *testing.T) {
+	invalid := []string{
+		"consumer:\n  enabled: true\n  topic: \"tasks\"\n  group_id: \"go-fred\"\n",
+		"consumer:\n  enabled: true\n  brokers: [\"localhost:9092\"]\n  group_id: \"go-fred\"\n",
+		"consumer:\n  enabled: true\n  brokers: [\"localhost:9092\"]\n  topic: \"tasks\"\n",
+		"consumer:\n  enabled: true\n  brokers: [\"localhost:9092\"]\n  topic: \"tasks\"\n  group_id: \"go-fred\"\n  dead_letter_topic: \"tasks\"\n",
+	}
+
+	for _, configContent := range invalid {
+		tmpFile, err := os.CreateTemp("", "test-config-consumer-invalid-*.yaml")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %v", err)
+		}
+		defer os.Remove(tmpFile.Name())
+
+		if _, err := tmpFile.WriteString(configContent); err != nil {
+			t.Fatalf("Failed to write config content: %v", err)
+		}
+		tmpFile.Close()
+
+		if _, err := Load(tmpFile.Name()); err == nil {
+			t.Errorf("Expected error for %q", configContent)
+		}
+	}
+}
+
+func TestLoadPublishersConfig(t *testing.T) {
+	configContent := `
+events:
+  publishers:
+    - name: "analytics"
+      type: "kafka"
+      kafka:
+        brokers: ["localhost:9092"]
+        topic: "analytics"
+      exclude:
+        - types: ["schedule.*"]
+    - type: "webhook"
+      webhook:
+        endpoints:
+          - url: "https://alerts.example.com/events"
+      include:
+        - types: ["task.failed"]
+        - types: ["workflow.*"]
+          data:
+            status: "failed"
+      max_attempts: 10
+`
+
+	tmpFile, err := os.CreateTemp("", "test-config-publishers-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config content: %v", err)
+	}
+	tmpFile.Close()
+
+	config, err := Load(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	publishers := config.Events.Publishers
+	if len(publishers) != 2 {
+		t.Fatalf("Expected 2 publishers, got %d", len(publishers))
+	}
+	if publishers[0].Name != "analytics" || publishers[0].Kafka.Topic != "analytics" {
+		t.Errorf("Unexpected first publisher %+v", publishers[0])
+	}
+	if len(publishers[0].Exclude) != 1 || publishers[0].Exclude[0].Types[0] != "schedule.*" {
+		t.Errorf("Expected to exclude schedule events, got %+v", publishers[0].Exclude)
+	}
+	// Unnamed publishers are named after their type and position
+	if publishers[1].Name != "webhook-2" || publishers[1].MaxAttempts != 10 {
+		t.Errorf("Expected publisher 'webhook-2' with max_attempts 10, got '%s' and %d", publishers[1].Name, publishers[1].MaxAttempts)
+	}
+	if len(publishers[1].Include) != 2 || publishers[1].Include[1].Data["status"] != "failed" {
+		t.Errorf("Expected to include failed workflow events, got %+v", publishers[1].Include)
+	}
+}
+
+func TestLoadDuplicatePublisherName(t *testing.T) {
+	configContent := `
+events:
+  publishers:
+    - name: "alerts"
+      type: "noop"
+    - name: "alerts"
+      type: "noop"
+`
+
+	tmpFile, err := os.CreateTemp("", "test-config-publishers-invalid-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config content: %v", err)
+	}
+	tmpFile.Close()
+
+	_, err = Load(tmpFile.Name())
+	if err == nil {
+		t.Error("Expected error for duplicate publisher names")
+	}
+}
+
+func TestLoadInvalidWebhookSubscription(t *testing.T) {
+	configContent := `
+webhooks:
+  subscriptions:
+    - url: "hooks.example.com/tasks"
+`
+
+	tmpFile, err := os.CreateTemp("", "test-config-webhooks-invalid-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config content: %v", err)
+	}
+	tmpFile.Close()
+
+	_, err = Load(tmpFile.Name())
+	if err == nil {
+		t.Error("Expected error for a relative webhook subscription URL")
+	}
+}
+
 func TestGetAddress(t *testing.T) {
 	config := &Config{
 		Server: ServerConfig{
@@ -185,3 +688,121 @@ func TestGetAddress(t *testing.T) {
 		t.Errorf("Expected address '%s', got '%s'", expected, actual)
 	}
 }
+
+func TestLoadNATSConfig(t *testing.T) {
+	configContent := `
+events:
+  publishers:
+    - type: "nats"
+      nats:
+        urls: ["nats://nats-1:4222", "nats://nats-2:4222"]
+        subject: "fred.tasks.{type}.{event}"
+        format: "cloudevents"
+        jetstream: true
+        stream: "EVENTS"
+        ack_timeout_ms: 2000
+        credentials_file: "/etc/nats/fred.creds"
+        reconnect_wait_ms: 500
+        max_reconnects: 10
+`
+
+	tmpFile, err := os.CreateTemp("", "test-config-nats-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config content: %v", err)
+	}
+	tmpFile.Close()
+
+	config, err := Load(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	publisher := config.Events.Publishers[0]
+	if publisher.Name != "nats-1" {
+		t.Errorf("Expected name 'nats-1', got '%s'", publisher.Name)
+	}
+	nats := publisher.NATS
+	if len(nats.URLs) != 2 || nats.Subject != "fred.tasks.{type}.{event}" || nats.Format != "cloudevents" {
+		t.Errorf("Unexpected URLs, subject or format: %+v", nats)
+	}
+	if !nats.JetStream || nats.Stream != "EVENTS" || nats.AckTimeoutMs != 2000 {
+		t.Errorf("Expected JetStream acknowledgements from EVENTS within 2s, got %+v", nats)
+	}
+	if nats.CredentialsFile != "/etc/nats/fred.creds" || nats.ReconnectWaitMs != 500 || nats.MaxReconnects != 10 {
+		t.Errorf("Unexpected credentials or reconnect settings: %+v", nats)
+	}
+}
+
+func TestLoadRedisConfig(t *testing.T) {
+	configContent := `
+events:
+  publisher: "redis"
+  redis:
+    url: "rediss://redis.internal:6380/2"
+    password: "secret"
+    stream: "go-fred:events"
+    max_len: 5000
+    trim: "exact"
+    format: "cloudevents"
+`
+
+	tmpFile, err := os.CreateTemp("", "test-config-redis-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config content: %v", err)
+	}
+	tmpFile.Close()
+
+	config, err := Load(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	redis := config.Events.Redis
+	if config.Events.Publisher != "redis" || redis.URL != "rediss://redis.internal:6380/2" || redis.Password != "secret" {
+		t.Errorf("Unexpected publisher, URL or password: %s, %+v", config.Events.Publisher, redis)
+	}
+	if redis.Stream != "go-fred:events" || redis.MaxLen != 5000 || redis.Trim != "exact" || redis.Format != "cloudevents" {
+		t.Errorf("Unexpected stream, trimming or format: %+v", redis)
+	}
+}
+
+func TestLoadKafkaAsyncWithOutbox(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		valid   bool
+	}{
+		{"async", "events:\n  publisher: \"kafka\"\n  kafka:\n    async: true\n  outbox:\n    enabled: true\n", false},
+		{"async publisher", "events:\n  publishers:\n    - name: \"analytics\"\n      type: \"kafka\"\n      kafka:\n        async: true\n  outbox:\n    enabled: true\n", false},
+		{"async without outbox", "events:\n  publisher: \"kafka\"\n  kafka:\n    async: true\n", true},
+		{"async unused", "events:\n  publisher: \"noop\"\n  kafka:\n    async: true\n  outbox:\n    enabled: true\n", true},
+		{"sync", "events:\n  publisher: \"kafka\"\n  outbox:\n    enabled: true\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+
+			_, err := Load(path)
+			if tt.valid && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Error("Expected error for kafka async with the outbox")
+			}
+		})
+	}
+}
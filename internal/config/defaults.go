@@ -0,0 +1,20 @@
+package config
+
+import "time"
+
+// IntOr returns the configured value, or the default when it is not set
+func IntOr(value, defaultValue int) int {
+	if value > 0 {
+		return value
+	}
+	return defaultValue
+}
+
+// DurationOr converts a configured value to a duration, or returns the
+// default when it is not set
+func DurationOr(value int, unit, defaultValue time.Duration) time.Duration {
+	if value > 0 {
+		return time.Duration(value) * unit
+	}
+	return defaultValue
+}
@@ -0,0 +1,25 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDefaults(t *testing.T) {
+	if got := IntOr(0, 5); got != 5 {
+		t.Errorf("Expected default 5, got %d", got)
+	}
+	if got := IntOr(-1, 5); got != 5 {
+		t.Errorf("Expected default 5, got %d", got)
+	}
+	if got := IntOr(3, 5); got != 3 {
+		t.Errorf("Expected 3, got %d", got)
+	}
+
+	if got := DurationOr(0, time.Millisecond, time.Second); got != time.Second {
+		t.Errorf("Expected default 1s, got %v", got)
+	}
+	if got := DurationOr(250, time.Millisecond, time.Second); got != 250*time.Millisecond {
+		t.Errorf("Expected 250ms, got %v", got)
+	}
+}
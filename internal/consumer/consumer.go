@@ -0,0 +1,289 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go-fred/internal/backoff"
+	"go-fred/internal/config"
+	"go-fred/internal/events"
+	"go-fred/internal/models"
+	"go-fred/internal/tasks"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Headers added to the messages produced to the dead-letter topic, next to
+// the headers of the original message
+const (
+	HeaderDeadLetterError     = "dead-letter-error"
+	HeaderDeadLetterTopic     = "dead-letter-topic"
+	HeaderDeadLetterPartition = "dead-letter-partition"
+	HeaderDeadLetterOffset    = "dead-letter-offset"
+)
+
+// Backoff between failed attempts and dial timeout of the consumer, unless
+// configured
+const (
+	defaultDelay       = time.Second
+	defaultMaxDelay    = time.Minute
+	defaultDialTimeout = 10 * time.Second
+)
+
+// Reader is the part of a kafka.Reader the consumer uses
+type Reader interface {
+	FetchMessage(ctx context.Context) (kafka.Message, error)
+	CommitMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+// Writer is the part of a kafka.Writer the consumer uses
+type Writer interface {
+	WriteMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+// Consumer creates and executes tasks from the task requests read from a
+// Kafka topic. The offset of a message is only committed once its task is
+// stored and queued, so a request is never lost; a message read again after
+// a restart returns the task created first, as its offset is the default
+// idempotency key of the request. Messages that cannot be turned into a task
+// are produced to the dead-letter topic, and everything else, such as a full
+// queue or a failing store, is retried with exponential backoff, holding up
+// the partition.
+type Consumer struct {
+	taskManager *tasks.TaskManager
+	reader      Reader
+	deadLetters Writer
+	delay       time.Duration
+	maxDelay    time.Duration
+	ctx         context.Context
+	cancel      context.CancelFunc
+	started     atomic.Bool
+	done        chan struct{}
+}
+
+// New creates a consumer reading the configured topic as a member of the
+// consumer group
+func New(taskManager *tasks.TaskManager, cfg *config.ConsumerConfig) (*Consumer, error) {
+	tlsConfig, err := events.NewKafkaTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	mechanism, err := events.NewKafkaSASLMechanism(cfg.SASL)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: cfg.Brokers,
+		GroupID: cfg.GroupID,
+		Topic:   cfg.Topic,
+		Dialer: &kafka.Dialer{
+			ClientID:      cfg.ClientID,
+			Timeout:       defaultDialTimeout,
+			DualStack:     true,
+			TLS:           tlsConfig,
+			SASLMechanism: mechanism,
+		},
+	})
+
+	var deadLetters Writer
+	if cfg.DeadLetterTopic != "" {
+		deadLetters = &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Topic:        cfg.DeadLetterTopic,
+			Balancer:     &kafka.Murmur2Balancer{},
+			BatchSize:    1,
+			RequiredAcks: kafka.RequireAll,
+			Transport: &kafka.Transport{
+				ClientID: cfg.ClientID,
+				TLS:      tlsConfig,
+				SASL:     mechanism,
+			},
+		}
+	}
+	return newConsumer(taskManager, reader, deadLetters, cfg), nil
+}
+
+// newConsumer creates a consumer on the given reader and dead-letter writer,
+// which may be nil to drop the messages instead
+func newConsumer(taskManager *tasks.TaskManager, reader Reader, deadLetters Writer, cfg *config.ConsumerConfig) *Consumer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Consumer{
+		taskManager: taskManager,
+		reader:      reader,
+		deadLetters: deadLetters,
+		delay:       config.DurationOr(cfg.DelayMs, time.Millisecond, defaultDelay),
+		maxDelay:    config.DurationOr(cfg.MaxDelayMs, time.Millisecond, defaultMaxDelay),
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+}
+
+// Start starts consuming messages in the background
+func (c *Consumer) Start() {
+	c.started.Store(true)
+	go c.run()
+}
+
+// Close stops consuming, waits for the message being handled and closes the
+// connections. A message whose offset was not committed yet is read again
+// after the next start.
+func (c *Consumer) Close() error {
+	c.cancel()
+	if c.started.Load() {
+		<-c.done
+	}
+
+	var errs []error
+	if err := c.reader.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.deadLetters != nil {
+		if err := c.deadLetters.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// run handles the messages one by one until the consumer is closed
+func (c *Consumer) run() {
+	defer close(c.done)
+
+	failures := 0
+	for {
+		message, err := c.reader.FetchMessage(c.ctx)
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+			failures++
+			log.Printf("Failed to read task request: %v", err)
+			if !c.sleep(failures) {
+				return
+			}
+			continue
+		}
+		failures = 0
+
+		if !c.retry(func() error { return c.handle(message) }) {
+			return
+		}
+		if !c.retry(func() error { return c.reader.CommitMessages(c.ctx, message) }) {
+			return
+		}
+	}
+}
+
+// retry calls f until it succeeds, waiting with exponential backoff after
+// failures. It reports false if the consumer was closed first.
+func (c *Consumer) retry(f func() error) bool {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil {
+			return true
+		}
+		if c.ctx.Err() != nil {
+			return false
+		}
+		log.Printf("Failed to consume task request (attempt %d): %v", attempt, err)
+		if !c.sleep(attempt) {
+			return false
+		}
+	}
+}
+
+// handle creates and queues the task of a message, or produces the message
+// to the dead-letter topic when it is not a valid task request. An error
+// means the message must be handled again.
+func (c *Consumer) handle(message kafka.Message) error {
+	req, err := decodeRequest(message.Value)
+	if err != nil {
+		return c.deadLetter(message, err)
+	}
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = fmt.Sprintf("kafka:%s:%d:%d", message.Topic, message.Partition, message.Offset)
+	}
+
+	// Requests the task manager rejects are dead-lettered, while failures
+	// such as an unavailable store are tried again
+	task, created, err := c.taskManager.CreateTaskIdempotent(req)
+	if errors.Is(err, tasks.ErrInvalidRequest) || errors.Is(err, tasks.ErrIdempotencyKeyReused) {
+		return c.deadLetter(message, err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create task: %w", err)
+	}
+	if task.Status != models.TaskStatusPending {
+		// Postponed, or already run for an earlier delivery of the message
+		return nil
+	}
+
+	// A task read again may already be queued, which is fine; only a task
+	// that could not be queued yet must be tried again
+	err = c.taskManager.ExecuteTaskAsync(c.ctx, task.ID)
+	if err != nil && (created || errors.Is(err, tasks.ErrQueueFull) || errors.Is(err, tasks.ErrQueueDraining)) {
+		return fmt.Errorf("failed to execute task %s: %w", task.ID, err)
+	}
+	return nil
+}
+
+// deadLetter produces a message that cannot be turned into a task to the
+// dead-letter topic, recording why and where it came from
+func (c *Consumer) deadLetter(message kafka.Message, cause error) error {
+	log.Printf("Rejected task request at offset %d of %s/%d: %v", message.Offset, message.Topic, message.Partition, cause)
+	if c.deadLetters == nil {
+		return nil
+	}
+
+	headers := append([]kafka.Header(nil), message.Headers...)
+	headers = append(headers,
+		kafka.Header{Key: HeaderDeadLetterError, Value: []byte(cause.Error())},
+		kafka.Header{Key: HeaderDeadLetterTopic, Value: []byte(message.Topic)},
+		kafka.Header{Key: HeaderDeadLetterPartition, Value: []byte(strconv.Itoa(message.Partition))},
+		kafka.Header{Key: HeaderDeadLetterOffset, Value: []byte(strconv.FormatInt(message.Offset, 10))},
+	)
+	err := c.deadLetters.WriteMessages(c.ctx, kafka.Message{
+		Key:     message.Key,
+		Value:   message.Value,
+		Headers: headers,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write to the dead-letter topic: %w", err)
+	}
+	return nil
+}
+
+// sleep waits for the backoff of the given failed attempt. It reports false
+// if the consumer was closed first.
+func (c *Consumer) sleep(attempt int) bool {
+	timer := time.NewTimer(backoff.Exponential(c.delay, c.maxDelay, attempt))
+	defer timer.Stop()
+	select {
+	case <-c.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// decodeRequest decodes the task request of a message
+func decodeRequest(value []byte) (*models.TaskRequest, error) {
+	var req models.TaskRequest
+	if err := json.Unmarshal(value, &req); err != nil {
+		return nil, fmt.Errorf("invalid task request: %w", err)
+	}
+	if req.Type == "" {
+		return nil, fmt.Errorf("invalid task request: type is required")
+	}
+	return &req, nil
+}
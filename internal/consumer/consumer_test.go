@@ -0,0 +1,352 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go-fred/internal/config"
+	"go-fred/internal/events"
+	"go-fred/internal/models"
+	"go-fred/internal/tasks"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeReader hands out the messages sent to it and records the commits
+type fakeReader struct {
+	messages  chan kafka.Message
+	mu        sync.Mutex
+	committed []int64
+	failures  int
+	closed    bool
+}
+
+func newFakeReader() *fakeReader {
+	return &fakeReader{messages: make(chan kafka.Message, 10)}
+}
+
+func (r *fakeReader) FetchMessage(ctx context.Context) (kafka.Message, error) {
+	select {
+	case message := <-r.messages:
+		return message, nil
+	case <-ctx.Done():
+		return kafka.Message{}, ctx.Err()
+	}
+}
+
+func (r *fakeReader) CommitMessages(ctx context.Context, messages ...kafka.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		return errors.New("commit failed")
+	}
+	for _, message := range messages {
+		r.committed = append(r.committed, message.Offset)
+	}
+	return nil
+}
+
+func (r *fakeReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+func (r *fakeReader) offsets() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int64(nil), r.committed...)
+}
+
+// fakeWriter records the dead-lettered messages, failing the first writes
+type fakeWriter struct {
+	mu       sync.Mutex
+	messages []kafka.Message
+	failures int
+	closed   bool
+}
+
+func (w *fakeWriter) WriteMessages(ctx context.Context, messages ...kafka.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("broker unavailable")
+	}
+	w.messages = append(w.messages, messages...)
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	return nil
+}
+
+func (w *fakeWriter) written() []kafka.Message {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]kafka.Message(nil), w.messages...)
+}
+
+func newTestConsumer(t *testing.T, deadLetters Writer) (*tasks.TaskManager, *fakeReader, *Consumer) {
+	t.Helper()
+	registry := tasks.NewExecutorRegistry()
+	tasks.RegisterDefaultExecutors(registry)
+	taskManager := tasks.NewTaskManager(registry, events.NewNoOpPublisher(), 2)
+	t.Cleanup(taskManager.Close)
+
+	reader := newFakeReader()
+	consumer := newConsumer(taskManager, reader, deadLetters, &config.ConsumerConfig{DelayMs: 5, MaxDelayMs: 20})
+	consumer.Start()
+	t.Cleanup(func() { consumer.Close() })
+	return taskManager, reader, consumer
+}
+
+func message(offset int64, value string) kafka.Message {
+	return kafka.Message{Topic: "tasks", Partition: 0, Offset: offset, Key: []byte("key"), Value: []byte(value)}
+}
+
+func waitForOffsets(t *testing.T, reader *fakeReader, n int) []int64 {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(reader.offsets()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d commits, got %v", n, reader.offsets())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return reader.offsets()
+}
+
+func TestConsumerCreatesAndExecutesTasks(t *testing.T) {
+	taskManager, reader, _ := newTestConsumer(t, &fakeWriter{})
+
+	reader.messages <- message(1, `{"type": "echo", "input": {"message": "hello"}, "labels": {"source": "kafka"}}`)
+	reader.messages <- message(2, `{"type": "echo", "delay": 3600}`)
+	waitForOffsets(t, reader, 2)
+
+	taskList := taskManager.ListTasks()
+	if len(taskList) != 2 {
+		t.Fatalf("Expected 2 tasks, got %d", len(taskList))
+	}
+	for _, task := range taskList {
+		if task.IdempotencyKey == "kafka:tasks:0:1" {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			finished, err := taskManager.WaitForTask(ctx, task.ID)
+			cancel()
+			if err != nil || finished.Status != models.TaskStatusCompleted || finished.Labels["source"] != "kafka" {
+				t.Errorf("Expected the labelled task to complete, got %+v (%v)", finished, err)
+			}
+		} else if task.Status != models.TaskStatusScheduled {
+			// Postponed tasks wait for their time
+			t.Errorf("Expected the postponed task to be scheduled, got %s", task.Status)
+		}
+	}
+}
+
+func TestConsumerRedeliveredMessage(t *testing.T) {
+	taskManager, reader, _ := newTestConsumer(t, &fakeWriter{})
+
+	// The same message read twice, as after a restart before the commit,
+	// creates a single task
+	reader.messages <- message(7, `{"type": "echo", "input": {"n": 1}}`)
+	reader.messages <- message(7, `{"type": "echo", "input": {"n": 1}}`)
+	// Requests with their own idempotency key keep it
+	reader.messages <- message(8, `{"type": "echo", "idempotency_key": "order-42"}`)
+	reader.messages <- message(9, `{"type": "echo", "idempotency_key": "order-42"}`)
+	waitForOffsets(t, reader, 4)
+
+	if got := len(taskManager.ListTasks()); got != 2 {
+		t.Errorf("Expected 2 tasks, got %d", got)
+	}
+}
+
+func TestConsumerDeadLetters(t *testing.T) {
+	deadLetters := &fakeWriter{failures: 2}
+	taskManager, reader, _ := newTestConsumer(t, deadLetters)
+
+	invalid := []string{
+		`not json`,
+		`{"input": {"message": "no type"}}`,
+		`{"type": "unknown"}`,
+		`{"type": "echo", "priority": -1}`,
+	}
+	for i, value := range invalid {
+		msg := message(int64(i+1), value)
+		msg.Headers = []kafka.Header{{Key: "producer", Value: []byte("billing")}}
+		reader.messages <- msg
+	}
+	reader.messages <- message(5, `{"type": "echo"}`)
+	offsets := waitForOffsets(t, reader, 5)
+
+	// Every message is committed in order, the invalid ones once they were
+	// dead-lettered despite the failing writes
+	for i, offset := range offsets {
+		if offset != int64(i+1) {
+			t.Errorf("Expected offsets 1 to 5 in order, got %v", offsets)
+			break
+		}
+	}
+	written := deadLetters.written()
+	if len(written) != len(invalid) {
+		t.Fatalf("Expected %d dead-lettered messages, got %d", len(invalid), len(written))
+	}
+	for i, msg := range written {
+		headers := make(map[string]string)
+		for _, header := range msg.Headers {
+			headers[header.Key] = string(header.Value)
+		}
+		if string(msg.Value) != invalid[i] || string(msg.Key) != "key" {
+			t.Errorf("Expected the original message, got %s", msg.Value)
+		}
+		if headers["producer"] != "billing" || headers[HeaderDeadLetterTopic] != "tasks" || headers[HeaderDeadLetterPartition] != "0" {
+			t.Errorf("Expected the original headers and the source, got %v", headers)
+		}
+		if headers[HeaderDeadLetterOffset] != strconv.Itoa(i+1) || headers[HeaderDeadLetterError] == "" {
+			t.Errorf("Expected the offset and the error, got %v", headers)
+		}
+	}
+	if got := len(taskManager.ListTasks()); got != 1 {
+		t.Errorf("Expected 1 task, got %d", got)
+	}
+}
+
+// failingStore is a task store whose first creates fail
+type failingStore struct {
+	*tasks.MemoryTaskStore
+	mu       sync.Mutex
+	failures int
+}
+
+func (s *failingStore) Create(task *models.Task, evts ...events.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("store unavailable")
+	}
+	return s.MemoryTaskStore.Create(task, evts...)
+}
+
+func TestConsumerRetriesStoreFailures(t *testing.T) {
+	registry := tasks.NewExecutorRegistry()
+	tasks.RegisterDefaultExecutors(registry)
+	store := &failingStore{MemoryTaskStore: tasks.NewMemoryTaskStore(), failures: 2}
+	taskManager := tasks.NewTaskManagerWithStore(registry, events.NewNoOpPublisher(), store, 2)
+	defer taskManager.Close()
+
+	deadLetters := &fakeWriter{}
+	reader := newFakeReader()
+	consumer := newConsumer(taskManager, reader, deadLetters, &config.ConsumerConfig{DelayMs: 5, MaxDelayMs: 20})
+	consumer.Start()
+	defer consumer.Close()
+
+	// A request that failed to be stored is not dead-lettered but tried
+	// again until the store accepts it
+	reader.messages <- message(1, `{"type": "echo"}`)
+	waitForOffsets(t, reader, 1)
+
+	if written := deadLetters.written(); len(written) != 0 {
+		t.Errorf("Expected no dead-lettered messages, got %d", len(written))
+	}
+	if got := len(taskManager.ListTasks()); got != 1 {
+		t.Errorf("Expected 1 task, got %d", got)
+	}
+}
+
+func TestConsumerWaitsForTheQueue(t *testing.T) {
+	taskManager, reader, _ := newTestConsumer(t, nil)
+	if _, err := taskManager.DrainQueue(models.DefaultQueue); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	reader.messages <- message(1, `{"type": "echo"}`)
+	time.Sleep(50 * time.Millisecond)
+	if offsets := reader.offsets(); len(offsets) != 0 {
+		t.Fatalf("Expected no commit while the queue rejects tasks, got %v", offsets)
+	}
+
+	if _, err := taskManager.ResumeQueue(models.DefaultQueue); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	waitForOffsets(t, reader, 1)
+
+	taskList := taskManager.ListTasks()
+	if len(taskList) != 1 {
+		t.Fatalf("Expected 1 task, got %d", len(taskList))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if task, err := taskManager.WaitForTask(ctx, taskList[0].ID); err != nil || task.Status != models.TaskStatusCompleted {
+		t.Errorf("Expected the task to complete, got %+v (%v)", task, err)
+	}
+}
+
+func TestConsumerRetriesCommits(t *testing.T) {
+	taskManager, reader, _ := newTestConsumer(t, nil)
+	reader.mu.Lock()
+	reader.failures = 2
+	reader.mu.Unlock()
+
+	reader.messages <- message(1, `{"type": "echo"}`)
+	waitForOffsets(t, reader, 1)
+	if got := len(taskManager.ListTasks()); got != 1 {
+		t.Errorf("Expected 1 task, got %d", got)
+	}
+}
+
+func TestConsumerClose(t *testing.T) {
+	deadLetters := &fakeWriter{failures: 1000}
+	_, reader, consumer := newTestConsumer(t, deadLetters)
+
+	// Close interrupts a message that cannot be handled yet without
+	// committing it
+	reader.messages <- message(1, `not json`)
+	time.Sleep(20 * time.Millisecond)
+	if err := consumer.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if offsets := reader.offsets(); len(offsets) != 0 {
+		t.Errorf("Expected no commit, got %v", offsets)
+	}
+	if !reader.closed || !deadLetters.closed {
+		t.Error("Expected the reader and writer to be closed")
+	}
+}
+
+func TestNew(t *testing.T) {
+	registry := tasks.NewExecutorRegistry()
+	taskManager := tasks.NewTaskManager(registry, events.NewNoOpPublisher(), 1)
+	defer taskManager.Close()
+
+	cfg := &config.ConsumerConfig{
+		Brokers:         []string{"localhost:9092"},
+		Topic:           "task-requests",
+		GroupID:         "go-fred",
+		DeadLetterTopic: "task-requests-dlq",
+	}
+	consumer, err := New(taskManager, cfg)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if consumer.deadLetters == nil {
+		t.Error("Expected a dead-letter writer")
+	}
+	// A consumer that never started closes right away
+	if err := consumer.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cfg.SASL = config.KafkaSASLConfig{Mechanism: "kerberos"}
+	if _, err := New(taskManager, cfg); err == nil {
+		t.Error("Expected error for an unsupported SASL mechanism")
+	}
+}
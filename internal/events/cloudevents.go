@@ -0,0 +1,119 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Formats events are published in. FormatNative is the Event shape itself;
+// the CloudEvents formats follow the CloudEvents 1.0 specification, with the
+// event either as a JSON envelope (structured mode) or as message headers
+// around the data (binary mode, Kafka only).
+const (
+	FormatNative            = "native"
+	FormatCloudEvents       = "cloudevents"
+	FormatCloudEventsBinary = "cloudevents-binary"
+)
+
+// CloudEvents attributes common to all published events
+const (
+	CloudEventsSpecVersion = "1.0"
+	// CloudEventsContentType is the content type of a structured event
+	CloudEventsContentType = "application/cloudevents+json"
+	// CloudEventsBatchContentType is the content type of a batch of
+	// structured events
+	CloudEventsBatchContentType = "application/cloudevents-batch+json"
+	// EventDataContentType is the content type of the event data
+	EventDataContentType = "application/json"
+	// EventSchemaVersion is the version of the event data schemas. It changes
+	// whenever the data of an event changes incompatibly.
+	EventSchemaVersion = 1
+)
+
+// CloudEvent is an event in the CloudEvents 1.0 JSON format. The subject is
+// the ID of the task the event is about, if any, and the labels of the task
+// are part of the data.
+type CloudEvent struct {
+	SpecVersion     string                 `json:"specversion"`
+	ID              string                 `json:"id"`
+	Source          string                 `json:"source"`
+	Type            string                 `json:"type"`
+	Subject         string                 `json:"subject,omitempty"`
+	Time            time.Time              `json:"time"`
+	DataContentType string                 `json:"datacontenttype"`
+	DataSchema      string                 `json:"dataschema"`
+	Data            map[string]interface{} `json:"data"`
+}
+
+// NewCloudEvent wraps an event in a CloudEvents envelope
+func NewCloudEvent(event Event) CloudEvent {
+	data := event.Data
+	if len(event.Labels) > 0 {
+		data = make(map[string]interface{}, len(event.Data)+1)
+		for key, value := range event.Data {
+			data[key] = value
+		}
+		data["labels"] = event.Labels
+	}
+
+	subject, _ := event.Data["task_id"].(string)
+	return CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              event.ID,
+		Source:          event.Source,
+		Type:            event.Type,
+		Subject:         subject,
+		Time:            event.Timestamp,
+		DataContentType: EventDataContentType,
+		DataSchema:      EventDataSchema(event.Type),
+		Data:            data,
+	}
+}
+
+// EventDataSchema returns the URI identifying the schema of the data of an
+// event type, as in "urn:go-fred:events:v1:task.created"
+func EventDataSchema(eventType string) string {
+	return fmt.Sprintf("urn:go-fred:events:v%d:%s", EventSchemaVersion, eventType)
+}
+
+// Attributes returns the context attributes of the event by name, as they
+// are sent in the headers of a binary mode message
+func (e CloudEvent) Attributes() map[string]string {
+	attributes := map[string]string{
+		"specversion":     e.SpecVersion,
+		"id":              e.ID,
+		"source":          e.Source,
+		"type":            e.Type,
+		"time":            e.Time.Format(time.RFC3339Nano),
+		"datacontenttype": e.DataContentType,
+		"dataschema":      e.DataSchema,
+	}
+	if e.Subject != "" {
+		attributes["subject"] = e.Subject
+	}
+	return attributes
+}
+
+// validateFormat checks that a publisher supports the configured format
+func validateFormat(format string, binary bool) error {
+	switch format {
+	case "", FormatNative, FormatCloudEvents:
+		return nil
+	case FormatCloudEventsBinary:
+		if binary {
+			return nil
+		}
+		return fmt.Errorf("event format %s is only supported by the kafka publisher", format)
+	default:
+		return fmt.Errorf("unsupported event format: %s", format)
+	}
+}
+
+// marshalEvent encodes an event as the body of a structured mode message
+func marshalEvent(event Event, format string) ([]byte, error) {
+	if format == FormatCloudEvents {
+		return json.Marshal(NewCloudEvent(event))
+	}
+	return json.Marshal(event)
+}
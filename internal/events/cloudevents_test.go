@@ -0,0 +1,182 @@
+package events
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-fred/internal/config"
+)
+
+func TestNewCloudEvent(t *testing.T) {
+	event := NewEventBuilder(EventTypeTaskCompleted).
+		WithTaskID("task-1").
+		WithData("task_type", "echo").
+		WithLabels(map[string]string{"team": "data"}).
+		Build()
+
+	cloudEvent := NewCloudEvent(event)
+	if cloudEvent.SpecVersion != "1.0" || cloudEvent.ID != event.ID || cloudEvent.Type != EventTypeTaskCompleted {
+		t.Errorf("Unexpected context attributes %+v", cloudEvent)
+	}
+	if cloudEvent.Source != "go-fred" || cloudEvent.Subject != "task-1" || !cloudEvent.Time.Equal(event.Timestamp) {
+		t.Errorf("Expected source 'go-fred' and subject 'task-1', got %+v", cloudEvent)
+	}
+	if cloudEvent.DataContentType != "application/json" || cloudEvent.DataSchema != "urn:go-fred:events:v1:task.completed" {
+		t.Errorf("Unexpected data attributes %s and %s", cloudEvent.DataContentType, cloudEvent.DataSchema)
+	}
+	if cloudEvent.Data["task_type"] != "echo" || cloudEvent.Data["labels"].(map[string]string)["team"] != "data" {
+		t.Errorf("Expected the data with the labels, got %v", cloudEvent.Data)
+	}
+	if _, exists := event.Data["labels"]; exists {
+		t.Error("Expected the data of the event to be left alone")
+	}
+
+	body, err := json.Marshal(cloudEvent)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var decoded map[string]interface{}
+	json.Unmarshal(body, &decoded)
+	for _, attribute := range []string{"specversion", "id", "source", "type", "subject", "time", "datacontenttype", "dataschema", "data"} {
+		if _, exists := decoded[attribute]; !exists {
+			t.Errorf("Expected attribute %s in %s", attribute, body)
+		}
+	}
+
+	// Events not about a task have no subject
+	cloudEvent = NewCloudEvent(NewEventBuilder(EventTypeScheduleCreated).WithScheduleID("schedule-1").Build())
+	if cloudEvent.Subject != "" {
+		t.Errorf("Expected no subject, got %q", cloudEvent.Subject)
+	}
+	if _, exists := cloudEvent.Attributes()["subject"]; exists {
+		t.Error("Expected no subject attribute")
+	}
+}
+
+func TestKafkaPublisherMessage(t *testing.T) {
+	event := NewEventBuilder(EventTypeTaskFailed).WithTaskID("task-1").WithData("error", "boom").Build()
+
+	tests := []struct {
+		format      string
+		contentType string
+	}{
+		{format: "", contentType: ""},
+		{format: FormatCloudEvents, contentType: CloudEventsContentType},
+		{format: FormatCloudEventsBinary, contentType: "application/json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			publisher, err := NewKafkaPublisher(config.KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "events", Format: tt.format})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			defer publisher.Close()
+
+			message, err := publisher.message(event)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			headers := make(map[string]string)
+			for _, header := range message.Headers {
+				headers[header.Key] = string(header.Value)
+			}
+			if headers["content-type"] != tt.contentType {
+				t.Errorf("Expected content type %q, got %q", tt.contentType, headers["content-type"])
+			}
+
+			var value map[string]interface{}
+			if err := json.Unmarshal(message.Value, &value); err != nil {
+				t.Fatalf("Failed to decode message value: %v", err)
+			}
+			switch tt.format {
+			case FormatCloudEventsBinary:
+				if value["error"] != "boom" || value["task_id"] != "task-1" {
+					t.Errorf("Expected the event data as value, got %v", value)
+				}
+				expected := map[string]string{
+					"ce_specversion": "1.0",
+					"ce_id":          event.ID,
+					"ce_source":      "go-fred",
+					"ce_type":        EventTypeTaskFailed,
+					"ce_subject":     "task-1",
+					"ce_dataschema":  "urn:go-fred:events:v1:task.failed",
+					"ce_time":        event.Timestamp.Format(time.RFC3339Nano),
+				}
+				for key, want := range expected {
+					if headers[key] != want {
+						t.Errorf("Expected header %s %q, got %q", key, want, headers[key])
+					}
+				}
+			case FormatCloudEvents:
+				if value["specversion"] != "1.0" || value["subject"] != "task-1" {
+					t.Errorf("Expected a structured CloudEvent, got %v", value)
+				}
+			default:
+				if value["id"] != event.ID || value["timestamp"] == nil {
+					t.Errorf("Expected the native event, got %v", value)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookPublisherCloudEvents(t *testing.T) {
+	single := &webhookReceiver{}
+	singleServer := httptest.NewServer(single)
+	defer singleServer.Close()
+	batched := &webhookReceiver{}
+	batchedServer := httptest.NewServer(batched)
+	defer batchedServer.Close()
+
+	publishers := []config.WebhookPublisherConfig{
+		{Format: FormatCloudEvents, Endpoints: []config.WebhookEndpoint{{URL: singleServer.URL}}},
+		{Format: FormatCloudEvents, BatchSize: 2, Endpoints: []config.WebhookEndpoint{{URL: batchedServer.URL}}},
+	}
+	for _, cfg := range publishers {
+		publisher, err := NewWebhookPublisher(cfg)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		publishEvents(t, publisher, EventTypeTaskCreated, EventTypeTaskStarted)
+		if err := publisher.Close(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	if single.count() != 2 {
+		t.Fatalf("Expected 2 requests, got %d", single.count())
+	}
+	var cloudEvent CloudEvent
+	if err := json.Unmarshal(single.bodies[0], &cloudEvent); err != nil || cloudEvent.Type != EventTypeTaskCreated {
+		t.Errorf("Expected a structured task.created event, got %s", single.bodies[0])
+	}
+	if got := single.headers[0].Get("Content-Type"); got != CloudEventsContentType {
+		t.Errorf("Expected content type %s, got %s", CloudEventsContentType, got)
+	}
+
+	if batched.count() != 1 {
+		t.Fatalf("Expected 1 request, got %d", batched.count())
+	}
+	var cloudEvents []CloudEvent
+	if err := json.Unmarshal(batched.bodies[0], &cloudEvents); err != nil || len(cloudEvents) != 2 {
+		t.Errorf("Expected a batch of 2 events, got %s", batched.bodies[0])
+	}
+	if got := batched.headers[0].Get("Content-Type"); got != CloudEventsBatchContentType {
+		t.Errorf("Expected content type %s, got %s", CloudEventsBatchContentType, got)
+	}
+}
+
+func TestInvalidEventFormat(t *testing.T) {
+	if _, err := NewWebhookPublisher(config.WebhookPublisherConfig{
+		Format:    FormatCloudEventsBinary,
+		Endpoints: []config.WebhookEndpoint{{URL: "https://example.com/events"}},
+	}); err == nil {
+		t.Error("Expected error for binary mode webhooks")
+	}
+	if _, err := NewKafkaPublisher(config.KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "events", Format: "xml"}); err == nil {
+		t.Error("Expected error for an unknown format")
+	}
+}
@@ -9,11 +9,30 @@ import (
 
 // EventType constants for different event types
 const (
-	EventTypeTaskCreated   = "task.created"
-	EventTypeTaskStarted   = "task.started"
-	EventTypeTaskCompleted = "task.completed"
-	EventTypeTaskFailed    = "task.failed"
-	EventTypeTaskCancelled = "task.cancelled"
+	EventTypeTaskCreated        = "task.created"
+	EventTypeTaskStarted        = "task.started"
+	EventTypeTaskCompleted      = "task.completed"
+	EventTypeTaskFailed         = "task.failed"
+	EventTypeTaskCancelled      = "task.cancelled"
+	EventTypeTaskTimedOut       = "task.timed_out"
+	EventTypeTaskRetryScheduled = "task.retry_scheduled"
+	EventTypeTaskScheduled      = "task.scheduled"
+
+	EventTypeScheduleCreated   = "schedule.created"
+	EventTypeScheduleUpdated   = "schedule.updated"
+	EventTypeScheduleDeleted   = "schedule.deleted"
+	EventTypeSchedulePaused    = "schedule.paused"
+	EventTypeScheduleResumed   = "schedule.resumed"
+	EventTypeScheduleTriggered = "schedule.triggered"
+	EventTypeScheduleSkipped   = "schedule.skipped"
+
+	EventTypeWorkflowCreated      = "workflow.created"
+	EventTypeWorkflowCompleted    = "workflow.completed"
+	EventTypeWorkflowFailed       = "workflow.failed"
+	EventTypeWorkflowCancelled    = "workflow.cancelled"
+	EventTypeWorkflowNodeStarted  = "workflow.node_started"
+	EventTypeWorkflowNodeFinished = "workflow.node_finished"
+	EventTypeWorkflowNodeSkipped  = "workflow.node_skipped"
 )
 
 // EventBuilder helps build events with common patterns
@@ -46,8 +65,29 @@ func (b *EventBuilder) WithTaskID(taskID string) *EventBuilder {
 	return b
 }
 
+// WithScheduleID adds schedule ID to the event data
+func (b *EventBuilder) WithScheduleID(scheduleID string) *EventBuilder {
+	b.event.Data["schedule_id"] = scheduleID
+	return b
+}
+
+// WithWorkflowID adds workflow ID to the event data
+func (b *EventBuilder) WithWorkflowID(workflowID string) *EventBuilder {
+	b.event.Data["workflow_id"] = workflowID
+	return b
+}
+
+// WithLabels copies the labels of the task or workflow the event is about
+func (b *EventBuilder) WithLabels(labels map[string]string) *EventBuilder {
+	b.event.Labels = copyLabels(labels)
+	return b
+}
+
 // WithError adds error information to the event data
 func (b *EventBuilder) WithError(err error) *EventBuilder {
+	if err == nil {
+		return b
+	}
 	b.event.Data["error"] = err.Error()
 	return b
 }
@@ -63,6 +103,85 @@ func (b *EventBuilder) Build() Event {
 	return b.event
 }
 
+// labelsKey is the context key of the labels added to published events
+type labelsKey struct{}
+
+// ContextWithLabels returns a context whose labels are copied into the
+// events published with it, so that every event about a task carries the
+// task's labels
+func ContextWithLabels(ctx context.Context, labels map[string]string) context.Context {
+	if len(labels) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, labelsKey{}, labels)
+}
+
+// LabelsFromContext returns the labels added to the context, if any
+func LabelsFromContext(ctx context.Context) map[string]string {
+	labels, _ := ctx.Value(labelsKey{}).(map[string]string)
+	return labels
+}
+
+// taskTypeKey is the context key of the task type added to published events
+type taskTypeKey struct{}
+
+// ContextWithTaskType returns a context whose task type is added to the data
+// of the events published with it, so that every event about a task says
+// what type of task it is
+func ContextWithTaskType(ctx context.Context, taskType string) context.Context {
+	if taskType == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, taskTypeKey{}, taskType)
+}
+
+// TaskTypeFromContext returns the task type added to the context, if any
+func TaskTypeFromContext(ctx context.Context) string {
+	taskType, _ := ctx.Value(taskTypeKey{}).(string)
+	return taskType
+}
+
+// storedKey is the context key marking events already stored in the outbox
+type storedKey struct{}
+
+// ContextWithStored returns a context marking the events published with it
+// as already stored in the outbox, in the transaction that stored the change
+// they describe, so that the outbox does not store them again
+func ContextWithStored(ctx context.Context) context.Context {
+	return context.WithValue(ctx, storedKey{}, true)
+}
+
+// StoredFromContext reports whether the events published with the context
+// are already stored in the outbox
+func StoredFromContext(ctx context.Context) bool {
+	stored, _ := ctx.Value(storedKey{}).(bool)
+	return stored
+}
+
+// publish adds the labels and task type of the context to the event, unless
+// it has its own, and publishes it
+func publish(ctx context.Context, publisher Publisher, event Event) error {
+	if event.Labels == nil {
+		event.Labels = copyLabels(LabelsFromContext(ctx))
+	}
+	if taskType := TaskTypeFromContext(ctx); taskType != "" && event.Data["task_type"] == nil {
+		event.Data["task_type"] = taskType
+	}
+	return publisher.Publish(ctx, event)
+}
+
+// copyLabels copies labels so that publishers may keep the events they get
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(labels))
+	for key, value := range labels {
+		copied[key] = value
+	}
+	return copied
+}
+
 // PublishTaskCreated publishes a task created event
 func PublishTaskCreated(ctx context.Context, publisher Publisher, taskID, taskType string, isAsync bool) error {
 	event := NewEventBuilder(EventTypeTaskCreated).
@@ -71,7 +190,7 @@ func PublishTaskCreated(ctx context.Context, publisher Publisher, taskID, taskTy
 		WithData("is_async", isAsync).
 		Build()
 
-	return publisher.Publish(ctx, event)
+	return publish(ctx, publisher, event)
 }
 
 // PublishTaskStarted publishes a task started event
@@ -80,7 +199,7 @@ func PublishTaskStarted(ctx context.Context, publisher Publisher, taskID string)
 		WithTaskID(taskID).
 		Build()
 
-	return publisher.Publish(ctx, event)
+	return publish(ctx, publisher, event)
 }
 
 // PublishTaskCompleted publishes a task completed event
@@ -91,7 +210,7 @@ func PublishTaskCompleted(ctx context.Context, publisher Publisher, taskID strin
 		WithData("result", result).
 		Build()
 
-	return publisher.Publish(ctx, event)
+	return publish(ctx, publisher, event)
 }
 
 // PublishTaskFailed publishes a task failed event
@@ -102,7 +221,7 @@ func PublishTaskFailed(ctx context.Context, publisher Publisher, taskID string,
 		WithError(err).
 		Build()
 
-	return publisher.Publish(ctx, event)
+	return publish(ctx, publisher, event)
 }
 
 // PublishTaskCancelled publishes a task cancelled event
@@ -111,7 +230,113 @@ func PublishTaskCancelled(ctx context.Context, publisher Publisher, taskID strin
 		WithTaskID(taskID).
 		Build()
 
-	return publisher.Publish(ctx, event)
+	return publish(ctx, publisher, event)
+}
+
+// PublishTaskTimedOut publishes a task timed out event
+func PublishTaskTimedOut(ctx context.Context, publisher Publisher, taskID string, duration, timeout time.Duration) error {
+	event := NewEventBuilder(EventTypeTaskTimedOut).
+		WithTaskID(taskID).
+		WithDuration(duration).
+		WithData("timeout_seconds", timeout.Seconds()).
+		Build()
+
+	return publish(ctx, publisher, event)
+}
+
+// PublishTaskRetryScheduled publishes an event for a failed attempt that will be retried
+func PublishTaskRetryScheduled(ctx context.Context, publisher Publisher, taskID string, attempt int, delay time.Duration, err error) error {
+	event := NewEventBuilder(EventTypeTaskRetryScheduled).
+		WithTaskID(taskID).
+		WithError(err).
+		WithData("attempt", attempt).
+		WithData("retry_in_ms", delay.Milliseconds()).
+		Build()
+
+	return publish(ctx, publisher, event)
+}
+
+// PublishTaskScheduled publishes an event for a task postponed until the given time
+func PublishTaskScheduled(ctx context.Context, publisher Publisher, taskID string, runAt time.Time) error {
+	event := NewEventBuilder(EventTypeTaskScheduled).
+		WithTaskID(taskID).
+		WithData("run_at", runAt.Format(time.RFC3339Nano)).
+		Build()
+
+	return publish(ctx, publisher, event)
+}
+
+// PublishScheduleEvent publishes a schedule lifecycle event of the given type
+func PublishScheduleEvent(ctx context.Context, publisher Publisher, eventType, scheduleID string) error {
+	event := NewEventBuilder(eventType).
+		WithScheduleID(scheduleID).
+		Build()
+
+	return publish(ctx, publisher, event)
+}
+
+// PublishScheduleTriggered publishes an event for a task created by a schedule
+func PublishScheduleTriggered(ctx context.Context, publisher Publisher, scheduleID, taskID string, queued bool) error {
+	event := NewEventBuilder(EventTypeScheduleTriggered).
+		WithScheduleID(scheduleID).
+		WithTaskID(taskID).
+		WithData("queued", queued).
+		Build()
+
+	return publish(ctx, publisher, event)
+}
+
+// PublishScheduleSkipped publishes an event for a schedule run that did not happen
+func PublishScheduleSkipped(ctx context.Context, publisher Publisher, scheduleID, reason string) error {
+	event := NewEventBuilder(EventTypeScheduleSkipped).
+		WithScheduleID(scheduleID).
+		WithData("reason", reason).
+		Build()
+
+	return publish(ctx, publisher, event)
+}
+
+// PublishWorkflowEvent publishes a workflow lifecycle event of the given type
+func PublishWorkflowEvent(ctx context.Context, publisher Publisher, eventType, workflowID string) error {
+	event := NewEventBuilder(eventType).
+		WithWorkflowID(workflowID).
+		Build()
+
+	return publish(ctx, publisher, event)
+}
+
+// PublishWorkflowNodeStarted publishes an event for a workflow node whose task was started
+func PublishWorkflowNodeStarted(ctx context.Context, publisher Publisher, workflowID, nodeID, taskID string) error {
+	event := NewEventBuilder(EventTypeWorkflowNodeStarted).
+		WithWorkflowID(workflowID).
+		WithTaskID(taskID).
+		WithData("node_id", nodeID).
+		Build()
+
+	return publish(ctx, publisher, event)
+}
+
+// PublishWorkflowNodeFinished publishes an event for a workflow node whose task finished
+func PublishWorkflowNodeFinished(ctx context.Context, publisher Publisher, workflowID, nodeID, taskID, status string) error {
+	event := NewEventBuilder(EventTypeWorkflowNodeFinished).
+		WithWorkflowID(workflowID).
+		WithTaskID(taskID).
+		WithData("node_id", nodeID).
+		WithData("status", status).
+		Build()
+
+	return publish(ctx, publisher, event)
+}
+
+// PublishWorkflowNodeSkipped publishes an event for a workflow node that will not run
+func PublishWorkflowNodeSkipped(ctx context.Context, publisher Publisher, workflowID, nodeID, reason string) error {
+	event := NewEventBuilder(EventTypeWorkflowNodeSkipped).
+		WithWorkflowID(workflowID).
+		WithData("node_id", nodeID).
+		WithData("reason", reason).
+		Build()
+
+	return publish(ctx, publisher, event)
 }
 
 // PublishCustomEvent publishes a custom event with the given type and data
@@ -122,5 +347,5 @@ func PublishCustomEvent(ctx context.Context, publisher Publisher, eventType stri
 	}
 
 	event := builder.Build()
-	return publisher.Publish(ctx, event)
+	return publish(ctx, publisher, event)
 }
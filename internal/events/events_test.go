@@ -61,6 +61,13 @@ func TestNewPublisher(t *testing.T) {
 			},
 			expectError: true,
 		},
+		{
+			name: "webhook publisher with no endpoints",
+			config: &config.EventsConfig{
+				Publisher: "webhook",
+			},
+			expectError: true,
+		},
 		{
 			name: "unsupported publisher",
 			config: &config.EventsConfig{
@@ -93,6 +100,29 @@ func TestNewPublisher(t *testing.T) {
 	}
 }
 
+func TestNewPublisherBehindOutbox(t *testing.T) {
+	enabled := true
+	publisher, err := NewPublisher(&config.EventsConfig{
+		Publishers: []config.PublisherConfig{
+			{Name: "kafka", Type: "kafka", Kafka: config.KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "events"}},
+			{Name: "webhook", Type: "webhook", Webhook: config.WebhookPublisherConfig{
+				Endpoints: []config.WebhookEndpoint{{URL: "http://localhost:9999/events"}},
+			}},
+		},
+		Outbox: config.OutboxConfig{Enabled: &enabled},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+
+	// The outbox delivers to every publisher and webhook endpoint on its own
+	routes := publisher.(*FanOutPublisher).Routes()
+	if len(routes) != 2 || routes[0].Name != "kafka" || routes[1].Name != "webhook/http://localhost:9999/events" {
+		t.Errorf("Expected a route for the webhook endpoint, got %+v", routes)
+	}
+}
+
 func TestNoOpPublisher(t *testing.T) {
 	publisher := NewNoOpPublisher()
 	if publisher == nil {
@@ -228,6 +258,70 @@ func TestPublishTaskCancelled(t *testing.T) {
 	}
 }
 
+func TestPublishTaskTimedOut(t *testing.T) {
+	publisher := NewNoOpPublisher()
+	ctx := context.Background()
+
+	err := PublishTaskTimedOut(ctx, publisher, "task-123", 5*time.Second, 5*time.Second)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestPublishTaskRetryScheduled(t *testing.T) {
+	publisher := NewNoOpPublisher()
+	ctx := context.Background()
+	testErr := &testError{message: "task failed"}
+
+	err := PublishTaskRetryScheduled(ctx, publisher, "task-123", 1, 2*time.Second, testErr)
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestPublishTaskScheduled(t *testing.T) {
+	publisher := NewNoOpPublisher()
+	ctx := context.Background()
+
+	err := PublishTaskScheduled(ctx, publisher, "task-123", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestPublishScheduleEvents(t *testing.T) {
+	publisher := NewNoOpPublisher()
+	ctx := context.Background()
+
+	if err := PublishScheduleEvent(ctx, publisher, EventTypeScheduleCreated, "schedule-123"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := PublishScheduleTriggered(ctx, publisher, "schedule-123", "task-123", false); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := PublishScheduleSkipped(ctx, publisher, "schedule-123", "overlap"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestPublishWorkflowEvents(t *testing.T) {
+	publisher := NewNoOpPublisher()
+	ctx := context.Background()
+
+	if err := PublishWorkflowEvent(ctx, publisher, EventTypeWorkflowCreated, "workflow-123"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := PublishWorkflowNodeStarted(ctx, publisher, "workflow-123", "fetch", "task-123"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := PublishWorkflowNodeFinished(ctx, publisher, "workflow-123", "fetch", "task-123", "completed"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if err := PublishWorkflowNodeSkipped(ctx, publisher, "workflow-123", "notify", "dependency failed"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
 func TestPublishCustomEvent(t *testing.T) {
 	publisher := NewNoOpPublisher()
 	ctx := context.Background()
@@ -250,3 +344,78 @@ type testError struct {
 func (e *testError) Error() string {
 	return e.message
 }
+
+// recordingPublisher keeps the events it is given
+type recordingPublisher struct {
+	events []Event
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, event Event) error {
+	p.events = append(p.events, event)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error {
+	return nil
+}
+
+func TestPublishWithContextLabels(t *testing.T) {
+	publisher := &recordingPublisher{}
+	labels := map[string]string{"env": "prod"}
+	ctx := ContextWithLabels(context.Background(), labels)
+
+	if err := PublishTaskStarted(ctx, publisher, "task-123"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := PublishTaskStarted(context.Background(), publisher, "task-456"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if got := publisher.events[0].Labels["env"]; got != "prod" {
+		t.Errorf("Expected label env 'prod', got '%s'", got)
+	}
+	if publisher.events[1].Labels != nil {
+		t.Errorf("Expected no labels, got %v", publisher.events[1].Labels)
+	}
+
+	// Events get a copy of the labels
+	labels["env"] = "dev"
+	if got := publisher.events[0].Labels["env"]; got != "prod" {
+		t.Errorf("Expected label env 'prod' after the labels changed, got '%s'", got)
+	}
+
+	// Labels set on the event take precedence over the context
+	event := NewEventBuilder("test.type").WithLabels(map[string]string{"team": "a"}).Build()
+	if err := publish(ctx, publisher, event); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := publisher.events[2].Labels; len(got) != 1 || got["team"] != "a" {
+		t.Errorf("Expected the event's own labels, got %v", got)
+	}
+}
+
+func TestPublishWithContextTaskType(t *testing.T) {
+	publisher := &recordingPublisher{}
+	ctx := ContextWithTaskType(context.Background(), "echo")
+
+	if err := PublishTaskStarted(ctx, publisher, "task-123"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := PublishTaskCreated(ctx, publisher, "task-456", "math", false); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := PublishTaskStarted(context.Background(), publisher, "task-789"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if got := publisher.events[0].Data["task_type"]; got != "echo" {
+		t.Errorf("Expected task_type 'echo', got %v", got)
+	}
+	// The task type in the event data takes precedence over the context
+	if got := publisher.events[1].Data["task_type"]; got != "math" {
+		t.Errorf("Expected task_type 'math', got %v", got)
+	}
+	if _, exists := publisher.events[2].Data["task_type"]; exists {
+		t.Errorf("Expected no task_type, got %v", publisher.events[2].Data)
+	}
+}
@@ -0,0 +1,243 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go-fred/internal/backoff"
+	"go-fred/internal/config"
+)
+
+// Queueing and retries of every route, unless configured
+const (
+	defaultRouteQueueSize      = 1000
+	defaultRouteMaxAttempts    = 5
+	defaultRouteDelay          = time.Second
+	defaultRouteMaxDelay       = time.Minute
+	defaultRoutePublishTimeout = 10 * time.Second
+)
+
+// FanOutPublisher hands every event to the publishers whose routing rules
+// match it. Each publisher has its own queue and sender that retries failed
+// events with exponential backoff, so a broken or slow publisher does not
+// hold up the others. Behind the outbox, the outbox publishes to every
+// publisher itself through Routes, and the queues stay empty.
+type FanOutPublisher struct {
+	routes []*route
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// route sends the events matching its rules to one publisher
+type route struct {
+	name        string
+	publisher   Publisher
+	include     []config.EventRule
+	exclude     []config.EventRule
+	queue       chan Event
+	maxAttempts int
+	delay       time.Duration
+	maxDelay    time.Duration
+	closing     chan struct{}
+}
+
+// NewFanOutPublisher creates the configured publishers and starts their
+// senders
+func NewFanOutPublisher(cfgs []config.PublisherConfig) (*FanOutPublisher, error) {
+	var routes []*route
+	for _, cfg := range cfgs {
+		var publisher Publisher
+		err := fmt.Errorf("no publisher type")
+		if cfg.Type != "" {
+			publisher, err = newPublisher(cfg)
+		}
+		if err != nil {
+			// Release the publishers created so far
+			for _, created := range routes {
+				created.publisher.Close()
+			}
+			return nil, fmt.Errorf("event publisher %s: %w", cfg.Name, err)
+		}
+		routes = append(routes, newRoute(cfg, publisher))
+	}
+	return newFanOutPublisher(routes), nil
+}
+
+// newFanOutPublisher starts the senders of the routes
+func newFanOutPublisher(routes []*route) *FanOutPublisher {
+	fanOut := &FanOutPublisher{routes: routes}
+	for _, r := range routes {
+		fanOut.wg.Add(1)
+		go func() {
+			defer fanOut.wg.Done()
+			r.run()
+		}()
+	}
+	return fanOut
+}
+
+// newRoute creates the route of a configured publisher
+func newRoute(cfg config.PublisherConfig, publisher Publisher) *route {
+	return &route{
+		name:        cfg.Name,
+		publisher:   publisher,
+		include:     cfg.Include,
+		exclude:     cfg.Exclude,
+		queue:       make(chan Event, config.IntOr(cfg.QueueSize, defaultRouteQueueSize)),
+		maxAttempts: config.IntOr(cfg.MaxAttempts, defaultRouteMaxAttempts),
+		delay:       config.DurationOr(cfg.DelayMs, time.Millisecond, defaultRouteDelay),
+		maxDelay:    config.DurationOr(cfg.MaxDelayMs, time.Millisecond, defaultRouteMaxDelay),
+		closing:     make(chan struct{}),
+	}
+}
+
+// Publish queues the event for every publisher whose rules match it. It does
+// not wait for the publishers, and fails if the queue of one of them is full.
+func (p *FanOutPublisher) Publish(ctx context.Context, event Event) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return fmt.Errorf("fan-out publisher is closed")
+	}
+
+	var errs []error
+	for _, r := range p.routes {
+		if !r.matches(event) {
+			continue
+		}
+		select {
+		case r.queue <- event:
+		default:
+			errs = append(errs, fmt.Errorf("queue of event publisher %s is full, dropping event %s", r.name, event.ID))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Routes returns a route for every publisher, with its routing rules and
+// retries. Publishers with routes of their own, such as webhook publishers,
+// are split into them, keeping their own retries and batching, the route
+// names being prefixed by the publisher name.
+func (p *FanOutPublisher) Routes() []Route {
+	var routes []Route
+	for _, r := range p.routes {
+		router, ok := r.publisher.(Router)
+		if !ok {
+			routes = append(routes, Route{
+				Name:        r.name,
+				Publisher:   r.publisher,
+				Matches:     r.matches,
+				MaxAttempts: r.maxAttempts,
+				Delay:       r.delay,
+				MaxDelay:    r.maxDelay,
+			})
+			continue
+		}
+		for _, inner := range router.Routes() {
+			route := inner
+			route.Name = r.name + "/" + inner.Name
+			route.Matches = func(event Event) bool {
+				return r.matches(event) && (inner.Matches == nil || inner.Matches(event))
+			}
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+// Close stops accepting events, waits for the queued ones to be published
+// and closes every publisher. Events still failing get one last attempt
+// instead of waiting for their backoff.
+func (p *FanOutPublisher) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, r := range p.routes {
+			close(r.closing)
+			close(r.queue)
+		}
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+
+	var errs []error
+	for _, r := range p.routes {
+		if err := r.publisher.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("event publisher %s: %w", r.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// matches reports whether the event is routed to the publisher
+func (r *route) matches(event Event) bool {
+	if len(r.include) > 0 && !matchesAnyRule(event, r.include) {
+		return false
+	}
+	return !matchesAnyRule(event, r.exclude)
+}
+
+// run publishes the queued events in order until the queue is closed
+func (r *route) run() {
+	for event := range r.queue {
+		r.publish(event)
+	}
+}
+
+// publish hands an event to the publisher until it is accepted or the
+// attempts run out
+func (r *route) publish(event Event) {
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), defaultRoutePublishTimeout)
+		err := r.publisher.Publish(ctx, event)
+		cancel()
+		if err == nil {
+			return
+		}
+		if attempt >= r.maxAttempts {
+			log.Printf("Dropping event %s for publisher %s after %d attempts: %v", event.ID, r.name, attempt, err)
+			return
+		}
+
+		timer := time.NewTimer(backoff.Exponential(r.delay, r.maxDelay, attempt))
+		select {
+		case <-timer.C:
+		case <-r.closing:
+			// Shutting down: make one last attempt right away
+			timer.Stop()
+			attempt = max(attempt, r.maxAttempts-1)
+		}
+	}
+}
+
+// matchesAnyRule reports whether the event matches one of the rules
+func matchesAnyRule(event Event, rules []config.EventRule) bool {
+	for _, rule := range rules {
+		if matchesRule(event, rule) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesRule reports whether the event type matches one of the types of the
+// rule and its data has every value of the rule
+func matchesRule(event Event, rule config.EventRule) bool {
+	if !MatchesEventType(event.Type, rule.Types) {
+		return false
+	}
+	for key, value := range rule.Data {
+		data, exists := event.Data[key]
+		if !exists || fmt.Sprint(data) != value {
+			return false
+		}
+	}
+	return true
+}
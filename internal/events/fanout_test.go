@@ -0,0 +1,300 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go-fred/internal/config"
+)
+
+// sinkPublisher records the events it accepts. It rejects the first
+// failures events, and blocks while blocked is open.
+type sinkPublisher struct {
+	mu       sync.Mutex
+	failures int
+	blocked  chan struct{}
+	events   []Event
+	calls    int
+	closed   bool
+}
+
+func (p *sinkPublisher) Publish(ctx context.Context, event Event) error {
+	if p.blocked != nil {
+		<-p.blocked
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("sink unavailable")
+	}
+	p.events = append(p.events, event)
+	return nil
+}
+
+func (p *sinkPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func (p *sinkPublisher) attempts() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+func (p *sinkPublisher) types() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	types := make([]string, len(p.events))
+	for i, event := range p.events {
+		types[i] = event.Type
+	}
+	return types
+}
+
+func waitForTypes(t *testing.T, sink *sinkPublisher, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.types()) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d events, got %v", n, sink.types())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return sink.types()
+}
+
+func TestFanOutPublisherRouting(t *testing.T) {
+	all := &sinkPublisher{}
+	alerts := &sinkPublisher{}
+	fanOut := newFanOutPublisher([]*route{
+		newRoute(config.PublisherConfig{Name: "analytics", Exclude: []config.EventRule{{Types: []string{"schedule.*"}}}}, all),
+		newRoute(config.PublisherConfig{
+			Name: "alerts",
+			Include: []config.EventRule{
+				{Types: []string{EventTypeTaskFailed, EventTypeTaskTimedOut}},
+				{Types: []string{"workflow.*"}, Data: map[string]string{"status": "failed"}},
+			},
+		}, alerts),
+	})
+
+	publishEvents(t, fanOut, EventTypeTaskCreated, EventTypeTaskFailed, EventTypeScheduleCreated)
+	for _, status := range []string{"completed", "failed"} {
+		event := NewEventBuilder(EventTypeWorkflowNodeFinished).WithData("status", status).Build()
+		if err := fanOut.Publish(context.Background(), event); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := fanOut.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if got := all.types(); len(got) != 4 || got[0] != EventTypeTaskCreated || got[2] != EventTypeWorkflowNodeFinished {
+		t.Errorf("Expected every event but the schedule event, got %v", got)
+	}
+	if got := alerts.types(); len(got) != 2 || got[0] != EventTypeTaskFailed || got[1] != EventTypeWorkflowNodeFinished {
+		t.Errorf("Expected the failure events, got %v", got)
+	}
+	if alerts.events[1].Data["status"] != "failed" {
+		t.Errorf("Expected the failed workflow node, got %v", alerts.events[1].Data)
+	}
+	if !all.closed || !alerts.closed {
+		t.Error("Expected every publisher to be closed")
+	}
+}
+
+func TestFanOutPublisherIsolatesFailures(t *testing.T) {
+	stuck := &sinkPublisher{blocked: make(chan struct{})}
+	flaky := &sinkPublisher{failures: 2}
+	healthy := &sinkPublisher{}
+	fanOut := newFanOutPublisher([]*route{
+		newRoute(config.PublisherConfig{Name: "stuck"}, stuck),
+		newRoute(config.PublisherConfig{Name: "flaky", DelayMs: 5}, flaky),
+		newRoute(config.PublisherConfig{Name: "healthy"}, healthy),
+	})
+
+	publishEvents(t, fanOut, "a", "b")
+
+	// The healthy publisher gets the events while the others are stuck or
+	// failing, and the flaky one gets them once it recovers, in order
+	if got := waitForTypes(t, healthy, 2); got[0] != "a" || got[1] != "b" {
+		t.Errorf("Expected [a b], got %v", got)
+	}
+	if got := waitForTypes(t, flaky, 2); got[0] != "a" || got[1] != "b" {
+		t.Errorf("Expected [a b] after the retries, got %v", got)
+	}
+	if len(stuck.types()) != 0 {
+		t.Errorf("Expected the stuck publisher to have no events, got %v", stuck.types())
+	}
+
+	close(stuck.blocked)
+	if err := fanOut.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := stuck.types(); len(got) != 2 {
+		t.Errorf("Expected the stuck publisher to catch up on close, got %v", got)
+	}
+}
+
+func TestFanOutPublisherGivesUp(t *testing.T) {
+	broken := &sinkPublisher{failures: 100}
+	fanOut := newFanOutPublisher([]*route{
+		newRoute(config.PublisherConfig{Name: "broken", MaxAttempts: 3, DelayMs: 1}, broken),
+	})
+
+	publishEvents(t, fanOut, "a", "b")
+	deadline := time.Now().Add(5 * time.Second)
+	for broken.attempts() < 6 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := fanOut.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := broken.attempts(); got != 6 {
+		t.Errorf("Expected 3 attempts per event, got %d", got)
+	}
+	if len(broken.types()) != 0 {
+		t.Errorf("Expected no events, got %v", broken.types())
+	}
+}
+
+func TestFanOutPublisherQueueFull(t *testing.T) {
+	stuck := &sinkPublisher{blocked: make(chan struct{})}
+	fanOut := newFanOutPublisher([]*route{
+		newRoute(config.PublisherConfig{Name: "stuck", QueueSize: 1}, stuck),
+	})
+	defer fanOut.Close()
+	defer close(stuck.blocked)
+
+	// One event is being published and one waits in the queue
+	publishEvents(t, fanOut, "a")
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fanOut.routes[0].queue) != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	publishEvents(t, fanOut, "b")
+
+	if err := fanOut.Publish(context.Background(), NewEventBuilder("c").Build()); err == nil {
+		t.Error("Expected error when the queue of a publisher is full")
+	}
+}
+
+func TestFanOutPublisherRoutes(t *testing.T) {
+	all := &sinkPublisher{}
+	alerts := &sinkPublisher{}
+	fanOut := newFanOutPublisher([]*route{
+		newRoute(config.PublisherConfig{Name: "analytics"}, all),
+		newRoute(config.PublisherConfig{
+			Name:        "alerts",
+			Include:     []config.EventRule{{Types: []string{EventTypeTaskFailed}}},
+			MaxAttempts: 3,
+			DelayMs:     10,
+		}, alerts),
+	})
+	defer fanOut.Close()
+
+	// Every publisher is a route with its rules and retries
+	routes := fanOut.Routes()
+	if len(routes) != 2 || routes[0].Name != "analytics" || routes[1].Publisher != alerts {
+		t.Fatalf("Expected the analytics and alerts routes, got %+v", routes)
+	}
+	if routes[0].MaxAttempts != defaultRouteMaxAttempts || routes[1].MaxAttempts != 3 || routes[1].Delay != 10*time.Millisecond {
+		t.Errorf("Expected the retries of the publishers, got %+v", routes)
+	}
+	created := NewEventBuilder(EventTypeTaskCreated).Build()
+	if !routes[0].Matches(created) || routes[1].Matches(created) {
+		t.Error("Expected the task.created event to go to analytics only")
+	}
+}
+
+func TestFanOutPublisherNestedRoutes(t *testing.T) {
+	webhook, err := NewWebhookPublisher(config.WebhookPublisherConfig{
+		Endpoints: []config.WebhookEndpoint{
+			{URL: "http://localhost:9999/tasks", EventTypes: []string{"task.*"}},
+			{URL: "http://localhost:9999/all"},
+		},
+		BatchSize: 5,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	fanOut := newFanOutPublisher([]*route{
+		newRoute(config.PublisherConfig{
+			Name:    "hooks",
+			Exclude: []config.EventRule{{Types: []string{EventTypeTaskStarted}}},
+		}, webhook),
+	})
+	defer fanOut.Close()
+
+	// The endpoints of a webhook publisher are routes of their own
+	routes := fanOut.Routes()
+	if len(routes) != 2 || routes[0].Name != "hooks/http://localhost:9999/tasks" || routes[1].Name != "hooks/http://localhost:9999/all" {
+		t.Fatalf("Expected a route for every endpoint, got %+v", routes)
+	}
+	if routes[0].BatchSize != 5 {
+		t.Errorf("Expected the batch size of the endpoint, got %d", routes[0].BatchSize)
+	}
+
+	// Events go to an endpoint when both the publisher and the endpoint take them
+	tests := []struct {
+		eventType string
+		tasks     bool
+		all       bool
+	}{
+		{eventType: EventTypeTaskCreated, tasks: true, all: true},
+		{eventType: EventTypeTaskStarted, tasks: false, all: false},
+		{eventType: EventTypeScheduleCreated, tasks: false, all: true},
+	}
+	for _, tt := range tests {
+		event := NewEventBuilder(tt.eventType).Build()
+		if routes[0].Matches(event) != tt.tasks || routes[1].Matches(event) != tt.all {
+			t.Errorf("Expected %s to go to tasks=%v all=%v", tt.eventType, tt.tasks, tt.all)
+		}
+	}
+}
+
+func TestNewFanOutPublisher(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	publisher, err := NewPublisher(&config.EventsConfig{
+		Publisher: "kafka",
+		Publishers: []config.PublisherConfig{
+			{Name: "log", Type: "noop"},
+			{Name: "alerts", Type: "webhook", Webhook: config.WebhookPublisherConfig{
+				Endpoints: []config.WebhookEndpoint{{URL: server.URL}},
+			}, Include: []config.EventRule{{Types: []string{EventTypeTaskFailed}}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	publishEvents(t, publisher, EventTypeTaskCreated, EventTypeTaskFailed)
+	if err := publisher.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := receiver.eventTypes(); len(got) != 1 || got[0] != EventTypeTaskFailed {
+		t.Errorf("Expected the webhook to get the task.failed event, got %v", got)
+	}
+
+	invalid := [][]config.PublisherConfig{
+		{{Name: "untyped"}},
+		{{Name: "log", Type: "noop"}, {Name: "kafka", Type: "kafka"}},
+		{{Name: "unknown", Type: "carrier-pigeon"}},
+	}
+	for _, cfgs := range invalid {
+		if _, err := NewFanOutPublisher(cfgs); err == nil {
+			t.Errorf("Expected error for %+v", cfgs)
+		}
+	}
+}
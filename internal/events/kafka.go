@@ -0,0 +1,304 @@
+package events
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go-fred/internal/config"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// Headers added to every Kafka message, whatever its format
+const (
+	KafkaHeaderEventID   = "event-id"
+	KafkaHeaderEventType = "event-type"
+	KafkaHeaderTaskType  = "task-type"
+)
+
+// Batching of the Kafka writer when the configuration does not set it. A
+// synchronous Publish waits for its batch to be written, so by default it
+// writes every message on its own rather than waiting for the batch timeout;
+// asynchronous writes are batched.
+const (
+	defaultKafkaBatchSize      = 1
+	defaultKafkaAsyncBatchSize = 100
+	defaultKafkaBatchTimeout   = 10 * time.Millisecond
+)
+
+// KafkaErrorHandler is called with the IDs of the events an asynchronous
+// write failed for
+type KafkaErrorHandler func(eventIDs []string, err error)
+
+// KafkaPublisher publishes events to Kafka. Messages are keyed by the ID of
+// the task the event is about, so that the events of a task go to the same
+// partition and are consumed in order. In async mode Publish returns without
+// waiting for the brokers, and failed writes are reported to the error
+// handler instead.
+type KafkaPublisher struct {
+	writer *kafka.Writer
+	topic  string
+	format string
+
+	mu      sync.RWMutex
+	onError KafkaErrorHandler
+}
+
+// NewKafkaPublisher creates a new Kafka publisher
+func NewKafkaPublisher(cfg config.KafkaConfig) (*KafkaPublisher, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, fmt.Errorf("kafka brokers not configured")
+	}
+	if cfg.Topic == "" {
+		return nil, fmt.Errorf("kafka topic not configured")
+	}
+	if err := validateFormat(cfg.Format, true); err != nil {
+		return nil, err
+	}
+
+	requiredAcks, err := kafkaRequiredAcks(cfg.RequiredAcks)
+	if err != nil {
+		return nil, err
+	}
+	compression, err := kafkaCompression(cfg.Compression)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := NewKafkaTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	mechanism, err := NewKafkaSASLMechanism(cfg.SASL)
+	if err != nil {
+		return nil, err
+	}
+
+	batchSize := defaultKafkaBatchSize
+	if cfg.Async {
+		batchSize = defaultKafkaAsyncBatchSize
+	}
+
+	publisher := &KafkaPublisher{
+		topic:   cfg.Topic,
+		format:  cfg.Format,
+		onError: logKafkaError,
+	}
+	publisher.writer = &kafka.Writer{
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Topic:        cfg.Topic,
+		Balancer:     &kafka.Murmur2Balancer{},
+		BatchSize:    config.IntOr(cfg.BatchSize, batchSize),
+		BatchTimeout: config.DurationOr(cfg.BatchTimeoutMs, time.Millisecond, defaultKafkaBatchTimeout),
+		RequiredAcks: requiredAcks,
+		Async:        cfg.Async,
+		Compression:  compression,
+		Transport: &kafka.Transport{
+			ClientID: cfg.ClientID,
+			TLS:      tlsConfig,
+			SASL:     mechanism,
+		},
+	}
+	if cfg.Async {
+		publisher.writer.Completion = publisher.completed
+	}
+	return publisher, nil
+}
+
+// OnError sets the handler of failed asynchronous writes, which by default
+// are logged
+func (p *KafkaPublisher) OnError(handler KafkaErrorHandler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.onError = handler
+}
+
+// Publish sends the event to Kafka
+func (p *KafkaPublisher) Publish(ctx context.Context, event Event) error {
+	message, err := p.message(event)
+	if err != nil {
+		return err
+	}
+
+	if err := p.writer.WriteMessages(ctx, message); err != nil {
+		return fmt.Errorf("failed to write message to kafka: %w", err)
+	}
+	return nil
+}
+
+// Close flushes the pending messages and closes the Kafka writer
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
+
+// message encodes an event in the format of the publisher. In binary mode
+// the value is the event data and the CloudEvents attributes are "ce_"
+// headers; otherwise the value is the whole event.
+func (p *KafkaPublisher) message(event Event) (kafka.Message, error) {
+	message := kafka.Message{
+		Key:  []byte(messageKey(event)),
+		Time: event.Timestamp,
+		Headers: []kafka.Header{
+			{Key: KafkaHeaderEventID, Value: []byte(event.ID)},
+			{Key: KafkaHeaderEventType, Value: []byte(event.Type)},
+		},
+	}
+	if taskType, ok := event.Data["task_type"].(string); ok {
+		message.Headers = append(message.Headers, kafka.Header{Key: KafkaHeaderTaskType, Value: []byte(taskType)})
+	}
+
+	var err error
+	switch p.format {
+	case FormatCloudEventsBinary:
+		cloudEvent := NewCloudEvent(event)
+		message.Value, err = json.Marshal(cloudEvent.Data)
+		var headers []kafka.Header
+		for name, value := range cloudEvent.Attributes() {
+			if name == "datacontenttype" {
+				// Binary mode carries it as the content type of the message
+				name = "content-type"
+			} else {
+				name = "ce_" + name
+			}
+			headers = append(headers, kafka.Header{Key: name, Value: []byte(value)})
+		}
+		sort.Slice(headers, func(i, j int) bool {
+			return headers[i].Key < headers[j].Key
+		})
+		message.Headers = append(message.Headers, headers...)
+	case FormatCloudEvents:
+		message.Value, err = marshalEvent(event, p.format)
+		message.Headers = append(message.Headers, kafka.Header{Key: "content-type", Value: []byte(CloudEventsContentType)})
+	default:
+		message.Value, err = marshalEvent(event, p.format)
+	}
+	if err != nil {
+		return kafka.Message{}, fmt.Errorf("failed to marshal event: %w", err)
+	}
+	return message, nil
+}
+
+// completed reports the failed asynchronous writes to the error handler
+func (p *KafkaPublisher) completed(messages []kafka.Message, err error) {
+	if err == nil {
+		return
+	}
+
+	eventIDs := make([]string, 0, len(messages))
+	for _, message := range messages {
+		for _, header := range message.Headers {
+			if header.Key == KafkaHeaderEventID {
+				eventIDs = append(eventIDs, string(header.Value))
+			}
+		}
+	}
+
+	p.mu.RLock()
+	onError := p.onError
+	p.mu.RUnlock()
+	onError(eventIDs, err)
+}
+
+// logKafkaError is the default handler of failed asynchronous writes
+func logKafkaError(eventIDs []string, err error) {
+	log.Printf("Failed to write %d events to kafka: %v", len(eventIDs), err)
+}
+
+// messageKey returns the key of the message of an event: the ID of the task
+// it is about, or else of its workflow or schedule, or else of the event
+func messageKey(event Event) string {
+	for _, field := range []string{"task_id", "workflow_id", "schedule_id"} {
+		if id, ok := event.Data[field].(string); ok && id != "" {
+			return id
+		}
+	}
+	return event.ID
+}
+
+// kafkaRequiredAcks parses the acknowledgements a write waits for
+func kafkaRequiredAcks(acks string) (kafka.RequiredAcks, error) {
+	switch acks {
+	case "", "all":
+		return kafka.RequireAll, nil
+	case "leader":
+		return kafka.RequireOne, nil
+	case "none":
+		return kafka.RequireNone, nil
+	default:
+		return 0, fmt.Errorf("unsupported kafka required acks: %s", acks)
+	}
+}
+
+// kafkaCompression parses the compression codec of the messages
+func kafkaCompression(codec string) (kafka.Compression, error) {
+	switch codec {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafka.Gzip, nil
+	case "snappy":
+		return kafka.Snappy, nil
+	case "lz4":
+		return kafka.Lz4, nil
+	case "zstd":
+		return kafka.Zstd, nil
+	default:
+		return 0, fmt.Errorf("unsupported kafka compression: %s", codec)
+	}
+}
+
+// NewKafkaTLSConfig loads the TLS configuration of Kafka connections, or
+// returns nil for plaintext connections
+func NewKafkaTLSConfig(cfg config.KafkaTLSConfig) (*tls.Config, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		caPEM, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read kafka CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in kafka CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load kafka client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+// NewKafkaSASLMechanism returns the SASL mechanism authenticating Kafka
+// connections, or nil if they are not authenticated
+func NewKafkaSASLMechanism(cfg config.KafkaSASLConfig) (sasl.Mechanism, error) {
+	switch cfg.Mechanism {
+	case "":
+		return nil, nil
+	case "plain":
+		return plain.Mechanism{Username: cfg.Username, Password: cfg.Password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, cfg.Username, cfg.Password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, cfg.Username, cfg.Password)
+	default:
+		return nil, fmt.Errorf("unsupported kafka SASL mechanism: %s", cfg.Mechanism)
+	}
+}
@@ -0,0 +1,235 @@
+package events
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-fred/internal/config"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// writeCertificate writes a self-signed certificate and its key to PEM files
+func writeCertificate(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "go-fred"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestNewKafkaPublisherSettings(t *testing.T) {
+	certFile, keyFile := writeCertificate(t)
+
+	publisher, err := NewKafkaPublisher(config.KafkaConfig{
+		Brokers:        []string{"localhost:9093"},
+		Topic:          "events",
+		ClientID:       "go-fred-test",
+		RequiredAcks:   "leader",
+		BatchSize:      50,
+		BatchTimeoutMs: 20,
+		Async:          true,
+		Compression:    "zstd",
+		TLS:            config.KafkaTLSConfig{Enabled: true, CAFile: certFile, CertFile: certFile, KeyFile: keyFile},
+		SASL:           config.KafkaSASLConfig{Mechanism: "plain", Username: "fred", Password: "secret"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+
+	writer := publisher.writer
+	if writer.RequiredAcks != kafka.RequireOne || writer.BatchSize != 50 || writer.BatchTimeout != 20*time.Millisecond {
+		t.Errorf("Unexpected acks, batch size or batch timeout: %v, %d, %v", writer.RequiredAcks, writer.BatchSize, writer.BatchTimeout)
+	}
+	if !writer.Async || writer.Completion == nil || writer.Compression != kafka.Zstd {
+		t.Errorf("Expected async zstd writes with a completion handler, got %+v", writer)
+	}
+	transport := writer.Transport.(*kafka.Transport)
+	if transport.ClientID != "go-fred-test" {
+		t.Errorf("Expected client ID 'go-fred-test', got '%s'", transport.ClientID)
+	}
+	if transport.TLS == nil || transport.TLS.RootCAs == nil || len(transport.TLS.Certificates) != 1 {
+		t.Errorf("Expected TLS with the CA and client certificate, got %+v", transport.TLS)
+	}
+	if mechanism, ok := transport.SASL.(plain.Mechanism); !ok || mechanism.Username != "fred" {
+		t.Errorf("Expected SASL PLAIN for 'fred', got %+v", transport.SASL)
+	}
+
+	// Defaults wait for all replicas, write every message on its own and
+	// leave connections plaintext
+	publisher, err = NewKafkaPublisher(config.KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "events"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+
+	writer = publisher.writer
+	if writer.RequiredAcks != kafka.RequireAll || writer.BatchSize != 1 || writer.Async || writer.Completion != nil {
+		t.Errorf("Unexpected defaults %+v", writer)
+	}
+	transport = writer.Transport.(*kafka.Transport)
+	if transport.TLS != nil || transport.SASL != nil {
+		t.Errorf("Expected plaintext connections, got %+v", transport)
+	}
+
+	// Asynchronous writes are batched by default
+	publisher, err = NewKafkaPublisher(config.KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "events", Async: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+	if writer := publisher.writer; writer.BatchSize != 100 || !writer.Async {
+		t.Errorf("Expected async batches of 100, got %d (async %v)", writer.BatchSize, writer.Async)
+	}
+
+	publisher, err = NewKafkaPublisher(config.KafkaConfig{
+		Brokers: []string{"localhost:9092"},
+		Topic:   "events",
+		SASL:    config.KafkaSASLConfig{Mechanism: "scram-sha-512", Username: "fred", Password: "secret"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+	if name := publisher.writer.Transport.(*kafka.Transport).SASL.Name(); name != "SCRAM-SHA-512" {
+		t.Errorf("Expected SCRAM-SHA-512, got %s", name)
+	}
+}
+
+func TestNewKafkaPublisherInvalidSettings(t *testing.T) {
+	invalid := []config.KafkaConfig{
+		{RequiredAcks: "some"},
+		{Compression: "brotli"},
+		{SASL: config.KafkaSASLConfig{Mechanism: "oauthbearer"}},
+		{TLS: config.KafkaTLSConfig{Enabled: true, CAFile: filepath.Join(t.TempDir(), "missing.pem")}},
+		{TLS: config.KafkaTLSConfig{Enabled: true, CertFile: filepath.Join(t.TempDir(), "missing.pem")}},
+	}
+
+	for _, cfg := range invalid {
+		cfg.Brokers = []string{"localhost:9092"}
+		cfg.Topic = "events"
+		if _, err := NewKafkaPublisher(cfg); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestKafkaPublisherKeysAndHeaders(t *testing.T) {
+	publisher, err := NewKafkaPublisher(config.KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "events"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+
+	ctx := ContextWithTaskType(context.Background(), "echo")
+	recorder := &recordingPublisher{}
+	PublishTaskStarted(ctx, recorder, "task-1")
+	PublishTaskCompleted(ctx, recorder, "task-1", time.Second, nil)
+	PublishScheduleEvent(context.Background(), recorder, EventTypeScheduleCreated, "schedule-1")
+	custom := NewEventBuilder("custom").Build()
+
+	for i, event := range append(recorder.events, custom) {
+		message, err := publisher.message(event)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		headers := make(map[string]string)
+		for _, header := range message.Headers {
+			headers[header.Key] = string(header.Value)
+		}
+		if headers[KafkaHeaderEventID] != event.ID || headers[KafkaHeaderEventType] != event.Type {
+			t.Errorf("Expected the event ID and type headers, got %v", headers)
+		}
+
+		switch i {
+		case 0, 1:
+			// Every event of a task goes to the partition of the task
+			if string(message.Key) != "task-1" || headers[KafkaHeaderTaskType] != "echo" {
+				t.Errorf("Expected key 'task-1' and task type 'echo', got '%s' and %v", message.Key, headers)
+			}
+		case 2:
+			if string(message.Key) != "schedule-1" {
+				t.Errorf("Expected key 'schedule-1', got '%s'", message.Key)
+			}
+		case 3:
+			if string(message.Key) != event.ID {
+				t.Errorf("Expected the event ID as key, got '%s'", message.Key)
+			}
+			if _, exists := headers[KafkaHeaderTaskType]; exists {
+				t.Error("Expected no task type header")
+			}
+		}
+	}
+}
+
+func TestKafkaPublisherAsyncErrors(t *testing.T) {
+	publisher, err := NewKafkaPublisher(config.KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "events", Async: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+
+	var failedIDs []string
+	var failure error
+	publisher.OnError(func(eventIDs []string, err error) {
+		failedIDs, failure = eventIDs, err
+	})
+
+	first, _ := publisher.message(NewEventBuilder(EventTypeTaskCreated).Build())
+	second, _ := publisher.message(NewEventBuilder(EventTypeTaskStarted).Build())
+	publisher.writer.Completion([]kafka.Message{first, second}, nil)
+	if failedIDs != nil {
+		t.Errorf("Expected successful writes not to be reported, got %v", failedIDs)
+	}
+
+	brokerErr := errors.New("broker unavailable")
+	publisher.writer.Completion([]kafka.Message{first, second}, brokerErr)
+	if len(failedIDs) != 2 || failedIDs[0] != string(headerValue(first, KafkaHeaderEventID)) || failure != brokerErr {
+		t.Errorf("Expected both events reported with the error, got %v and %v", failedIDs, failure)
+	}
+}
+
+func headerValue(message kafka.Message, key string) []byte {
+	for _, header := range message.Headers {
+		if header.Key == key {
+			return header.Value
+		}
+	}
+	return nil
+}
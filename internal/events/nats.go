@@ -0,0 +1,209 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go-fred/internal/config"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Headers added to every NATS message, whatever its format
+const (
+	NATSHeaderEventID   = "event-id"
+	NATSHeaderEventType = "event-type"
+	NATSHeaderTaskType  = "task-type"
+)
+
+// Subject template, publish acknowledgement timeout and reconnect wait,
+// unless configured
+const (
+	defaultNATSSubject       = "fred.{category}.{type}.{event}"
+	defaultNATSAckTimeout    = 5 * time.Second
+	defaultNATSReconnectWait = 2 * time.Second
+)
+
+// NATSPublisher publishes events to NATS subjects rendered from a template,
+// so that subscribers can pick events by task type or event type with
+// wildcards. Core NATS publishes return once the message is buffered; with
+// JetStream they wait until the stream stored the message, which is
+// deduplicated on the event ID. The connection is reestablished in the
+// background, and messages published in the meantime are buffered.
+type NATSPublisher struct {
+	conn    *nats.Conn
+	js      jetstream.JetStream
+	subject string
+	stream  string
+	format  string
+}
+
+// NewNATSPublisher creates a new NATS publisher. The publisher is created
+// even if the servers cannot be reached yet.
+func NewNATSPublisher(cfg config.NATSConfig) (*NATSPublisher, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, fmt.Errorf("nats urls not configured")
+	}
+	if err := validateFormat(cfg.Format, false); err != nil {
+		return nil, err
+	}
+
+	publisher := &NATSPublisher{
+		subject: cfg.Subject,
+		stream:  cfg.Stream,
+		format:  cfg.Format,
+	}
+	if publisher.subject == "" {
+		publisher.subject = defaultNATSSubject
+	}
+	sample := NewEventBuilder(EventTypeTaskCreated).WithData("task_type", "echo").Build()
+	if subject := publisher.subjectFor(sample); !validNATSSubject(subject) {
+		return nil, fmt.Errorf("invalid nats subject: %s", publisher.subject)
+	}
+
+	// Reconnect for as long as it takes unless limited
+	maxReconnects := -1
+	if cfg.MaxReconnects > 0 {
+		maxReconnects = cfg.MaxReconnects
+	}
+	options := []nats.Option{
+		nats.Name(cfg.ClientName),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(maxReconnects),
+		nats.ReconnectWait(config.DurationOr(cfg.ReconnectWaitMs, time.Millisecond, defaultNATSReconnectWait)),
+		nats.DisconnectErrHandler(func(conn *nats.Conn, err error) {
+			if err != nil {
+				log.Printf("Disconnected from nats: %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			log.Printf("Reconnected to nats at %s", conn.ConnectedUrlRedacted())
+		}),
+		nats.ClosedHandler(func(conn *nats.Conn) {
+			if err := conn.LastError(); err != nil {
+				log.Printf("Nats connection closed: %v", err)
+			}
+		}),
+		nats.ErrorHandler(func(conn *nats.Conn, subscription *nats.Subscription, err error) {
+			log.Printf("Nats error: %v", err)
+		}),
+	}
+	if cfg.Username != "" {
+		options = append(options, nats.UserInfo(cfg.Username, cfg.Password))
+	}
+	if cfg.Token != "" {
+		options = append(options, nats.Token(cfg.Token))
+	}
+	if cfg.CredentialsFile != "" {
+		options = append(options, nats.UserCredentials(cfg.CredentialsFile))
+	}
+
+	conn, err := nats.Connect(strings.Join(cfg.URLs, ","), options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to nats: %w", err)
+	}
+	publisher.conn = conn
+
+	if cfg.JetStream {
+		ackTimeout := config.DurationOr(cfg.AckTimeoutMs, time.Millisecond, defaultNATSAckTimeout)
+		publisher.js, err = jetstream.New(conn, jetstream.WithDefaultTimeout(ackTimeout))
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to create jetstream context: %w", err)
+		}
+	}
+	return publisher, nil
+}
+
+// Publish sends the event to the subject rendered for it
+func (p *NATSPublisher) Publish(ctx context.Context, event Event) error {
+	message, err := p.message(event)
+	if err != nil {
+		return err
+	}
+
+	if p.js == nil {
+		if err := p.conn.PublishMsg(message); err != nil {
+			return fmt.Errorf("failed to publish event to nats: %w", err)
+		}
+		return nil
+	}
+
+	options := []jetstream.PublishOpt{jetstream.WithMsgID(event.ID)}
+	if p.stream != "" {
+		options = append(options, jetstream.WithExpectStream(p.stream))
+	}
+	if _, err := p.js.PublishMsg(ctx, message, options...); err != nil {
+		return fmt.Errorf("failed to publish event to jetstream: %w", err)
+	}
+	return nil
+}
+
+// Close flushes the buffered messages and closes the connection. Messages
+// buffered while disconnected are dropped.
+func (p *NATSPublisher) Close() error {
+	p.conn.Close()
+	return nil
+}
+
+// message encodes an event in the format of the publisher
+func (p *NATSPublisher) message(event Event) (*nats.Msg, error) {
+	data, err := marshalEvent(event, p.format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	message := nats.NewMsg(p.subjectFor(event))
+	message.Data = data
+	message.Header.Set(NATSHeaderEventID, event.ID)
+	message.Header.Set(NATSHeaderEventType, event.Type)
+	if taskType, ok := event.Data["task_type"].(string); ok {
+		message.Header.Set(NATSHeaderTaskType, taskType)
+	}
+	if p.format == FormatCloudEvents {
+		message.Header.Set("content-type", CloudEventsContentType)
+	}
+	return message, nil
+}
+
+// subjectFor renders the subject template for an event. Values that would
+// not make a single subject token, such as the missing task type of
+// schedule events, are replaced by "_".
+func (p *NATSPublisher) subjectFor(event Event) string {
+	category, name, _ := strings.Cut(event.Type, ".")
+	taskType, _ := event.Data["task_type"].(string)
+	return strings.NewReplacer(
+		"{category}", natsToken(category),
+		"{event}", natsToken(name),
+		"{type}", natsToken(taskType),
+	).Replace(p.subject)
+}
+
+// natsToken turns a value into a single subject token
+func natsToken(value string) string {
+	if value == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// validNATSSubject reports whether a subject can be published to: it has no
+// empty tokens, wildcards or whitespace
+func validNATSSubject(subject string) bool {
+	for _, token := range strings.Split(subject, ".") {
+		if token == "" || token == "*" || token == ">" || strings.ContainsAny(token, " \t\r\n") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,286 @@
+//go:build natsserver
+
+// The tests against an embedded NATS server need the server module, which is
+// not a dependency of the service: add it with
+// go get github.com/nats-io/nats-server/v2 and run go test -tags natsserver.
+
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"go-fred/internal/config"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// jsErrCodeStreamNotMatch is the JetStream error code of a publish whose
+// expected stream is not the stream storing the subject
+const jsErrCodeStreamNotMatch jetstream.ErrorCode = 10060
+
+// runNATSServer starts an embedded NATS server with JetStream on the given
+// port, or on a free one for -1, keeping the streams in storeDir
+func runNATSServer(t *testing.T, port int, storeDir string) *server.Server {
+	t.Helper()
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      port,
+		JetStream: true,
+		StoreDir:  storeDir,
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create nats server: %v", err)
+	}
+	go srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		srv.Shutdown()
+		t.Fatal("Timed out waiting for the nats server")
+	}
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+// createNATSStream creates a stream storing everything published under fred
+func createNATSStream(t *testing.T, srv *server.Server, name string) jetstream.Stream {
+	t.Helper()
+	conn, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("Failed to connect to nats: %v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	stream, err := js.CreateStream(context.Background(), jetstream.StreamConfig{
+		Name:     name,
+		Subjects: []string{"fred.>"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create stream %s: %v", name, err)
+	}
+	return stream
+}
+
+// storedMessages returns the messages stored by a stream
+func storedMessages(t *testing.T, stream jetstream.Stream) []*jetstream.RawStreamMsg {
+	t.Helper()
+	info, err := stream.Info(context.Background())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var messages []*jetstream.RawStreamMsg
+	for seq := info.State.FirstSeq; seq > 0 && seq <= info.State.LastSeq; seq++ {
+		message, err := stream.GetMsg(context.Background(), seq)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func TestNATSPublisherSubjects(t *testing.T) {
+	srv := runNATSServer(t, -1, t.TempDir())
+	publisher, err := NewNATSPublisher(config.NATSConfig{
+		URLs:    []string{srv.ClientURL()},
+		Subject: "fred.tasks.{type}.{event}",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+
+	conn, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("Failed to connect to nats: %v", err)
+	}
+	defer conn.Close()
+	subscription, err := conn.SubscribeSync("fred.>")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := conn.Flush(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctx := ContextWithTaskType(context.Background(), "send.email")
+	PublishTaskCompleted(ctx, publisher, "task-1", time.Second, nil)
+	PublishScheduleEvent(context.Background(), publisher, EventTypeScheduleCreated, "schedule-1")
+	var messages []*nats.Msg
+	for range 2 {
+		message, err := subscription.NextMsg(5 * time.Second)
+		if err != nil {
+			t.Fatalf("Expected 2 messages, got %d: %v", len(messages), err)
+		}
+		messages = append(messages, message)
+	}
+
+	// Values are turned into single tokens, and missing ones into "_"
+	if messages[0].Subject != "fred.tasks.send_email.completed" || messages[1].Subject != "fred.tasks._.created" {
+		t.Errorf("Unexpected subjects %s and %s", messages[0].Subject, messages[1].Subject)
+	}
+	headers := messages[0].Header
+	if headers.Get(NATSHeaderEventType) != EventTypeTaskCompleted || headers.Get(NATSHeaderTaskType) != "send.email" || headers.Get(NATSHeaderEventID) == "" {
+		t.Errorf("Expected the event ID, event type and task type headers, got %v", headers)
+	}
+	var event Event
+	if err := json.Unmarshal(messages[0].Data, &event); err != nil || event.Data["task_id"] != "task-1" {
+		t.Errorf("Expected the native event, got %s", messages[0].Data)
+	}
+}
+
+func TestNATSPublisherJetStream(t *testing.T) {
+	srv := runNATSServer(t, -1, t.TempDir())
+	stream := createNATSStream(t, srv, "EVENTS")
+	publisher, err := NewNATSPublisher(config.NATSConfig{
+		URLs:      []string{srv.ClientURL()},
+		Format:    FormatCloudEvents,
+		JetStream: true,
+		Stream:    "EVENTS",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+
+	// Publish returns once the stream acknowledged the message
+	event := NewEventBuilder(EventTypeTaskCreated).WithTaskID("task-1").WithData("task_type", "echo").Build()
+	if err := publisher.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	messages := storedMessages(t, stream)
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 stored message, got %d", len(messages))
+	}
+
+	// A retried event is stored once, as the stream deduplicates on its ID
+	if err := publisher.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if messages := storedMessages(t, stream); len(messages) != 1 {
+		t.Fatalf("Expected 1 stored message after the retry, got %d", len(messages))
+	}
+
+	message := messages[0]
+	if message.Subject != "fred.task.echo.created" || message.Header.Get(jetstream.MsgIDHeader) != event.ID {
+		t.Errorf("Unexpected subject %s or headers %v", message.Subject, message.Header)
+	}
+	var cloudEvent CloudEvent
+	if err := json.Unmarshal(message.Data, &cloudEvent); err != nil || cloudEvent.ID != event.ID {
+		t.Errorf("Expected a structured CloudEvent, got %s", message.Data)
+	}
+	if got := message.Header.Get("content-type"); got != CloudEventsContentType {
+		t.Errorf("Expected content type %s, got %s", CloudEventsContentType, got)
+	}
+
+	// Another event is stored next to it
+	if err := publisher.Publish(context.Background(), NewEventBuilder(EventTypeTaskStarted).Build()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if messages := storedMessages(t, stream); len(messages) != 2 {
+		t.Errorf("Expected 2 stored messages, got %d", len(messages))
+	}
+}
+
+func TestNATSPublisherJetStreamErrors(t *testing.T) {
+	srv := runNATSServer(t, -1, t.TempDir())
+	stream := createNATSStream(t, srv, "EVENTS")
+	publisher, err := NewNATSPublisher(config.NATSConfig{
+		URLs:         []string{srv.ClientURL()},
+		JetStream:    true,
+		Stream:       "AUDIT",
+		AckTimeoutMs: 500,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+
+	// Rejected as the subject is stored by another stream
+	err = publisher.Publish(context.Background(), NewEventBuilder(EventTypeTaskCreated).Build())
+	var apiErr *jetstream.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode != jsErrCodeStreamNotMatch {
+		t.Errorf("Expected the stream mismatch error, got %v", err)
+	}
+	if messages := storedMessages(t, stream); len(messages) != 0 {
+		t.Errorf("Expected no stored message, got %d", len(messages))
+	}
+
+	// Not stored by any stream
+	publisher.subject = "audit.{category}.{event}"
+	publisher.stream = ""
+	if err := publisher.Publish(context.Background(), NewEventBuilder(EventTypeTaskCreated).Build()); !errors.Is(err, jetstream.ErrNoStreamResponse) {
+		t.Errorf("Expected %v, got %v", jetstream.ErrNoStreamResponse, err)
+	}
+}
+
+func TestNATSPublisherReconnects(t *testing.T) {
+	storeDir := t.TempDir()
+	srv := runNATSServer(t, -1, storeDir)
+	createNATSStream(t, srv, "EVENTS")
+	port := srv.Addr().(*net.TCPAddr).Port
+	publisher, err := NewNATSPublisher(config.NATSConfig{
+		URLs:            []string{srv.ClientURL()},
+		JetStream:       true,
+		ReconnectWaitMs: 10,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+
+	if err := publisher.Publish(context.Background(), NewEventBuilder(EventTypeTaskCreated).Build()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Events published while the server is down are stored once it is back
+	srv.Shutdown()
+	srv.WaitForShutdown()
+	for publisher.conn.IsConnected() {
+		time.Sleep(time.Millisecond)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- publisher.Publish(context.Background(), NewEventBuilder(EventTypeTaskStarted).Build())
+	}()
+	time.Sleep(50 * time.Millisecond)
+	srv = runNATSServer(t, port, storeDir)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the publish after the reconnect")
+	}
+
+	conn, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatalf("Failed to connect to nats: %v", err)
+	}
+	defer conn.Close()
+	js, err := jetstream.New(conn)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	stream, err := js.Stream(context.Background(), "EVENTS")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	messages := storedMessages(t, stream)
+	if len(messages) != 2 || messages[1].Header.Get(NATSHeaderEventType) != EventTypeTaskStarted {
+		t.Errorf("Expected both events, got %+v", messages)
+	}
+}
@@ -0,0 +1,28 @@
+package events
+
+import (
+	"testing"
+
+	"go-fred/internal/config"
+)
+
+func TestNewNATSPublisherInvalidSettings(t *testing.T) {
+	invalid := []config.NATSConfig{
+		{},
+		{URLs: []string{"nats://localhost:4222"}, Format: FormatCloudEventsBinary},
+		{URLs: []string{"nats://localhost:4222"}, Subject: "fred.>"},
+		{URLs: []string{"nats://localhost:4222"}, Subject: "fred..{event}"},
+	}
+	for _, cfg := range invalid {
+		if _, err := NewNATSPublisher(cfg); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+
+	// The servers do not need to be up yet
+	publisher, err := NewNATSPublisher(config.NATSConfig{URLs: []string{"nats://127.0.0.1:1"}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	publisher.Close()
+}
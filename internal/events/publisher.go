@@ -8,8 +8,6 @@ import (
 	"time"
 
 	"go-fred/internal/config"
-
-	"github.com/segmentio/kafka-go"
 )
 
 // Event represents a system event
@@ -19,6 +17,8 @@ type Event struct {
 	Timestamp time.Time              `json:"timestamp"`
 	Data      map[string]interface{} `json:"data"`
 	Source    string                 `json:"source"`
+	// Labels are the labels of the task the event is about
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 // Publisher defines the interface for event publishing
@@ -27,15 +27,91 @@ type Publisher interface {
 	Close() error
 }
 
-// NewPublisher creates a new event publisher based on configuration
+// Route is one of the destinations of a publisher delivering events to
+// several. The outbox publishes to every route on its own and keeps track of
+// the routes each event reached, so that a failing route neither holds up
+// the others nor makes them get the event again.
+type Route struct {
+	// Name identifies the route. It keys the events stored for the route,
+	// so it must stay the same across restarts.
+	Name      string
+	Publisher Publisher
+	// Matches reports whether an event goes to the route
+	Matches func(event Event) bool
+	// MaxAttempts, Delay and MaxDelay are the retries of the route
+	MaxAttempts int
+	Delay       time.Duration
+	MaxDelay    time.Duration
+	// BatchSize events at most are published at once when the publisher is
+	// a BatchPublisher, waiting up to BatchTimeout for a batch to fill up
+	BatchSize    int
+	BatchTimeout time.Duration
+}
+
+// BatchPublisher is implemented by publishers that publish several events
+// at once, accepting or rejecting them together
+type BatchPublisher interface {
+	PublishBatch(ctx context.Context, evts []Event) error
+}
+
+// Router is implemented by publishers delivering events to several routes,
+// so that the outbox can publish to the routes itself
+type Router interface {
+	Publisher
+	Routes() []Route
+}
+
+// NewPublisher creates a new event publisher based on configuration. The
+// outbox publishes to the publishers of a fan-out publisher and the endpoints
+// of a webhook publisher through their routes.
 func NewPublisher(cfg *config.EventsConfig) (Publisher, error) {
-	switch cfg.Publisher {
+	if len(cfg.Publishers) > 0 {
+		publisher, err := NewFanOutPublisher(cfg.Publishers)
+		if err != nil {
+			return nil, err
+		}
+		return publisher, nil
+	}
+	return newPublisher(config.PublisherConfig{
+		Type:    cfg.Publisher,
+		Kafka:   cfg.Kafka,
+		Webhook: cfg.Webhook,
+		NATS:    cfg.NATS,
+		Redis:   cfg.Redis,
+	})
+}
+
+// newPublisher creates a publisher of the configured type
+func newPublisher(cfg config.PublisherConfig) (Publisher, error) {
+	switch cfg.Type {
 	case "kafka":
-		return NewKafkaPublisher(cfg.Kafka)
+		publisher, err := NewKafkaPublisher(cfg.Kafka)
+		if err != nil {
+			return nil, err
+		}
+		return publisher, nil
+	case "webhook":
+		publisher, err := NewWebhookPublisher(cfg.Webhook)
+		if err != nil {
+			return nil, err
+		}
+		return publisher, nil
+	case "nats":
+		publisher, err := NewNATSPublisher(cfg.NATS)
+		if err != nil {
+			return nil, err
+		}
+		return publisher, nil
+	case "redis":
+		publisher, err := NewRedisPublisher(cfg.Redis)
+		if err != nil {
+			return nil, err
+		}
+		return publisher, nil
 	case "noop", "":
 		return NewNoOpPublisher(), nil
 	default:
-		return nil, fmt.Errorf("unsupported event publisher: %s", cfg.Publisher)
+		return nil, fmt.Errorf("unsupported event publisher: %s", cfg.Type)
 	}
 }
 
@@ -58,57 +134,3 @@ func (p *NoOpPublisher) Publish(ctx context.Context, event Event) error {
 func (p *NoOpPublisher) Close() error {
 	return nil
 }
-
-// KafkaPublisher publishes events to Kafka
-type KafkaPublisher struct {
-	writer *kafka.Writer
-	topic  string
-}
-
-// NewKafkaPublisher creates a new Kafka publisher
-func NewKafkaPublisher(cfg config.KafkaConfig) (*KafkaPublisher, error) {
-	if len(cfg.Brokers) == 0 {
-		return nil, fmt.Errorf("kafka brokers not configured")
-	}
-	if cfg.Topic == "" {
-		return nil, fmt.Errorf("kafka topic not configured")
-	}
-
-	writer := &kafka.Writer{
-		Addr:      kafka.TCP(cfg.Brokers...),
-		Topic:     cfg.Topic,
-		Balancer:  &kafka.LeastBytes{},
-		BatchSize: 1,
-	}
-
-	return &KafkaPublisher{
-		writer: writer,
-		topic:  cfg.Topic,
-	}, nil
-}
-
-// Publish sends the event to Kafka
-func (p *KafkaPublisher) Publish(ctx context.Context, event Event) error {
-	eventJSON, err := json.Marshal(event)
-	if err != nil {
-		return fmt.Errorf("failed to marshal event: %w", err)
-	}
-
-	message := kafka.Message{
-		Key:   []byte(event.ID),
-		Value: eventJSON,
-		Time:  event.Timestamp,
-	}
-
-	if err := p.writer.WriteMessages(ctx, message); err != nil {
-		return fmt.Errorf("failed to write message to kafka: %w", err)
-	}
-
-	log.Printf("Event published to Kafka: %s (topic: %s)", event.ID, p.topic)
-	return nil
-}
-
-// Close closes the Kafka writer
-func (p *KafkaPublisher) Close() error {
-	return p.writer.Close()
-}
@@ -0,0 +1,131 @@
+package events
+
+import (
+	"context"
+	"fmt"
+
+	"go-fred/internal/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Fields of every Redis stream entry, whatever its format
+const (
+	RedisFieldEventID     = "event-id"
+	RedisFieldEventType   = "event-type"
+	RedisFieldTaskType    = "task-type"
+	RedisFieldContentType = "content-type"
+	RedisFieldData        = "data"
+)
+
+// Stream the events are added to and its approximate length cap, unless
+// configured
+const (
+	defaultRedisStream = "fred:events"
+	defaultRedisMaxLen = 100000
+)
+
+// RedisPublisher appends events to a Redis stream with XADD, trimming the
+// stream so that it does not grow without bounds unless trimming is turned
+// off. Entries hold the encoded
+// event in their data field, next to the event ID and type and the task
+// type, so that consumers can skip events without decoding them.
+type RedisPublisher struct {
+	client *redis.Client
+	stream string
+	maxLen int64
+	approx bool
+	format string
+}
+
+// NewRedisPublisher creates a new Redis publisher
+func NewRedisPublisher(cfg config.RedisConfig) (*RedisPublisher, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("redis url not configured")
+	}
+	if err := validateFormat(cfg.Format, false); err != nil {
+		return nil, err
+	}
+	if cfg.MaxLen < 0 {
+		return nil, fmt.Errorf("redis max_len must not be negative")
+	}
+
+	// Without MAXLEN, XADD leaves the stream untrimmed
+	maxLen := int64(config.IntOr(cfg.MaxLen, defaultRedisMaxLen))
+	var approx bool
+	switch cfg.Trim {
+	case "", "approximate":
+		approx = true
+	case "exact":
+	case "none":
+		maxLen = 0
+	default:
+		return nil, fmt.Errorf("unsupported redis trim: %s", cfg.Trim)
+	}
+
+	options, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		// The URL may hold the password, so it is left out
+		return nil, fmt.Errorf("invalid redis url")
+	}
+	if cfg.Password != "" {
+		options.Password = cfg.Password
+	}
+
+	publisher := &RedisPublisher{
+		client: redis.NewClient(options),
+		stream: cfg.Stream,
+		maxLen: maxLen,
+		approx: approx,
+		format: cfg.Format,
+	}
+	if publisher.stream == "" {
+		publisher.stream = defaultRedisStream
+	}
+	return publisher, nil
+}
+
+// Publish appends the event to the stream
+func (p *RedisPublisher) Publish(ctx context.Context, event Event) error {
+	args, err := p.args(event)
+	if err != nil {
+		return err
+	}
+
+	if err := p.client.XAdd(ctx, args).Err(); err != nil {
+		return fmt.Errorf("failed to add event to redis stream: %w", err)
+	}
+	return nil
+}
+
+// Close closes the connections to Redis
+func (p *RedisPublisher) Close() error {
+	return p.client.Close()
+}
+
+// args builds the XADD of an event
+func (p *RedisPublisher) args(event Event) (*redis.XAddArgs, error) {
+	data, err := marshalEvent(event, p.format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	values := []interface{}{
+		RedisFieldEventID, event.ID,
+		RedisFieldEventType, event.Type,
+	}
+	if taskType, ok := event.Data["task_type"].(string); ok {
+		values = append(values, RedisFieldTaskType, taskType)
+	}
+	if p.format == FormatCloudEvents {
+		values = append(values, RedisFieldContentType, CloudEventsContentType)
+	}
+	values = append(values, RedisFieldData, data)
+
+	return &redis.XAddArgs{
+		Stream: p.stream,
+		MaxLen: p.maxLen,
+		Approx: p.approx,
+		Values: values,
+	}, nil
+}
@@ -0,0 +1,204 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go-fred/internal/config"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// streamFields returns the fields of a stream entry by name
+func streamFields(entry miniredis.StreamEntry) map[string]string {
+	fields := make(map[string]string)
+	for i := 0; i+1 < len(entry.Values); i += 2 {
+		fields[entry.Values[i]] = entry.Values[i+1]
+	}
+	return fields
+}
+
+func TestRedisPublisher(t *testing.T) {
+	server := miniredis.RunT(t)
+	publisher, err := NewRedisPublisher(config.RedisConfig{URL: "redis://" + server.Addr()})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+
+	ctx := ContextWithTaskType(context.Background(), "echo")
+	PublishTaskCompleted(ctx, publisher, "task-1", time.Second, nil)
+	PublishScheduleEvent(context.Background(), publisher, EventTypeScheduleCreated, "schedule-1")
+
+	entries, err := server.Stream("fred:events")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+
+	fields := streamFields(entries[0])
+	if fields[RedisFieldEventType] != EventTypeTaskCompleted || fields[RedisFieldTaskType] != "echo" || fields[RedisFieldEventID] == "" {
+		t.Errorf("Expected the event ID, event type and task type fields, got %v", fields)
+	}
+	var event Event
+	if err := json.Unmarshal([]byte(fields[RedisFieldData]), &event); err != nil || event.ID != fields[RedisFieldEventID] || event.Data["task_id"] != "task-1" {
+		t.Errorf("Expected the native event, got %s", fields[RedisFieldData])
+	}
+	if _, exists := fields[RedisFieldContentType]; exists {
+		t.Error("Expected no content type for native events")
+	}
+
+	if _, exists := streamFields(entries[1])[RedisFieldTaskType]; exists {
+		t.Error("Expected no task type for schedule events")
+	}
+}
+
+func TestRedisPublisherCloudEvents(t *testing.T) {
+	server := miniredis.RunT(t)
+	server.RequireAuth("secret")
+
+	publisher, err := NewRedisPublisher(config.RedisConfig{
+		URL:      "redis://" + server.Addr() + "/0",
+		Password: "secret",
+		Stream:   "audit",
+		Format:   FormatCloudEvents,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+
+	event := NewEventBuilder(EventTypeTaskFailed).WithTaskID("task-1").Build()
+	if err := publisher.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	entries, err := server.Stream("audit")
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("Expected 1 entry, got %d (%v)", len(entries), err)
+	}
+	fields := streamFields(entries[0])
+	if fields[RedisFieldContentType] != CloudEventsContentType {
+		t.Errorf("Expected content type %s, got %s", CloudEventsContentType, fields[RedisFieldContentType])
+	}
+	var cloudEvent CloudEvent
+	if err := json.Unmarshal([]byte(fields[RedisFieldData]), &cloudEvent); err != nil || cloudEvent.ID != event.ID || cloudEvent.Subject != "task-1" {
+		t.Errorf("Expected a structured CloudEvent, got %s", fields[RedisFieldData])
+	}
+}
+
+func TestRedisPublisherTrimsStream(t *testing.T) {
+	server := miniredis.RunT(t)
+	publisher, err := NewRedisPublisher(config.RedisConfig{URL: "redis://" + server.Addr(), MaxLen: 3, Trim: "exact"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+
+	var ids []string
+	for range 5 {
+		event := NewEventBuilder(EventTypeTaskCreated).Build()
+		ids = append(ids, event.ID)
+		if err := publisher.Publish(context.Background(), event); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// The oldest entries make room for the new ones
+	entries, _ := server.Stream("fred:events")
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+	for i, entry := range entries {
+		if id := streamFields(entry)[RedisFieldEventID]; id != ids[i+2] {
+			t.Errorf("Expected event %s at %d, got %s", ids[i+2], i, id)
+		}
+	}
+
+	args, _ := publisher.args(NewEventBuilder(EventTypeTaskCreated).Build())
+	if args.MaxLen != 3 || args.Approx {
+		t.Errorf("Expected exact trimming to 3 entries, got %+v", args)
+	}
+	publisher, _ = NewRedisPublisher(config.RedisConfig{URL: "redis://" + server.Addr()})
+	defer publisher.Close()
+	args, _ = publisher.args(NewEventBuilder(EventTypeTaskCreated).Build())
+	if args.MaxLen != 100000 || !args.Approx {
+		t.Errorf("Expected approximate trimming to 100000 entries by default, got %+v", args)
+	}
+}
+
+func TestRedisPublisherWithoutTrimming(t *testing.T) {
+	server := miniredis.RunT(t)
+	publisher, err := NewRedisPublisher(config.RedisConfig{URL: "redis://" + server.Addr(), MaxLen: 3, Trim: "none"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+
+	for range 5 {
+		if err := publisher.Publish(context.Background(), NewEventBuilder(EventTypeTaskCreated).Build()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// Every entry is kept
+	entries, _ := server.Stream("fred:events")
+	if len(entries) != 5 {
+		t.Errorf("Expected 5 entries, got %d", len(entries))
+	}
+	args, _ := publisher.args(NewEventBuilder(EventTypeTaskCreated).Build())
+	if args.MaxLen != 0 {
+		t.Errorf("Expected no MAXLEN, got %+v", args)
+	}
+}
+
+func TestRedisPublisherReconnects(t *testing.T) {
+	server := miniredis.RunT(t)
+	publisher, err := NewRedisPublisher(config.RedisConfig{URL: "redis://" + server.Addr()})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+
+	event := NewEventBuilder(EventTypeTaskCreated).Build()
+	if err := publisher.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Failures are returned for the caller to retry
+	server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := publisher.Publish(ctx, event); err == nil {
+		t.Fatal("Expected error while Redis is down")
+	}
+
+	if err := server.Restart(); err != nil {
+		t.Fatalf("Failed to restart: %v", err)
+	}
+	if err := publisher.Publish(context.Background(), event); err != nil {
+		t.Fatalf("Unexpected error after the restart: %v", err)
+	}
+	if entries, _ := server.Stream("fred:events"); len(entries) != 2 {
+		t.Errorf("Expected 2 entries, got %d", len(entries))
+	}
+}
+
+func TestNewRedisPublisherInvalidSettings(t *testing.T) {
+	invalid := []config.RedisConfig{
+		{},
+		{URL: "http://localhost:6379"},
+		{URL: "redis://localhost:6379", Format: FormatCloudEventsBinary},
+		{URL: "redis://localhost:6379", Trim: "minid"},
+		{URL: "redis://localhost:6379", MaxLen: -1},
+	}
+	for _, cfg := range invalid {
+		if _, err := NewRedisPublisher(cfg); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
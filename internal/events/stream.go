@@ -0,0 +1,160 @@
+package events
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultStreamBufferSize is how many recent events a stream keeps for
+// subscribers resuming after a disconnect, unless configured otherwise
+const DefaultStreamBufferSize = 1000
+
+// subscriptionBufferSize is how many events a subscriber may fall behind
+// before it is dropped
+const subscriptionBufferSize = 256
+
+// StreamEvent is an event with its position in a stream. Sequences start at
+// 1 and grow by one with every published event.
+type StreamEvent struct {
+	Sequence uint64
+	Event    Event
+}
+
+// EventFilter selects the events a subscriber receives
+type EventFilter func(event Event) bool
+
+// Stream is a publisher that hands events to in-process subscribers, such as
+// clients of the server-sent events endpoints, before passing them on to the
+// next publisher. It keeps the most recent events in a ring buffer so that
+// subscribers can resume where they left off.
+type Stream struct {
+	next        Publisher
+	mu          sync.Mutex
+	buffer      []StreamEvent
+	start       int
+	sequence    uint64
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+// NewStream creates a stream keeping the last bufferSize events, which
+// forwards every event to next
+func NewStream(next Publisher, bufferSize int) *Stream {
+	if bufferSize <= 0 {
+		bufferSize = DefaultStreamBufferSize
+	}
+	return &Stream{
+		next:        next,
+		buffer:      make([]StreamEvent, 0, bufferSize),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish records the event, hands it to the matching subscribers and
+// publishes it to the next publisher
+func (s *Stream) Publish(ctx context.Context, event Event) error {
+	s.mu.Lock()
+	s.sequence++
+	streamEvent := StreamEvent{Sequence: s.sequence, Event: event}
+	if len(s.buffer) < cap(s.buffer) {
+		s.buffer = append(s.buffer, streamEvent)
+	} else {
+		s.buffer[s.start] = streamEvent
+		s.start = (s.start + 1) % len(s.buffer)
+	}
+
+	for subscription := range s.subscribers {
+		if subscription.filter != nil && !subscription.filter(event) {
+			continue
+		}
+		select {
+		case subscription.events <- streamEvent:
+		default:
+			// The subscriber cannot keep up; drop it so that it resumes
+			// from the buffer instead of silently missing events
+			s.unsubscribe(subscription)
+		}
+	}
+	s.mu.Unlock()
+
+	return s.next.Publish(ctx, event)
+}
+
+// Subscribe registers a subscriber for the events matching the filter, or
+// all events if it is nil. The matching events still buffered after the
+// given sequence are returned as backlog: pass 0 to replay the whole buffer,
+// or the current Sequence to only get new events.
+func (s *Stream) Subscribe(after uint64, filter EventFilter) ([]StreamEvent, *Subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var backlog []StreamEvent
+	for i := range s.buffer {
+		streamEvent := s.buffer[(s.start+i)%len(s.buffer)]
+		if streamEvent.Sequence > after && (filter == nil || filter(streamEvent.Event)) {
+			backlog = append(backlog, streamEvent)
+		}
+	}
+
+	subscription := &Subscription{
+		stream: s,
+		filter: filter,
+		events: make(chan StreamEvent, subscriptionBufferSize),
+	}
+	if s.closed {
+		close(subscription.events)
+	} else {
+		s.subscribers[subscription] = struct{}{}
+	}
+	return backlog, subscription
+}
+
+// Sequence returns the sequence of the last published event
+func (s *Stream) Sequence() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sequence
+}
+
+// Close ends every subscription and closes the next publisher
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for subscription := range s.subscribers {
+		s.unsubscribe(subscription)
+	}
+	s.mu.Unlock()
+
+	return s.next.Close()
+}
+
+// unsubscribe removes a subscriber and closes its channel. The caller must
+// hold s.mu.
+func (s *Stream) unsubscribe(subscription *Subscription) {
+	if _, exists := s.subscribers[subscription]; !exists {
+		return
+	}
+	delete(s.subscribers, subscription)
+	close(subscription.events)
+}
+
+// Subscription receives the events of a stream matching its filter
+type Subscription struct {
+	stream *Stream
+	filter EventFilter
+	events chan StreamEvent
+}
+
+// Events returns the channel the events are delivered on. It is closed when
+// the subscription ends: on Close, when the stream is closed, or when the
+// subscriber fell too far behind.
+func (s *Subscription) Events() <-chan StreamEvent {
+	return s.events
+}
+
+// Close ends the subscription
+func (s *Subscription) Close() {
+	s.stream.mu.Lock()
+	defer s.stream.mu.Unlock()
+	s.stream.unsubscribe(s)
+}
@@ -0,0 +1,117 @@
+package events
+
+import (
+	"context"
+	"testing"
+)
+
+func publishTypes(t *testing.T, stream *Stream, eventTypes ...string) {
+	t.Helper()
+	for _, eventType := range eventTypes {
+		if err := stream.Publish(context.Background(), NewEventBuilder(eventType).Build()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func streamTypes(streamEvents []StreamEvent) []string {
+	types := make([]string, len(streamEvents))
+	for i, streamEvent := range streamEvents {
+		types[i] = streamEvent.Event.Type
+	}
+	return types
+}
+
+func TestStreamBacklog(t *testing.T) {
+	next := &recordingPublisher{}
+	stream := NewStream(next, 3)
+
+	publishTypes(t, stream, "a", "b", "c", "d", "e")
+	if stream.Sequence() != 5 {
+		t.Errorf("Expected sequence 5, got %d", stream.Sequence())
+	}
+	if len(next.events) != 5 {
+		t.Errorf("Expected 5 events passed on, got %d", len(next.events))
+	}
+
+	// Only the last three events are kept
+	backlog, subscription := stream.Subscribe(0, nil)
+	subscription.Close()
+	if got := streamTypes(backlog); len(got) != 3 || got[0] != "c" || got[2] != "e" {
+		t.Errorf("Expected backlog [c d e], got %v", got)
+	}
+	if backlog[0].Sequence != 3 {
+		t.Errorf("Expected the backlog to start at sequence 3, got %d", backlog[0].Sequence)
+	}
+
+	backlog, subscription = stream.Subscribe(4, nil)
+	subscription.Close()
+	if got := streamTypes(backlog); len(got) != 1 || got[0] != "e" {
+		t.Errorf("Expected backlog [e], got %v", got)
+	}
+
+	backlog, subscription = stream.Subscribe(0, func(event Event) bool { return event.Type != "d" })
+	subscription.Close()
+	if got := streamTypes(backlog); len(got) != 2 || got[0] != "c" || got[1] != "e" {
+		t.Errorf("Expected filtered backlog [c e], got %v", got)
+	}
+}
+
+func TestStreamSubscription(t *testing.T) {
+	stream := NewStream(NewNoOpPublisher(), 10)
+	publishTypes(t, stream, "task.created")
+
+	backlog, subscription := stream.Subscribe(stream.Sequence(), func(event Event) bool {
+		return event.Type == "task.completed"
+	})
+	if len(backlog) != 0 {
+		t.Errorf("Expected no backlog, got %v", streamTypes(backlog))
+	}
+
+	publishTypes(t, stream, "task.started", "task.completed")
+	streamEvent := <-subscription.Events()
+	if streamEvent.Event.Type != "task.completed" || streamEvent.Sequence != 3 {
+		t.Errorf("Expected task.completed at sequence 3, got %s at %d", streamEvent.Event.Type, streamEvent.Sequence)
+	}
+
+	subscription.Close()
+	if _, ok := <-subscription.Events(); ok {
+		t.Error("Expected the subscription to end on close")
+	}
+	// Closing twice is harmless
+	subscription.Close()
+}
+
+func TestStreamDropsSlowSubscribers(t *testing.T) {
+	stream := NewStream(NewNoOpPublisher(), 10)
+	_, subscription := stream.Subscribe(0, nil)
+
+	for i := 0; i <= subscriptionBufferSize; i++ {
+		publishTypes(t, stream, "task.started")
+	}
+
+	received := 0
+	for range subscription.Events() {
+		received++
+	}
+	if received != subscriptionBufferSize {
+		t.Errorf("Expected %d events before the subscription was dropped, got %d", subscriptionBufferSize, received)
+	}
+}
+
+func TestStreamClose(t *testing.T) {
+	stream := NewStream(NewNoOpPublisher(), 10)
+	_, subscription := stream.Subscribe(0, nil)
+
+	if err := stream.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := <-subscription.Events(); ok {
+		t.Error("Expected the subscription to end when the stream closes")
+	}
+
+	_, late := stream.Subscribe(0, nil)
+	if _, ok := <-late.Events(); ok {
+		t.Error("Expected subscriptions to a closed stream to end right away")
+	}
+}
@@ -0,0 +1,379 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-fred/internal/backoff"
+	"go-fred/internal/config"
+
+	"github.com/google/uuid"
+)
+
+// Headers sent with every webhook request. The signature is the hex
+// HMAC-SHA256 of the timestamp, a dot and the body, keyed with the secret of
+// the receiver, prefixed with "sha256=". It is left out when no secret is
+// configured.
+const (
+	WebhookHeaderEvent     = "X-Fred-Event"
+	WebhookHeaderDelivery  = "X-Fred-Delivery"
+	WebhookHeaderTimestamp = "X-Fred-Timestamp"
+	WebhookHeaderSignature = "X-Fred-Signature"
+)
+
+// Timeout, batching, queueing and retries of the webhook publisher, unless
+// configured
+const (
+	defaultWebhookTimeout      = 10 * time.Second
+	defaultWebhookBatchSize    = 1
+	defaultWebhookBatchTimeout = time.Second
+	defaultWebhookQueueSize    = 1000
+	defaultWebhookMaxAttempts  = 5
+	defaultWebhookDelay        = time.Second
+	defaultWebhookMaxDelay     = time.Minute
+)
+
+// MaxWebhookResponseSize caps how much of the response to a webhook request
+// is read before the connection is reused
+const MaxWebhookResponseSize = 64 << 10
+
+// SignWebhook returns the signature of a webhook body sent at the given Unix
+// timestamp, as found in the X-Fred-Signature header. Receivers compute it
+// with their secret and compare it with hmac.Equal.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookBatch is the body posted by the webhook publisher in the native
+// format. In the CloudEvents format the body is a single structured event
+// when the batch size is 1, and a JSON array of them otherwise.
+type WebhookBatch struct {
+	Events []Event `json:"events"`
+}
+
+// webhookDeliveryNamespace is the namespace of the delivery IDs derived from
+// the events of a batch
+var webhookDeliveryNamespace = uuid.MustParse("3f6c2a8e-1d4b-4f0a-9c7e-5b2d8e6f1a90")
+
+// WebhookPublisher posts events to HTTP endpoints. Every endpoint has its own
+// queue and sender, so that a slow endpoint does not hold up the others:
+// events are collected into batches, signed and retried with exponential
+// backoff until the endpoint accepts them with a 2xx response. Behind the
+// outbox, the outbox batches and retries the events of every endpoint itself
+// through Routes, and the queues stay empty.
+type WebhookPublisher struct {
+	endpoints []*webhookEndpoint
+	mu        sync.RWMutex
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// webhookEndpoint sends the events matching its filter to one URL
+type webhookEndpoint struct {
+	url          string
+	format       string
+	secret       string
+	eventTypes   []string
+	client       *http.Client
+	queue        chan Event
+	batchSize    int
+	batchTimeout time.Duration
+	maxAttempts  int
+	delay        time.Duration
+	maxDelay     time.Duration
+	closing      chan struct{}
+}
+
+// NewWebhookPublisher creates a webhook publisher and starts the senders of
+// its endpoints
+func NewWebhookPublisher(cfg config.WebhookPublisherConfig) (*WebhookPublisher, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, fmt.Errorf("webhook endpoints not configured")
+	}
+	if err := validateFormat(cfg.Format, false); err != nil {
+		return nil, err
+	}
+
+	publisher := &WebhookPublisher{}
+	urls := make(map[string]bool)
+	for _, endpointConfig := range cfg.Endpoints {
+		parsed, err := url.Parse(endpointConfig.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid webhook endpoint URL: %q", endpointConfig.URL)
+		}
+		// The URL names the route of the endpoint behind the outbox
+		if urls[endpointConfig.URL] {
+			return nil, fmt.Errorf("duplicate webhook endpoint URL: %q", endpointConfig.URL)
+		}
+		urls[endpointConfig.URL] = true
+
+		timeout := config.DurationOr(cfg.TimeoutSeconds, time.Second, defaultWebhookTimeout)
+		if endpointConfig.TimeoutSeconds > 0 {
+			timeout = time.Duration(endpointConfig.TimeoutSeconds) * time.Second
+		}
+		secret := cfg.Secret
+		if endpointConfig.Secret != "" {
+			secret = endpointConfig.Secret
+		}
+
+		publisher.endpoints = append(publisher.endpoints, &webhookEndpoint{
+			url:          endpointConfig.URL,
+			format:       cfg.Format,
+			secret:       secret,
+			eventTypes:   endpointConfig.EventTypes,
+			client:       &http.Client{Timeout: timeout},
+			queue:        make(chan Event, config.IntOr(cfg.QueueSize, defaultWebhookQueueSize)),
+			batchSize:    config.IntOr(cfg.BatchSize, defaultWebhookBatchSize),
+			batchTimeout: config.DurationOr(cfg.BatchTimeoutMs, time.Millisecond, defaultWebhookBatchTimeout),
+			maxAttempts:  config.IntOr(cfg.MaxAttempts, defaultWebhookMaxAttempts),
+			delay:        config.DurationOr(cfg.DelayMs, time.Millisecond, defaultWebhookDelay),
+			maxDelay:     config.DurationOr(cfg.MaxDelayMs, time.Millisecond, defaultWebhookMaxDelay),
+			closing:      make(chan struct{}),
+		})
+	}
+
+	for _, endpoint := range publisher.endpoints {
+		publisher.wg.Add(1)
+		go func() {
+			defer publisher.wg.Done()
+			endpoint.run()
+		}()
+	}
+	return publisher, nil
+}
+
+// Publish queues the event for every endpoint whose filter matches it. It
+// does not wait for the event to be sent, and fails if the queue of an
+// endpoint is full.
+func (p *WebhookPublisher) Publish(ctx context.Context, event Event) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return fmt.Errorf("webhook publisher is closed")
+	}
+
+	var errs []error
+	for _, endpoint := range p.endpoints {
+		if !endpoint.matches(event) {
+			continue
+		}
+		select {
+		case endpoint.queue <- event:
+		default:
+			errs = append(errs, fmt.Errorf("webhook queue of %s is full, dropping event %s", endpoint.url, event.ID))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Routes returns a route for every endpoint, named by its URL, with its
+// filter, batching and retries
+func (p *WebhookPublisher) Routes() []Route {
+	routes := make([]Route, len(p.endpoints))
+	for i, endpoint := range p.endpoints {
+		routes[i] = Route{
+			Name:         endpoint.url,
+			Publisher:    endpoint,
+			Matches:      endpoint.matches,
+			MaxAttempts:  endpoint.maxAttempts,
+			Delay:        endpoint.delay,
+			MaxDelay:     endpoint.maxDelay,
+			BatchSize:    endpoint.batchSize,
+			BatchTimeout: endpoint.batchTimeout,
+		}
+	}
+	return routes
+}
+
+// Close stops accepting events and sends the ones already queued. Batches
+// still failing get one last attempt instead of waiting for their backoff.
+func (p *WebhookPublisher) Close() error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for _, endpoint := range p.endpoints {
+			close(endpoint.closing)
+			close(endpoint.queue)
+		}
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+	return nil
+}
+
+// matches reports whether the event is sent to the endpoint
+func (e *webhookEndpoint) matches(event Event) bool {
+	return MatchesEventType(event.Type, e.eventTypes)
+}
+
+// run collects the queued events into batches, sending a batch once it is
+// full or its first event waited for the batch timeout
+func (e *webhookEndpoint) run() {
+	var batch []Event
+	timer := time.NewTimer(e.batchTimeout)
+	timer.Stop()
+
+	for {
+		select {
+		case event, ok := <-e.queue:
+			if !ok {
+				if len(batch) > 0 {
+					e.send(batch)
+				}
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) >= e.batchSize {
+				timer.Stop()
+				e.send(batch)
+				batch = nil
+			} else if len(batch) == 1 {
+				timer.Reset(e.batchTimeout)
+			}
+		case <-timer.C:
+			e.send(batch)
+			batch = nil
+		}
+	}
+}
+
+// send posts a batch until the endpoint accepts it or the attempts run out
+func (e *webhookEndpoint) send(batch []Event) {
+	body, contentType, err := e.encode(batch)
+	if err != nil {
+		log.Printf("Failed to marshal webhook batch: %v", err)
+		return
+	}
+	deliveryID := uuid.New().String()
+
+	for attempt := 1; ; attempt++ {
+		err := e.post(context.Background(), deliveryID, contentType, body, batch)
+		if err == nil {
+			return
+		}
+		if attempt >= e.maxAttempts {
+			log.Printf("Dropping %d events for webhook %s after %d attempts: %v", len(batch), e.url, attempt, err)
+			return
+		}
+
+		timer := time.NewTimer(backoff.Exponential(e.delay, e.maxDelay, attempt))
+		select {
+		case <-timer.C:
+		case <-e.closing:
+			// Shutting down: make one last attempt right away
+			timer.Stop()
+			attempt = max(attempt, e.maxAttempts-1)
+		}
+	}
+}
+
+// Publish makes a single attempt to post an event on its own
+func (e *webhookEndpoint) Publish(ctx context.Context, event Event) error {
+	return e.PublishBatch(ctx, []Event{event})
+}
+
+// PublishBatch makes a single attempt to post a batch. The delivery ID is
+// derived from the events, so that a batch resent by the outbox keeps it.
+func (e *webhookEndpoint) PublishBatch(ctx context.Context, batch []Event) error {
+	body, contentType, err := e.encode(batch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook batch: %w", err)
+	}
+	eventIDs := make([]string, len(batch))
+	for i, event := range batch {
+		eventIDs[i] = event.ID
+	}
+	deliveryID := uuid.NewSHA1(webhookDeliveryNamespace, []byte(strings.Join(eventIDs, ","))).String()
+	return e.post(ctx, deliveryID, contentType, body, batch)
+}
+
+// Close does nothing; the webhook publisher closes its endpoints
+func (e *webhookEndpoint) Close() error {
+	return nil
+}
+
+// encode returns the body of a batch and its content type
+func (e *webhookEndpoint) encode(batch []Event) ([]byte, string, error) {
+	if e.format != FormatCloudEvents {
+		body, err := json.Marshal(WebhookBatch{Events: batch})
+		return body, "application/json", err
+	}
+	if e.batchSize == 1 {
+		body, err := marshalEvent(batch[0], e.format)
+		return body, CloudEventsContentType, err
+	}
+	cloudEvents := make([]CloudEvent, len(batch))
+	for i, event := range batch {
+		cloudEvents[i] = NewCloudEvent(event)
+	}
+	body, err := json.Marshal(cloudEvents)
+	return body, CloudEventsBatchContentType, err
+}
+
+// post makes a single attempt to send a batch. Any response other than 2xx
+// is an error.
+func (e *webhookEndpoint) post(ctx context.Context, deliveryID, contentType string, body []byte, batch []Event) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(WebhookHeaderDelivery, deliveryID)
+	req.Header.Set(WebhookHeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	if len(batch) == 1 {
+		req.Header.Set(WebhookHeaderEvent, batch[0].Type)
+	}
+	if e.secret != "" {
+		req.Header.Set(WebhookHeaderSignature, SignWebhook(e.secret, timestamp, body))
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, MaxWebhookResponseSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// MatchesEventType reports whether the event type is one of the types, or
+// all types are accepted. Types may end with "*" to match a prefix, as in
+// "task.*".
+func MatchesEventType(eventType string, types []string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, pattern := range types {
+		if prefix, found := strings.CutSuffix(pattern, "*"); found && strings.HasPrefix(eventType, prefix) {
+			return true
+		}
+		if pattern == eventType {
+			return true
+		}
+	}
+	return false
+}
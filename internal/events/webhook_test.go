@@ -0,0 +1,325 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go-fred/internal/config"
+)
+
+// webhookReceiver records the batches posted to an httptest server,
+// answering with the given statuses in turn and 200 after them
+type webhookReceiver struct {
+	mu       sync.Mutex
+	statuses []int
+	headers  []http.Header
+	bodies   [][]byte
+	batches  []WebhookBatch
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	var batch WebhookBatch
+	json.Unmarshal(body, &batch)
+
+	r.mu.Lock()
+	r.headers = append(r.headers, req.Header)
+	r.bodies = append(r.bodies, body)
+	r.batches = append(r.batches, batch)
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	r.mu.Unlock()
+
+	w.WriteHeader(status)
+}
+
+func (r *webhookReceiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.batches)
+}
+
+// eventTypes returns the types of the events received, in order
+func (r *webhookReceiver) eventTypes() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var types []string
+	for _, batch := range r.batches {
+		for _, event := range batch.Events {
+			types = append(types, event.Type)
+		}
+	}
+	return types
+}
+
+func waitForRequests(t *testing.T, receiver *webhookReceiver, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for receiver.count() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d requests, got %d", n, receiver.count())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func publishEvents(t *testing.T, publisher Publisher, eventTypes ...string) {
+	t.Helper()
+	for _, eventType := range eventTypes {
+		if err := publisher.Publish(context.Background(), NewEventBuilder(eventType).Build()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func TestWebhookPublisherFiltersAndSigns(t *testing.T) {
+	tasksReceiver := &webhookReceiver{}
+	tasksServer := httptest.NewServer(tasksReceiver)
+	defer tasksServer.Close()
+	schedulesReceiver := &webhookReceiver{}
+	schedulesServer := httptest.NewServer(schedulesReceiver)
+	defer schedulesServer.Close()
+
+	publisher, err := NewWebhookPublisher(config.WebhookPublisherConfig{
+		Secret: "global",
+		Endpoints: []config.WebhookEndpoint{
+			{URL: tasksServer.URL, EventTypes: []string{"task.*"}},
+			{URL: schedulesServer.URL, Secret: "own", EventTypes: []string{EventTypeScheduleCreated}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	publishEvents(t, publisher, EventTypeTaskCreated, EventTypeScheduleCreated, EventTypeWorkflowCreated, EventTypeTaskCompleted)
+	if err := publisher.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if got := tasksReceiver.eventTypes(); len(got) != 2 || got[0] != EventTypeTaskCreated || got[1] != EventTypeTaskCompleted {
+		t.Errorf("Expected the task events, got %v", got)
+	}
+	if got := schedulesReceiver.eventTypes(); len(got) != 1 || got[0] != EventTypeScheduleCreated {
+		t.Errorf("Expected the schedule.created event, got %v", got)
+	}
+
+	secrets := map[*webhookReceiver]string{tasksReceiver: "global", schedulesReceiver: "own"}
+	for receiver, secret := range secrets {
+		for i, header := range receiver.headers {
+			timestamp, _ := strconv.ParseInt(header.Get(WebhookHeaderTimestamp), 10, 64)
+			if got := header.Get(WebhookHeaderSignature); got != SignWebhook(secret, timestamp, receiver.bodies[i]) {
+				t.Errorf("Expected the body signed with secret %q, got %s", secret, got)
+			}
+			if header.Get(WebhookHeaderEvent) != receiver.batches[i].Events[0].Type {
+				t.Errorf("Expected the event type header of a single event, got %q", header.Get(WebhookHeaderEvent))
+			}
+		}
+	}
+
+	// Closed publishers reject events
+	if err := publisher.Publish(context.Background(), NewEventBuilder(EventTypeTaskCreated).Build()); err == nil {
+		t.Error("Expected error publishing to a closed publisher")
+	}
+}
+
+func TestWebhookPublisherBatches(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	publisher, err := NewWebhookPublisher(config.WebhookPublisherConfig{
+		Endpoints:      []config.WebhookEndpoint{{URL: server.URL}},
+		BatchSize:      3,
+		BatchTimeoutMs: 60000,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	publishEvents(t, publisher, "a", "b", "c", "d")
+	waitForRequests(t, receiver, 1)
+	if got := receiver.eventTypes(); len(got) != 3 {
+		t.Errorf("Expected a full batch of 3 events, got %v", got)
+	}
+
+	// Closing sends the partial batch without waiting for the timeout
+	if err := publisher.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if receiver.count() != 2 || len(receiver.batches[1].Events) != 1 || receiver.batches[1].Events[0].Type != "d" {
+		t.Errorf("Expected the remaining event in a second batch, got %+v", receiver.batches)
+	}
+	if receiver.headers[0].Get(WebhookHeaderEvent) != "" {
+		t.Error("Expected no event type header for a batch of several events")
+	}
+}
+
+func TestWebhookPublisherBatchTimeout(t *testing.T) {
+	receiver := &webhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	publisher, err := NewWebhookPublisher(config.WebhookPublisherConfig{
+		Endpoints:      []config.WebhookEndpoint{{URL: server.URL}},
+		BatchSize:      10,
+		BatchTimeoutMs: 20,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+
+	publishEvents(t, publisher, "a", "b")
+	waitForRequests(t, receiver, 1)
+	if got := receiver.eventTypes(); len(got) != 2 {
+		t.Errorf("Expected both events after the batch timeout, got %v", got)
+	}
+}
+
+func TestWebhookPublisherRetries(t *testing.T) {
+	receiver := &webhookReceiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	publisher, err := NewWebhookPublisher(config.WebhookPublisherConfig{
+		Endpoints: []config.WebhookEndpoint{{URL: server.URL}},
+		DelayMs:   5,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+
+	publishEvents(t, publisher, EventTypeTaskFailed)
+	waitForRequests(t, receiver, 3)
+
+	// Retries resend the same batch
+	deliveryID := receiver.headers[0].Get(WebhookHeaderDelivery)
+	for _, header := range receiver.headers {
+		if header.Get(WebhookHeaderDelivery) != deliveryID {
+			t.Errorf("Expected every attempt to have delivery ID %s, got %s", deliveryID, header.Get(WebhookHeaderDelivery))
+		}
+	}
+}
+
+func TestWebhookPublisherGivesUp(t *testing.T) {
+	receiver := &webhookReceiver{statuses: []int{500, 500, 500, 500}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	publisher, err := NewWebhookPublisher(config.WebhookPublisherConfig{
+		Endpoints:   []config.WebhookEndpoint{{URL: server.URL}},
+		MaxAttempts: 2,
+		DelayMs:     5,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	publishEvents(t, publisher, EventTypeTaskFailed, EventTypeTaskCompleted)
+	if err := publisher.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Each event is attempted twice, and the one-event batches are dropped
+	if receiver.count() != 4 {
+		t.Errorf("Expected 4 attempts, got %d", receiver.count())
+	}
+}
+
+func TestWebhookPublisherRoutes(t *testing.T) {
+	receiver := &webhookReceiver{statuses: []int{http.StatusServiceUnavailable}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	publisher, err := NewWebhookPublisher(config.WebhookPublisherConfig{
+		Endpoints: []config.WebhookEndpoint{
+			{URL: server.URL},
+			{URL: server.URL + "/alerts", EventTypes: []string{"task.failed"}},
+		},
+		BatchSize:      10,
+		BatchTimeoutMs: 50,
+		MaxAttempts:    3,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer publisher.Close()
+
+	// Every endpoint is a route with its filter, batching and retries
+	routes := publisher.Routes()
+	if len(routes) != 2 || routes[0].Name != server.URL || routes[1].Name != server.URL+"/alerts" {
+		t.Fatalf("Expected a route for every endpoint, got %+v", routes)
+	}
+	if routes[0].BatchSize != 10 || routes[0].BatchTimeout != 50*time.Millisecond || routes[0].MaxAttempts != 3 {
+		t.Errorf("Expected the batching and retries of the publisher, got %+v", routes[0])
+	}
+	created := NewEventBuilder(EventTypeTaskCreated).Build()
+	if !routes[0].Matches(created) || routes[1].Matches(created) {
+		t.Error("Expected the task.created event to go to the first endpoint only")
+	}
+
+	// A rejected batch is reported to the caller instead of being retried
+	endpoint := routes[0].Publisher.(BatchPublisher)
+	batch := []Event{created, NewEventBuilder(EventTypeTaskStarted).Build()}
+	if err := endpoint.PublishBatch(context.Background(), batch); err == nil {
+		t.Fatal("Expected error when the endpoint rejects the batch")
+	}
+	if err := endpoint.PublishBatch(context.Background(), batch); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := receiver.count(); got != 2 || len(receiver.batches[1].Events) != 2 {
+		t.Fatalf("Expected the batch to be posted twice, got %d requests", got)
+	}
+
+	// The batch keeps its delivery ID when it is sent again
+	first := receiver.headers[0].Get("X-Fred-Delivery")
+	if first == "" || receiver.headers[1].Get("X-Fred-Delivery") != first {
+		t.Errorf("Expected the same delivery ID, got %q and %q", first, receiver.headers[1].Get("X-Fred-Delivery"))
+	}
+}
+
+func TestNewWebhookPublisherInvalidConfig(t *testing.T) {
+	configs := map[string]config.WebhookPublisherConfig{
+		"no endpoints":     {},
+		"relative URL":     {Endpoints: []config.WebhookEndpoint{{URL: "/events"}}},
+		"unsupported URL":  {Endpoints: []config.WebhookEndpoint{{URL: "ftp://example.com/events"}}},
+		"URL without host": {Endpoints: []config.WebhookEndpoint{{URL: "https://"}}},
+		"duplicate URL":    {Endpoints: []config.WebhookEndpoint{{URL: "https://example.com"}, {URL: "https://example.com"}}},
+	}
+
+	for name, cfg := range configs {
+		if _, err := NewWebhookPublisher(cfg); err == nil {
+			t.Errorf("Expected error for %s", name)
+		}
+	}
+}
+
+func TestMatchesEventType(t *testing.T) {
+	tests := []struct {
+		eventType string
+		types     []string
+		matches   bool
+	}{
+		{eventType: "task.created", types: nil, matches: true},
+		{eventType: "task.created", types: []string{"task.created"}, matches: true},
+		{eventType: "task.created", types: []string{"task.*"}, matches: true},
+		{eventType: "task.created", types: []string{"schedule.*", "task.completed"}, matches: false},
+		{eventType: "task.created", types: []string{"*"}, matches: true},
+	}
+
+	for _, tt := range tests {
+		if got := MatchesEventType(tt.eventType, tt.types); got != tt.matches {
+			t.Errorf("Expected %s matching %v to be %v, got %v", tt.eventType, tt.types, tt.matches, got)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Size limits of task labels and metadata
+const (
+	MaxLabels           = 64
+	MaxLabelNameLength  = 63
+	MaxLabelValueLength = 63
+	MaxLabelPrefixSize  = 253
+	MaxMetadataSize     = 16 * 1024
+)
+
+var (
+	// labelNamePattern matches label names and values: alphanumerics with
+	// dashes, underscores and dots inside
+	labelNamePattern = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	// labelPrefixPattern matches the optional DNS subdomain prefix of a key
+	labelPrefixPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+)
+
+// ValidateLabelKey checks a label key: a name of at most 63 characters,
+// optionally preceded by a DNS subdomain prefix and a slash, as in
+// "example.com/team"
+func ValidateLabelKey(key string) error {
+	name := key
+	if prefix, rest, found := strings.Cut(key, "/"); found {
+		if len(prefix) > MaxLabelPrefixSize || !labelPrefixPattern.MatchString(prefix) {
+			return fmt.Errorf("invalid label key %q: prefix must be a DNS subdomain of at most %d characters", key, MaxLabelPrefixSize)
+		}
+		name = rest
+	}
+	if len(name) > MaxLabelNameLength || !labelNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid label key %q: name must be 1-%d alphanumeric characters, '-', '_' or '.', starting and ending with an alphanumeric character", key, MaxLabelNameLength)
+	}
+	return nil
+}
+
+// ValidateLabelValue checks a label value: empty, or at most 63 characters
+// like a label name
+func ValidateLabelValue(value string) error {
+	if value == "" {
+		return nil
+	}
+	if len(value) > MaxLabelValueLength || !labelNamePattern.MatchString(value) {
+		return fmt.Errorf("invalid label value %q: must be at most %d alphanumeric characters, '-', '_' or '.', starting and ending with an alphanumeric character", value, MaxLabelValueLength)
+	}
+	return nil
+}
+
+// ValidateLabels checks the number of labels and the syntax of their keys
+// and values
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > MaxLabels {
+		return fmt.Errorf("too many labels: %d, at most %d are allowed", len(labels), MaxLabels)
+	}
+	for key, value := range labels {
+		if err := ValidateLabelKey(key); err != nil {
+			return err
+		}
+		if err := ValidateLabelValue(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateMetadata checks that metadata stays within MaxMetadataSize once
+// encoded as JSON
+func ValidateMetadata(metadata map[string]interface{}) error {
+	if len(metadata) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("invalid metadata: %w", err)
+	}
+	if len(data) > MaxMetadataSize {
+		return fmt.Errorf("metadata is too large: %d bytes, at most %d are allowed", len(data), MaxMetadataSize)
+	}
+	return nil
+}
+
+// Label selector operators
+const (
+	SelectorEquals       = "="
+	SelectorNotEquals    = "!="
+	SelectorIn           = "in"
+	SelectorNotIn        = "notin"
+	SelectorExists       = "exists"
+	SelectorDoesNotExist = "!"
+)
+
+// LabelRequirement is a single condition of a label selector
+type LabelRequirement struct {
+	Key      string
+	Operator string
+	Values   []string
+}
+
+// Matches reports whether the labels meet the requirement
+func (r LabelRequirement) Matches(labels map[string]string) bool {
+	value, exists := labels[r.Key]
+	switch r.Operator {
+	case SelectorEquals, SelectorIn:
+		return exists && slices.Contains(r.Values, value)
+	case SelectorNotEquals, SelectorNotIn:
+		return !exists || !slices.Contains(r.Values, value)
+	case SelectorExists:
+		return exists
+	case SelectorDoesNotExist:
+		return !exists
+	}
+	return false
+}
+
+// LabelSelector selects tasks by their labels; every requirement must hold
+type LabelSelector []LabelRequirement
+
+// Matches reports whether the labels meet every requirement of the selector
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, requirement := range s {
+		if !requirement.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseLabelSelector parses a comma-separated list of requirements:
+//
+//	env=prod         the label equals a value ("==" works too)
+//	env!=prod        the label is missing or has another value
+//	team in (a,b)    the label has one of the values
+//	team notin (a,b) the label is missing or has none of the values
+//	team             the label is set
+//	!team            the label is not set
+//
+// An empty selector matches every task.
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	var requirements LabelSelector
+	for _, term := range splitSelector(selector) {
+		requirement, err := parseRequirement(term)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector %q: %w", selector, err)
+		}
+		requirements = append(requirements, requirement)
+	}
+	return requirements, nil
+}
+
+// splitSelector splits a selector at the commas outside of value sets
+func splitSelector(selector string) []string {
+	var terms []string
+	depth, start := 0, 0
+	for i, r := range selector {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	terms = append(terms, selector[start:])
+
+	nonEmpty := terms[:0]
+	for _, term := range terms {
+		if term = strings.TrimSpace(term); term != "" {
+			nonEmpty = append(nonEmpty, term)
+		}
+	}
+	return nonEmpty
+}
+
+// parseRequirement parses a single term of a selector
+func parseRequirement(term string) (LabelRequirement, error) {
+	if key, found := strings.CutPrefix(term, "!"); found && !strings.HasPrefix(key, "=") {
+		key = strings.TrimSpace(key)
+		return LabelRequirement{Key: key, Operator: SelectorDoesNotExist}, ValidateLabelKey(key)
+	}
+
+	for _, operator := range []string{"!=", "==", "="} {
+		key, value, found := strings.Cut(term, operator)
+		if !found {
+			continue
+		}
+		requirement := LabelRequirement{Key: strings.TrimSpace(key), Operator: SelectorEquals, Values: []string{strings.TrimSpace(value)}}
+		if operator == "!=" {
+			requirement.Operator = SelectorNotEquals
+		}
+		return requirement, validateRequirement(requirement)
+	}
+
+	if key, values, found := strings.Cut(term, "("); found {
+		fields := strings.Fields(key)
+		if len(fields) != 2 || (fields[1] != SelectorIn && fields[1] != SelectorNotIn) {
+			return LabelRequirement{}, fmt.Errorf("expected \"key in (values)\" or \"key notin (values)\", got %q", term)
+		}
+		values, found = strings.CutSuffix(strings.TrimSpace(values), ")")
+		if !found {
+			return LabelRequirement{}, fmt.Errorf("missing closing parenthesis in %q", term)
+		}
+
+		requirement := LabelRequirement{Key: fields[0], Operator: fields[1]}
+		for _, value := range strings.Split(values, ",") {
+			requirement.Values = append(requirement.Values, strings.TrimSpace(value))
+		}
+		return requirement, validateRequirement(requirement)
+	}
+
+	return LabelRequirement{Key: term, Operator: SelectorExists}, ValidateLabelKey(term)
+}
+
+// validateRequirement checks the key and values of a requirement
+func validateRequirement(requirement LabelRequirement) error {
+	if err := ValidateLabelKey(requirement.Key); err != nil {
+		return err
+	}
+	for _, value := range requirement.Values {
+		if err := ValidateLabelValue(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
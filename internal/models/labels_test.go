@@ -0,0 +1,107 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestValidateLabels(t *testing.T) {
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		expectError bool
+	}{
+		{name: "no labels", labels: nil},
+		{name: "simple labels", labels: map[string]string{"env": "prod", "team": "data-eng", "owner": ""}},
+		{name: "prefixed key", labels: map[string]string{"example.com/tenant": "acme_1"}},
+		{name: "empty key", labels: map[string]string{"": "prod"}, expectError: true},
+		{name: "key with spaces", labels: map[string]string{"my env": "prod"}, expectError: true},
+		{name: "key ending with a dash", labels: map[string]string{"env-": "prod"}, expectError: true},
+		{name: "upper-case prefix", labels: map[string]string{"Example.com/tenant": "acme"}, expectError: true},
+		{name: "empty name after prefix", labels: map[string]string{"example.com/": "acme"}, expectError: true},
+		{name: "key too long", labels: map[string]string{strings.Repeat("k", 64): "v"}, expectError: true},
+		{name: "value too long", labels: map[string]string{"env": strings.Repeat("v", 64)}, expectError: true},
+		{name: "value with a comma", labels: map[string]string{"team": "a,b"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateLabels(tt.labels)
+			if tt.expectError && err == nil {
+				t.Error("Expected error but got none")
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+
+	tooMany := make(map[string]string)
+	for i := 0; i <= MaxLabels; i++ {
+		tooMany[fmt.Sprintf("key-%d", i)] = "v"
+	}
+	if err := ValidateLabels(tooMany); err == nil {
+		t.Errorf("Expected error for %d labels", len(tooMany))
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	if err := ValidateMetadata(map[string]interface{}{"request_id": "abc", "attempt": 2}); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	large := map[string]interface{}{"blob": strings.Repeat("x", MaxMetadataSize)}
+	if err := ValidateMetadata(large); err == nil {
+		t.Error("Expected error for metadata over the size limit")
+	}
+}
+
+func TestParseLabelSelector(t *testing.T) {
+	labels := map[string]string{"env": "prod", "team": "b", "example.com/tenant": "acme"}
+
+	tests := []struct {
+		selector    string
+		matches     bool
+		expectError bool
+	}{
+		{selector: "", matches: true},
+		{selector: "env=prod", matches: true},
+		{selector: "env==prod", matches: true},
+		{selector: "env=dev", matches: false},
+		{selector: "env!=dev", matches: true},
+		{selector: "region!=eu", matches: true},
+		{selector: "env=prod,team in (a,b)", matches: true},
+		{selector: "env=prod, team in (a, c)", matches: false},
+		{selector: "team notin (a,c)", matches: true},
+		{selector: "region notin (eu)", matches: true},
+		{selector: "example.com/tenant", matches: true},
+		{selector: "region", matches: false},
+		{selector: "!region", matches: true},
+		{selector: "!env", matches: false},
+		{selector: "env=prod,,", matches: true},
+		{selector: "team in (a,b", expectError: true},
+		{selector: "team within (a,b)", expectError: true},
+		{selector: "env=pro d", expectError: true},
+		{selector: "=prod", expectError: true},
+		{selector: "!env=prod", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			selector, err := ParseLabelSelector(tt.selector)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error but got selector %+v", selector)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got := selector.Matches(labels); got != tt.matches {
+				t.Errorf("Expected match %v, got %v", tt.matches, got)
+			}
+		})
+	}
+}
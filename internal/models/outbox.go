@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// OutboxStats describes the backlog of the event outbox: the events stored
+// but not yet accepted by the event publisher. With several publishers, the
+// stats of every publisher are listed in Routes, and the top level adds them
+// up and describes the oldest event of all.
+type OutboxStats struct {
+	// Route names the publisher the stats of a route are about
+	Route   string `json:"route,omitempty"`
+	Backlog int    `json:"backlog"`
+	// Parked counts the events that ran out of attempts and were moved out
+	// of the backlog
+	Parked int `json:"parked"`
+	// Oldest* describe the event at the head of the backlog, which is
+	// published next
+	OldestEventID         string     `json:"oldest_event_id,omitempty"`
+	OldestEventType       string     `json:"oldest_event_type,omitempty"`
+	OldestEventAt         *time.Time `json:"oldest_event_at,omitempty"`
+	OldestEventAgeSeconds float64    `json:"oldest_event_age_seconds"`
+	OldestEventAttempts   int        `json:"oldest_event_attempts,omitempty"`
+	NextAttemptAt         *time.Time `json:"next_attempt_at,omitempty"`
+	LastError             string     `json:"last_error,omitempty"`
+	LastErrorAt           *time.Time `json:"last_error_at,omitempty"`
+	// Published and FailedAttempts count since the server started
+	Published      int64          `json:"published"`
+	FailedAttempts int64          `json:"failed_attempts"`
+	Routes         []*OutboxStats `json:"routes,omitempty"`
+}
+
+// OutboxResponse represents the response for the outbox stats
+type OutboxResponse struct {
+	Outbox *OutboxStats `json:"outbox"`
+}
@@ -0,0 +1,57 @@
+package models
+
+// Queue states
+const (
+	QueueStateActive   = "active"
+	QueueStatePaused   = "paused"
+	QueueStateDraining = "draining"
+)
+
+// DefaultQueue is the queue tasks go to when no other queue takes them
+const DefaultQueue = "default"
+
+// QueueStats describes a task queue and its current load
+type QueueStats struct {
+	Name        string   `json:"name"`
+	State       string   `json:"state"`
+	Concurrency int      `json:"concurrency"`
+	MaxDepth    int      `json:"max_depth"`
+	TaskTypes   []string `json:"task_types,omitempty"`
+	Waiting     int      `json:"waiting"`
+	Running     int      `json:"running"`
+	Submitted   int64    `json:"submitted"`
+	Rejected    int64    `json:"rejected"`
+	Processed   int64    `json:"processed"`
+}
+
+// QueueResponse represents the response for a queue
+type QueueResponse struct {
+	Queue *QueueStats `json:"queue"`
+}
+
+// QueueListResponse represents the response for listing queues
+type QueueListResponse struct {
+	Queues []QueueStats `json:"queues"`
+	Total  int          `json:"total"`
+}
+
+// ConcurrencyKeyStats lists the tasks holding a concurrency key and the ones
+// waiting for it, in the order they will get it
+type ConcurrencyKeyStats struct {
+	Key     string   `json:"key"`
+	Limit   int      `json:"limit"`
+	Running []string `json:"running"`
+	Waiting []string `json:"waiting"`
+}
+
+// ConcurrencyKeyResponse represents the response for a concurrency key
+type ConcurrencyKeyResponse struct {
+	ConcurrencyKey *ConcurrencyKeyStats `json:"concurrency_key"`
+}
+
+// ConcurrencyKeyListResponse represents the response for listing
+// concurrency keys
+type ConcurrencyKeyListResponse struct {
+	ConcurrencyKeys []ConcurrencyKeyStats `json:"concurrency_keys"`
+	Total           int                   `json:"total"`
+}
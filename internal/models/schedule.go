@@ -0,0 +1,132 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Misfire policies decide what happens to runs missed while the scheduler
+// was stopped or the schedule was paused
+const (
+	MisfirePolicySkip    = "skip"
+	MisfirePolicyRunOnce = "run_once"
+)
+
+// Overlap policies decide what happens when a run is due while the task of
+// the previous run is still unfinished
+const (
+	OverlapPolicySkip           = "skip"
+	OverlapPolicyQueue          = "queue"
+	OverlapPolicyCancelPrevious = "cancel_previous"
+)
+
+// Schedule represents a recurring task definition
+type Schedule struct {
+	ID              string                 `json:"id"`
+	Name            string                 `json:"name,omitempty"`
+	TaskType        string                 `json:"task_type"`
+	Input           map[string]interface{} `json:"input,omitempty"`
+	Cron            string                 `json:"cron,omitempty"`
+	IntervalSeconds int                    `json:"interval_seconds,omitempty"`
+	Timezone        string                 `json:"timezone,omitempty"`
+	StartAt         *time.Time             `json:"start_at,omitempty"`
+	EndAt           *time.Time             `json:"end_at,omitempty"`
+	JitterSeconds   int                    `json:"jitter_seconds,omitempty"`
+	MisfirePolicy   string                 `json:"misfire_policy"`
+	OverlapPolicy   string                 `json:"overlap_policy"`
+	Paused          bool                   `json:"paused"`
+	NextRunAt       *time.Time             `json:"next_run_at,omitempty"`
+	LastRunAt       *time.Time             `json:"last_run_at,omitempty"`
+	LastTaskID      string                 `json:"last_task_id,omitempty"`
+	QueuedTaskIDs   []string               `json:"queued_task_ids,omitempty"`
+	CreatedAt       time.Time              `json:"created_at"`
+	UpdatedAt       time.Time              `json:"updated_at"`
+	Version         int64                  `json:"version"`
+}
+
+// ScheduleRequest represents a request to create or update a schedule
+type ScheduleRequest struct {
+	Name            string                 `json:"name"`
+	TaskType        string                 `json:"task_type" binding:"required"`
+	Input           map[string]interface{} `json:"input"`
+	Cron            string                 `json:"cron"`
+	IntervalSeconds int                    `json:"interval_seconds"`
+	Timezone        string                 `json:"timezone"`
+	StartAt         *time.Time             `json:"start_at"`
+	EndAt           *time.Time             `json:"end_at"`
+	JitterSeconds   int                    `json:"jitter_seconds"`
+	MisfirePolicy   string                 `json:"misfire_policy"`
+	OverlapPolicy   string                 `json:"overlap_policy"`
+	Paused          bool                   `json:"paused"`
+}
+
+// ScheduleResponse represents the response for a schedule
+type ScheduleResponse struct {
+	Schedule *Schedule `json:"schedule"`
+}
+
+// ScheduleListResponse represents the response for listing schedules
+type ScheduleListResponse struct {
+	Schedules []Schedule `json:"schedules"`
+	Total     int        `json:"total"`
+}
+
+// NewSchedule creates a new schedule from the given request
+func NewSchedule(req *ScheduleRequest) *Schedule {
+	now := time.Now()
+	schedule := &Schedule{
+		ID:        uuid.New().String(),
+		CreatedAt: now,
+	}
+	schedule.Apply(req)
+	return schedule
+}
+
+// Apply copies the fields of the request onto the schedule, filling in the
+// default policies
+func (s *Schedule) Apply(req *ScheduleRequest) {
+	s.Name = req.Name
+	s.TaskType = req.TaskType
+	s.Input = req.Input
+	s.Cron = req.Cron
+	s.IntervalSeconds = req.IntervalSeconds
+	s.Timezone = req.Timezone
+	s.StartAt = req.StartAt
+	s.EndAt = req.EndAt
+	s.JitterSeconds = req.JitterSeconds
+	s.MisfirePolicy = req.MisfirePolicy
+	s.OverlapPolicy = req.OverlapPolicy
+	s.Paused = req.Paused
+	s.UpdatedAt = time.Now()
+
+	if s.MisfirePolicy == "" {
+		s.MisfirePolicy = MisfirePolicyRunOnce
+	}
+	if s.OverlapPolicy == "" {
+		s.OverlapPolicy = OverlapPolicyQueue
+	}
+}
+
+// Clone returns a copy of the schedule that can be modified independently
+func (s *Schedule) Clone() *Schedule {
+	clone := *s
+	clone.Input = cloneMap(s.Input)
+	clone.StartAt = cloneTime(s.StartAt)
+	clone.EndAt = cloneTime(s.EndAt)
+	clone.NextRunAt = cloneTime(s.NextRunAt)
+	clone.LastRunAt = cloneTime(s.LastRunAt)
+	if s.QueuedTaskIDs != nil {
+		clone.QueuedTaskIDs = append([]string(nil), s.QueuedTaskIDs...)
+	}
+	return &clone
+}
+
+// cloneTime returns a copy of the given time pointer
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	clone := *t
+	return &clone
+}
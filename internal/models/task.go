@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,21 +17,70 @@ const (
 	TaskStatusCompleted TaskStatus = "completed"
 	TaskStatusFailed    TaskStatus = "failed"
 	TaskStatusCancelled TaskStatus = "cancelled"
+	TaskStatusTimedOut  TaskStatus = "timed_out"
+	TaskStatusRetrying  TaskStatus = "retrying"
+	TaskStatusScheduled TaskStatus = "scheduled"
+)
+
+// MaxPriority is the highest priority a task can have; tasks with a higher
+// priority are executed first
+const MaxPriority = 100
+
+// Backoff strategies for retried tasks
+const (
+	BackoffFixed       = "fixed"
+	BackoffExponential = "exponential"
+	BackoffJittered    = "jittered"
 )
 
 // Task represents a task in the system
 type Task struct {
-	ID          string                 `json:"id"`
-	Type        string                 `json:"type"`
-	Status      TaskStatus             `json:"status"`
-	Input       map[string]interface{} `json:"input"`
-	Output      map[string]interface{} `json:"output,omitempty"`
-	Error       string                 `json:"error,omitempty"`
-	CreatedAt   time.Time              `json:"created_at"`
-	StartedAt   *time.Time             `json:"started_at,omitempty"`
-	CompletedAt *time.Time             `json:"completed_at,omitempty"`
-	Duration    *time.Duration         `json:"duration_ms,omitempty"`
-	IsAsync     bool                   `json:"is_async"`
+	ID            string                 `json:"id"`
+	Type          string                 `json:"type"`
+	Status        TaskStatus             `json:"status"`
+	Input         map[string]interface{} `json:"input"`
+	Output        map[string]interface{} `json:"output,omitempty"`
+	Error         string                 `json:"error,omitempty"`
+	CreatedAt     time.Time              `json:"created_at"`
+	StartedAt     *time.Time             `json:"started_at,omitempty"`
+	CompletedAt   *time.Time             `json:"completed_at,omitempty"`
+	Duration      *time.Duration         `json:"duration_ms,omitempty"`
+	IsAsync       bool                   `json:"is_async"`
+	Queue         string                 `json:"queue,omitempty"`
+	Priority      int                    `json:"priority,omitempty"`
+	Timeout       int                    `json:"timeout,omitempty"` // seconds
+	Retry         *RetryPolicy           `json:"retry,omitempty"`
+	Attempts      []TaskAttempt          `json:"attempts,omitempty"`
+	NextAttemptAt *time.Time             `json:"next_attempt_at,omitempty"`
+	RunAt         *time.Time             `json:"run_at,omitempty"`
+	// IdempotencyKey is the key the task was created with, if any
+	IdempotencyKey   string                 `json:"idempotency_key,omitempty"`
+	ConcurrencyKey   string                 `json:"concurrency_key,omitempty"`
+	ConcurrencyLimit int                    `json:"concurrency_limit,omitempty"`
+	Labels           map[string]string      `json:"labels,omitempty"`
+	Metadata         map[string]interface{} `json:"metadata,omitempty"`
+	CallbackURL      string                 `json:"callback_url,omitempty"`
+	Version          int64                  `json:"version"`
+}
+
+// TaskAttempt records a single execution attempt of a task
+type TaskAttempt struct {
+	Number      int        `json:"number"`
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// RetryPolicy describes how failed attempts of a task are retried
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int    `json:"max_attempts"`
+	Backoff     string `json:"backoff,omitempty"`
+	DelayMs     int    `json:"delay_ms,omitempty"`
+	MaxDelayMs  int    `json:"max_delay_ms,omitempty"`
+	// RetryOn limits retries to errors containing one of these strings;
+	// when empty every error is retried
+	RetryOn []string `json:"retry_on,omitempty"`
 }
 
 // TaskRequest represents a request to create a task
@@ -38,6 +88,38 @@ type TaskRequest struct {
 	Type   string                 `json:"type" binding:"required"`
 	Input  map[string]interface{} `json:"input"`
 	Async  bool                   `json:"async,omitempty"`
+	// Queue names the queue the task is executed from; by default a queue
+	// is picked by task type
+	Queue string `json:"queue,omitempty"`
+	// Priority orders queued tasks, from 0 (default) to MaxPriority
+	Priority int `json:"priority,omitempty"`
+	// Timeout overrides the configured task timeout, in seconds
+	Timeout int `json:"timeout,omitempty"`
+	// Retry overrides the retry policy configured for the task type
+	Retry *RetryPolicy `json:"retry,omitempty"`
+	// RunAt and Delay (in seconds) postpone the execution of the task; they
+	// are mutually exclusive
+	RunAt *time.Time `json:"run_at,omitempty"`
+	Delay int        `json:"delay,omitempty"`
+	// IdempotencyKey makes retried requests return the task created first
+	// instead of creating another one
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// ConcurrencyKey limits how many tasks sharing it run at once, to
+	// ConcurrencyLimit (default 1)
+	ConcurrencyKey   string `json:"concurrency_key,omitempty"`
+	ConcurrencyLimit int    `json:"concurrency_limit,omitempty"`
+	// Labels identify the task for label selectors and are copied into its
+	// events; Metadata is free-form data kept with the task
+	Labels   map[string]string      `json:"labels,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	// CallbackURL receives the task as a signed webhook once it finished
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// RescheduleRequest represents a request to move a scheduled task
+type RescheduleRequest struct {
+	RunAt *time.Time `json:"run_at"`
+	Delay int        `json:"delay"`
 }
 
 // TaskResponse represents the response for a task
@@ -45,10 +127,48 @@ type TaskResponse struct {
 	Task *Task `json:"task"`
 }
 
-// TaskListResponse represents the response for listing tasks
+// TaskListResponse represents the response for listing tasks. Total counts
+// the tasks matching the filters across all pages; NextCursor continues the
+// listing when there are more.
 type TaskListResponse struct {
-	Tasks []Task `json:"tasks"`
-	Total int    `json:"total"`
+	Tasks      []Task `json:"tasks"`
+	Total      int    `json:"total"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// CancelTasksResponse lists the tasks cancelled by a label selector
+type CancelTasksResponse struct {
+	Cancelled []string `json:"cancelled"`
+	Total     int      `json:"total"`
+}
+
+// Fields tasks can be sorted by
+const (
+	TaskSortCreatedAt = "created_at"
+	TaskSortStartedAt = "started_at"
+	TaskSortDuration  = "duration"
+)
+
+// TaskQuery selects, orders and pages tasks. Time ranges include their lower
+// bound and exclude their upper bound.
+type TaskQuery struct {
+	Statuses        []TaskStatus
+	Types           []string
+	Async           *bool
+	CreatedAfter    *time.Time
+	CreatedBefore   *time.Time
+	CompletedAfter  *time.Time
+	CompletedBefore *time.Time
+	// Labels selects tasks by their labels
+	Labels LabelSelector
+	// SortBy is one of the TaskSort fields, created_at by default. Tasks
+	// without a start time or duration sort as if it was zero.
+	SortBy     string
+	Descending bool
+	// Limit caps the number of tasks in a page
+	Limit int
+	// Cursor continues a previous query where its page ended
+	Cursor string
 }
 
 // NewTask creates a new task with the given parameters
@@ -64,11 +184,44 @@ func NewTask(taskType string, input map[string]interface{}, isAsync bool) *Task
 	}
 }
 
-// Start marks the task as started
+// Start marks the task as started and records a new attempt
 func (t *Task) Start() {
 	now := time.Now()
 	t.Status = TaskStatusRunning
-	t.StartedAt = &now
+	if t.StartedAt == nil {
+		t.StartedAt = &now
+	}
+	t.Error = ""
+	t.NextAttemptAt = nil
+	t.Attempts = append(t.Attempts, TaskAttempt{
+		Number:    len(t.Attempts) + 1,
+		StartedAt: now,
+	})
+}
+
+// Schedule marks the task as waiting to be executed at the given time
+func (t *Task) Schedule(at time.Time) {
+	t.Status = TaskStatusScheduled
+	t.RunAt = &at
+}
+
+// Release marks a scheduled task as pending once it is due
+func (t *Task) Release() {
+	t.Status = TaskStatusPending
+}
+
+// Attempt returns the number of the current or last attempt
+func (t *Task) Attempt() int {
+	return len(t.Attempts)
+}
+
+// ScheduleRetry records the failure of the current attempt and marks the
+// task as waiting for another attempt at the given time
+func (t *Task) ScheduleRetry(err error, at time.Time) {
+	t.Status = TaskStatusRetrying
+	t.Error = err.Error()
+	t.NextAttemptAt = &at
+	t.finishAttempt(time.Now(), t.Error)
 }
 
 // Complete marks the task as completed with the given output
@@ -77,6 +230,7 @@ func (t *Task) Complete(output map[string]interface{}) {
 	t.Status = TaskStatusCompleted
 	t.CompletedAt = &now
 	t.Output = output
+	t.finishAttempt(now, "")
 
 	if t.StartedAt != nil {
 		duration := now.Sub(*t.StartedAt)
@@ -90,6 +244,8 @@ func (t *Task) Fail(err error) {
 	t.Status = TaskStatusFailed
 	t.CompletedAt = &now
 	t.Error = err.Error()
+	t.NextAttemptAt = nil
+	t.finishAttempt(now, t.Error)
 
 	if t.StartedAt != nil {
 		duration := now.Sub(*t.StartedAt)
@@ -102,6 +258,8 @@ func (t *Task) Cancel() {
 	now := time.Now()
 	t.Status = TaskStatusCancelled
 	t.CompletedAt = &now
+	t.NextAttemptAt = nil
+	t.finishAttempt(now, "")
 
 	if t.StartedAt != nil {
 		duration := now.Sub(*t.StartedAt)
@@ -109,11 +267,99 @@ func (t *Task) Cancel() {
 	}
 }
 
+// TimeOut marks the task as timed out after the given timeout
+func (t *Task) TimeOut(timeout time.Duration) {
+	now := time.Now()
+	t.Status = TaskStatusTimedOut
+	t.CompletedAt = &now
+	t.Error = fmt.Sprintf("task timed out after %s", timeout)
+	t.finishAttempt(now, t.Error)
+
+	if t.StartedAt != nil {
+		duration := now.Sub(*t.StartedAt)
+		t.Duration = &duration
+	}
+}
+
+// Requeue resets a task that was interrupted mid-run back to pending
+func (t *Task) Requeue() {
+	t.Status = TaskStatusPending
+	t.StartedAt = nil
+	t.CompletedAt = nil
+	t.Duration = nil
+	t.Error = ""
+	t.Output = nil
+	t.NextAttemptAt = nil
+}
+
+// finishAttempt closes the current attempt, if it is still open
+func (t *Task) finishAttempt(now time.Time, errMsg string) {
+	if len(t.Attempts) == 0 {
+		return
+	}
+	attempt := &t.Attempts[len(t.Attempts)-1]
+	if attempt.CompletedAt != nil {
+		return
+	}
+	attempt.CompletedAt = &now
+	attempt.Error = errMsg
+}
+
+// Clone returns a copy of the task that can be modified independently
+func (t *Task) Clone() *Task {
+	clone := *t
+	clone.Input = cloneMap(t.Input)
+	clone.Output = cloneMap(t.Output)
+	clone.Metadata = cloneMap(t.Metadata)
+	if t.Labels != nil {
+		clone.Labels = make(map[string]string, len(t.Labels))
+		for key, value := range t.Labels {
+			clone.Labels[key] = value
+		}
+	}
+	if t.StartedAt != nil {
+		startedAt := *t.StartedAt
+		clone.StartedAt = &startedAt
+	}
+	if t.CompletedAt != nil {
+		completedAt := *t.CompletedAt
+		clone.CompletedAt = &completedAt
+	}
+	if t.Duration != nil {
+		duration := *t.Duration
+		clone.Duration = &duration
+	}
+	if t.Retry != nil {
+		retry := *t.Retry
+		clone.Retry = &retry
+	}
+	if t.Attempts != nil {
+		clone.Attempts = make([]TaskAttempt, len(t.Attempts))
+		for i, attempt := range t.Attempts {
+			if attempt.CompletedAt != nil {
+				completedAt := *attempt.CompletedAt
+				attempt.CompletedAt = &completedAt
+			}
+			clone.Attempts[i] = attempt
+		}
+	}
+	if t.NextAttemptAt != nil {
+		nextAttempt := *t.NextAttemptAt
+		clone.NextAttemptAt = &nextAttempt
+	}
+	if t.RunAt != nil {
+		runAt := *t.RunAt
+		clone.RunAt = &runAt
+	}
+	return &clone
+}
+
 // IsFinished returns true if the task is in a finished state
 func (t *Task) IsFinished() bool {
 	return t.Status == TaskStatusCompleted ||
 		   t.Status == TaskStatusFailed ||
-		   t.Status == TaskStatusCancelled
+		   t.Status == TaskStatusCancelled ||
+		   t.Status == TaskStatusTimedOut
 }
 
 // ToJSON converts the task to JSON string
@@ -134,3 +380,15 @@ func FromJSON(jsonStr string) (*Task, error) {
 	}
 	return &task, nil
 }
+
+// cloneMap returns a shallow copy of the given map
+func cloneMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	clone := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		clone[key] = value
+	}
+	return clone
+}
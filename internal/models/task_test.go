@@ -2,6 +2,7 @@ package models
 
 import (
 	"encoding/json"
+	"errors"
 	"testing"
 	"time"
 )
@@ -49,6 +50,80 @@ func TestTaskStart(t *testing.T) {
 	}
 }
 
+func TestTaskSchedule(t *testing.T) {
+	task := NewTask("echo", map[string]interface{}{}, false)
+
+	runAt := time.Now().Add(time.Minute)
+	task.Schedule(runAt)
+
+	if task.Status != TaskStatusScheduled {
+		t.Errorf("Expected status %s, got %s", TaskStatusScheduled, task.Status)
+	}
+	if task.RunAt == nil || !task.RunAt.Equal(runAt) {
+		t.Errorf("Expected RunAt %v, got %v", runAt, task.RunAt)
+	}
+
+	clone := task.Clone()
+	task.RunAt = nil
+	if clone.RunAt == nil {
+		t.Error("Expected clone to keep its RunAt")
+	}
+
+	task.Release()
+	if task.Status != TaskStatusPending {
+		t.Errorf("Expected status %s, got %s", TaskStatusPending, task.Status)
+	}
+}
+
+func TestTaskAttempts(t *testing.T) {
+	task := NewTask("error", map[string]interface{}{}, false)
+
+	task.Start()
+	firstStart := *task.StartedAt
+
+	retryAt := time.Now().Add(time.Second)
+	task.ScheduleRetry(errors.New("boom"), retryAt)
+
+	if task.Status != TaskStatusRetrying {
+		t.Errorf("Expected status %s, got %s", TaskStatusRetrying, task.Status)
+	}
+	if task.NextAttemptAt == nil || !task.NextAttemptAt.Equal(retryAt) {
+		t.Errorf("Expected next attempt at %v, got %v", retryAt, task.NextAttemptAt)
+	}
+	if task.IsFinished() {
+		t.Error("Expected retrying task not to be finished")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	task.Start()
+
+	if !task.StartedAt.Equal(firstStart) {
+		t.Error("Expected StartedAt to keep the first attempt's start time")
+	}
+	if task.NextAttemptAt != nil {
+		t.Error("Expected NextAttemptAt to be cleared")
+	}
+
+	task.Fail(errors.New("boom again"))
+
+	if task.Attempt() != 2 {
+		t.Fatalf("Expected 2 attempts, got %d", task.Attempt())
+	}
+	if task.Attempts[0].Number != 1 || task.Attempts[0].Error != "boom" || task.Attempts[0].CompletedAt == nil {
+		t.Errorf("Unexpected first attempt: %+v", task.Attempts[0])
+	}
+	if task.Attempts[1].Number != 2 || task.Attempts[1].Error != "boom again" || task.Attempts[1].CompletedAt == nil {
+		t.Errorf("Unexpected second attempt: %+v", task.Attempts[1])
+	}
+
+	// Attempts of a clone are independent
+	clone := task.Clone()
+	clone.Attempts[0].Error = "changed"
+	if task.Attempts[0].Error != "boom" {
+		t.Error("Expected clone attempts to be independent")
+	}
+}
+
 func TestTaskComplete(t *testing.T) {
 	task := NewTask("echo", map[string]interface{}{}, false)
 	task.Start()
@@ -135,6 +210,26 @@ func TestTaskCancel(t *testing.T) {
 	}
 }
 
+func TestTaskTimeOut(t *testing.T) {
+	task := NewTask("sleep", map[string]interface{}{}, false)
+	task.Start()
+
+	task.TimeOut(5 * time.Second)
+
+	if task.Status != TaskStatusTimedOut {
+		t.Errorf("Expected status %s, got %s", TaskStatusTimedOut, task.Status)
+	}
+	if task.Error != "task timed out after 5s" {
+		t.Errorf("Expected error 'task timed out after 5s', got %s", task.Error)
+	}
+	if task.CompletedAt == nil {
+		t.Error("Expected non-nil CompletedAt")
+	}
+	if task.Duration == nil {
+		t.Error("Expected non-nil Duration")
+	}
+}
+
 func TestTaskIsFinished(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -146,6 +241,8 @@ func TestTaskIsFinished(t *testing.T) {
 		{"completed", TaskStatusCompleted, true},
 		{"failed", TaskStatusFailed, true},
 		{"cancelled", TaskStatusCancelled, true},
+		{"timed out", TaskStatusTimedOut, true},
+		{"scheduled", TaskStatusScheduled, false},
 	}
 
 	for _, tt := range tests {
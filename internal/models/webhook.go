@@ -0,0 +1,112 @@
+package models
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DeliveryStatus represents the status of a webhook delivery
+type DeliveryStatus string
+
+const (
+	DeliveryStatusPending   DeliveryStatus = "pending"
+	DeliveryStatusSucceeded DeliveryStatus = "succeeded"
+	DeliveryStatusFailed    DeliveryStatus = "failed"
+)
+
+// Delivery sources tell whether a delivery goes to the callback URL of the
+// task or to a webhook subscription from the configuration
+const (
+	DeliverySourceCallback     = "callback"
+	DeliverySourceSubscription = "subscription"
+)
+
+// WebhookDelivery records the delivery of a finished task to a webhook
+// receiver, with every attempt made so far
+type WebhookDelivery struct {
+	ID            string            `json:"id"`
+	TaskID        string            `json:"task_id"`
+	Event         string            `json:"event"`
+	URL           string            `json:"url"`
+	Source        string            `json:"source"`
+	Status        DeliveryStatus    `json:"status"`
+	Attempts      []DeliveryAttempt `json:"attempts"`
+	NextAttemptAt *time.Time        `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	CompletedAt   *time.Time        `json:"completed_at,omitempty"`
+}
+
+// DeliveryAttempt records a single attempt to deliver a webhook. StatusCode
+// is 0 when no response was received.
+type DeliveryAttempt struct {
+	Number      int       `json:"number"`
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int64     `json:"duration_ms"`
+}
+
+// WebhookPayload is the body posted to webhook receivers
+type WebhookPayload struct {
+	Event      string `json:"event"`
+	DeliveryID string `json:"delivery_id"`
+	Task       *Task  `json:"task"`
+}
+
+// DeliveryListResponse represents the response for listing the webhook
+// deliveries of a task
+type DeliveryListResponse struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+	Total      int               `json:"total"`
+}
+
+// NewWebhookDelivery creates a pending delivery of a task event to a URL
+func NewWebhookDelivery(taskID, event, url, source string) *WebhookDelivery {
+	return &WebhookDelivery{
+		ID:        uuid.New().String(),
+		TaskID:    taskID,
+		Event:     event,
+		URL:       url,
+		Source:    source,
+		Status:    DeliveryStatusPending,
+		Attempts:  []DeliveryAttempt{},
+		CreatedAt: time.Now(),
+	}
+}
+
+// IsFinished reports whether the delivery succeeded or was given up
+func (d *WebhookDelivery) IsFinished() bool {
+	return d.Status == DeliveryStatusSucceeded || d.Status == DeliveryStatusFailed
+}
+
+// Clone returns a deep copy of the delivery
+func (d *WebhookDelivery) Clone() *WebhookDelivery {
+	clone := *d
+	clone.Attempts = make([]DeliveryAttempt, len(d.Attempts))
+	copy(clone.Attempts, d.Attempts)
+	if d.NextAttemptAt != nil {
+		nextAttempt := *d.NextAttemptAt
+		clone.NextAttemptAt = &nextAttempt
+	}
+	if d.CompletedAt != nil {
+		completedAt := *d.CompletedAt
+		clone.CompletedAt = &completedAt
+	}
+	return &clone
+}
+
+// ValidateWebhookURL checks that a webhook URL is an absolute http or https
+// URL
+func ValidateWebhookURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL %q: %w", rawURL, err)
+	}
+	if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid webhook URL %q: must be an absolute http or https URL", rawURL)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WorkflowStatus represents the status of a workflow
+type WorkflowStatus string
+
+const (
+	WorkflowStatusRunning   WorkflowStatus = "running"
+	WorkflowStatusCompleted WorkflowStatus = "completed"
+	WorkflowStatusFailed    WorkflowStatus = "failed"
+	WorkflowStatusCancelled WorkflowStatus = "cancelled"
+)
+
+// NodeStatus represents the status of a workflow node. Once its task has
+// started, a node takes over the status of the task.
+type NodeStatus string
+
+const (
+	NodeStatusWaiting   NodeStatus = "waiting"
+	NodeStatusPending   NodeStatus = "pending"
+	NodeStatusScheduled NodeStatus = "scheduled"
+	NodeStatusRunning   NodeStatus = "running"
+	NodeStatusRetrying  NodeStatus = "retrying"
+	NodeStatusCompleted NodeStatus = "completed"
+	NodeStatusFailed    NodeStatus = "failed"
+	NodeStatusTimedOut  NodeStatus = "timed_out"
+	NodeStatusCancelled NodeStatus = "cancelled"
+	NodeStatusSkipped   NodeStatus = "skipped"
+)
+
+// Dependency conditions decide, per edge, which outcome of the upstream node
+// lets the downstream node run. When the condition can no longer be met the
+// downstream node is skipped.
+const (
+	// DependencyOnSuccess runs the node once the upstream node completed
+	DependencyOnSuccess = "success"
+	// DependencyOnFailure runs the node once the upstream node failed,
+	// timed out or was cancelled
+	DependencyOnFailure = "failure"
+	// DependencyAlways runs the node once the upstream node finished,
+	// whatever the outcome
+	DependencyAlways = "always"
+)
+
+// Workflow represents a DAG of tasks
+type Workflow struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name,omitempty"`
+	Status      WorkflowStatus `json:"status"`
+	Nodes       []WorkflowNode `json:"nodes"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	CompletedAt *time.Time     `json:"completed_at,omitempty"`
+	Version     int64          `json:"version"`
+}
+
+// WorkflowNodeRequest defines a node of a workflow: the task to run and the
+// nodes it depends on. Unlike the input of a task request, the input of a
+// node may hold {{ tasks.<node>.output... }} templates, resolved against the
+// outputs of its upstream nodes when it starts.
+type WorkflowNodeRequest struct {
+	ID string `json:"id" binding:"required"`
+	TaskRequest
+	DependsOn []WorkflowDependency `json:"depends_on,omitempty"`
+}
+
+// WorkflowNode is a node of a workflow along with its progress
+type WorkflowNode struct {
+	WorkflowNodeRequest
+	Status NodeStatus `json:"status"`
+	TaskID string     `json:"task_id,omitempty"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// WorkflowDependency is an edge from an upstream node. In requests it may be
+// given as the plain node ID, meaning the upstream node must succeed.
+type WorkflowDependency struct {
+	Node      string `json:"node"`
+	Condition string `json:"condition,omitempty"`
+}
+
+// UnmarshalJSON accepts a dependency as a node ID or as an object
+func (d *WorkflowDependency) UnmarshalJSON(data []byte) error {
+	var node string
+	if err := json.Unmarshal(data, &node); err == nil {
+		*d = WorkflowDependency{Node: node}
+		return nil
+	}
+
+	type dependency WorkflowDependency
+	return json.Unmarshal(data, (*dependency)(d))
+}
+
+// WorkflowRequest represents a request to create a workflow
+type WorkflowRequest struct {
+	Name  string                `json:"name"`
+	Nodes []WorkflowNodeRequest `json:"nodes" binding:"required,min=1,dive"`
+}
+
+// WorkflowResponse represents the response for a workflow
+type WorkflowResponse struct {
+	Workflow *Workflow `json:"workflow"`
+}
+
+// WorkflowListResponse represents the response for listing workflows
+type WorkflowListResponse struct {
+	Workflows []Workflow `json:"workflows"`
+	Total     int        `json:"total"`
+}
+
+// NewWorkflow creates a new workflow from the given request, with every node
+// waiting and every dependency condition filled in
+func NewWorkflow(req *WorkflowRequest) *Workflow {
+	now := time.Now()
+	workflow := &Workflow{
+		ID:        uuid.New().String(),
+		Name:      req.Name,
+		Status:    WorkflowStatusRunning,
+		Nodes:     make([]WorkflowNode, len(req.Nodes)),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	for i, nodeReq := range req.Nodes {
+		node := WorkflowNode{WorkflowNodeRequest: nodeReq, Status: NodeStatusWaiting}
+		node.DependsOn = make([]WorkflowDependency, len(nodeReq.DependsOn))
+		for j, dep := range nodeReq.DependsOn {
+			if dep.Condition == "" {
+				dep.Condition = DependencyOnSuccess
+			}
+			node.DependsOn[j] = dep
+		}
+		workflow.Nodes[i] = node
+	}
+	return workflow
+}
+
+// Node returns the node with the given ID, or nil
+func (w *Workflow) Node(nodeID string) *WorkflowNode {
+	for i := range w.Nodes {
+		if w.Nodes[i].ID == nodeID {
+			return &w.Nodes[i]
+		}
+	}
+	return nil
+}
+
+// IsFinished returns true if the workflow is in a finished state
+func (w *Workflow) IsFinished() bool {
+	return w.Status != WorkflowStatusRunning
+}
+
+// Finish marks the workflow with its final status
+func (w *Workflow) Finish(status WorkflowStatus) {
+	now := time.Now()
+	w.Status = status
+	w.CompletedAt = &now
+	w.UpdatedAt = now
+}
+
+// IsFinished returns true if the node will not change anymore
+func (n *WorkflowNode) IsFinished() bool {
+	switch n.Status {
+	case NodeStatusCompleted, NodeStatusFailed, NodeStatusTimedOut, NodeStatusCancelled, NodeStatusSkipped:
+		return true
+	}
+	return false
+}
+
+// Succeeded returns true if the task of the node completed
+func (n *WorkflowNode) Succeeded() bool {
+	return n.Status == NodeStatusCompleted
+}
+
+// Failed returns true if the task of the node failed, timed out or was
+// cancelled
+func (n *WorkflowNode) Failed() bool {
+	return n.Status == NodeStatusFailed || n.Status == NodeStatusTimedOut || n.Status == NodeStatusCancelled
+}
+
+// Clone returns a copy of the workflow that can be modified independently
+func (w *Workflow) Clone() *Workflow {
+	clone := *w
+	clone.CompletedAt = cloneTime(w.CompletedAt)
+	clone.Nodes = make([]WorkflowNode, len(w.Nodes))
+	for i, node := range w.Nodes {
+		node.Input = cloneMap(node.Input)
+		node.RunAt = cloneTime(node.RunAt)
+		if node.Retry != nil {
+			retry := *node.Retry
+			node.Retry = &retry
+		}
+		node.DependsOn = append([]WorkflowDependency(nil), node.DependsOn...)
+		clone.Nodes[i] = node
+	}
+	return &clone
+}
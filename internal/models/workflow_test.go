@@ -0,0 +1,86 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestWorkflowRequestUnmarshal(t *testing.T) {
+	data := `{
+		"name": "pipeline",
+		"nodes": [
+			{"id": "a", "type": "echo", "input": {"message": "hi"}},
+			{"id": "b", "type": "echo", "depends_on": ["a", {"node": "a", "condition": "failure"}]}
+		]
+	}`
+
+	var req WorkflowRequest
+	if err := json.Unmarshal([]byte(data), &req); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if req.Nodes[0].Type != "echo" || req.Nodes[0].Input["message"] != "hi" {
+		t.Errorf("Expected task fields to be inlined, got %+v", req.Nodes[0])
+	}
+
+	deps := req.Nodes[1].DependsOn
+	if len(deps) != 2 {
+		t.Fatalf("Expected 2 dependencies, got %d", len(deps))
+	}
+	if deps[0] != (WorkflowDependency{Node: "a"}) {
+		t.Errorf("Expected plain dependency on a, got %+v", deps[0])
+	}
+	if deps[1] != (WorkflowDependency{Node: "a", Condition: DependencyOnFailure}) {
+		t.Errorf("Expected failure dependency on a, got %+v", deps[1])
+	}
+}
+
+func TestNewWorkflow(t *testing.T) {
+	req := &WorkflowRequest{
+		Nodes: []WorkflowNodeRequest{
+			{ID: "a", TaskRequest: TaskRequest{Type: "echo"}},
+			{ID: "b", TaskRequest: TaskRequest{Type: "echo"}, DependsOn: []WorkflowDependency{{Node: "a"}}},
+		},
+	}
+
+	workflow := NewWorkflow(req)
+
+	if workflow.ID == "" {
+		t.Error("Expected non-empty ID")
+	}
+	if workflow.Status != WorkflowStatusRunning {
+		t.Errorf("Expected status %s, got %s", WorkflowStatusRunning, workflow.Status)
+	}
+	for _, node := range workflow.Nodes {
+		if node.Status != NodeStatusWaiting {
+			t.Errorf("Expected node %s to be waiting, got %s", node.ID, node.Status)
+		}
+	}
+	if condition := workflow.Node("b").DependsOn[0].Condition; condition != DependencyOnSuccess {
+		t.Errorf("Expected default condition %s, got %s", DependencyOnSuccess, condition)
+	}
+
+	// The request is left untouched
+	if req.Nodes[1].DependsOn[0].Condition != "" {
+		t.Error("Expected request dependency to be left untouched")
+	}
+}
+
+func TestWorkflowCloneIsIndependent(t *testing.T) {
+	workflow := NewWorkflow(&WorkflowRequest{
+		Nodes: []WorkflowNodeRequest{
+			{ID: "a", TaskRequest: TaskRequest{Type: "echo", Input: map[string]interface{}{"message": "hi"}}},
+		},
+	})
+
+	clone := workflow.Clone()
+	clone.Nodes[0].Status = NodeStatusCompleted
+	clone.Nodes[0].Input["message"] = "changed"
+
+	if workflow.Nodes[0].Status != NodeStatusWaiting {
+		t.Error("Expected original node status to be unchanged")
+	}
+	if workflow.Nodes[0].Input["message"] != "hi" {
+		t.Error("Expected original node input to be unchanged")
+	}
+}
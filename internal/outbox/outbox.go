@@ -0,0 +1,457 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go-fred/internal/backoff"
+	"go-fred/internal/config"
+	"go-fred/internal/events"
+	"go-fred/internal/models"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Polling, publish timeout and retries of the relay, unless configured
+const (
+	defaultBatchSize      = 100
+	defaultPollInterval   = time.Second
+	defaultPublishTimeout = 10 * time.Second
+	defaultMaxAttempts    = 10
+	defaultDelay          = time.Second
+	defaultMaxDelay       = time.Minute
+)
+
+// Outbox is a publisher that stores events before they are published, so
+// that they are not lost while the next publisher is failing. Publish only
+// appends the event to the store; a relay goroutine publishes the stored
+// events in order through the next publisher, retrying the oldest one with
+// exponential backoff until it is accepted. An event still rejected after
+// the maximum number of attempts is parked, so that it does not hold up the
+// events behind it.
+//
+// When the next publisher is an events.Router, such as the fan-out
+// publisher, every route has its own entries and relay: an event is stored
+// for the routes matching it and removed from each once that route accepted
+// it, so that a failing route neither holds up the others nor makes them get
+// the event again. Routes whose publisher is an events.BatchPublisher, such as
+// webhook endpoints, get their events in batches.
+//
+// With a BoltDB store the outbox is also the event recorder of the task
+// store: task events are appended in the transaction storing the task change
+// they describe, so that the event is stored if and only if the change is.
+type Outbox struct {
+	store          Store
+	next           events.Publisher
+	relays         []*relay
+	batchSize      int
+	pollInterval   time.Duration
+	publishTimeout time.Duration
+	done           chan struct{}
+	wg             sync.WaitGroup
+	closeOnce      sync.Once
+}
+
+// relay publishes the stored events of one route
+type relay struct {
+	outbox      *Outbox
+	route       events.Route
+	maxAttempts int
+	delay       time.Duration
+	maxDelay    time.Duration
+	// batchSize and batchTimeout batch the events of a batch publisher
+	batchSize    int
+	batchTimeout time.Duration
+	wake         chan struct{}
+
+	mu             sync.Mutex
+	published      int64
+	failedAttempts int64
+	lastError      string
+	lastErrorAt    *time.Time
+}
+
+// New creates an outbox publishing through next and starts its relays.
+// Events left in the store by a previous process are published first.
+func New(store Store, next events.Publisher, cfg *config.OutboxConfig) *Outbox {
+	o := &Outbox{
+		store:          store,
+		next:           next,
+		batchSize:      config.IntOr(cfg.BatchSize, defaultBatchSize),
+		pollInterval:   config.DurationOr(cfg.PollIntervalMs, time.Millisecond, defaultPollInterval),
+		publishTimeout: config.DurationOr(cfg.PublishTimeoutSeconds, time.Second, defaultPublishTimeout),
+		done:           make(chan struct{}),
+	}
+
+	// A publisher without routes is a single route
+	routes := []events.Route{{Publisher: next}}
+	if router, ok := next.(events.Router); ok {
+		routes = router.Routes()
+	}
+	for _, route := range routes {
+		r := &relay{
+			outbox:      o,
+			route:       route,
+			maxAttempts: config.IntOr(route.MaxAttempts, config.IntOr(cfg.MaxAttempts, defaultMaxAttempts)),
+			delay:       route.Delay,
+			maxDelay:    route.MaxDelay,
+			batchSize:   1,
+			wake:        make(chan struct{}, 1),
+		}
+		if _, ok := route.Publisher.(events.BatchPublisher); ok {
+			r.batchSize = config.IntOr(route.BatchSize, 1)
+			r.batchTimeout = route.BatchTimeout
+		}
+		if r.delay <= 0 {
+			r.delay = config.DurationOr(cfg.DelayMs, time.Millisecond, defaultDelay)
+		}
+		if r.maxDelay <= 0 {
+			r.maxDelay = config.DurationOr(cfg.MaxDelayMs, time.Millisecond, defaultMaxDelay)
+		}
+		o.relays = append(o.relays, r)
+	}
+
+	for _, r := range o.relays {
+		o.wg.Add(1)
+		go func() {
+			defer o.wg.Done()
+			r.run()
+		}()
+	}
+	return o
+}
+
+// Publish appends the event to the outbox of every route matching it,
+// unless it was already recorded with the task change it describes. Events
+// the outbox cannot store are not published.
+func (o *Outbox) Publish(ctx context.Context, event events.Event) error {
+	if events.StoredFromContext(ctx) {
+		return nil
+	}
+	routes := o.routes(event)
+	if len(routes) == 0 {
+		return nil
+	}
+	if _, err := o.store.Append(event, routes); err != nil {
+		log.Printf("Failed to append event %s to the outbox: %v", event.ID, err)
+		return fmt.Errorf("failed to append event %s to the outbox: %w", event.ID, err)
+	}
+
+	o.notify()
+	return nil
+}
+
+// RecordEvents appends the events of a task change in the transaction
+// storing the change, and wakes the relays once it is committed
+func (o *Outbox) RecordEvents(tx *bolt.Tx, evts []events.Event) error {
+	store, ok := o.store.(*BoltStore)
+	if !ok {
+		return errors.New("outbox store is not kept in the task store")
+	}
+	for _, event := range evts {
+		routes := o.routes(event)
+		if len(routes) == 0 {
+			continue
+		}
+		if _, err := store.AppendTx(tx, event, routes); err != nil {
+			return err
+		}
+	}
+	tx.OnCommit(o.notify)
+	return nil
+}
+
+// routes returns the names of the routes the event goes to
+func (o *Outbox) routes(event events.Event) []string {
+	var routes []string
+	for _, r := range o.relays {
+		if r.route.Matches == nil || r.route.Matches(event) {
+			routes = append(routes, r.route.Name)
+		}
+	}
+	return routes
+}
+
+// notify wakes the relays to publish newly stored events
+func (o *Outbox) notify() {
+	for _, r := range o.relays {
+		select {
+		case r.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Stats returns the size of the backlog and describes its oldest event. With
+// several routes, the totals are broken down by route.
+func (o *Outbox) Stats() (*models.OutboxStats, error) {
+	var routes []*models.OutboxStats
+	for _, r := range o.relays {
+		stats, err := r.stats()
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, stats)
+	}
+	if len(routes) == 1 && routes[0].Route == "" {
+		return routes[0], nil
+	}
+
+	total := &models.OutboxStats{Routes: routes}
+	for _, stats := range routes {
+		total.Backlog += stats.Backlog
+		total.Parked += stats.Parked
+		total.Published += stats.Published
+		total.FailedAttempts += stats.FailedAttempts
+		if stats.OldestEventAt != nil && (total.OldestEventAt == nil || stats.OldestEventAt.Before(*total.OldestEventAt)) {
+			total.OldestEventID = stats.OldestEventID
+			total.OldestEventType = stats.OldestEventType
+			total.OldestEventAt = stats.OldestEventAt
+			total.OldestEventAgeSeconds = stats.OldestEventAgeSeconds
+			total.OldestEventAttempts = stats.OldestEventAttempts
+			total.NextAttemptAt = stats.NextAttemptAt
+		}
+		if stats.LastErrorAt != nil && (total.LastErrorAt == nil || stats.LastErrorAt.After(*total.LastErrorAt)) {
+			total.LastError = fmt.Sprintf("%s: %s", stats.Route, stats.LastError)
+			total.LastErrorAt = stats.LastErrorAt
+		}
+	}
+	return total, nil
+}
+
+// Close stops the relays after a last attempt to publish the backlog, then
+// closes the next publisher. Events still in a durable store are published
+// after the next start.
+func (o *Outbox) Close() error {
+	o.closeOnce.Do(func() {
+		close(o.done)
+	})
+	o.wg.Wait()
+	return o.next.Close()
+}
+
+// stats describes the backlog of the route
+func (r *relay) stats() (*models.OutboxStats, error) {
+	store := r.outbox.store
+	backlog, err := store.Count(r.route.Name)
+	if err != nil {
+		return nil, err
+	}
+	parked, err := store.CountParked(r.route.Name)
+	if err != nil {
+		return nil, err
+	}
+	oldest, err := store.Pending(r.route.Name, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	stats := &models.OutboxStats{
+		Route:          r.route.Name,
+		Backlog:        backlog,
+		Parked:         parked,
+		Published:      r.published,
+		FailedAttempts: r.failedAttempts,
+		LastError:      r.lastError,
+		LastErrorAt:    r.lastErrorAt,
+	}
+	r.mu.Unlock()
+
+	if len(oldest) > 0 {
+		entry := oldest[0]
+		stats.OldestEventID = entry.Event.ID
+		stats.OldestEventType = entry.Event.Type
+		stats.OldestEventAt = &entry.CreatedAt
+		stats.OldestEventAgeSeconds = time.Since(entry.CreatedAt).Seconds()
+		stats.OldestEventAttempts = entry.Attempts
+		stats.NextAttemptAt = entry.NextAttemptAt
+	}
+	return stats, nil
+}
+
+// run relays the stored events of the route until the outbox is closed.
+// After a failure it waits for the backoff of the oldest event, which new
+// events queue behind; otherwise it waits for new events, polling the store
+// in case they were appended by someone else.
+func (r *relay) run() {
+	for {
+		timer := time.NewTimer(r.relay(false))
+		select {
+		case <-r.outbox.done:
+			timer.Stop()
+			r.relay(true)
+			return
+		case <-r.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// relay publishes stored events, oldest first, until the outbox of the route
+// is empty or publishing fails; an event failing its last attempt is parked
+// and the relay goes on. It returns how long to wait before relaying again.
+// Events waiting for their next attempt, or for their batch to fill up, are
+// only published early when ignoreBackoff is set.
+func (r *relay) relay(ignoreBackoff bool) time.Duration {
+	o := r.outbox
+	limit := max(o.batchSize, r.batchSize)
+	for {
+		entries, err := o.store.Pending(r.route.Name, limit)
+		if err != nil {
+			log.Printf("Failed to read the outbox: %v", err)
+			return o.pollInterval
+		}
+		if len(entries) == 0 {
+			return o.pollInterval
+		}
+
+		for pending := entries; len(pending) > 0; {
+			head := pending[0]
+			if head.NextAttemptAt != nil && !ignoreBackoff {
+				if wait := time.Until(*head.NextAttemptAt); wait > 0 {
+					return wait
+				}
+			}
+			batch := r.batch(pending)
+			// A new batch waits for more events until its oldest one is
+			// batchTimeout old
+			incomplete := len(batch) < r.batchSize && len(batch) == len(pending) && len(entries) < limit
+			if incomplete && head.Attempts == 0 && !ignoreBackoff {
+				if wait := time.Until(head.CreatedAt.Add(r.batchTimeout)); wait > 0 {
+					return wait
+				}
+			}
+			if err := r.publish(batch); err != nil {
+				if head.Attempts+1 < r.maxAttempts {
+					return r.recordFailure(batch, err)
+				}
+				if !r.park(batch, err) {
+					return o.pollInterval
+				}
+			}
+			pending = pending[len(batch):]
+		}
+	}
+}
+
+// batch returns the entries published together with the first one: those
+// following it with as many attempts, up to the batch size of the route, so
+// that a rejected batch is retried as a whole
+func (r *relay) batch(entries []*Entry) []*Entry {
+	n := 1
+	for n < len(entries) && n < r.batchSize && entries[n].Attempts == entries[0].Attempts {
+		n++
+	}
+	return entries[:n]
+}
+
+// publish hands a batch to the publisher of the route and removes it from
+// the store once accepted
+func (r *relay) publish(batch []*Entry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.outbox.publishTimeout)
+	defer cancel()
+
+	var err error
+	if publisher, ok := r.route.Publisher.(events.BatchPublisher); ok && len(batch) > 1 {
+		evts := make([]events.Event, len(batch))
+		for i, entry := range batch {
+			evts[i] = entry.Event
+		}
+		err = publisher.PublishBatch(ctx, evts)
+	} else {
+		err = r.route.Publisher.Publish(ctx, batch[0].Event)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range batch {
+		if err := r.outbox.store.Delete(entry); err != nil {
+			// The event may be published again after a restart
+			log.Printf("Failed to remove event %s from the outbox: %v", entry.Event.ID, err)
+		}
+	}
+
+	r.mu.Lock()
+	r.published += int64(len(batch))
+	r.mu.Unlock()
+	return nil
+}
+
+// recordFailure schedules the next attempt of a batch the publisher
+// rejected, and returns how long to wait for it
+func (r *relay) recordFailure(batch []*Entry, err error) time.Duration {
+	now := time.Now()
+	attempts := batch[0].Attempts + 1
+	wait := r.backoff(attempts)
+	nextAttempt := now.Add(wait)
+
+	for _, entry := range batch {
+		entry.Attempts = attempts
+		entry.LastError = err.Error()
+		entry.NextAttemptAt = &nextAttempt
+		if updateErr := r.outbox.store.Update(entry); updateErr != nil {
+			log.Printf("Failed to update outbox entry of event %s: %v", entry.Event.ID, updateErr)
+		}
+	}
+	r.recordError(now, err)
+
+	log.Printf("Failed to publish %s%s (attempt %d), retrying in %v: %v", describeBatch(batch), r.describe(), attempts, wait, err)
+	return wait
+}
+
+// park moves a batch that failed its last attempt out of the backlog, and
+// reports whether it was moved
+func (r *relay) park(batch []*Entry, err error) bool {
+	now := time.Now()
+	for _, entry := range batch {
+		entry.Attempts++
+		entry.LastError = err.Error()
+		entry.NextAttemptAt = nil
+		if parkErr := r.outbox.store.Park(entry); parkErr != nil {
+			log.Printf("Failed to park outbox entry of event %s: %v", entry.Event.ID, parkErr)
+			return false
+		}
+	}
+	r.recordError(now, err)
+
+	log.Printf("Parked %s%s after %d attempts: %v", describeBatch(batch), r.describe(), batch[0].Attempts, err)
+	return true
+}
+
+// describeBatch names the events of a batch in logs
+func describeBatch(batch []*Entry) string {
+	if len(batch) == 1 {
+		return "event " + batch[0].Event.ID
+	}
+	return fmt.Sprintf("%d events from %s", len(batch), batch[0].Event.ID)
+}
+
+// recordError counts a failed attempt for the stats
+func (r *relay) recordError(at time.Time, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failedAttempts++
+	r.lastError = err.Error()
+	r.lastErrorAt = &at
+}
+
+// describe names the route in logs, if it has a name
+func (r *relay) describe() string {
+	if r.route.Name == "" {
+		return ""
+	}
+	return " for publisher " + r.route.Name
+}
+
+// backoff returns the delay after the given failed attempt, doubling with
+// every attempt up to the maximum delay
+func (r *relay) backoff(attempt int) time.Duration {
+	return backoff.Exponential(r.delay, r.maxDelay, attempt)
+}
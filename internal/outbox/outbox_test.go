@@ -0,0 +1,385 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go-fred/internal/config"
+	"go-fred/internal/events"
+	"go-fred/internal/tasks"
+)
+
+// flakyPublisher records the events it accepts, fails while failing is set
+// and always rejects events of the rejected type
+type flakyPublisher struct {
+	mu       sync.Mutex
+	failing  bool
+	rejected string
+	attempts int
+	events   []events.Event
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, event events.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attempts++
+	if p.failing {
+		return errors.New("broker unavailable")
+	}
+	if event.Type == p.rejected {
+		return errors.New("message too large")
+	}
+	p.events = append(p.events, event)
+	return nil
+}
+
+func (p *flakyPublisher) Close() error {
+	return nil
+}
+
+func (p *flakyPublisher) setFailing(failing bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failing = failing
+}
+
+func (p *flakyPublisher) published() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	types := make([]string, len(p.events))
+	for i, event := range p.events {
+		types[i] = event.Type
+	}
+	return types
+}
+
+func (p *flakyPublisher) attemptCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.attempts
+}
+
+var testConfig = &config.OutboxConfig{PollIntervalMs: 10, DelayMs: 10, MaxDelayMs: 20}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func publishTypes(t *testing.T, publisher events.Publisher, eventTypes ...string) {
+	t.Helper()
+	for _, eventType := range eventTypes {
+		if err := publisher.Publish(context.Background(), events.NewEventBuilder(eventType).Build()); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+}
+
+func TestOutboxRelaysInOrder(t *testing.T) {
+	next := &flakyPublisher{}
+	outbox := New(NewMemoryStore(), next, testConfig)
+	defer outbox.Close()
+
+	publishTypes(t, outbox, "a", "b", "c")
+	waitFor(t, func() bool { return len(next.published()) == 3 })
+
+	if got := next.published(); got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Errorf("Expected events in order, got %v", got)
+	}
+	stats, err := outbox.Stats()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stats.Backlog != 0 || stats.Published != 3 || stats.OldestEventAt != nil {
+		t.Errorf("Expected an empty backlog after 3 events, got %+v", stats)
+	}
+}
+
+func TestOutboxRetriesFailedEvents(t *testing.T) {
+	next := &flakyPublisher{failing: true}
+	outbox := New(NewMemoryStore(), next, testConfig)
+	defer outbox.Close()
+
+	publishTypes(t, outbox, "a", "b")
+	waitFor(t, func() bool { return next.attemptCount() >= 2 })
+
+	// The backlog holds both events, the oldest one being retried
+	stats, err := outbox.Stats()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stats.Backlog != 2 || stats.OldestEventType != "a" || stats.OldestEventAttempts < 1 {
+		t.Errorf("Expected a backlog of 2 headed by a, got %+v", stats)
+	}
+	if stats.LastError != "broker unavailable" || stats.FailedAttempts < 1 || stats.OldestEventAt == nil {
+		t.Errorf("Expected the failure to be reported, got %+v", stats)
+	}
+
+	next.setFailing(false)
+	waitFor(t, func() bool { return len(next.published()) == 2 })
+	if got := next.published(); got[0] != "a" || got[1] != "b" {
+		t.Errorf("Expected events in order after recovery, got %v", got)
+	}
+}
+
+func TestOutboxParksFailingEvents(t *testing.T) {
+	next := &flakyPublisher{rejected: "poison"}
+	outbox := New(NewMemoryStore(), next, &config.OutboxConfig{PollIntervalMs: 10, MaxAttempts: 3, DelayMs: 10, MaxDelayMs: 20})
+	defer outbox.Close()
+
+	// The event rejected 3 times is parked, and the ones behind it go out
+	publishTypes(t, outbox, "a", "poison", "b")
+	waitFor(t, func() bool { return len(next.published()) == 2 })
+	if got := next.published(); got[0] != "a" || got[1] != "b" {
+		t.Errorf("Expected a and b, got %v", got)
+	}
+
+	stats, err := outbox.Stats()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stats.Backlog != 0 || stats.Parked != 1 {
+		t.Errorf("Expected an empty backlog and 1 parked event, got %+v", stats)
+	}
+	if stats.FailedAttempts != 3 || stats.LastError != "message too large" {
+		t.Errorf("Expected 3 failed attempts, got %+v", stats)
+	}
+}
+
+// routedPublisher delivers events to its routes, like the fan-out publisher.
+// Behind the outbox, only its routes are published to.
+type routedPublisher struct {
+	routes []events.Route
+}
+
+func (p *routedPublisher) Publish(ctx context.Context, event events.Event) error {
+	return errors.New("published past the routes")
+}
+
+func (p *routedPublisher) Close() error {
+	return nil
+}
+
+func (p *routedPublisher) Routes() []events.Route {
+	return p.routes
+}
+
+func TestOutboxRoutesIndependently(t *testing.T) {
+	kafka := &flakyPublisher{failing: true}
+	webhook := &flakyPublisher{}
+	alerts := &flakyPublisher{}
+	next := &routedPublisher{routes: []events.Route{
+		{Name: "kafka", Publisher: kafka, Delay: 5 * time.Millisecond},
+		{Name: "webhook", Publisher: webhook},
+		{Name: "alerts", Publisher: alerts, Matches: func(event events.Event) bool { return event.Type == "failed" }},
+	}}
+	outbox := New(NewMemoryStore(), next, testConfig)
+	defer outbox.Close()
+
+	// The healthy routes get every event they match once, while the broken
+	// one retries them
+	publishTypes(t, outbox, "a", "failed", "b")
+	waitFor(t, func() bool { return len(webhook.published()) == 3 && len(alerts.published()) == 1 })
+	waitFor(t, func() bool { return kafka.attemptCount() >= 3 })
+
+	stats, err := outbox.Stats()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stats.Backlog != 3 || stats.OldestEventType != "a" || len(stats.Routes) != 3 {
+		t.Fatalf("Expected the 3 events of the broken route, got %+v", stats)
+	}
+	if kafkaStats := stats.Routes[0]; kafkaStats.Route != "kafka" || kafkaStats.Backlog != 3 || kafkaStats.LastError != "broker unavailable" {
+		t.Errorf("Expected the backlog and failure of kafka, got %+v", kafkaStats)
+	}
+	if webhookStats := stats.Routes[1]; webhookStats.Backlog != 0 || webhookStats.Published != 3 {
+		t.Errorf("Expected the webhook to be done, got %+v", webhookStats)
+	}
+
+	kafka.setFailing(false)
+	waitFor(t, func() bool { return len(kafka.published()) == 3 })
+	if got := kafka.published(); got[0] != "a" || got[1] != "failed" || got[2] != "b" {
+		t.Errorf("Expected events in order after recovery, got %v", got)
+	}
+	if webhook.attemptCount() != 3 || alerts.attemptCount() != 1 {
+		t.Errorf("Expected the healthy routes to get every event once, got %d and %d attempts", webhook.attemptCount(), alerts.attemptCount())
+	}
+}
+
+// batchPublisher is a flaky publisher taking events in batches, recording
+// the types of every batch it accepts
+type batchPublisher struct {
+	flakyPublisher
+	batches [][]string
+}
+
+func (p *batchPublisher) PublishBatch(ctx context.Context, evts []events.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.attempts++
+	if p.failing {
+		return errors.New("endpoint unavailable")
+	}
+	var types []string
+	for _, event := range evts {
+		types = append(types, event.Type)
+	}
+	p.events = append(p.events, evts...)
+	p.batches = append(p.batches, types)
+	return nil
+}
+
+func (p *batchPublisher) Publish(ctx context.Context, event events.Event) error {
+	return p.PublishBatch(ctx, []events.Event{event})
+}
+
+func (p *batchPublisher) acceptedBatches() [][]string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][]string(nil), p.batches...)
+}
+
+func TestOutboxBatches(t *testing.T) {
+	endpoint := &batchPublisher{flakyPublisher: flakyPublisher{failing: true}}
+	next := &routedPublisher{routes: []events.Route{
+		{Name: "endpoint", Publisher: endpoint, Delay: 5 * time.Millisecond, BatchSize: 3, BatchTimeout: 50 * time.Millisecond},
+	}}
+	outbox := New(NewMemoryStore(), next, testConfig)
+	defer outbox.Close()
+
+	// A rejected batch is retried as a whole, and the events behind it make
+	// a batch of their own
+	publishTypes(t, outbox, "a", "b", "c", "d")
+	waitFor(t, func() bool { return endpoint.attemptCount() >= 2 })
+	endpoint.setFailing(false)
+	waitFor(t, func() bool { return len(endpoint.published()) == 4 })
+
+	batches := endpoint.acceptedBatches()
+	if len(batches) != 2 || len(batches[0]) != 3 || batches[0][0] != "a" || batches[0][2] != "c" || batches[1][0] != "d" {
+		t.Errorf("Expected the batches [a b c] and [d], got %v", batches)
+	}
+	stats, err := outbox.Stats()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if route := stats.Routes[0]; route.Published != 4 || route.Backlog != 0 {
+		t.Errorf("Expected 4 published events, got %+v", route)
+	}
+}
+
+// failingStore is an outbox store whose appends fail
+type failingStore struct {
+	*MemoryStore
+}
+
+func (s *failingStore) Append(event events.Event, routes []string) ([]*Entry, error) {
+	return nil, errors.New("disk full")
+}
+
+func TestOutboxAppendFailure(t *testing.T) {
+	next := &flakyPublisher{}
+	outbox := New(&failingStore{NewMemoryStore()}, next, testConfig)
+	defer outbox.Close()
+
+	// An event that cannot be stored is not published behind the outbox's back
+	err := outbox.Publish(context.Background(), events.NewEventBuilder("a").Build())
+	if err == nil {
+		t.Fatal("Expected error when the event cannot be stored")
+	}
+	if next.attemptCount() != 0 {
+		t.Errorf("Expected no publish, got %d attempts", next.attemptCount())
+	}
+}
+
+func TestOutboxRecordsTaskEvents(t *testing.T) {
+	taskStore := newBoltTaskStore(t, filepath.Join(t.TempDir(), "tasks.db"))
+	defer taskStore.Close()
+	store, err := NewStore(taskStore)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	next := &flakyPublisher{}
+	outbox := New(store, next, testConfig)
+	defer outbox.Close()
+	taskStore.SetEventRecorder(outbox)
+
+	registry := tasks.NewExecutorRegistry()
+	tasks.RegisterDefaultExecutors(registry)
+	taskManager := tasks.NewTaskManagerWithStore(registry, outbox, taskStore, 1)
+	defer taskManager.Close()
+
+	task, err := taskManager.CreateTask("echo", map[string]interface{}{"message": "hello"}, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := taskManager.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Every event was stored once, with its task change
+	waitFor(t, func() bool { return len(next.published()) >= 3 })
+	time.Sleep(50 * time.Millisecond)
+	got := next.published()
+	if len(got) != 3 || got[0] != events.EventTypeTaskCreated || got[1] != events.EventTypeTaskStarted || got[2] != events.EventTypeTaskCompleted {
+		t.Errorf("Expected the created, started and completed events once, got %v", got)
+	}
+}
+
+func TestOutboxSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.db")
+
+	// Events published while the next publisher is down stay in the store
+	taskStore := newBoltTaskStore(t, path)
+	store, err := NewStore(taskStore)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	down := &flakyPublisher{failing: true}
+	outbox := New(store, down, testConfig)
+	publishTypes(t, outbox, "a", "b")
+	if err := outbox.Close(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	taskStore.Close()
+
+	// and are published after the restart
+	taskStore = newBoltTaskStore(t, path)
+	defer taskStore.Close()
+	store, err = NewStore(taskStore)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	up := &flakyPublisher{}
+	outbox = New(store, up, testConfig)
+	defer outbox.Close()
+
+	waitFor(t, func() bool { return len(up.published()) == 2 })
+	if got := up.published(); got[0] != "a" || got[1] != "b" {
+		t.Errorf("Expected the stored events in order, got %v", got)
+	}
+	waitFor(t, func() bool {
+		count, _ := store.Count("")
+		return count == 0
+	})
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	r := &relay{delay: 100 * time.Millisecond, maxDelay: 500 * time.Millisecond}
+
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 500 * time.Millisecond}
+	for i, want := range expected {
+		if got := r.backoff(i + 1); got != want {
+			t.Errorf("Expected backoff %v after attempt %d, got %v", want, i+1, got)
+		}
+	}
+}
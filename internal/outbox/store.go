@@ -0,0 +1,373 @@
+package outbox
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go-fred/internal/events"
+	"go-fred/internal/tasks"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrEntryNotFound is returned when an entry does not exist in the store
+var ErrEntryNotFound = errors.New("outbox entry not found")
+
+var (
+	outboxBucket = []byte("event_outbox")
+	parkedBucket = []byte("event_outbox_parked")
+)
+
+// Entry is an event waiting in the outbox for one route. Sequences grow with
+// every appended event, are shared by the entries of an event and give the
+// order events are published in.
+type Entry struct {
+	Sequence      uint64       `json:"sequence"`
+	Route         string       `json:"route,omitempty"`
+	Event         events.Event `json:"event"`
+	CreatedAt     time.Time    `json:"created_at"`
+	Attempts      int          `json:"attempts"`
+	LastError     string       `json:"last_error,omitempty"`
+	NextAttemptAt *time.Time   `json:"next_attempt_at,omitempty"`
+}
+
+// Store defines the interface for outbox persistence. Every route has its
+// own entries, published and removed independently of the other routes.
+type Store interface {
+	// Append stores an event at the end of the outbox of every route
+	Append(event events.Event, routes []string) ([]*Entry, error)
+	// Pending returns up to limit entries of the route, oldest first
+	Pending(route string, limit int) ([]*Entry, error)
+	// Update records a failed attempt to publish an entry
+	Update(entry *Entry) error
+	// Delete removes an entry once it was published
+	Delete(entry *Entry) error
+	// Count returns the number of entries of the route
+	Count(route string) (int, error)
+	// Park moves an entry that ran out of attempts out of the outbox, so
+	// that the entries behind it are published. Parked entries are kept for
+	// inspection but not published again.
+	Park(entry *Entry) error
+	// CountParked returns the number of parked entries of the route
+	CountParked(route string) (int, error)
+}
+
+// NewStore creates an outbox store next to the given task store, so that
+// events are durable whenever tasks are
+func NewStore(taskStore tasks.TaskStore) (Store, error) {
+	if boltStore, ok := taskStore.(*tasks.BoltTaskStore); ok {
+		return NewBoltStore(boltStore.DB())
+	}
+	return NewMemoryStore(), nil
+}
+
+// entryID identifies an entry of a route
+type entryID struct {
+	route    string
+	sequence uint64
+}
+
+// MemoryStore keeps the outbox in process memory. Events survive failures of
+// the publisher, but not restarts.
+type MemoryStore struct {
+	entries  map[entryID]*Entry
+	parked   map[entryID]*Entry
+	sequence uint64
+	mu       sync.Mutex
+}
+
+// NewMemoryStore creates a new in-memory outbox store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: make(map[entryID]*Entry),
+		parked:  make(map[entryID]*Entry),
+	}
+}
+
+// Append stores an event at the end of the outbox of every route
+func (s *MemoryStore) Append(event events.Event, routes []string) ([]*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sequence++
+	appended := make([]*Entry, 0, len(routes))
+	for _, route := range routes {
+		entry := &Entry{Sequence: s.sequence, Route: route, Event: event, CreatedAt: time.Now()}
+		s.entries[entry.id()] = entry
+		copied := *entry
+		appended = append(appended, &copied)
+	}
+	return appended, nil
+}
+
+// Pending returns up to limit entries of the route, oldest first
+func (s *MemoryStore) Pending(route string, limit int) ([]*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var entries []*Entry
+	for id, entry := range s.entries {
+		if id.route != route {
+			continue
+		}
+		copied := *entry
+		entries = append(entries, &copied)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Sequence < entries[j].Sequence
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// Update records a failed attempt to publish an entry
+func (s *MemoryStore) Update(entry *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.entries[entry.id()]; !exists {
+		return entry.notFound()
+	}
+	copied := *entry
+	s.entries[entry.id()] = &copied
+	return nil
+}
+
+// Delete removes an entry once it was published
+func (s *MemoryStore) Delete(entry *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.entries[entry.id()]; !exists {
+		return entry.notFound()
+	}
+	delete(s.entries, entry.id())
+	return nil
+}
+
+// Count returns the number of entries of the route
+func (s *MemoryStore) Count(route string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return countRoute(s.entries, route), nil
+}
+
+// Park moves an entry that ran out of attempts out of the outbox
+func (s *MemoryStore) Park(entry *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.entries[entry.id()]; !exists {
+		return entry.notFound()
+	}
+	delete(s.entries, entry.id())
+	copied := *entry
+	s.parked[entry.id()] = &copied
+	return nil
+}
+
+// CountParked returns the number of parked entries of the route
+func (s *MemoryStore) CountParked(route string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return countRoute(s.parked, route), nil
+}
+
+// countRoute counts the entries of a route
+func countRoute(entries map[entryID]*Entry, route string) int {
+	count := 0
+	for id := range entries {
+		if id.route == route {
+			count++
+		}
+	}
+	return count
+}
+
+// BoltStore persists the outbox in a BoltDB file shared with the task
+// store. Entries are keyed by route and big-endian sequence, so that a
+// cursor walks the entries of a route in order. It does not own the file;
+// closing the task store closes it.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore creates an outbox store on an open BoltDB handle
+func NewBoltStore(db *bolt.DB) (*BoltStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(outboxBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(parkedBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize bolt outbox store: %w", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// Append stores an event at the end of the outbox of every route
+func (s *BoltStore) Append(event events.Event, routes []string) ([]*Entry, error) {
+	var appended []*Entry
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		appended, err = s.AppendTx(tx, event, routes)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return appended, nil
+}
+
+// AppendTx stores an event at the end of the outbox of every route in a
+// transaction of the shared file, so that it is stored if and only if the
+// rest of the transaction is
+func (s *BoltStore) AppendTx(tx *bolt.Tx, event events.Event, routes []string) ([]*Entry, error) {
+	bucket := tx.Bucket(outboxBucket)
+	sequence, err := bucket.NextSequence()
+	if err != nil {
+		return nil, err
+	}
+
+	appended := make([]*Entry, 0, len(routes))
+	for _, route := range routes {
+		entry := &Entry{Sequence: sequence, Route: route, Event: event, CreatedAt: time.Now()}
+		if err := putEntry(bucket, entry); err != nil {
+			return nil, err
+		}
+		appended = append(appended, entry)
+	}
+	return appended, nil
+}
+
+// Pending returns up to limit entries of the route, oldest first
+func (s *BoltStore) Pending(route string, limit int) ([]*Entry, error) {
+	var entries []*Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := routePrefix(route)
+		cursor := tx.Bucket(outboxBucket).Cursor()
+		for key, data := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix) && len(entries) < limit; key, data = cursor.Next() {
+			entry, err := decodeEntry(data)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Update records a failed attempt to publish an entry
+func (s *BoltStore) Update(entry *Entry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(outboxBucket)
+		if bucket.Get(entry.key()) == nil {
+			return entry.notFound()
+		}
+		return putEntry(bucket, entry)
+	})
+}
+
+// Delete removes an entry once it was published
+func (s *BoltStore) Delete(entry *Entry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(outboxBucket)
+		if bucket.Get(entry.key()) == nil {
+			return entry.notFound()
+		}
+		return bucket.Delete(entry.key())
+	})
+}
+
+// Count returns the number of entries of the route
+func (s *BoltStore) Count(route string) (int, error) {
+	return s.count(outboxBucket, route)
+}
+
+// Park moves an entry that ran out of attempts out of the outbox
+func (s *BoltStore) Park(entry *Entry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(outboxBucket)
+		if bucket.Get(entry.key()) == nil {
+			return entry.notFound()
+		}
+		if err := bucket.Delete(entry.key()); err != nil {
+			return err
+		}
+		return putEntry(tx.Bucket(parkedBucket), entry)
+	})
+}
+
+// CountParked returns the number of parked entries of the route
+func (s *BoltStore) CountParked(route string) (int, error) {
+	return s.count(parkedBucket, route)
+}
+
+// count returns the number of entries of the route in a bucket
+func (s *BoltStore) count(bucket []byte, route string) (int, error) {
+	var count int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := routePrefix(route)
+		cursor := tx.Bucket(bucket).Cursor()
+		for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
+
+// id returns the identity of the entry in the memory store
+func (e *Entry) id() entryID {
+	return entryID{route: e.Route, sequence: e.Sequence}
+}
+
+// key returns the key of the entry in the bolt store: the route prefix and
+// the big-endian sequence, so that keys of a route sort in sequence order
+func (e *Entry) key() []byte {
+	return binary.BigEndian.AppendUint64(routePrefix(e.Route), e.Sequence)
+}
+
+// notFound returns the error of an entry missing from the store
+func (e *Entry) notFound() error {
+	return fmt.Errorf("%w: %d of route %q", ErrEntryNotFound, e.Sequence, e.Route)
+}
+
+// routePrefix returns the prefix of the keys of a route's entries. Route
+// names end at a zero byte, so that no route's keys run into another's.
+func routePrefix(route string) []byte {
+	return append([]byte(route), 0)
+}
+
+// putEntry encodes an entry and writes it to the bucket
+func putEntry(bucket *bolt.Bucket, entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox entry: %w", err)
+	}
+	return bucket.Put(entry.key(), data)
+}
+
+// decodeEntry decodes a stored entry
+func decodeEntry(data []byte) (*Entry, error) {
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal outbox entry: %w", err)
+	}
+	return &entry, nil
+}
@@ -0,0 +1,120 @@
+package outbox
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"go-fred/internal/events"
+	"go-fred/internal/tasks"
+)
+
+func newBoltTaskStore(t *testing.T, path string) *tasks.BoltTaskStore {
+	t.Helper()
+	taskStore, err := tasks.NewBoltTaskStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return taskStore
+}
+
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return NewMemoryStore() },
+		"bolt": func(t *testing.T) Store {
+			taskStore := newBoltTaskStore(t, filepath.Join(t.TempDir(), "tasks.db"))
+			t.Cleanup(func() { taskStore.Close() })
+
+			store, err := NewStore(taskStore)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			return store
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+
+			var appended [][]*Entry
+			for _, eventType := range []string{"a", "b", "c"} {
+				entries, err := store.Append(events.NewEventBuilder(eventType).WithTaskID("task-1").Build(), []string{"kafka", "webhook"})
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if len(entries) != 2 || entries[0].Route != "kafka" || entries[1].Sequence != entries[0].Sequence {
+					t.Fatalf("Expected an entry per route with the same sequence, got %+v", entries)
+				}
+				appended = append(appended, entries)
+			}
+			if appended[0][0].Sequence >= appended[1][0].Sequence || appended[1][0].Sequence >= appended[2][0].Sequence {
+				t.Errorf("Expected growing sequences, got %d, %d, %d", appended[0][0].Sequence, appended[1][0].Sequence, appended[2][0].Sequence)
+			}
+
+			pending, err := store.Pending("kafka", 2)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(pending) != 2 || pending[0].Event.Type != "a" || pending[1].Event.Type != "b" {
+				t.Fatalf("Expected the 2 oldest entries, got %+v", pending)
+			}
+			if pending[0].Event.Data["task_id"] != "task-1" {
+				t.Errorf("Expected the event data to be stored, got %v", pending[0].Event.Data)
+			}
+
+			pending[0].Attempts = 1
+			pending[0].LastError = "broker down"
+			if err := store.Update(pending[0]); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if err := store.Delete(appended[1][0]); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if err := store.Delete(appended[1][0]); !errors.Is(err, ErrEntryNotFound) {
+				t.Errorf("Expected ErrEntryNotFound, got %v", err)
+			}
+
+			pending, err = store.Pending("kafka", 10)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(pending) != 2 || pending[0].Attempts != 1 || pending[0].LastError != "broker down" || pending[1].Event.Type != "c" {
+				t.Errorf("Expected the updated entry and c, got %+v", pending)
+			}
+			if count, err := store.Count("kafka"); err != nil || count != 2 {
+				t.Errorf("Expected 2 entries, got %d (%v)", count, err)
+			}
+
+			// The entries of the other route are left alone
+			others, err := store.Pending("webhook", 10)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(others) != 3 || others[0].Attempts != 0 || others[1].Event.Type != "b" {
+				t.Errorf("Expected the 3 untouched entries of the webhook, got %+v", others)
+			}
+
+			// A parked entry leaves the outbox
+			if err := store.Park(pending[0]); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if err := store.Park(pending[0]); !errors.Is(err, ErrEntryNotFound) {
+				t.Errorf("Expected ErrEntryNotFound, got %v", err)
+			}
+			pending, err = store.Pending("kafka", 10)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(pending) != 1 || pending[0].Event.Type != "c" {
+				t.Errorf("Expected only c, got %+v", pending)
+			}
+			if count, err := store.CountParked("kafka"); err != nil || count != 1 {
+				t.Errorf("Expected 1 parked entry, got %d (%v)", count, err)
+			}
+			if count, err := store.CountParked("webhook"); err != nil || count != 0 {
+				t.Errorf("Expected no parked entry of the webhook, got %d (%v)", count, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+// Package records keeps the records that live next to the tasks, such as
+// schedules, workflows and webhook deliveries, either in process memory or in
+// a bucket of the BoltDB file of the task store.
+package records
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go-fred/internal/tasks"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Kind describes a type of record: where it is stored, how it is keyed and
+// copied, and the errors reported for it
+type Kind[T any] struct {
+	// Name names the record in error messages
+	Name string
+	// Bucket is the BoltDB bucket the records are kept in
+	Bucket []byte
+	// Key returns the key a record is stored under
+	Key func(record *T) string
+	// Version returns the version field of a record. Like tasks.TaskStore,
+	// updates of versioned records only succeed when the version matches the
+	// stored one. Records without a version are overwritten by updates.
+	Version func(record *T) *int64
+	// Clone returns a deep copy of a record
+	Clone func(record *T) *T
+
+	// ErrNotFound is returned when a record does not exist in the store
+	ErrNotFound error
+	// ErrExists is returned when creating a record whose key is already stored
+	ErrExists error
+	// ErrVersionConflict is returned when updating a record that was modified
+	// concurrently
+	ErrVersionConflict error
+}
+
+// Store defines the interface for record persistence
+type Store[T any] interface {
+	Create(record *T) error
+	Get(key string) (*T, error)
+	List() ([]*T, error)
+	// ListPrefix returns the records whose key starts with the prefix
+	ListPrefix(prefix string) ([]*T, error)
+	Update(record *T) error
+	Delete(key string) error
+}
+
+// NewStore creates a record store next to the given task store, so that the
+// records are durable whenever tasks are
+func NewStore[T any](taskStore tasks.TaskStore, kind Kind[T]) (Store[T], error) {
+	if boltStore, ok := taskStore.(*tasks.BoltTaskStore); ok {
+		return NewBoltStore(boltStore.DB(), kind)
+	}
+	return NewMemoryStore(kind), nil
+}
+
+// checkVersion returns an error unless a versioned record matches the stored
+// version
+func (k Kind[T]) checkVersion(stored, record *T) error {
+	if k.Version == nil {
+		return nil
+	}
+	if storedVersion, version := *k.Version(stored), *k.Version(record); storedVersion != version {
+		return fmt.Errorf("%w: %s (expected version %d, got %d)", k.ErrVersionConflict, k.Key(record), storedVersion, version)
+	}
+	return nil
+}
+
+// setVersion sets the version of a versioned record
+func (k Kind[T]) setVersion(record *T, version int64) {
+	if k.Version != nil {
+		*k.Version(record) = version
+	}
+}
+
+// bumpVersion increments the version of a versioned record by delta
+func (k Kind[T]) bumpVersion(record *T, delta int64) {
+	if k.Version != nil {
+		*k.Version(record) += delta
+	}
+}
+
+// MemoryStore keeps records in process memory
+type MemoryStore[T any] struct {
+	kind    Kind[T]
+	records map[string]*T
+	mu      sync.RWMutex
+}
+
+// NewMemoryStore creates a new in-memory record store
+func NewMemoryStore[T any](kind Kind[T]) *MemoryStore[T] {
+	return &MemoryStore[T]{
+		kind:    kind,
+		records: make(map[string]*T),
+	}
+}
+
+// Create stores a new record
+func (s *MemoryStore[T]) Create(record *T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := s.kind.Key(record)
+	if _, exists := s.records[key]; exists {
+		return fmt.Errorf("%w: %s", s.kind.ErrExists, key)
+	}
+
+	s.kind.setVersion(record, 1)
+	s.records[key] = s.kind.Clone(record)
+	return nil
+}
+
+// Get retrieves a record by key
+func (s *MemoryStore[T]) Get(key string) (*T, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, exists := s.records[key]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", s.kind.ErrNotFound, key)
+	}
+	return s.kind.Clone(record), nil
+}
+
+// List returns all stored records
+func (s *MemoryStore[T]) List() ([]*T, error) {
+	return s.ListPrefix("")
+}
+
+// ListPrefix returns the records whose key starts with the prefix
+func (s *MemoryStore[T]) ListPrefix(prefix string) ([]*T, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := make([]*T, 0, len(s.records))
+	for key, record := range s.records {
+		if strings.HasPrefix(key, prefix) {
+			records = append(records, s.kind.Clone(record))
+		}
+	}
+	return records, nil
+}
+
+// Update replaces a stored record if its version matches
+func (s *MemoryStore[T]) Update(record *T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := s.kind.Key(record)
+	stored, exists := s.records[key]
+	if !exists {
+		return fmt.Errorf("%w: %s", s.kind.ErrNotFound, key)
+	}
+	if err := s.kind.checkVersion(stored, record); err != nil {
+		return err
+	}
+
+	s.kind.bumpVersion(record, 1)
+	s.records[key] = s.kind.Clone(record)
+	return nil
+}
+
+// Delete removes a record by key
+func (s *MemoryStore[T]) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.records[key]; !exists {
+		return fmt.Errorf("%w: %s", s.kind.ErrNotFound, key)
+	}
+	delete(s.records, key)
+	return nil
+}
+
+// BoltStore persists records in a bucket of the BoltDB file shared with the
+// task store. It does not own the file; closing the task store closes it.
+type BoltStore[T any] struct {
+	kind Kind[T]
+	db   *bolt.DB
+}
+
+// NewBoltStore creates a record store on an open BoltDB handle
+func NewBoltStore[T any](db *bolt.DB, kind Kind[T]) (*BoltStore[T], error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(kind.Bucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize bolt %s store: %w", kind.Name, err)
+	}
+
+	return &BoltStore[T]{kind: kind, db: db}, nil
+}
+
+// Create stores a new record
+func (s *BoltStore[T]) Create(record *T) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(s.kind.Bucket)
+		key := s.kind.Key(record)
+		if bucket.Get([]byte(key)) != nil {
+			return fmt.Errorf("%w: %s", s.kind.ErrExists, key)
+		}
+
+		s.kind.setVersion(record, 1)
+		if err := s.put(bucket, record); err != nil {
+			s.kind.setVersion(record, 0)
+			return err
+		}
+		return nil
+	})
+}
+
+// Get retrieves a record by key
+func (s *BoltStore[T]) Get(key string) (*T, error) {
+	var record *T
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(s.kind.Bucket).Get([]byte(key))
+		if data == nil {
+			return fmt.Errorf("%w: %s", s.kind.ErrNotFound, key)
+		}
+
+		var err error
+		record, err = s.decode(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// List returns all stored records
+func (s *BoltStore[T]) List() ([]*T, error) {
+	return s.ListPrefix("")
+}
+
+// ListPrefix returns the records whose key starts with the prefix, read
+// with a prefix scan
+func (s *BoltStore[T]) ListPrefix(prefix string) ([]*T, error) {
+	var records []*T
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(s.kind.Bucket).Cursor()
+		for key, data := cursor.Seek([]byte(prefix)); key != nil && bytes.HasPrefix(key, []byte(prefix)); key, data = cursor.Next() {
+			record, err := s.decode(data)
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Update replaces a stored record if its version matches
+func (s *BoltStore[T]) Update(record *T) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(s.kind.Bucket)
+		key := s.kind.Key(record)
+		data := bucket.Get([]byte(key))
+		if data == nil {
+			return fmt.Errorf("%w: %s", s.kind.ErrNotFound, key)
+		}
+
+		stored, err := s.decode(data)
+		if err != nil {
+			return err
+		}
+		if err := s.kind.checkVersion(stored, record); err != nil {
+			return err
+		}
+
+		s.kind.bumpVersion(record, 1)
+		if err := s.put(bucket, record); err != nil {
+			s.kind.bumpVersion(record, -1)
+			return err
+		}
+		return nil
+	})
+}
+
+// Delete removes a record by key
+func (s *BoltStore[T]) Delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(s.kind.Bucket)
+		if bucket.Get([]byte(key)) == nil {
+			return fmt.Errorf("%w: %s", s.kind.ErrNotFound, key)
+		}
+		return bucket.Delete([]byte(key))
+	})
+}
+
+// put encodes a record and writes it to the bucket
+func (s *BoltStore[T]) put(bucket *bolt.Bucket, record *T) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", s.kind.Name, err)
+	}
+	return bucket.Put([]byte(s.kind.Key(record)), data)
+}
+
+// decode decodes a stored record
+func (s *BoltStore[T]) decode(data []byte) (*T, error) {
+	var record T
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", s.kind.Name, err)
+	}
+	return &record, nil
+}
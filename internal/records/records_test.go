@@ -0,0 +1,279 @@
+package records
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"go-fred/internal/tasks"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	errNoteNotFound = errors.New("note not found")
+	errNoteExists   = errors.New("note already exists")
+	errNoteConflict = errors.New("note version conflict")
+)
+
+// note is the record the tests store
+type note struct {
+	ID      string   `json:"id"`
+	Text    string   `json:"text"`
+	Tags    []string `json:"tags"`
+	Version int64    `json:"version"`
+}
+
+// noteKind stores notes by ID, guarding updates by their version
+var noteKind = Kind[note]{
+	Name:    "note",
+	Bucket:  []byte("notes"),
+	Key:     func(n *note) string { return n.ID },
+	Version: func(n *note) *int64 { return &n.Version },
+	Clone: func(n *note) *note {
+		clone := *n
+		clone.Tags = append([]string(nil), n.Tags...)
+		return &clone
+	},
+	ErrNotFound:        errNoteNotFound,
+	ErrExists:          errNoteExists,
+	ErrVersionConflict: errNoteConflict,
+}
+
+// newBoltDB opens a BoltDB file that is closed when the test ends
+func newBoltDB(t *testing.T) *bolt.DB {
+	t.Helper()
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "records.db"), 0600, nil)
+	if err != nil {
+		t.Fatalf("Failed to open bolt file: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newStores returns an in-memory and a BoltDB store of the kind
+func newStores(t *testing.T, kind Kind[note]) map[string]Store[note] {
+	t.Helper()
+	boltStore, err := NewBoltStore(newBoltDB(t), kind)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return map[string]Store[note]{
+		"memory": NewMemoryStore(kind),
+		"bolt":   boltStore,
+	}
+}
+
+func TestStoreVersions(t *testing.T) {
+	for name, store := range newStores(t, noteKind) {
+		t.Run(name, func(t *testing.T) {
+			n := &note{ID: "n1", Text: "first"}
+			if err := store.Create(n); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if n.Version != 1 {
+				t.Errorf("Expected version 1 after create, got %d", n.Version)
+			}
+
+			stale := noteKind.Clone(n)
+			n.Text = "second"
+			if err := store.Update(n); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if n.Version != 2 {
+				t.Errorf("Expected version 2 after update, got %d", n.Version)
+			}
+
+			// The stale copy is rejected and keeps its version
+			stale.Text = "lost"
+			if err := store.Update(stale); !errors.Is(err, errNoteConflict) {
+				t.Errorf("Expected %v, got %v", errNoteConflict, err)
+			}
+			if stale.Version != 1 {
+				t.Errorf("Expected the rejected copy to keep version 1, got %d", stale.Version)
+			}
+
+			stored, err := store.Get("n1")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if stored.Text != "second" || stored.Version != 2 {
+				t.Errorf("Expected the second text at version 2, got %+v", stored)
+			}
+		})
+	}
+}
+
+func TestStoreUnversioned(t *testing.T) {
+	kind := noteKind
+	kind.Version = nil
+
+	for name, store := range newStores(t, kind) {
+		t.Run(name, func(t *testing.T) {
+			if err := store.Create(&note{ID: "n1", Text: "first"}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// Updates overwrite the record whatever its version
+			if err := store.Update(&note{ID: "n1", Text: "second", Version: 7}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			stored, err := store.Get("n1")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if stored.Text != "second" || stored.Version != 7 {
+				t.Errorf("Expected the overwritten record, got %+v", stored)
+			}
+		})
+	}
+}
+
+func TestStoreCopies(t *testing.T) {
+	for name, store := range newStores(t, noteKind) {
+		t.Run(name, func(t *testing.T) {
+			n := &note{ID: "n1", Tags: []string{"a"}}
+			if err := store.Create(n); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// Changing the created or retrieved record leaves the stored one alone
+			n.Tags[0] = "changed"
+			retrieved, err := store.Get("n1")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			retrieved.Tags[0] = "changed"
+
+			stored, err := store.Get("n1")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if stored.Tags[0] != "a" {
+				t.Errorf("Expected the stored record to be unchanged, got %+v", stored)
+			}
+		})
+	}
+}
+
+func TestStoreListPrefix(t *testing.T) {
+	for name, store := range newStores(t, noteKind) {
+		t.Run(name, func(t *testing.T) {
+			for _, id := range []string{"task-1/b", "task-1/a", "task-10/a", "task-2/a"} {
+				if err := store.Create(&note{ID: id}); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+
+			listed, err := store.ListPrefix("task-1/")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			var ids []string
+			for _, n := range listed {
+				ids = append(ids, n.ID)
+			}
+			sort.Strings(ids)
+			if len(ids) != 2 || ids[0] != "task-1/a" || ids[1] != "task-1/b" {
+				t.Errorf("Expected the notes of task-1, got %v", ids)
+			}
+
+			all, err := store.List()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(all) != 4 {
+				t.Errorf("Expected 4 notes, got %d", len(all))
+			}
+
+			none, err := store.ListPrefix("task-3/")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(none) != 0 {
+				t.Errorf("Expected no notes, got %+v", none)
+			}
+		})
+	}
+}
+
+func TestStoreMissingRecords(t *testing.T) {
+	for name, store := range newStores(t, noteKind) {
+		t.Run(name, func(t *testing.T) {
+			if _, err := store.Get("missing"); !errors.Is(err, errNoteNotFound) {
+				t.Errorf("Expected %v, got %v", errNoteNotFound, err)
+			}
+			if err := store.Update(&note{ID: "missing"}); !errors.Is(err, errNoteNotFound) {
+				t.Errorf("Expected %v, got %v", errNoteNotFound, err)
+			}
+			if err := store.Delete("missing"); !errors.Is(err, errNoteNotFound) {
+				t.Errorf("Expected %v, got %v", errNoteNotFound, err)
+			}
+
+			if err := store.Create(&note{ID: "n1"}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if err := store.Create(&note{ID: "n1"}); !errors.Is(err, errNoteExists) {
+				t.Errorf("Expected %v, got %v", errNoteExists, err)
+			}
+		})
+	}
+}
+
+func TestBoltStoreSharesFile(t *testing.T) {
+	db := newBoltDB(t)
+	store, err := NewBoltStore(db, noteKind)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := store.Create(&note{ID: "n1", Text: "kept"}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A second store on the same bucket sees the records, and creating it
+	// again leaves them in place
+	other, err := NewBoltStore(db, noteKind)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	stored, err := other.Get("n1")
+	if err != nil || stored.Text != "kept" {
+		t.Errorf("Expected the stored note, got %+v, %v", stored, err)
+	}
+
+	// Records of another kind live in their own bucket
+	kind := noteKind
+	kind.Bucket = []byte("other-notes")
+	separate, err := NewBoltStore(db, kind)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := separate.Get("n1"); !errors.Is(err, errNoteNotFound) {
+		t.Errorf("Expected %v, got %v", errNoteNotFound, err)
+	}
+}
+
+func TestNewStore(t *testing.T) {
+	store, err := NewStore(tasks.NewMemoryTaskStore(), noteKind)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := store.(*MemoryStore[note]); !ok {
+		t.Errorf("Expected an in-memory store next to an in-memory task store, got %T", store)
+	}
+
+	taskStore, err := tasks.NewBoltTaskStore(filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer taskStore.Close()
+
+	store, err = NewStore(taskStore, noteKind)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := store.(*BoltStore[note]); !ok {
+		t.Errorf("Expected a bolt store next to a bolt task store, got %T", store)
+	}
+}
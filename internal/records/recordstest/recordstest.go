@@ -0,0 +1,141 @@
+// Package recordstest runs the tests shared by every kind of record store
+// against both the in-memory and the BoltDB backend.
+package recordstest
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"go-fred/internal/records"
+	"go-fred/internal/tasks"
+)
+
+// Records describes how the shared tests build and change records
+type Records[T any] struct {
+	// New returns a new record with a fresh key
+	New func() *T
+	// Modify changes a record
+	Modify func(record *T)
+	// Modified reports whether a record carries the change made by Modify
+	Modified func(record *T) bool
+}
+
+// TestStores runs the shared store tests on the stores newStore creates next
+// to an in-memory and a BoltDB task store
+func TestStores[T any](t *testing.T, kind records.Kind[T], newStore func(taskStore tasks.TaskStore) (records.Store[T], error), recs Records[T]) {
+	backends := map[string]func(t *testing.T) records.Store[T]{
+		"memory": func(t *testing.T) records.Store[T] {
+			store, err := newStore(tasks.NewMemoryTaskStore())
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if _, ok := store.(*records.MemoryStore[T]); !ok {
+				t.Errorf("Expected in-memory %s store, got %T", kind.Name, store)
+			}
+			return store
+		},
+		"bolt": func(t *testing.T) records.Store[T] {
+			taskStore, err := tasks.NewBoltTaskStore(filepath.Join(t.TempDir(), "tasks.db"))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			t.Cleanup(func() { taskStore.Close() })
+
+			store, err := newStore(taskStore)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if _, ok := store.(*records.BoltStore[T]); !ok {
+				t.Errorf("Expected bolt %s store, got %T", kind.Name, store)
+			}
+			return store
+		},
+	}
+
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			store := newBackend(t)
+
+			record := recs.New()
+			key := kind.Key(record)
+			if err := store.Create(record); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if err := store.Create(record); !errors.Is(err, kind.ErrExists) {
+				t.Errorf("Expected %v, got %v", kind.ErrExists, err)
+			}
+
+			retrieved, err := store.Get(key)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if kind.Key(retrieved) != key {
+				t.Errorf("Expected stored %s %s, got %+v", kind.Name, key, retrieved)
+			}
+
+			recs.Modify(retrieved)
+			if err := store.Update(retrieved); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// Updating a stale copy is rejected
+			if kind.Version != nil {
+				if err := store.Update(record); !errors.Is(err, kind.ErrVersionConflict) {
+					t.Errorf("Expected %v, got %v", kind.ErrVersionConflict, err)
+				}
+			}
+
+			stored, err := store.List()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(stored) != 1 || !recs.Modified(stored[0]) {
+				t.Errorf("Expected 1 updated %s, got %+v", kind.Name, stored)
+			}
+
+			if err := store.Delete(key); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if _, err := store.Get(key); !errors.Is(err, kind.ErrNotFound) {
+				t.Errorf("Expected %v, got %v", kind.ErrNotFound, err)
+			}
+			if err := store.Update(retrieved); !errors.Is(err, kind.ErrNotFound) {
+				t.Errorf("Expected %v, got %v", kind.ErrNotFound, err)
+			}
+		})
+	}
+
+	t.Run("reopen", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "tasks.db")
+		taskStore, err := tasks.NewBoltTaskStore(path)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+
+		store, err := newStore(taskStore)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		record := recs.New()
+		if err := store.Create(record); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		taskStore.Close()
+
+		// Records survive reopening the file
+		taskStore, err = tasks.NewBoltTaskStore(path)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		defer taskStore.Close()
+
+		store, err = newStore(taskStore)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if _, err := store.Get(kind.Key(record)); err != nil {
+			t.Errorf("Expected %s to survive restart, got %v", kind.Name, err)
+		}
+	})
+}
@@ -0,0 +1,480 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"go-fred/internal/events"
+	"go-fred/internal/models"
+	"go-fred/internal/tasks"
+
+	"github.com/robfig/cron/v3"
+)
+
+// defaultTickInterval is how often the scheduler looks for due schedules
+const defaultTickInterval = time.Second
+
+// Scheduler creates tasks from schedules when they are due
+type Scheduler struct {
+	taskManager  *tasks.TaskManager
+	store        ScheduleStore
+	eventPub     events.Publisher
+	tickInterval time.Duration
+	mu           sync.Mutex
+	stop         chan struct{}
+	done         chan struct{}
+}
+
+// NewScheduler creates a new scheduler
+func NewScheduler(taskManager *tasks.TaskManager, store ScheduleStore, eventPub events.Publisher) *Scheduler {
+	return &Scheduler{
+		taskManager:  taskManager,
+		store:        store,
+		eventPub:     eventPub,
+		tickInterval: defaultTickInterval,
+	}
+}
+
+// Start runs the scheduler loop in the background
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stop != nil {
+		return
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+
+	go s.run(s.stop, s.done)
+}
+
+// Stop stops the scheduler loop and waits for it to exit
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+// run ticks until stopped
+func (s *Scheduler) run(stop, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(s.tickInterval)
+	defer ticker.Stop()
+
+	for {
+		s.tick(time.Now())
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// CreateSchedule creates a new schedule
+func (s *Scheduler) CreateSchedule(req *models.ScheduleRequest) (*models.Schedule, error) {
+	schedule := models.NewSchedule(req)
+	if err := s.validate(schedule); err != nil {
+		return nil, err
+	}
+
+	nextRunAt, err := nextRun(schedule, schedule.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	schedule.NextRunAt = nextRunAt
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.store.Create(schedule); err != nil {
+		return nil, fmt.Errorf("failed to store schedule: %w", err)
+	}
+
+	events.PublishScheduleEvent(context.Background(), s.eventPub, events.EventTypeScheduleCreated, schedule.ID)
+
+	return schedule, nil
+}
+
+// GetSchedule retrieves a schedule by ID
+func (s *Scheduler) GetSchedule(scheduleID string) (*models.Schedule, error) {
+	return s.store.Get(scheduleID)
+}
+
+// ListSchedules returns all schedules
+func (s *Scheduler) ListSchedules() []*models.Schedule {
+	schedules, err := s.store.List()
+	if err != nil {
+		log.Printf("Failed to list schedules: %v", err)
+		return []*models.Schedule{}
+	}
+	return schedules
+}
+
+// UpdateSchedule replaces the definition of a schedule and recomputes its
+// next run
+func (s *Scheduler) UpdateSchedule(scheduleID string, req *models.ScheduleRequest) (*models.Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, err := s.store.Get(scheduleID)
+	if err != nil {
+		return nil, err
+	}
+
+	schedule.Apply(req)
+	if err := s.validate(schedule); err != nil {
+		return nil, err
+	}
+
+	nextRunAt, err := nextRun(schedule, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	schedule.NextRunAt = nextRunAt
+
+	if err := s.store.Update(schedule); err != nil {
+		return nil, fmt.Errorf("failed to update schedule: %w", err)
+	}
+
+	events.PublishScheduleEvent(context.Background(), s.eventPub, events.EventTypeScheduleUpdated, schedule.ID)
+
+	return schedule, nil
+}
+
+// DeleteSchedule removes a schedule. Tasks it already started are kept,
+// tasks still queued behind them are cancelled.
+func (s *Scheduler) DeleteSchedule(scheduleID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, err := s.store.Get(scheduleID)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Delete(scheduleID); err != nil {
+		return err
+	}
+	s.cancelQueued(schedule)
+
+	events.PublishScheduleEvent(context.Background(), s.eventPub, events.EventTypeScheduleDeleted, scheduleID)
+
+	return nil
+}
+
+// PauseSchedule stops a schedule from creating tasks until it is resumed
+func (s *Scheduler) PauseSchedule(scheduleID string) (*models.Schedule, error) {
+	return s.setPaused(scheduleID, true, events.EventTypeSchedulePaused)
+}
+
+// ResumeSchedule lets a paused schedule create tasks again. Runs missed while
+// paused are handled by the misfire policy.
+func (s *Scheduler) ResumeSchedule(scheduleID string) (*models.Schedule, error) {
+	return s.setPaused(scheduleID, false, events.EventTypeScheduleResumed)
+}
+
+// setPaused pauses or resumes a schedule
+func (s *Scheduler) setPaused(scheduleID string, paused bool, eventType string) (*models.Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedule, err := s.store.Get(scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Paused == paused {
+		return schedule, nil
+	}
+
+	schedule.Paused = paused
+	schedule.UpdatedAt = time.Now()
+	if err := s.store.Update(schedule); err != nil {
+		return nil, fmt.Errorf("failed to update schedule: %w", err)
+	}
+
+	events.PublishScheduleEvent(context.Background(), s.eventPub, eventType, schedule.ID)
+
+	return schedule, nil
+}
+
+// validate checks the definition of a schedule
+func (s *Scheduler) validate(schedule *models.Schedule) error {
+	if err := s.taskManager.ValidateTaskType(schedule.TaskType); err != nil {
+		return err
+	}
+
+	if (schedule.Cron == "") == (schedule.IntervalSeconds == 0) {
+		return fmt.Errorf("exactly one of cron and interval_seconds must be set")
+	}
+	if schedule.IntervalSeconds < 0 {
+		return fmt.Errorf("interval_seconds must be positive")
+	}
+	if schedule.JitterSeconds < 0 {
+		return fmt.Errorf("jitter_seconds must not be negative")
+	}
+	if schedule.StartAt != nil && schedule.EndAt != nil && !schedule.EndAt.After(*schedule.StartAt) {
+		return fmt.Errorf("end_at must be after start_at")
+	}
+
+	switch schedule.MisfirePolicy {
+	case models.MisfirePolicySkip, models.MisfirePolicyRunOnce:
+	default:
+		return fmt.Errorf("unsupported misfire policy: %s", schedule.MisfirePolicy)
+	}
+
+	switch schedule.OverlapPolicy {
+	case models.OverlapPolicySkip, models.OverlapPolicyQueue, models.OverlapPolicyCancelPrevious:
+	default:
+		return fmt.Errorf("unsupported overlap policy: %s", schedule.OverlapPolicy)
+	}
+
+	// Parses the cron expression and timezone
+	_, err := nextRun(schedule, time.Now())
+	return err
+}
+
+// tick fires every schedule that is due at the given time
+func (s *Scheduler) tick(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	schedules, err := s.store.List()
+	if err != nil {
+		log.Printf("Failed to list schedules: %v", err)
+		return
+	}
+
+	for _, schedule := range schedules {
+		changed := s.dispatchQueued(schedule)
+
+		if !schedule.Paused && schedule.NextRunAt != nil && !schedule.NextRunAt.After(now) {
+			if err := s.fireDue(schedule, now); err != nil {
+				log.Printf("Failed to run schedule %s: %v", schedule.ID, err)
+			}
+			changed = true
+		}
+
+		if changed {
+			if err := s.store.Update(schedule); err != nil {
+				log.Printf("Failed to update schedule %s: %v", schedule.ID, err)
+			}
+		}
+	}
+}
+
+// fireDue handles a due run of the schedule and moves it to its next run
+func (s *Scheduler) fireDue(schedule *models.Schedule, now time.Time) error {
+	ctx := context.Background()
+
+	// A run is misfired when the one after it is due as well, i.e. at least
+	// a whole period was missed
+	following, err := nextRun(schedule, *schedule.NextRunAt)
+	if err != nil {
+		return err
+	}
+	misfired := following != nil && !following.After(now)
+
+	schedule.NextRunAt, err = nextRun(schedule, now)
+	if err != nil {
+		return err
+	}
+
+	if misfired && schedule.MisfirePolicy == models.MisfirePolicySkip {
+		events.PublishScheduleSkipped(ctx, s.eventPub, schedule.ID, "misfire")
+		return nil
+	}
+
+	return s.fire(schedule, now)
+}
+
+// fire creates a task for the schedule, applying its overlap policy when the
+// task of the previous run has not finished yet
+func (s *Scheduler) fire(schedule *models.Schedule, now time.Time) error {
+	ctx := context.Background()
+
+	if s.hasUnfinishedRun(schedule) {
+		switch schedule.OverlapPolicy {
+		case models.OverlapPolicySkip:
+			events.PublishScheduleSkipped(ctx, s.eventPub, schedule.ID, "overlap")
+			return nil
+		case models.OverlapPolicyQueue:
+			task, err := s.taskManager.CreateTask(schedule.TaskType, schedule.Input, true)
+			if err != nil {
+				return err
+			}
+			schedule.QueuedTaskIDs = append(schedule.QueuedTaskIDs, task.ID)
+			schedule.LastRunAt = &now
+			events.PublishScheduleTriggered(ctx, s.eventPub, schedule.ID, task.ID, true)
+			return nil
+		case models.OverlapPolicyCancelPrevious:
+			s.cancelPrevious(schedule)
+		}
+	}
+
+	task, err := s.taskManager.CreateTask(schedule.TaskType, schedule.Input, true)
+	if err != nil {
+		return err
+	}
+	// The run is due, so like delayed tasks it is not held to the queue
+	// depth; a task that cannot be started is not left pending
+	if err := s.taskManager.SubmitTaskAsync(ctx, task.ID); err != nil {
+		if cancelErr := s.taskManager.CancelTask(task.ID); cancelErr != nil {
+			log.Printf("Failed to cancel unstarted task %s of schedule %s: %v", task.ID, schedule.ID, cancelErr)
+		}
+		return err
+	}
+
+	schedule.LastTaskID = task.ID
+	schedule.LastRunAt = &now
+	events.PublishScheduleTriggered(ctx, s.eventPub, schedule.ID, task.ID, false)
+
+	return nil
+}
+
+// hasUnfinishedRun reports whether a task created by the schedule is still
+// pending, running or queued
+func (s *Scheduler) hasUnfinishedRun(schedule *models.Schedule) bool {
+	if len(schedule.QueuedTaskIDs) > 0 {
+		return true
+	}
+	if schedule.LastTaskID == "" {
+		return false
+	}
+
+	task, err := s.taskManager.GetTask(schedule.LastTaskID)
+	if err != nil {
+		return false
+	}
+	return !task.IsFinished()
+}
+
+// cancelPrevious cancels the queued and running tasks of the schedule
+func (s *Scheduler) cancelPrevious(schedule *models.Schedule) {
+	s.cancelQueued(schedule)
+
+	if schedule.LastTaskID == "" {
+		return
+	}
+	last, err := s.taskManager.GetTask(schedule.LastTaskID)
+	if err != nil || last.IsFinished() {
+		return
+	}
+	if err := s.taskManager.CancelTask(last.ID); err != nil {
+		log.Printf("Failed to cancel previous task %s of schedule %s: %v", last.ID, schedule.ID, err)
+	}
+}
+
+// cancelQueued cancels the tasks of the schedule still waiting for their turn
+func (s *Scheduler) cancelQueued(schedule *models.Schedule) {
+	for _, taskID := range schedule.QueuedTaskIDs {
+		if err := s.taskManager.CancelTask(taskID); err != nil {
+			log.Printf("Failed to cancel queued task %s of schedule %s: %v", taskID, schedule.ID, err)
+		}
+	}
+	schedule.QueuedTaskIDs = nil
+}
+
+// dispatchQueued starts the next queued task of the schedule once the
+// previous one has finished. It reports whether the schedule changed.
+func (s *Scheduler) dispatchQueued(schedule *models.Schedule) bool {
+	changed := false
+
+	for len(schedule.QueuedTaskIDs) > 0 {
+		if schedule.LastTaskID != "" {
+			last, err := s.taskManager.GetTask(schedule.LastTaskID)
+			if err == nil && !last.IsFinished() {
+				return changed
+			}
+		}
+
+		// Queued tasks may have been cancelled in the meantime; any other
+		// task that cannot be started stays first in line for the next tick
+		taskID := schedule.QueuedTaskIDs[0]
+		if err := s.taskManager.SubmitTaskAsync(context.Background(), taskID); err != nil {
+			log.Printf("Failed to start queued task %s of schedule %s: %v", taskID, schedule.ID, err)
+			if task, err := s.taskManager.GetTask(taskID); err == nil && !task.IsFinished() {
+				return changed
+			}
+		} else {
+			schedule.LastTaskID = taskID
+		}
+
+		schedule.QueuedTaskIDs = schedule.QueuedTaskIDs[1:]
+		if len(schedule.QueuedTaskIDs) == 0 {
+			schedule.QueuedTaskIDs = nil
+		}
+		changed = true
+	}
+
+	return changed
+}
+
+// nextRun returns the first run of the schedule after the given time, within
+// its start and end bounds and with its jitter applied. It returns nil once
+// the schedule has ended.
+func nextRun(schedule *models.Schedule, after time.Time) (*time.Time, error) {
+	location := time.UTC
+	if schedule.Timezone != "" {
+		var err error
+		location, err = time.LoadLocation(schedule.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone: %w", err)
+		}
+	}
+
+	var next time.Time
+	if schedule.Cron != "" {
+		spec, err := cron.ParseStandard(schedule.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression: %w", err)
+		}
+
+		// Cron schedules may start right at start_at
+		if schedule.StartAt != nil && after.Before(*schedule.StartAt) {
+			after = schedule.StartAt.Add(-time.Second)
+		}
+		next = spec.Next(after.In(location))
+		if next.IsZero() {
+			return nil, nil
+		}
+	} else {
+		// Interval schedules run every interval counted from start_at, or
+		// from their creation, so that neither late ticks nor the jitter make
+		// them drift. Runs missed before the given time are skipped.
+		anchor := schedule.CreatedAt
+		if schedule.StartAt != nil {
+			anchor = *schedule.StartAt
+		} else if anchor.IsZero() {
+			anchor = after
+		}
+		if after.Before(anchor) {
+			next = anchor
+		} else {
+			interval := time.Duration(schedule.IntervalSeconds) * time.Second
+			next = anchor.Add((after.Sub(anchor)/interval + 1) * interval)
+		}
+	}
+
+	if schedule.JitterSeconds > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(schedule.JitterSeconds) * int64(time.Second))))
+	}
+
+	if schedule.EndAt != nil && next.After(*schedule.EndAt) {
+		return nil, nil
+	}
+	return &next, nil
+}
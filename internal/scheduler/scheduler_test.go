@@ -0,0 +1,474 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go-fred/internal/events"
+	"go-fred/internal/models"
+	"go-fred/internal/tasks"
+)
+
+// mockPublisher is a mock event publisher for testing
+type mockPublisher struct {
+	events []events.Event
+	mu     sync.Mutex
+}
+
+func (m *mockPublisher) Publish(ctx context.Context, event events.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, event)
+	return nil
+}
+
+func (m *mockPublisher) Close() error {
+	return nil
+}
+
+// countEvents returns how many published events have the given type
+func (m *mockPublisher) countEvents(eventType string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, event := range m.events {
+		if event.Type == eventType {
+			count++
+		}
+	}
+	return count
+}
+
+func setupTestScheduler() (*Scheduler, *tasks.TaskManager, *mockPublisher) {
+	registry := tasks.NewExecutorRegistry()
+	tasks.RegisterDefaultExecutors(registry)
+
+	mockPub := &mockPublisher{}
+	taskManager := tasks.NewTaskManager(registry, mockPub, 5)
+
+	return NewScheduler(taskManager, NewMemoryScheduleStore(), mockPub), taskManager, mockPub
+}
+
+// waitForStatus waits until the task reaches the given status
+func waitForStatus(t *testing.T, taskManager *tasks.TaskManager, taskID string, status models.TaskStatus) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		task, err := taskManager.GetTask(taskID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if task.Status == status {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected task %s to be %s, got %s", taskID, status, task.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSchedulerFiresDueSchedule(t *testing.T) {
+	scheduler, taskManager, mockPub := setupTestScheduler()
+
+	schedule, err := scheduler.CreateSchedule(&models.ScheduleRequest{
+		TaskType:        "echo",
+		Input:           map[string]interface{}{"message": "hello"},
+		IntervalSeconds: 60,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Nothing happens before the schedule is due
+	scheduler.tick(time.Now())
+	if tasks := taskManager.ListTasks(); len(tasks) != 0 {
+		t.Fatalf("Expected no tasks, got %d", len(tasks))
+	}
+
+	now := schedule.NextRunAt.Add(time.Second)
+	scheduler.tick(now)
+
+	updated, err := scheduler.GetSchedule(schedule.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if updated.LastTaskID == "" {
+		t.Fatal("Expected schedule to record the created task")
+	}
+	if updated.NextRunAt == nil || !updated.NextRunAt.After(now) {
+		t.Errorf("Expected next run after %v, got %v", now, updated.NextRunAt)
+	}
+
+	waitForStatus(t, taskManager, updated.LastTaskID, models.TaskStatusCompleted)
+
+	if count := mockPub.countEvents(events.EventTypeScheduleTriggered); count != 1 {
+		t.Errorf("Expected 1 schedule.triggered event, got %d", count)
+	}
+}
+
+func TestSchedulerRunsPastDrainingQueue(t *testing.T) {
+	scheduler, taskManager, _ := setupTestScheduler()
+	if _, err := taskManager.DrainQueue(models.DefaultQueue); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	schedule, err := scheduler.CreateSchedule(&models.ScheduleRequest{
+		TaskType:        "sleep",
+		Input:           map[string]interface{}{"duration": 5.0},
+		IntervalSeconds: 60,
+		OverlapPolicy:   models.OverlapPolicyQueue,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Due runs are not turned away like new tasks, so none is left pending
+	first := schedule.NextRunAt.Add(time.Second)
+	scheduler.tick(first)
+	fired, err := scheduler.GetSchedule(schedule.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if fired.LastTaskID == "" {
+		t.Fatal("Expected schedule to record the created task")
+	}
+	waitForStatus(t, taskManager, fired.LastTaskID, models.TaskStatusRunning)
+
+	// Nor are queued runs, which start once the previous one has finished
+	scheduler.tick(first.Add(time.Minute))
+	queued, _ := scheduler.GetSchedule(schedule.ID)
+	if len(queued.QueuedTaskIDs) != 1 {
+		t.Fatalf("Expected 1 queued task, got %d", len(queued.QueuedTaskIDs))
+	}
+	queuedTaskID := queued.QueuedTaskIDs[0]
+
+	taskManager.CancelTask(fired.LastTaskID)
+	scheduler.tick(first.Add(time.Minute + time.Second))
+	waitForStatus(t, taskManager, queuedTaskID, models.TaskStatusRunning)
+
+	dispatched, _ := scheduler.GetSchedule(schedule.ID)
+	if dispatched.LastTaskID != queuedTaskID || len(dispatched.QueuedTaskIDs) != 0 {
+		t.Errorf("Expected last task %s and no queued tasks, got %s and %v", queuedTaskID, dispatched.LastTaskID, dispatched.QueuedTaskIDs)
+	}
+	taskManager.CancelTask(queuedTaskID)
+}
+
+func TestSchedulerPausedSchedule(t *testing.T) {
+	scheduler, taskManager, _ := setupTestScheduler()
+
+	schedule, err := scheduler.CreateSchedule(&models.ScheduleRequest{TaskType: "echo", IntervalSeconds: 60})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := scheduler.PauseSchedule(schedule.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	scheduler.tick(schedule.NextRunAt.Add(time.Second))
+	if tasks := taskManager.ListTasks(); len(tasks) != 0 {
+		t.Fatalf("Expected no tasks for paused schedule, got %d", len(tasks))
+	}
+
+	if _, err := scheduler.ResumeSchedule(schedule.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	scheduler.tick(schedule.NextRunAt.Add(time.Second))
+	if tasks := taskManager.ListTasks(); len(tasks) != 1 {
+		t.Fatalf("Expected 1 task after resuming, got %d", len(tasks))
+	}
+}
+
+func TestSchedulerMisfirePolicies(t *testing.T) {
+	tests := []struct {
+		policy        string
+		expectedTasks int
+	}{
+		{models.MisfirePolicySkip, 0},
+		{models.MisfirePolicyRunOnce, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			scheduler, taskManager, _ := setupTestScheduler()
+
+			schedule, err := scheduler.CreateSchedule(&models.ScheduleRequest{
+				TaskType:        "echo",
+				IntervalSeconds: 60,
+				MisfirePolicy:   tt.policy,
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// Ten runs were missed
+			now := schedule.NextRunAt.Add(10 * time.Minute)
+			scheduler.tick(now)
+
+			if tasks := taskManager.ListTasks(); len(tasks) != tt.expectedTasks {
+				t.Errorf("Expected %d tasks, got %d", tt.expectedTasks, len(tasks))
+			}
+
+			// The missed runs are skipped, keeping the interval of the
+			// schedule
+			expected := schedule.NextRunAt.Add(11 * time.Minute)
+			updated, _ := scheduler.GetSchedule(schedule.ID)
+			if updated.NextRunAt == nil || !updated.NextRunAt.Equal(expected) {
+				t.Errorf("Expected next run at %v, got %v", expected, updated.NextRunAt)
+			}
+		})
+	}
+}
+
+func TestSchedulerIntervalDoesNotDrift(t *testing.T) {
+	scheduler, taskManager, _ := setupTestScheduler()
+
+	schedule, err := scheduler.CreateSchedule(&models.ScheduleRequest{
+		TaskType:        "echo",
+		IntervalSeconds: 60,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	first := *schedule.NextRunAt
+
+	// Ticks arriving late do not move the following runs
+	scheduler.tick(first.Add(20 * time.Second))
+	updated, _ := scheduler.GetSchedule(schedule.ID)
+	if expected := first.Add(time.Minute); updated.NextRunAt == nil || !updated.NextRunAt.Equal(expected) {
+		t.Fatalf("Expected next run at %v, got %v", expected, updated.NextRunAt)
+	}
+
+	scheduler.tick(first.Add(time.Minute + 45*time.Second))
+	updated, _ = scheduler.GetSchedule(schedule.ID)
+	if expected := first.Add(2 * time.Minute); updated.NextRunAt == nil || !updated.NextRunAt.Equal(expected) {
+		t.Errorf("Expected next run at %v, got %v", expected, updated.NextRunAt)
+	}
+
+	if tasks := taskManager.ListTasks(); len(tasks) != 2 {
+		t.Errorf("Expected 2 tasks, got %d", len(tasks))
+	}
+}
+
+func TestSchedulerOverlapPolicies(t *testing.T) {
+	t.Run(models.OverlapPolicySkip, func(t *testing.T) {
+		scheduler, taskManager, mockPub := setupTestScheduler()
+
+		schedule, _ := scheduler.CreateSchedule(&models.ScheduleRequest{
+			TaskType:        "sleep",
+			Input:           map[string]interface{}{"duration": 5.0},
+			IntervalSeconds: 60,
+			OverlapPolicy:   models.OverlapPolicySkip,
+		})
+
+		first := schedule.NextRunAt.Add(time.Second)
+		scheduler.tick(first)
+		scheduler.tick(first.Add(time.Minute))
+
+		if tasks := taskManager.ListTasks(); len(tasks) != 1 {
+			t.Errorf("Expected 1 task, got %d", len(tasks))
+		}
+		if count := mockPub.countEvents(events.EventTypeScheduleSkipped); count != 1 {
+			t.Errorf("Expected 1 schedule.skipped event, got %d", count)
+		}
+
+		updated, _ := scheduler.GetSchedule(schedule.ID)
+		taskManager.CancelTask(updated.LastTaskID)
+	})
+
+	t.Run(models.OverlapPolicyQueue, func(t *testing.T) {
+		scheduler, taskManager, _ := setupTestScheduler()
+
+		schedule, _ := scheduler.CreateSchedule(&models.ScheduleRequest{
+			TaskType:        "sleep",
+			Input:           map[string]interface{}{"duration": 5.0},
+			IntervalSeconds: 60,
+			OverlapPolicy:   models.OverlapPolicyQueue,
+		})
+
+		first := schedule.NextRunAt.Add(time.Second)
+		scheduler.tick(first)
+		scheduler.tick(first.Add(time.Minute))
+
+		queued, _ := scheduler.GetSchedule(schedule.ID)
+		if len(queued.QueuedTaskIDs) != 1 {
+			t.Fatalf("Expected 1 queued task, got %d", len(queued.QueuedTaskIDs))
+		}
+		queuedTaskID := queued.QueuedTaskIDs[0]
+
+		// The queued task starts once the previous one has finished
+		taskManager.CancelTask(queued.LastTaskID)
+		scheduler.tick(first.Add(time.Minute + time.Second))
+
+		waitForStatus(t, taskManager, queuedTaskID, models.TaskStatusRunning)
+
+		dispatched, _ := scheduler.GetSchedule(schedule.ID)
+		if dispatched.LastTaskID != queuedTaskID {
+			t.Errorf("Expected last task %s, got %s", queuedTaskID, dispatched.LastTaskID)
+		}
+		if len(dispatched.QueuedTaskIDs) != 0 {
+			t.Errorf("Expected no queued tasks, got %d", len(dispatched.QueuedTaskIDs))
+		}
+		taskManager.CancelTask(queuedTaskID)
+	})
+
+	t.Run(models.OverlapPolicyCancelPrevious, func(t *testing.T) {
+		scheduler, taskManager, _ := setupTestScheduler()
+
+		schedule, _ := scheduler.CreateSchedule(&models.ScheduleRequest{
+			TaskType:        "sleep",
+			Input:           map[string]interface{}{"duration": 5.0},
+			IntervalSeconds: 60,
+			OverlapPolicy:   models.OverlapPolicyCancelPrevious,
+		})
+
+		first := schedule.NextRunAt.Add(time.Second)
+		scheduler.tick(first)
+		previous, _ := scheduler.GetSchedule(schedule.ID)
+
+		scheduler.tick(first.Add(time.Minute))
+
+		waitForStatus(t, taskManager, previous.LastTaskID, models.TaskStatusCancelled)
+
+		current, _ := scheduler.GetSchedule(schedule.ID)
+		if current.LastTaskID == previous.LastTaskID {
+			t.Error("Expected a new task for the second run")
+		}
+		taskManager.CancelTask(current.LastTaskID)
+	})
+}
+
+func TestSchedulerDeleteSchedule(t *testing.T) {
+	scheduler, _, mockPub := setupTestScheduler()
+
+	schedule, err := scheduler.CreateSchedule(&models.ScheduleRequest{TaskType: "echo", IntervalSeconds: 60})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := scheduler.DeleteSchedule(schedule.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := scheduler.GetSchedule(schedule.ID); err == nil {
+		t.Error("Expected error for deleted schedule")
+	}
+	if count := mockPub.countEvents(events.EventTypeScheduleDeleted); count != 1 {
+		t.Errorf("Expected 1 schedule.deleted event, got %d", count)
+	}
+}
+
+func TestSchedulerStartStop(t *testing.T) {
+	scheduler, taskManager, _ := setupTestScheduler()
+	scheduler.tickInterval = 10 * time.Millisecond
+
+	startAt := time.Now().Add(50 * time.Millisecond)
+	_, err := scheduler.CreateSchedule(&models.ScheduleRequest{
+		TaskType:        "echo",
+		IntervalSeconds: 3600,
+		StartAt:         &startAt,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	scheduler.Start()
+	time.Sleep(200 * time.Millisecond)
+	scheduler.Stop()
+
+	if tasks := taskManager.ListTasks(); len(tasks) != 1 {
+		t.Errorf("Expected 1 task, got %d", len(tasks))
+	}
+
+	// Stopping twice is harmless
+	scheduler.Stop()
+}
+
+func TestNextRun(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 30, 0, 0, time.UTC)
+	startAt := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	endAt := time.Date(2024, 1, 1, 12, 45, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		schedule *models.Schedule
+		expected *time.Time
+	}{
+		{
+			"interval",
+			&models.Schedule{IntervalSeconds: 60},
+			timePtr(base.Add(time.Minute)),
+		},
+		{
+			"interval between runs",
+			&models.Schedule{IntervalSeconds: 60, CreatedAt: base.Add(-90 * time.Second)},
+			timePtr(base.Add(30 * time.Second)),
+		},
+		{
+			"interval before start",
+			&models.Schedule{IntervalSeconds: 60, StartAt: &startAt},
+			&startAt,
+		},
+		{
+			"interval from start",
+			&models.Schedule{IntervalSeconds: 3600, StartAt: timePtr(base.Add(-150 * time.Minute))},
+			timePtr(base.Add(30 * time.Minute)),
+		},
+		{
+			"cron",
+			&models.Schedule{Cron: "0 * * * *"},
+			timePtr(time.Date(2024, 1, 1, 13, 0, 0, 0, time.UTC)),
+		},
+		{
+			"cron in timezone",
+			&models.Schedule{Cron: "0 18 * * *", Timezone: "Europe/Istanbul"},
+			timePtr(time.Date(2024, 1, 1, 15, 0, 0, 0, time.UTC)),
+		},
+		{
+			"cron from start",
+			&models.Schedule{Cron: "0 * * * *", StartAt: &startAt},
+			&startAt,
+		},
+		{
+			"after end",
+			&models.Schedule{Cron: "0 * * * *", EndAt: &endAt},
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next, err := nextRun(tt.schedule, base)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if tt.expected == nil {
+				if next != nil {
+					t.Errorf("Expected no next run, got %v", next)
+				}
+				return
+			}
+			if next == nil || !next.Equal(*tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, next)
+			}
+		})
+	}
+
+	// Jitter delays the run by at most the jitter
+	jittered := &models.Schedule{IntervalSeconds: 60, JitterSeconds: 10}
+	for i := 0; i < 20; i++ {
+		next, _ := nextRun(jittered, base)
+		if next.Before(base.Add(time.Minute)) || next.After(base.Add(70*time.Second)) {
+			t.Errorf("Expected jittered run within 10s of %v, got %v", base.Add(time.Minute), next)
+		}
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,48 @@
+package scheduler
+
+import (
+	"errors"
+
+	"go-fred/internal/models"
+	"go-fred/internal/records"
+	"go-fred/internal/tasks"
+)
+
+var (
+	// ErrScheduleNotFound is returned when a schedule does not exist in the store
+	ErrScheduleNotFound = errors.New("schedule not found")
+	// ErrScheduleExists is returned when creating a schedule whose ID is already stored
+	ErrScheduleExists = errors.New("schedule already exists")
+	// ErrVersionConflict is returned when updating a schedule that was modified concurrently
+	ErrVersionConflict = errors.New("schedule version conflict")
+)
+
+// scheduleKind stores schedules by ID, guarding updates by their version
+var scheduleKind = records.Kind[models.Schedule]{
+	Name:               "schedule",
+	Bucket:             []byte("schedules"),
+	Key:                func(schedule *models.Schedule) string { return schedule.ID },
+	Version:            func(schedule *models.Schedule) *int64 { return &schedule.Version },
+	Clone:              (*models.Schedule).Clone,
+	ErrNotFound:        ErrScheduleNotFound,
+	ErrExists:          ErrScheduleExists,
+	ErrVersionConflict: ErrVersionConflict,
+}
+
+// ScheduleStore defines the interface for schedule persistence. Like
+// tasks.TaskStore, updates are guarded by the schedule version.
+type ScheduleStore = records.Store[models.Schedule]
+
+// MemoryScheduleStore keeps schedules in process memory
+type MemoryScheduleStore = records.MemoryStore[models.Schedule]
+
+// NewScheduleStore creates a schedule store next to the given task store, so
+// that schedules are durable whenever tasks are
+func NewScheduleStore(taskStore tasks.TaskStore) (ScheduleStore, error) {
+	return records.NewStore(taskStore, scheduleKind)
+}
+
+// NewMemoryScheduleStore creates a new in-memory schedule store
+func NewMemoryScheduleStore() *MemoryScheduleStore {
+	return records.NewMemoryStore(scheduleKind)
+}
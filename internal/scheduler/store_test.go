@@ -0,0 +1,18 @@
+package scheduler
+
+import (
+	"testing"
+
+	"go-fred/internal/models"
+	"go-fred/internal/records/recordstest"
+)
+
+func TestScheduleStores(t *testing.T) {
+	recordstest.TestStores(t, scheduleKind, NewScheduleStore, recordstest.Records[models.Schedule]{
+		New: func() *models.Schedule {
+			return models.NewSchedule(&models.ScheduleRequest{TaskType: "echo", IntervalSeconds: 60})
+		},
+		Modify:   func(schedule *models.Schedule) { schedule.Paused = true },
+		Modified: func(schedule *models.Schedule) bool { return schedule.Paused },
+	})
+}
@@ -1,10 +1,23 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go-fred/internal/models"
+	"go-fred/internal/tasks"
+)
+
+// Bounds of how long a request may wait for a task to finish
+const (
+	defaultWaitTimeout = 30 * time.Second
+	maxWaitTimeout     = 5 * time.Minute
 )
 
 // healthCheck returns the health status of the server
@@ -23,32 +36,166 @@ func (s *Server) createTask(c *gin.Context) {
 		return
 	}
 
-	task, err := s.taskManager.CreateTask(req.Type, req.Input, req.Async)
+	wait, err := parseWait(c)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	// The Idempotency-Key header and the idempotency_key field are
+	// interchangeable, but must agree when both are given
+	if key := c.GetHeader("Idempotency-Key"); key != "" {
+		if req.IdempotencyKey != "" && req.IdempotencyKey != key {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key header does not match idempotency_key"})
+			return
+		}
+		req.IdempotencyKey = key
+	}
+
+	task, created, err := s.taskManager.CreateTaskIdempotent(&req)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, tasks.ErrIdempotencyKeyReused) {
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A retried request gets the task created first
+	if !created {
+		c.Header("Idempotent-Replayed", "true")
+	}
+
+	// Waiting runs the task right away, unless it is postponed or was
+	// created by an earlier request
+	if wait > 0 {
+		if created && task.Status == models.TaskStatusPending {
+			if err := s.taskManager.ExecuteTaskAsync(c.Request.Context(), task.ID); err != nil {
+				c.JSON(executeErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+		}
+		task, err = s.waitForTask(c, task.ID, wait)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	response := models.TaskResponse{Task: task}
 	c.JSON(http.StatusCreated, response)
 }
 
-// listTasks returns all tasks
+// listTasks returns a page of the tasks matching the query parameters
 func (s *Server) listTasks(c *gin.Context) {
-	tasks := s.taskManager.ListTasks()
-	
+	query, err := parseTaskQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := s.taskManager.QueryTasks(query)
+	if err != nil {
+		c.JSON(queryErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
 	response := models.TaskListResponse{
-		Tasks: make([]models.Task, len(tasks)),
-		Total: len(tasks),
+		Tasks:      make([]models.Task, len(page.Tasks)),
+		Total:      page.Total,
+		NextCursor: page.NextCursor,
 	}
-	
-	for i, task := range tasks {
+	for i, task := range page.Tasks {
 		response.Tasks[i] = *task
 	}
-	
+
 	c.JSON(http.StatusOK, response)
 }
 
+// parseTaskQuery reads the filters, sort order and page of a task listing
+// from the query string
+func parseTaskQuery(c *gin.Context) (*models.TaskQuery, error) {
+	query := &models.TaskQuery{
+		Types:  splitList(c.Query("type")),
+		SortBy: c.Query("sort"),
+		Cursor: c.Query("cursor"),
+	}
+
+	selector, err := models.ParseLabelSelector(c.Query("selector"))
+	if err != nil {
+		return nil, err
+	}
+	query.Labels = selector
+
+	for _, status := range splitList(c.Query("status")) {
+		query.Statuses = append(query.Statuses, models.TaskStatus(status))
+	}
+
+	if value := c.Query("async"); value != "" {
+		async, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid async: %s", value)
+		}
+		query.Async = &async
+	}
+
+	times := map[string]**time.Time{
+		"created_after":    &query.CreatedAfter,
+		"created_before":   &query.CreatedBefore,
+		"completed_after":  &query.CompletedAfter,
+		"completed_before": &query.CompletedBefore,
+	}
+	for name, field := range times {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: expected an RFC 3339 time", name)
+		}
+		*field = &parsed
+	}
+
+	switch order := c.Query("order"); order {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return nil, fmt.Errorf("invalid order: %s", order)
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("invalid limit: %s", value)
+		}
+		query.Limit = limit
+	}
+
+	return query, nil
+}
+
+// splitList splits a comma-separated query parameter, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// queryErrorStatus maps task query errors to HTTP status codes
+func queryErrorStatus(err error) int {
+	if errors.Is(err, tasks.ErrInvalidQuery) || errors.Is(err, tasks.ErrInvalidCursor) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
 // getTask returns a specific task by ID
 func (s *Server) getTask(c *gin.Context) {
 	taskID := c.Param("id")
@@ -63,13 +210,87 @@ func (s *Server) getTask(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// waitTask returns a task once it finished, or as it is when the timeout
+// elapses first
+func (s *Server) waitTask(c *gin.Context) {
+	timeout, err := parseWaitTimeout(c.Query("timeout"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	task, err := s.waitForTask(c, c.Param("id"), timeout)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, tasks.ErrTaskNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := models.TaskResponse{Task: task}
+	c.JSON(http.StatusOK, response)
+}
+
+// waitForTask waits up to timeout for a task to finish. Running out of time
+// is not an error: the task is returned as it is then.
+func (s *Server) waitForTask(c *gin.Context, taskID string, timeout time.Duration) (*models.Task, error) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+
+	task, err := s.taskManager.WaitForTask(ctx, taskID)
+	if err != nil && task != nil && ctx.Err() != nil {
+		return task, nil
+	}
+	return task, err
+}
+
+// parseWait reads the wait and timeout options of a create request and
+// returns how long to wait for the task, or 0 when not waiting
+func parseWait(c *gin.Context) (time.Duration, error) {
+	value := c.Query("wait")
+	if value == "" {
+		return 0, nil
+	}
+	wait, err := strconv.ParseBool(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid wait: %s", value)
+	}
+	if !wait {
+		return 0, nil
+	}
+	return parseWaitTimeout(c.Query("timeout"))
+}
+
+// parseWaitTimeout parses how long to wait for a task, given as a duration
+// such as "30s" or as a number of seconds
+func parseWaitTimeout(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultWaitTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(value)
+		if convErr != nil {
+			return 0, fmt.Errorf("invalid timeout: %s", value)
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	if timeout <= 0 || timeout > maxWaitTimeout {
+		return 0, fmt.Errorf("timeout must be positive and at most %s", maxWaitTimeout)
+	}
+	return timeout, nil
+}
+
 // executeTask executes a task synchronously
 func (s *Server) executeTask(c *gin.Context) {
 	taskID := c.Param("id")
 	
 	err := s.taskManager.ExecuteTask(c.Request.Context(), taskID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(executeErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	
@@ -88,9 +309,9 @@ func (s *Server) executeTask(c *gin.Context) {
 func (s *Server) executeTaskAsync(c *gin.Context) {
 	taskID := c.Param("id")
 	
-	err := s.taskManager.ExecuteTaskAsync(c.Request.Context(), taskID)
+	err := s.taskManager.StartTaskAsync(c.Request.Context(), taskID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(executeErrorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
 	
@@ -126,6 +347,68 @@ func (s *Server) cancelTask(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// cancelTasks cancels the unfinished tasks matching the label selector
+func (s *Server) cancelTasks(c *gin.Context) {
+	if c.Query("selector") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "selector is required"})
+		return
+	}
+	selector, err := models.ParseLabelSelector(c.Query("selector"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cancelled, err := s.taskManager.CancelTasks(selector)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := models.CancelTasksResponse{Cancelled: cancelled, Total: len(cancelled)}
+	c.JSON(http.StatusOK, response)
+}
+
+// rescheduleTask moves a scheduled task to a new run time
+func (s *Server) rescheduleTask(c *gin.Context) {
+	var req models.RescheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	task, err := s.taskManager.RescheduleTask(c.Param("id"), &req)
+	if errors.Is(err, tasks.ErrTaskNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, tasks.ErrTaskNotScheduled) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := models.TaskResponse{Task: task}
+	c.JSON(http.StatusOK, response)
+}
+
+// executeErrorStatus maps execution errors to HTTP status codes
+func executeErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, tasks.ErrQueueFull):
+		return http.StatusTooManyRequests
+	case errors.Is(err, tasks.ErrQueueDraining):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, tasks.ErrTaskCancelled):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
 // getTaskTypes returns all supported task types
 func (s *Server) getTaskTypes(c *gin.Context) {
 	// Get the registry from task manager (we need to expose this method)
@@ -6,13 +6,18 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"sync"
 	"testing"
 	"time"
 
 	"go-fred/internal/config"
 	"go-fred/internal/events"
 	"go-fred/internal/models"
+	"go-fred/internal/scheduler"
 	"go-fred/internal/tasks"
+	"go-fred/internal/webhooks"
+	"go-fred/internal/workflows"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -22,9 +27,12 @@ import (
 // mockPublisher is a mock event publisher for testing
 type mockPublisher struct {
 	events []events.Event
+	mu     sync.Mutex
 }
 
 func (m *mockPublisher) Publish(ctx context.Context, event events.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.events = append(m.events, event)
 	return nil
 }
@@ -34,10 +42,14 @@ func (m *mockPublisher) Close() error {
 }
 
 func (m *mockPublisher) GetEvents() []events.Event {
-	return m.events
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]events.Event(nil), m.events...)
 }
 
 func (m *mockPublisher) ClearEvents() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.events = nil
 }
 
@@ -53,27 +65,47 @@ func setupTestServer() *Server {
 		Tasks: config.TasksConfig{
 			MaxConcurrent: 10,
 		},
+		Webhooks: config.WebhooksConfig{
+			Secret:      "test-secret",
+			MaxAttempts: 3,
+			DelayMs:     10,
+		},
 	}
 
-	// Create event publisher
+	// Create event publisher, keeping recent events for the streams
 	eventPub := &mockPublisher{}
+	stream := events.NewStream(eventPub, 100)
 
 	// Create task executor registry and register default executors
 	registry := tasks.NewExecutorRegistry()
 	tasks.RegisterDefaultExecutors(registry)
 
 	// Create task manager
-	taskManager := tasks.NewTaskManager(registry, eventPub, cfg.Tasks.MaxConcurrent)
+	taskManager := tasks.NewTaskManager(registry, stream, cfg.Tasks.MaxConcurrent)
+
+	// Create scheduler
+	taskScheduler := scheduler.NewScheduler(taskManager, scheduler.NewMemoryScheduleStore(), stream)
+
+	// Create workflow manager
+	workflowManager := workflows.NewManager(taskManager, workflows.NewMemoryWorkflowStore(), stream)
+
+	// Create webhook dispatcher
+	dispatcher := webhooks.NewDispatcher(taskManager, webhooks.NewMemoryDeliveryStore(), &cfg.Webhooks)
 
 	// Create Gin router
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
+	router.Use(corsMiddleware())
 
 	server := &Server{
 		config:      cfg,
 		router:      router,
 		taskManager: taskManager,
-		eventPub:    eventPub,
+		scheduler:   taskScheduler,
+		workflows:   workflowManager,
+		webhooks:    dispatcher,
+		eventPub:    stream,
+		stream:      stream,
 	}
 
 	// Setup routes
@@ -128,6 +160,53 @@ func TestCreateTask(t *testing.T) {
 	assert.False(t, response.Task.IsAsync)
 }
 
+func TestCreateTaskIdempotencyKey(t *testing.T) {
+	server := setupTestServer()
+
+	post := func(body, key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"type": "echo", "input": {"message": "hello"}}`, "retry-me")
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+
+	var first models.TaskResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &first))
+	assert.Equal(t, "retry-me", first.Task.IdempotencyKey)
+
+	// Retrying with the header or the field returns the original task
+	for _, retry := range []struct{ body, key string }{
+		{`{"type": "echo", "input": {"message": "hello"}}`, "retry-me"},
+		{`{"type": "echo", "input": {"message": "hello"}, "idempotency_key": "retry-me"}`, ""},
+	} {
+		w = post(retry.body, retry.key)
+		require.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "true", w.Header().Get("Idempotent-Replayed"))
+
+		var response models.TaskResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, first.Task.ID, response.Task.ID)
+	}
+
+	// Reusing the key for a different payload is rejected
+	w = post(`{"type": "echo", "input": {"message": "goodbye"}}`, "retry-me")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+
+	// Header and field must agree
+	w = post(`{"type": "echo", "idempotency_key": "other"}`, "retry-me")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	assert.Len(t, server.taskManager.ListTasks(), 1)
+}
+
 func TestCreateTaskInvalidType(t *testing.T) {
 	server := setupTestServer()
 
@@ -202,6 +281,198 @@ func TestListTasks(t *testing.T) {
 	assert.True(t, found2, "Task 2 not found in response")
 }
 
+func TestListTasksQuery(t *testing.T) {
+	server := setupTestServer()
+
+	var echoTasks []*models.Task
+	for i := 0; i < 3; i++ {
+		task, err := server.taskManager.CreateTask("echo", map[string]interface{}{"message": "hello"}, false)
+		require.NoError(t, err)
+		echoTasks = append(echoTasks, task)
+	}
+	_, err := server.taskManager.CreateTask("sleep", map[string]interface{}{"duration": 5}, true)
+	require.NoError(t, err)
+
+	list := func(query string) models.TaskListResponse {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/tasks?"+query, nil)
+		server.router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var response models.TaskListResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	response := list("type=echo&order=desc&limit=2")
+	require.Len(t, response.Tasks, 2)
+	assert.Equal(t, 3, response.Total)
+	assert.Equal(t, echoTasks[2].ID, response.Tasks[0].ID)
+	assert.Equal(t, echoTasks[1].ID, response.Tasks[1].ID)
+	require.NotEmpty(t, response.NextCursor)
+
+	response = list("type=echo&order=desc&limit=2&cursor=" + response.NextCursor)
+	require.Len(t, response.Tasks, 1)
+	assert.Equal(t, 3, response.Total)
+	assert.Equal(t, echoTasks[0].ID, response.Tasks[0].ID)
+	assert.Empty(t, response.NextCursor)
+
+	response = list("status=pending&async=true")
+	require.Len(t, response.Tasks, 1)
+	assert.Equal(t, "sleep", response.Tasks[0].Type)
+
+	response = list("created_before=2000-01-01T00:00:00Z")
+	assert.Empty(t, response.Tasks)
+	assert.Equal(t, 0, response.Total)
+}
+
+func TestListTasksInvalidQuery(t *testing.T) {
+	server := setupTestServer()
+
+	_, err := server.taskManager.CreateTask("echo", map[string]interface{}{"message": "hello"}, false)
+	require.NoError(t, err)
+
+	queries := []string{
+		"async=maybe",
+		"created_after=yesterday",
+		"sort=name",
+		"order=sideways",
+		"limit=0",
+		"cursor=garbage",
+	}
+	for _, query := range queries {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/tasks?"+query, nil)
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestTaskLabelSelectors(t *testing.T) {
+	server := setupTestServer()
+
+	create := func(labels map[string]string) *models.Task {
+		task, err := server.taskManager.CreateTaskFromRequest(&models.TaskRequest{Type: "echo", Labels: labels})
+		require.NoError(t, err)
+		return task
+	}
+	prod := create(map[string]string{"env": "prod", "team": "a"})
+	create(map[string]string{"env": "dev", "team": "a"})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/tasks?selector="+url.QueryEscape("env=prod,team in (a,b)"), nil)
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var list models.TaskListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Tasks, 1)
+	assert.Equal(t, prod.ID, list.Tasks[0].ID)
+	assert.Equal(t, "prod", list.Tasks[0].Labels["env"])
+
+	// Bulk cancellation needs a selector
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/api/v1/tasks", nil)
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/api/v1/tasks?selector="+url.QueryEscape("team in (a"), nil)
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/api/v1/tasks?selector=env%3Dprod", nil)
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var cancelled models.CancelTasksResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &cancelled))
+	assert.Equal(t, []string{prod.ID}, cancelled.Cancelled)
+	assert.Equal(t, 1, cancelled.Total)
+}
+
+func TestCreateTaskInvalidLabels(t *testing.T) {
+	server := setupTestServer()
+
+	w := httptest.NewRecorder()
+	body := `{"type": "echo", "labels": {"env": "not valid"}}`
+	req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestWaitTask(t *testing.T) {
+	server := setupTestServer()
+
+	task, err := server.taskManager.CreateTask("sleep", map[string]interface{}{"duration": 0.1}, true)
+	require.NoError(t, err)
+
+	// A task that does not finish in time is returned as it is
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/tasks/"+task.ID+"/wait?timeout=50ms", nil)
+	server.router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response models.TaskResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, models.TaskStatusPending, response.Task.Status)
+
+	require.NoError(t, server.taskManager.ExecuteTaskAsync(context.Background(), task.ID))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/tasks/"+task.ID+"/wait?timeout=5", nil)
+	server.router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, models.TaskStatusCompleted, response.Task.Status)
+}
+
+func TestWaitTaskInvalid(t *testing.T) {
+	server := setupTestServer()
+
+	task, err := server.taskManager.CreateTask("echo", map[string]interface{}{}, false)
+	require.NoError(t, err)
+
+	requests := map[string]int{
+		"/api/v1/tasks/missing/wait":                      http.StatusNotFound,
+		"/api/v1/tasks/" + task.ID + "/wait?timeout=soon": http.StatusBadRequest,
+		"/api/v1/tasks/" + task.ID + "/wait?timeout=-1s":  http.StatusBadRequest,
+		"/api/v1/tasks/" + task.ID + "/wait?timeout=1h":   http.StatusBadRequest,
+	}
+	for path, status := range requests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, status, w.Code, path)
+	}
+}
+
+func TestCreateTaskAndWait(t *testing.T) {
+	server := setupTestServer()
+
+	w := httptest.NewRecorder()
+	body := `{"type": "math", "input": {"operation": "add", "a": 1, "b": 2}}`
+	req, _ := http.NewRequest("POST", "/api/v1/tasks?wait=true&timeout=5s", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	server.router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	var response models.TaskResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, models.TaskStatusCompleted, response.Task.Status)
+	assert.Equal(t, float64(3), response.Task.Output["result"])
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/tasks?wait=maybe", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
 func TestGetTask(t *testing.T) {
 	server := setupTestServer()
 
@@ -307,6 +578,34 @@ func TestExecuteTaskAsync(t *testing.T) {
 	assert.Equal(t, models.TaskStatusCompleted, completedTask.Status)
 }
 
+func TestExecuteTaskAsyncQueueFull(t *testing.T) {
+	server := setupTestServer()
+
+	// A single worker with room for a single waiting task
+	registry := tasks.NewExecutorRegistry()
+	tasks.RegisterDefaultExecutors(registry)
+	cfg := &config.TasksConfig{MaxConcurrent: 1, MaxQueueDepth: 1}
+	server.taskManager = tasks.NewTaskManagerWithConfig(registry, &mockPublisher{}, tasks.NewMemoryTaskStore(), cfg)
+	defer server.taskManager.Close()
+
+	codes := make([]int, 3)
+	for i := range codes {
+		task, err := server.taskManager.CreateTask("sleep", map[string]interface{}{"duration": 5.0}, true)
+		require.NoError(t, err)
+		defer server.taskManager.CancelTask(task.ID)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/tasks/"+task.ID+"/execute-async", nil)
+		server.router.ServeHTTP(w, req)
+		codes[i] = w.Code
+
+		// Let the worker pick up the first task
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	assert.Equal(t, []int{http.StatusAccepted, http.StatusAccepted, http.StatusTooManyRequests}, codes)
+}
+
 func TestExecuteTaskAsyncNotFound(t *testing.T) {
 	server := setupTestServer()
 
@@ -363,6 +662,100 @@ func TestCancelTaskNotFound(t *testing.T) {
 	assert.Contains(t, response, "error")
 }
 
+func TestCreateDelayedTask(t *testing.T) {
+	server := setupTestServer()
+
+	requestBody := models.TaskRequest{
+		Type:  "echo",
+		Input: map[string]interface{}{"message": "later"},
+		Delay: 3600,
+	}
+
+	jsonBody, _ := json.Marshal(requestBody)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response models.TaskResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+
+	assert.Equal(t, models.TaskStatusScheduled, response.Task.Status)
+	require.NotNil(t, response.Task.RunAt)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), *response.Task.RunAt, time.Minute)
+
+	server.taskManager.CancelTask(response.Task.ID)
+}
+
+func TestCreateDelayedTaskInvalid(t *testing.T) {
+	server := setupTestServer()
+
+	runAt := time.Now().Add(time.Hour)
+	requestBody := models.TaskRequest{
+		Type:  "echo",
+		RunAt: &runAt,
+		Delay: 60,
+	}
+
+	jsonBody, _ := json.Marshal(requestBody)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRescheduleTask(t *testing.T) {
+	server := setupTestServer()
+
+	task, err := server.taskManager.CreateTaskFromRequest(&models.TaskRequest{Type: "echo", Delay: 3600})
+	require.NoError(t, err)
+	defer server.taskManager.CancelTask(task.ID)
+
+	runAt := time.Now().Add(2 * time.Hour).UTC()
+	jsonBody, _ := json.Marshal(models.RescheduleRequest{RunAt: &runAt})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/tasks/"+task.ID+"/reschedule", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response models.TaskResponse
+	err = json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+
+	assert.Equal(t, models.TaskStatusScheduled, response.Task.Status)
+	require.NotNil(t, response.Task.RunAt)
+	assert.True(t, runAt.Equal(*response.Task.RunAt))
+}
+
+func TestRescheduleTaskNotScheduled(t *testing.T) {
+	server := setupTestServer()
+
+	task, err := server.taskManager.CreateTask("echo", map[string]interface{}{}, false)
+	require.NoError(t, err)
+
+	jsonBody, _ := json.Marshal(models.RescheduleRequest{Delay: 60})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/tasks/"+task.ID+"/reschedule", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/tasks/non-existent/reschedule", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
 func TestGetTaskTypes(t *testing.T) {
 	server := setupTestServer()
 
@@ -405,5 +798,5 @@ func TestCORS(t *testing.T) {
 	assert.Equal(t, http.StatusNoContent, w.Code)
 	assert.Equal(t, "*", w.Header().Get("Access-Control-Allow-Origin"))
 	assert.Equal(t, "GET, POST, PUT, DELETE, OPTIONS", w.Header().Get("Access-Control-Allow-Methods"))
-	assert.Equal(t, "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, Idempotency-Key, Last-Event-ID", w.Header().Get("Access-Control-Allow-Headers"))
 }
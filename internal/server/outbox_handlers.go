@@ -0,0 +1,26 @@
+package server
+
+import (
+	"net/http"
+
+	"go-fred/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getOutbox returns the backlog of the event outbox
+func (s *Server) getOutbox(c *gin.Context) {
+	if s.outbox == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "event outbox is not enabled"})
+		return
+	}
+
+	stats, err := s.outbox.Stats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := models.OutboxResponse{Outbox: stats}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-fred/internal/config"
+	"go-fred/internal/models"
+	"go-fred/internal/outbox"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetOutbox(t *testing.T) {
+	server := setupTestServer()
+
+	// Without an outbox the endpoint does not exist
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/events/outbox", nil)
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	server.outbox = outbox.New(outbox.NewMemoryStore(), &mockPublisher{}, &config.OutboxConfig{PollIntervalMs: 10})
+	defer server.outbox.Close()
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/events/outbox", nil)
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response models.OutboxResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 0, response.Outbox.Backlog)
+	assert.Nil(t, response.Outbox.OldestEventAt)
+}
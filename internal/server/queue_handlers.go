@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go-fred/internal/models"
+	"go-fred/internal/tasks"
+)
+
+// listQueues returns the stats of all queues
+func (s *Server) listQueues(c *gin.Context) {
+	queues := s.taskManager.ListQueues()
+
+	response := models.QueueListResponse{
+		Queues: make([]models.QueueStats, len(queues)),
+		Total:  len(queues),
+	}
+
+	for i, queue := range queues {
+		response.Queues[i] = *queue
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// getQueue returns the stats of a specific queue
+func (s *Server) getQueue(c *gin.Context) {
+	queue, err := s.taskManager.GetQueue(c.Param("name"))
+	if err != nil {
+		c.JSON(queueErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	response := models.QueueResponse{Queue: queue}
+	c.JSON(http.StatusOK, response)
+}
+
+// pauseQueue stops a queue from starting tasks
+func (s *Server) pauseQueue(c *gin.Context) {
+	queue, err := s.taskManager.PauseQueue(c.Param("name"))
+	if err != nil {
+		c.JSON(queueErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	response := models.QueueResponse{Queue: queue}
+	c.JSON(http.StatusOK, response)
+}
+
+// resumeQueue lets a paused or draining queue run tasks again
+func (s *Server) resumeQueue(c *gin.Context) {
+	queue, err := s.taskManager.ResumeQueue(c.Param("name"))
+	if err != nil {
+		c.JSON(queueErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	response := models.QueueResponse{Queue: queue}
+	c.JSON(http.StatusOK, response)
+}
+
+// drainQueue makes a queue reject new tasks while it finishes queued ones
+func (s *Server) drainQueue(c *gin.Context) {
+	queue, err := s.taskManager.DrainQueue(c.Param("name"))
+	if err != nil {
+		c.JSON(queueErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	response := models.QueueResponse{Queue: queue}
+	c.JSON(http.StatusOK, response)
+}
+
+// listConcurrencyKeys returns the concurrency keys in use
+func (s *Server) listConcurrencyKeys(c *gin.Context) {
+	keys := s.taskManager.ListConcurrencyKeys()
+
+	response := models.ConcurrencyKeyListResponse{
+		ConcurrencyKeys: make([]models.ConcurrencyKeyStats, len(keys)),
+		Total:           len(keys),
+	}
+
+	for i, key := range keys {
+		response.ConcurrencyKeys[i] = *key
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// getConcurrencyKey returns the tasks holding and waiting for a concurrency
+// key
+func (s *Server) getConcurrencyKey(c *gin.Context) {
+	key, err := s.taskManager.GetConcurrencyKey(c.Param("key"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := models.ConcurrencyKeyResponse{ConcurrencyKey: key}
+	c.JSON(http.StatusOK, response)
+}
+
+// queueErrorStatus maps queue errors to HTTP status codes
+func queueErrorStatus(err error) int {
+	if errors.Is(err, tasks.ErrQueueNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
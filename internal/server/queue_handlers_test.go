@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-fred/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListQueues(t *testing.T) {
+	server := setupTestServer()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/queues", nil)
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response models.QueueListResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+
+	require.Equal(t, 1, response.Total)
+	assert.Equal(t, models.DefaultQueue, response.Queues[0].Name)
+	assert.Equal(t, models.QueueStateActive, response.Queues[0].State)
+	assert.Equal(t, 10, response.Queues[0].Concurrency)
+}
+
+func TestGetQueueNotFound(t *testing.T) {
+	server := setupTestServer()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/queues/missing", nil)
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestQueueStateChanges(t *testing.T) {
+	server := setupTestServer()
+
+	tests := []struct {
+		action        string
+		expectedState string
+	}{
+		{"pause", models.QueueStatePaused},
+		{"resume", models.QueueStateActive},
+		{"drain", models.QueueStateDraining},
+		{"resume", models.QueueStateActive},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/queues/default/"+tt.action, nil)
+		server.router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response models.QueueResponse
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		require.NoError(t, err)
+		assert.Equal(t, tt.expectedState, response.Queue.State, tt.action)
+	}
+}
+
+func TestExecuteTaskDrainingQueue(t *testing.T) {
+	server := setupTestServer()
+
+	_, err := server.taskManager.DrainQueue(models.DefaultQueue)
+	require.NoError(t, err)
+
+	task, err := server.taskManager.CreateTask("echo", map[string]interface{}{}, true)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/tasks/"+task.ID+"/execute-async", nil)
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestConcurrencyKeys(t *testing.T) {
+	server := setupTestServer()
+
+	var taskIDs []string
+	for i := 0; i < 2; i++ {
+		task, err := server.taskManager.CreateTaskFromRequest(&models.TaskRequest{
+			Type:           "sleep",
+			Input:          map[string]interface{}{"duration": 0.2},
+			ConcurrencyKey: "customer-1",
+		})
+		require.NoError(t, err)
+		require.NoError(t, server.taskManager.ExecuteTaskAsync(context.Background(), task.ID))
+		taskIDs = append(taskIDs, task.ID)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/concurrency-keys/customer-1", nil)
+	server.router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response models.ConcurrencyKeyResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+	assert.Equal(t, 1, response.ConcurrencyKey.Limit)
+	assert.Equal(t, taskIDs[:1], response.ConcurrencyKey.Running)
+	assert.Equal(t, taskIDs[1:], response.ConcurrencyKey.Waiting)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/concurrency-keys", nil)
+	server.router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var list models.ConcurrencyKeyListResponse
+	err = json.Unmarshal(w.Body.Bytes(), &list)
+	require.NoError(t, err)
+	assert.Equal(t, 1, list.Total)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/concurrency-keys/customer-2", nil)
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go-fred/internal/models"
+	"go-fred/internal/scheduler"
+)
+
+// createSchedule creates a new schedule
+func (s *Server) createSchedule(c *gin.Context) {
+	var req models.ScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	schedule, err := s.scheduler.CreateSchedule(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := models.ScheduleResponse{Schedule: schedule}
+	c.JSON(http.StatusCreated, response)
+}
+
+// listSchedules returns all schedules
+func (s *Server) listSchedules(c *gin.Context) {
+	schedules := s.scheduler.ListSchedules()
+
+	response := models.ScheduleListResponse{
+		Schedules: make([]models.Schedule, len(schedules)),
+		Total:     len(schedules),
+	}
+
+	for i, schedule := range schedules {
+		response.Schedules[i] = *schedule
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// getSchedule returns a specific schedule by ID
+func (s *Server) getSchedule(c *gin.Context) {
+	schedule, err := s.scheduler.GetSchedule(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := models.ScheduleResponse{Schedule: schedule}
+	c.JSON(http.StatusOK, response)
+}
+
+// updateSchedule replaces the definition of a schedule
+func (s *Server) updateSchedule(c *gin.Context) {
+	var req models.ScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	schedule, err := s.scheduler.UpdateSchedule(c.Param("id"), &req)
+	if err != nil {
+		c.JSON(scheduleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	response := models.ScheduleResponse{Schedule: schedule}
+	c.JSON(http.StatusOK, response)
+}
+
+// deleteSchedule removes a schedule
+func (s *Server) deleteSchedule(c *gin.Context) {
+	if err := s.scheduler.DeleteSchedule(c.Param("id")); err != nil {
+		c.JSON(scheduleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// pauseSchedule pauses a schedule
+func (s *Server) pauseSchedule(c *gin.Context) {
+	schedule, err := s.scheduler.PauseSchedule(c.Param("id"))
+	if err != nil {
+		c.JSON(scheduleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	response := models.ScheduleResponse{Schedule: schedule}
+	c.JSON(http.StatusOK, response)
+}
+
+// resumeSchedule resumes a paused schedule
+func (s *Server) resumeSchedule(c *gin.Context) {
+	schedule, err := s.scheduler.ResumeSchedule(c.Param("id"))
+	if err != nil {
+		c.JSON(scheduleErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	response := models.ScheduleResponse{Schedule: schedule}
+	c.JSON(http.StatusOK, response)
+}
+
+// scheduleErrorStatus maps scheduler errors to HTTP status codes
+func scheduleErrorStatus(err error) int {
+	if errors.Is(err, scheduler.ErrScheduleNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,178 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-fred/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createTestSchedule creates a schedule through the API
+func createTestSchedule(t *testing.T, server *Server, scheduleRequest models.ScheduleRequest) *models.Schedule {
+	jsonData, _ := json.Marshal(scheduleRequest)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/schedules", bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	server.router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var response models.ScheduleResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+	require.NotNil(t, response.Schedule)
+
+	return response.Schedule
+}
+
+func TestCreateSchedule(t *testing.T) {
+	server := setupTestServer()
+
+	schedule := createTestSchedule(t, server, models.ScheduleRequest{
+		Name:     "every minute",
+		TaskType: "echo",
+		Input:    map[string]interface{}{"message": "hello"},
+		Cron:     "* * * * *",
+		Timezone: "Europe/Istanbul",
+	})
+
+	assert.Equal(t, "echo", schedule.TaskType)
+	assert.Equal(t, models.MisfirePolicyRunOnce, schedule.MisfirePolicy)
+	assert.Equal(t, models.OverlapPolicyQueue, schedule.OverlapPolicy)
+	assert.NotNil(t, schedule.NextRunAt)
+	assert.False(t, schedule.Paused)
+}
+
+func TestCreateScheduleInvalid(t *testing.T) {
+	server := setupTestServer()
+
+	tests := []struct {
+		name    string
+		request models.ScheduleRequest
+	}{
+		{"invalid task type", models.ScheduleRequest{TaskType: "invalid", IntervalSeconds: 60}},
+		{"no timing", models.ScheduleRequest{TaskType: "echo"}},
+		{"cron and interval", models.ScheduleRequest{TaskType: "echo", Cron: "* * * * *", IntervalSeconds: 60}},
+		{"invalid cron", models.ScheduleRequest{TaskType: "echo", Cron: "not a cron"}},
+		{"invalid timezone", models.ScheduleRequest{TaskType: "echo", IntervalSeconds: 60, Timezone: "Mars/Olympus"}},
+		{"invalid overlap policy", models.ScheduleRequest{TaskType: "echo", IntervalSeconds: 60, OverlapPolicy: "invalid"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonData, _ := json.Marshal(tt.request)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/api/v1/schedules", bytes.NewBuffer(jsonData))
+			req.Header.Set("Content-Type", "application/json")
+			server.router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestListAndGetSchedules(t *testing.T) {
+	server := setupTestServer()
+
+	schedule := createTestSchedule(t, server, models.ScheduleRequest{TaskType: "echo", IntervalSeconds: 60})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/schedules", nil)
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var listResponse models.ScheduleListResponse
+	err := json.Unmarshal(w.Body.Bytes(), &listResponse)
+	require.NoError(t, err)
+	assert.Equal(t, 1, listResponse.Total)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/schedules/"+schedule.ID, nil)
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/schedules/non-existent", nil)
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestUpdateSchedule(t *testing.T) {
+	server := setupTestServer()
+
+	schedule := createTestSchedule(t, server, models.ScheduleRequest{TaskType: "echo", IntervalSeconds: 60})
+
+	jsonData, _ := json.Marshal(models.ScheduleRequest{TaskType: "math", Cron: "0 * * * *", OverlapPolicy: models.OverlapPolicySkip})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/api/v1/schedules/"+schedule.ID, bytes.NewBuffer(jsonData))
+	req.Header.Set("Content-Type", "application/json")
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response models.ScheduleResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+
+	assert.Equal(t, "math", response.Schedule.TaskType)
+	assert.Equal(t, "0 * * * *", response.Schedule.Cron)
+	assert.Equal(t, 0, response.Schedule.IntervalSeconds)
+	assert.Equal(t, models.OverlapPolicySkip, response.Schedule.OverlapPolicy)
+}
+
+func TestPauseAndResumeSchedule(t *testing.T) {
+	server := setupTestServer()
+
+	schedule := createTestSchedule(t, server, models.ScheduleRequest{TaskType: "echo", IntervalSeconds: 60})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/schedules/"+schedule.ID+"/pause", nil)
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response models.ScheduleResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+	assert.True(t, response.Schedule.Paused)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/v1/schedules/"+schedule.ID+"/resume", nil)
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	err = json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+	assert.False(t, response.Schedule.Paused)
+}
+
+func TestDeleteSchedule(t *testing.T) {
+	server := setupTestServer()
+
+	schedule := createTestSchedule(t, server, models.ScheduleRequest{TaskType: "echo", IntervalSeconds: 60})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/api/v1/schedules/"+schedule.ID, nil)
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/api/v1/schedules/"+schedule.ID, nil)
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
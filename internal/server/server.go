@@ -5,10 +5,16 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
 
 	"go-fred/internal/config"
+	"go-fred/internal/consumer"
 	"go-fred/internal/events"
+	"go-fred/internal/outbox"
+	"go-fred/internal/scheduler"
 	"go-fred/internal/tasks"
+	"go-fred/internal/webhooks"
+	"go-fred/internal/workflows"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,8 +24,17 @@ type Server struct {
 	config      *config.Config
 	router      *gin.Engine
 	taskManager *tasks.TaskManager
+	taskStore   tasks.TaskStore
+	scheduler   *scheduler.Scheduler
+	workflows   *workflows.Manager
+	webhooks    *webhooks.Dispatcher
+	outbox      *outbox.Outbox
+	consumer    *consumer.Consumer
 	eventPub    events.Publisher
+	stream      *events.Stream
 	httpServer  *http.Server
+	// mu guards httpServer, which Start sets while Stop may be reading it
+	mu sync.Mutex
 }
 
 // New creates a new server instance
@@ -34,8 +49,70 @@ func New(cfg *config.Config) *Server {
 	registry := tasks.NewExecutorRegistry()
 	tasks.RegisterDefaultExecutors(registry)
 
+	// Create task store
+	taskStore, err := tasks.NewTaskStore(&cfg.Tasks.Store)
+	if err != nil {
+		log.Fatalf("Failed to create task store: %v", err)
+	}
+
+	// Keep events in an outbox next to the tasks until they are published
+	var eventOutbox *outbox.Outbox
+	if cfg.Events.Outbox.IsEnabled() {
+		outboxStore, err := outbox.NewStore(taskStore)
+		if err != nil {
+			log.Fatalf("Failed to create outbox store: %v", err)
+		}
+		eventOutbox = outbox.New(outboxStore, eventPub, &cfg.Events.Outbox)
+		eventPub = eventOutbox
+
+		// Store task events in the transaction of the task change they
+		// describe
+		if boltStore, ok := taskStore.(*tasks.BoltTaskStore); ok {
+			boltStore.SetEventRecorder(eventOutbox)
+		}
+	}
+
+	// Keep recent events for the server-sent events streams
+	stream := events.NewStream(eventPub, cfg.Events.Stream.BufferSize)
+
 	// Create task manager
-	taskManager := tasks.NewTaskManager(registry, eventPub, cfg.Tasks.MaxConcurrent)
+	taskManager := tasks.NewTaskManagerWithConfig(registry, stream, taskStore, &cfg.Tasks)
+
+	// Create scheduler, keeping schedules next to the tasks
+	scheduleStore, err := scheduler.NewScheduleStore(taskStore)
+	if err != nil {
+		log.Fatalf("Failed to create schedule store: %v", err)
+	}
+	taskScheduler := scheduler.NewScheduler(taskManager, scheduleStore, stream)
+
+	// Create workflow manager, keeping workflows next to the tasks
+	workflowStore, err := workflows.NewWorkflowStore(taskStore)
+	if err != nil {
+		log.Fatalf("Failed to create workflow store: %v", err)
+	}
+	workflowManager := workflows.NewManager(taskManager, workflowStore, stream)
+
+	// Create webhook dispatcher, keeping deliveries next to the tasks
+	deliveryStore, err := webhooks.NewDeliveryStore(taskStore)
+	if err != nil {
+		log.Fatalf("Failed to create delivery store: %v", err)
+	}
+	dispatcher := webhooks.NewDispatcher(taskManager, deliveryStore, &cfg.Webhooks)
+
+	// Recover tasks interrupted by a previous run once the workflows and
+	// webhooks listen for finished tasks
+	if err := taskManager.RecoverTasks(cfg.Tasks.Store.RecoveryPolicy); err != nil {
+		log.Fatalf("Failed to recover tasks: %v", err)
+	}
+
+	// Create tasks from the requests produced to Kafka
+	var taskConsumer *consumer.Consumer
+	if cfg.Consumer.Enabled {
+		taskConsumer, err = consumer.New(taskManager, &cfg.Consumer)
+		if err != nil {
+			log.Fatalf("Failed to create task consumer: %v", err)
+		}
+	}
 
 	// Create Gin router
 	router := gin.Default()
@@ -49,7 +126,14 @@ func New(cfg *config.Config) *Server {
 		config:      cfg,
 		router:      router,
 		taskManager: taskManager,
-		eventPub:    eventPub,
+		taskStore:   taskStore,
+		scheduler:   taskScheduler,
+		workflows:   workflowManager,
+		webhooks:    dispatcher,
+		outbox:      eventOutbox,
+		consumer:    taskConsumer,
+		eventPub:    stream,
+		stream:      stream,
 	}
 
 	// Setup routes
@@ -72,7 +156,42 @@ func (s *Server) setupRoutes() {
 		v1.GET("/tasks/:id", s.getTask)
 		v1.POST("/tasks/:id/execute", s.executeTask)
 		v1.POST("/tasks/:id/execute-async", s.executeTaskAsync)
+		v1.DELETE("/tasks", s.cancelTasks)
 		v1.DELETE("/tasks/:id", s.cancelTask)
+		v1.POST("/tasks/:id/reschedule", s.rescheduleTask)
+		v1.GET("/tasks/:id/events", s.streamTaskEvents)
+		v1.GET("/tasks/:id/wait", s.waitTask)
+		v1.GET("/tasks/:id/deliveries", s.listDeliveries)
+
+		// Schedule management endpoints
+		v1.POST("/schedules", s.createSchedule)
+		v1.GET("/schedules", s.listSchedules)
+		v1.GET("/schedules/:id", s.getSchedule)
+		v1.PUT("/schedules/:id", s.updateSchedule)
+		v1.DELETE("/schedules/:id", s.deleteSchedule)
+		v1.POST("/schedules/:id/pause", s.pauseSchedule)
+		v1.POST("/schedules/:id/resume", s.resumeSchedule)
+
+		// Workflow management endpoints
+		v1.POST("/workflows", s.createWorkflow)
+		v1.GET("/workflows", s.listWorkflows)
+		v1.GET("/workflows/:id", s.getWorkflow)
+		v1.DELETE("/workflows/:id", s.cancelWorkflow)
+
+		// Queue management endpoints
+		v1.GET("/queues", s.listQueues)
+		v1.GET("/queues/:name", s.getQueue)
+		v1.POST("/queues/:name/pause", s.pauseQueue)
+		v1.POST("/queues/:name/resume", s.resumeQueue)
+		v1.POST("/queues/:name/drain", s.drainQueue)
+
+		// Concurrency key endpoints
+		v1.GET("/concurrency-keys", s.listConcurrencyKeys)
+		v1.GET("/concurrency-keys/:key", s.getConcurrencyKey)
+
+		// Event stream and outbox endpoints
+		v1.GET("/events/stream", s.streamEvents)
+		v1.GET("/events/outbox", s.getOutbox)
 
 		// Task types endpoint
 		v1.GET("/task-types", s.getTaskTypes)
@@ -83,14 +202,32 @@ func (s *Server) setupRoutes() {
 func (s *Server) Start() error {
 	address := s.config.GetAddress()
 
-	s.httpServer = &http.Server{
+	s.mu.Lock()
+	httpServer := &http.Server{
 		Addr:    address,
 		Handler: s.router,
 	}
+	s.httpServer = httpServer
+
+	// Start creating tasks from schedules
+	s.scheduler.Start()
+
+	// Pick up workflows left running by a previous run
+	s.workflows.Start()
+
+	// Resume webhook deliveries left pending by a previous run
+	s.webhooks.Start()
+
+	// Start consuming task requests
+	if s.consumer != nil {
+		s.consumer.Start()
+	}
+
+	s.mu.Unlock()
 
 	log.Printf("Starting server on %s", address)
 
-	if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		return fmt.Errorf("failed to start server: %w", err)
 	}
 
@@ -99,18 +236,46 @@ func (s *Server) Start() error {
 
 // Stop gracefully stops the HTTP server
 func (s *Server) Stop(ctx context.Context) error {
-	if s.httpServer == nil {
+	s.mu.Lock()
+	httpServer := s.httpServer
+	s.mu.Unlock()
+
+	if httpServer == nil {
 		return nil
 	}
 
+	// Shutdown HTTP server first so no handler touches the components below
+	// once they are closed
+	shutdownErr := httpServer.Shutdown(ctx)
+
+	// Stop scheduling and consuming new tasks
+	s.scheduler.Stop()
+	if s.consumer != nil {
+		if err := s.consumer.Close(); err != nil {
+			log.Printf("Error closing task consumer: %v", err)
+		}
+	}
+
+	// Stop the task workers
+	s.taskManager.Close()
+
+	// Stop delivering webhooks; unfinished deliveries resume on restart
+	s.webhooks.Close()
+
 	// Close event publisher
 	if err := s.eventPub.Close(); err != nil {
 		log.Printf("Error closing event publisher: %v", err)
 	}
 
-	// Shutdown HTTP server
-	if err := s.httpServer.Shutdown(ctx); err != nil {
-		return fmt.Errorf("failed to shutdown server: %w", err)
+	// Close task store
+	if s.taskStore != nil {
+		if err := s.taskStore.Close(); err != nil {
+			log.Printf("Error closing task store: %v", err)
+		}
+	}
+
+	if shutdownErr != nil {
+		return fmt.Errorf("failed to shutdown server: %w", shutdownErr)
 	}
 
 	return nil
@@ -121,7 +286,7 @@ func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, Idempotency-Key, Last-Event-ID")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -2,11 +2,16 @@ package server
 
 import (
 	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"go-fred/internal/config"
 	"go-fred/internal/events"
+	"go-fred/internal/models"
 	"go-fred/internal/tasks"
 )
 
@@ -183,14 +188,21 @@ func TestServerWithInvalidEventPublisher(t *testing.T) {
 		},
 	}
 
-	// This should panic due to invalid event publisher
-	defer func() {
-		if r := recover(); r == nil {
-			t.Error("Expected panic for invalid event publisher")
-		}
-	}()
+	// New exits the process on an invalid event publisher, so run it in a
+	// child test process
+	if os.Getenv("GO_FRED_TEST_NEW") == "1" {
+		New(cfg)
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestServerWithInvalidEventPublisher$")
+	cmd.Env = append(os.Environ(), "GO_FRED_TEST_NEW=1")
+	err := cmd.Run()
 
-	New(cfg)
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.Success() {
+		t.Errorf("Expected exit for invalid event publisher, got %v", err)
+	}
 }
 
 func TestServerTaskManagerIntegration(t *testing.T) {
@@ -215,7 +227,6 @@ func TestServerTaskManagerIntegration(t *testing.T) {
 	}
 
 	// Test that default executors are registered
-	registry := server.taskManager.(*tasks.TaskManager)
 	// We can't access the registry directly, but we can test through task creation
 	task, err := server.taskManager.CreateTask("echo", map[string]interface{}{"message": "test"}, false)
 	if err != nil {
@@ -273,3 +284,61 @@ func TestServerEventPublisherIntegration(t *testing.T) {
 		t.Errorf("Unexpected error closing event publisher: %v", err)
 	}
 }
+
+func TestNewRecoversTasksAfterListeners(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.db")
+
+	// A task left running by a previous run
+	taskStore, err := tasks.NewBoltTaskStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	task := models.NewTask("echo", nil, false)
+	task.CallbackURL = "http://127.0.0.1:1/callback"
+	if err := taskStore.Create(task); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	task.Start()
+	if err := taskStore.Update(task); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	taskStore.Close()
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{
+			Host: "localhost",
+			Port: 0,
+		},
+		Events: config.EventsConfig{
+			Publisher: "noop",
+		},
+		Tasks: config.TasksConfig{
+			MaxConcurrent: 10,
+			Store: config.StoreConfig{
+				Driver:         "bolt",
+				Path:           path,
+				RecoveryPolicy: "fail",
+			},
+		},
+	}
+
+	server := New(cfg)
+	defer server.taskStore.Close()
+	defer server.webhooks.Close()
+
+	// The task failed by the recovery policy is delivered to its callback
+	deadline := time.Now().Add(time.Second)
+	for {
+		deliveries, err := server.webhooks.Deliveries(task.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(deliveries) == 1 && deliveries[0].Event == "task.failed" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a task.failed delivery for the recovered task, got %+v", deliveries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
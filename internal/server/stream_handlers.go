@@ -0,0 +1,188 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-fred/internal/events"
+	"go-fred/internal/models"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultHeartbeatInterval is used when no heartbeat interval is configured
+const defaultHeartbeatInterval = 15 * time.Second
+
+// taskFinishedEvents are the events after which a task has no further
+// lifecycle updates
+var taskFinishedEvents = map[string]bool{
+	events.EventTypeTaskCompleted: true,
+	events.EventTypeTaskFailed:    true,
+	events.EventTypeTaskCancelled: true,
+	events.EventTypeTaskTimedOut:  true,
+}
+
+// streamEvents pushes the lifecycle events matching the filters as
+// server-sent events. Clients resuming with a Last-Event-ID first get the
+// events they missed that are still buffered.
+func (s *Server) streamEvents(c *gin.Context) {
+	filter, err := parseEventFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	after, resumed, err := lastEventID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !resumed {
+		after = s.stream.Sequence()
+	}
+
+	backlog, subscription := s.stream.Subscribe(after, filter)
+	defer subscription.Close()
+
+	s.serveEvents(c, backlog, subscription, nil)
+}
+
+// streamTaskEvents pushes the lifecycle events of a task as server-sent
+// events, starting with those still buffered, and ends the stream once the
+// task finished
+func (s *Server) streamTaskEvents(c *gin.Context) {
+	taskID := c.Param("id")
+
+	filter, err := parseEventFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	after, _, err := lastEventID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	task, err := s.taskManager.GetTask(taskID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	backlog, subscription := s.stream.Subscribe(after, func(event events.Event) bool {
+		return event.Data["task_id"] == taskID && filter(event)
+	})
+	defer subscription.Close()
+
+	// A task that already finished gets no further events
+	if task.IsFinished() {
+		subscription.Close()
+	}
+
+	s.serveEvents(c, backlog, subscription, func(event events.Event) bool {
+		return taskFinishedEvents[event.Type]
+	})
+}
+
+// serveEvents writes the backlog and then the events of the subscription
+// until the client goes away, the subscription ends or last reports the
+// final event. Heartbeat comments keep idle connections open through
+// proxies.
+func (s *Server) serveEvents(c *gin.Context, backlog []events.StreamEvent, subscription *events.Subscription, last func(event events.Event) bool) {
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	for _, streamEvent := range backlog {
+		if err := writeStreamEvent(c, streamEvent); err != nil {
+			return
+		}
+		if last != nil && last(streamEvent.Event) {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(s.heartbeatInterval())
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case streamEvent, ok := <-subscription.Events():
+			if !ok {
+				return
+			}
+			if err := writeStreamEvent(c, streamEvent); err != nil {
+				return
+			}
+			c.Writer.Flush()
+			if last != nil && last(streamEvent.Event) {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := c.Writer.WriteString(": heartbeat\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// heartbeatInterval returns the configured interval between heartbeats
+func (s *Server) heartbeatInterval() time.Duration {
+	if s.config.Events.Stream.HeartbeatSeconds > 0 {
+		return time.Duration(s.config.Events.Stream.HeartbeatSeconds) * time.Second
+	}
+	return defaultHeartbeatInterval
+}
+
+// writeStreamEvent writes an event with its sequence as event ID, so that
+// clients can resume after it
+func writeStreamEvent(c *gin.Context, streamEvent events.StreamEvent) error {
+	return sse.Encode(c.Writer, sse.Event{
+		Id:    strconv.FormatUint(streamEvent.Sequence, 10),
+		Event: streamEvent.Event.Type,
+		Data:  streamEvent.Event,
+	})
+}
+
+// lastEventID reads the ID of the last event a client received, from the
+// Last-Event-ID header browsers send when reconnecting or from the
+// last_event_id query parameter
+func lastEventID(c *gin.Context) (uint64, bool, error) {
+	value := c.GetHeader("Last-Event-ID")
+	if value == "" {
+		value = c.Query("last_event_id")
+	}
+	if value == "" {
+		return 0, false, nil
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid last event ID: %s", value)
+	}
+	return id, true, nil
+}
+
+// parseEventFilter builds the event filter of a stream from the type and
+// selector query parameters. Types may end with "*" to match a prefix, as in
+// "task.*".
+func parseEventFilter(c *gin.Context) (events.EventFilter, error) {
+	types := splitList(c.Query("type"))
+	selector, err := models.ParseLabelSelector(c.Query("selector"))
+	if err != nil {
+		return nil, err
+	}
+
+	return func(event events.Event) bool {
+		return events.MatchesEventType(event.Type, types) && selector.Matches(event.Labels)
+	}, nil
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-fred/internal/events"
+	"go-fred/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readSSE reads server-sent events until n events arrived or the stream
+// ended, returning the type and ID of each
+func readSSE(t *testing.T, reader *bufio.Reader, n int) (types, ids []string) {
+	t.Helper()
+	for len(types) < n {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return types, ids
+		}
+		line = strings.TrimRight(line, "\n")
+		if value, found := strings.CutPrefix(line, "event:"); found {
+			types = append(types, value)
+		}
+		if value, found := strings.CutPrefix(line, "id:"); found {
+			ids = append(ids, value)
+		}
+	}
+	return types, ids
+}
+
+func TestStreamTaskEvents(t *testing.T) {
+	server := setupTestServer()
+
+	task, err := server.taskManager.CreateTask("echo", map[string]interface{}{"message": "hello"}, false)
+	require.NoError(t, err)
+	other, err := server.taskManager.CreateTask("echo", map[string]interface{}{"message": "other"}, false)
+	require.NoError(t, err)
+	require.NoError(t, server.taskManager.ExecuteTask(context.Background(), task.ID))
+
+	// The stream of a finished task replays its events and ends
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/tasks/"+task.ID+"/events", nil)
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	types, ids := readSSE(t, bufio.NewReader(w.Body), 10)
+	assert.Equal(t, []string{events.EventTypeTaskCreated, events.EventTypeTaskStarted, events.EventTypeTaskCompleted}, types)
+	assert.Equal(t, []string{"1", "3", "4"}, ids)
+	assert.NotContains(t, w.Body.String(), other.ID)
+
+	// Resuming skips the events already received
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/v1/tasks/"+task.ID+"/events", nil)
+	req.Header.Set("Last-Event-ID", "3")
+	server.router.ServeHTTP(w, req)
+
+	types, _ = readSSE(t, bufio.NewReader(w.Body), 10)
+	assert.Equal(t, []string{events.EventTypeTaskCompleted}, types)
+}
+
+func TestStreamTaskEventsUntilFinished(t *testing.T) {
+	server := setupTestServer()
+	httpServer := httptest.NewServer(server.router)
+	defer httpServer.Close()
+
+	task, err := server.taskManager.CreateTask("sleep", map[string]interface{}{"duration": 0.2}, true)
+	require.NoError(t, err)
+
+	resp, err := http.Get(httpServer.URL + "/api/v1/tasks/" + task.ID + "/events?type=task.*")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.NoError(t, server.taskManager.ExecuteTaskAsync(context.Background(), task.ID))
+
+	// The stream ends by itself after the completion event
+	types, _ := readSSE(t, bufio.NewReader(resp.Body), 10)
+	assert.Equal(t, []string{events.EventTypeTaskCreated, events.EventTypeTaskStarted, events.EventTypeTaskCompleted}, types)
+}
+
+func TestStreamEvents(t *testing.T) {
+	server := setupTestServer()
+	httpServer := httptest.NewServer(server.router)
+	defer httpServer.Close()
+
+	_, err := server.taskManager.CreateTaskFromRequest(&models.TaskRequest{Type: "echo", Labels: map[string]string{"env": "dev"}})
+	require.NoError(t, err)
+
+	resp, err := http.Get(httpServer.URL + "/api/v1/events/stream?type=task.created&selector=env%3Dprod")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// Only new events matching the filters are pushed
+	_, err = server.taskManager.CreateTaskFromRequest(&models.TaskRequest{Type: "echo", Labels: map[string]string{"env": "dev"}})
+	require.NoError(t, err)
+	prod, err := server.taskManager.CreateTaskFromRequest(&models.TaskRequest{Type: "echo", Labels: map[string]string{"env": "prod"}})
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(resp.Body)
+	types, ids := readSSE(t, reader, 1)
+	assert.Equal(t, []string{events.EventTypeTaskCreated}, types)
+	assert.Equal(t, []string{"3"}, ids)
+	data, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Contains(t, data, prod.ID)
+}
+
+func TestStreamEventsHeartbeat(t *testing.T) {
+	server := setupTestServer()
+	server.config.Events.Stream.HeartbeatSeconds = 1
+	httpServer := httptest.NewServer(server.router)
+	defer httpServer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", httpServer.URL+"/api/v1/events/stream", nil)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, ": heartbeat\n", line)
+}
+
+func TestStreamEventsInvalidRequests(t *testing.T) {
+	server := setupTestServer()
+
+	requests := map[string]int{
+		"/api/v1/events/stream?last_event_id=abc":     http.StatusBadRequest,
+		"/api/v1/events/stream?selector=env%20in%20(": http.StatusBadRequest,
+		"/api/v1/tasks/missing/events":                http.StatusNotFound,
+	}
+	for path, status := range requests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, status, w.Code, path)
+	}
+}
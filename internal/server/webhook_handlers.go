@@ -0,0 +1,34 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"go-fred/internal/models"
+	"go-fred/internal/tasks"
+
+	"github.com/gin-gonic/gin"
+)
+
+// listDeliveries handles listing the webhook deliveries of a task
+func (s *Server) listDeliveries(c *gin.Context) {
+	deliveries, err := s.webhooks.Deliveries(c.Param("id"))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, tasks.ErrTaskNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := models.DeliveryListResponse{
+		Deliveries: make([]models.WebhookDelivery, len(deliveries)),
+		Total:      len(deliveries),
+	}
+	for i, delivery := range deliveries {
+		response.Deliveries[i] = *delivery
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-fred/internal/events"
+	"go-fred/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaskCallbackDeliveries(t *testing.T) {
+	server := setupTestServer()
+	defer server.webhooks.Close()
+
+	// The receiver rejects the first delivery and accepts the retry
+	var calls atomic.Int32
+	payloads := make(chan models.WebhookPayload, 2)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(events.WebhookHeaderTimestamp), 10, 64)
+		assert.Equal(t, events.SignWebhook("test-secret", timestamp, body), r.Header.Get(events.WebhookHeaderSignature))
+		assert.Equal(t, "task.completed", r.Header.Get(events.WebhookHeaderEvent))
+
+		var payload models.WebhookPayload
+		assert.NoError(t, json.Unmarshal(body, &payload))
+		payloads <- payload
+
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	taskRequest := models.TaskRequest{
+		Type:        "echo",
+		Input:       map[string]interface{}{"message": "hello"},
+		CallbackURL: receiver.URL + "/hooks",
+	}
+	body, _ := json.Marshal(taskRequest)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	server.router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var created models.TaskResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, receiver.URL+"/hooks", created.Task.CallbackURL)
+	require.NoError(t, server.taskManager.ExecuteTask(context.Background(), created.Task.ID))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case payload := <-payloads:
+			assert.Equal(t, created.Task.ID, payload.Task.ID)
+			assert.Equal(t, models.TaskStatusCompleted, payload.Task.Status)
+			assert.Equal(t, "hello", payload.Task.Input["message"])
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the webhook")
+		}
+	}
+
+	// Both attempts are recorded once the retry went through
+	var response models.DeliveryListResponse
+	require.Eventually(t, func() bool {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/tasks/"+created.Task.ID+"/deliveries", nil)
+		server.router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &response) != nil {
+			return false
+		}
+		return response.Total == 1 && response.Deliveries[0].Status == models.DeliveryStatusSucceeded
+	}, 5*time.Second, 10*time.Millisecond)
+
+	delivery := response.Deliveries[0]
+	assert.Equal(t, models.DeliverySourceCallback, delivery.Source)
+	require.Len(t, delivery.Attempts, 2)
+	assert.Equal(t, http.StatusServiceUnavailable, delivery.Attempts[0].StatusCode)
+	assert.NotEmpty(t, delivery.Attempts[0].Error)
+	assert.Equal(t, http.StatusNoContent, delivery.Attempts[1].StatusCode)
+	assert.Empty(t, delivery.Attempts[1].Error)
+}
+
+func TestTaskDeliveriesInvalidRequests(t *testing.T) {
+	server := setupTestServer()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/tasks/missing/deliveries", nil)
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Only absolute http(s) callback URLs are accepted
+	for _, callbackURL := range []string{"/hooks", "ftp://example.com/hooks", "http://"} {
+		body, _ := json.Marshal(models.TaskRequest{Type: "echo", CallbackURL: callbackURL})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/tasks", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		server.router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, callbackURL)
+	}
+}
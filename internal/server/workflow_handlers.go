@@ -0,0 +1,79 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go-fred/internal/models"
+	"go-fred/internal/workflows"
+)
+
+// createWorkflow creates a new workflow and starts its root nodes
+func (s *Server) createWorkflow(c *gin.Context) {
+	var req models.WorkflowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	workflow, err := s.workflows.CreateWorkflow(&req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := models.WorkflowResponse{Workflow: workflow}
+	c.JSON(http.StatusCreated, response)
+}
+
+// listWorkflows returns all workflows
+func (s *Server) listWorkflows(c *gin.Context) {
+	workflows := s.workflows.ListWorkflows()
+
+	response := models.WorkflowListResponse{
+		Workflows: make([]models.Workflow, len(workflows)),
+		Total:     len(workflows),
+	}
+
+	for i, workflow := range workflows {
+		response.Workflows[i] = *workflow
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// getWorkflow returns a specific workflow by ID
+func (s *Server) getWorkflow(c *gin.Context) {
+	workflow, err := s.workflows.GetWorkflow(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := models.WorkflowResponse{Workflow: workflow}
+	c.JSON(http.StatusOK, response)
+}
+
+// cancelWorkflow cancels a running workflow
+func (s *Server) cancelWorkflow(c *gin.Context) {
+	workflow, err := s.workflows.CancelWorkflow(c.Param("id"))
+	if err != nil {
+		c.JSON(workflowErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	response := models.WorkflowResponse{Workflow: workflow}
+	c.JSON(http.StatusOK, response)
+}
+
+// workflowErrorStatus maps workflow errors to HTTP status codes
+func workflowErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, workflows.ErrWorkflowNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, workflows.ErrWorkflowFinished):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
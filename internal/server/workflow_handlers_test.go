@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-fred/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// postWorkflow posts a raw workflow definition to the API
+func postWorkflow(server *Server, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/v1/workflows", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreateWorkflow(t *testing.T) {
+	server := setupTestServer()
+
+	w := postWorkflow(server, `{
+		"name": "pipeline",
+		"nodes": [
+			{"id": "fetch", "type": "math", "input": {"operation": "add", "a": 1, "b": 2}},
+			{"id": "store", "type": "math", "input": {"operation": "add", "a": 3, "b": 4}, "depends_on": ["fetch"]},
+			{"id": "alert", "type": "echo", "depends_on": [{"node": "fetch", "condition": "failure"}]}
+		]
+	}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var response models.WorkflowResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+	require.NotNil(t, response.Workflow)
+
+	workflow := response.Workflow
+	assert.Equal(t, "pipeline", workflow.Name)
+	assert.Equal(t, models.WorkflowStatusRunning, workflow.Status)
+	assert.NotEmpty(t, workflow.Node("fetch").TaskID)
+	assert.Equal(t, models.DependencyOnSuccess, workflow.Node("store").DependsOn[0].Condition)
+
+	// Poll until the workflow finishes
+	require.Eventually(t, func() bool {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/workflows/"+workflow.ID, nil)
+		server.router.ServeHTTP(w, req)
+
+		var response models.WorkflowResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			return false
+		}
+		workflow = response.Workflow
+		return workflow.IsFinished()
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, models.WorkflowStatusCompleted, workflow.Status)
+	assert.Equal(t, models.NodeStatusCompleted, workflow.Node("store").Status)
+	assert.Equal(t, models.NodeStatusSkipped, workflow.Node("alert").Status)
+}
+
+func TestCreateWorkflowInvalid(t *testing.T) {
+	server := setupTestServer()
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"no nodes", `{"nodes": []}`},
+		{"missing node id", `{"nodes": [{"type": "echo"}]}`},
+		{"missing task type", `{"nodes": [{"id": "a"}]}`},
+		{"invalid task type", `{"nodes": [{"id": "a", "type": "invalid"}]}`},
+		{"cycle", `{"nodes": [{"id": "a", "type": "echo", "depends_on": ["b"]}, {"id": "b", "type": "echo", "depends_on": ["a"]}]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postWorkflow(server, tt.body)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestListWorkflows(t *testing.T) {
+	server := setupTestServer()
+
+	for i := 0; i < 2; i++ {
+		w := postWorkflow(server, `{"nodes": [{"id": "a", "type": "echo"}]}`)
+		require.Equal(t, http.StatusCreated, w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/v1/workflows", nil)
+	server.router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response models.WorkflowListResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+	assert.Equal(t, 2, response.Total)
+}
+
+func TestCancelWorkflow(t *testing.T) {
+	server := setupTestServer()
+
+	w := postWorkflow(server, `{"nodes": [{"id": "wait", "type": "sleep", "input": {"duration": 5}}]}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var created models.WorkflowResponse
+	err := json.Unmarshal(w.Body.Bytes(), &created)
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/api/v1/workflows/"+created.Workflow.ID, nil)
+	server.router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response models.WorkflowResponse
+	err = json.Unmarshal(w.Body.Bytes(), &response)
+	require.NoError(t, err)
+	assert.Equal(t, models.WorkflowStatusCancelled, response.Workflow.Status)
+	assert.Equal(t, models.NodeStatusCancelled, response.Workflow.Node("wait").Status)
+
+	// A finished workflow cannot be cancelled again
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/api/v1/workflows/"+created.Workflow.ID, nil)
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestGetWorkflowNotFound(t *testing.T) {
+	server := setupTestServer()
+
+	for _, method := range []string{"GET", "DELETE"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, "/api/v1/workflows/missing", nil)
+		server.router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code, method)
+	}
+}
@@ -0,0 +1,382 @@
+package tasks
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go-fred/internal/events"
+	"go-fred/internal/models"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	tasksBucket     = []byte("tasks")
+	taskIndexBucket = []byte("task_index")
+)
+
+// EventRecorder stores the events of a task change in the transaction
+// storing the change, so that they are stored if and only if the change is.
+// The event outbox implements it.
+type EventRecorder interface {
+	RecordEvents(tx *bolt.Tx, evts []events.Event) error
+}
+
+// BoltTaskStore persists tasks in an embedded BoltDB file
+type BoltTaskStore struct {
+	db       *bolt.DB
+	recorder EventRecorder
+}
+
+// NewBoltTaskStore opens (or creates) a BoltDB file at the given path
+func NewBoltTaskStore(path string) (*BoltTaskStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("bolt store path not configured")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt store: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		tasks, err := tx.CreateBucketIfNotExists(tasksBucket)
+		if err != nil {
+			return err
+		}
+		if tx.Bucket(taskIndexBucket) != nil {
+			return nil
+		}
+
+		// Files written before tasks were indexed get their index built
+		// from the stored tasks
+		index, err := tx.CreateBucket(taskIndexBucket)
+		if err != nil {
+			return err
+		}
+		return tasks.ForEach(func(_, data []byte) error {
+			task, err := decodeTask(data)
+			if err != nil {
+				return err
+			}
+			return putIndexEntries(index, task)
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize bolt store: %w", err)
+	}
+
+	return &BoltTaskStore{db: db}, nil
+}
+
+// Create stores a new task, and the events announcing it if the store has an
+// event recorder
+func (s *BoltTaskStore) Create(task *models.Task, evts ...events.Event) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(tasksBucket)
+		if bucket.Get([]byte(task.ID)) != nil {
+			return fmt.Errorf("%w: %s", ErrTaskExists, task.ID)
+		}
+
+		task.Version = 1
+		if err := putTask(bucket, task); err != nil {
+			task.Version = 0
+			return err
+		}
+		if err := s.recordEvents(tx, evts); err != nil {
+			task.Version = 0
+			return err
+		}
+		return putIndexEntries(tx.Bucket(taskIndexBucket), task)
+	})
+}
+
+// Get retrieves a task by ID
+func (s *BoltTaskStore) Get(taskID string) (*models.Task, error) {
+	var task *models.Task
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(tasksBucket).Get([]byte(taskID))
+		if data == nil {
+			return fmt.Errorf("%w: %s", ErrTaskNotFound, taskID)
+		}
+
+		var err error
+		task, err = decodeTask(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// List returns all stored tasks
+func (s *BoltTaskStore) List() ([]*models.Task, error) {
+	var tasks []*models.Task
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tasksBucket).ForEach(func(_, data []byte) error {
+			task, err := decodeTask(data)
+			if err != nil {
+				return err
+			}
+			tasks = append(tasks, task)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+// Query returns a page of the tasks matching the query, reading the index
+// and the tasks in a single transaction
+func (s *BoltTaskStore) Query(query *models.TaskQuery) (*TaskPage, error) {
+	var page *TaskPage
+	err := s.db.View(func(tx *bolt.Tx) error {
+		tasks := tx.Bucket(tasksBucket)
+		index := tx.Bucket(taskIndexBucket)
+
+		open := func(field, partition string, after *indexEntry, descending bool) indexIterator {
+			return openBoltIndex(index, field, partition, after, descending)
+		}
+		load := func(taskID string) (*models.Task, error) {
+			data := tasks.Get([]byte(taskID))
+			if data == nil {
+				return nil, fmt.Errorf("%w: %s", ErrTaskNotFound, taskID)
+			}
+			return decodeTask(data)
+		}
+
+		var err error
+		page, err = runQuery(query, open, load)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// Update replaces a stored task if its version matches, and stores the events
+// describing the change if the store has an event recorder
+func (s *BoltTaskStore) Update(task *models.Task, evts ...events.Event) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(tasksBucket)
+		data := bucket.Get([]byte(task.ID))
+		if data == nil {
+			return fmt.Errorf("%w: %s", ErrTaskNotFound, task.ID)
+		}
+
+		stored, err := decodeTask(data)
+		if err != nil {
+			return err
+		}
+		if stored.Version != task.Version {
+			return fmt.Errorf("%w: %s (expected version %d, got %d)", ErrVersionConflict, task.ID, stored.Version, task.Version)
+		}
+
+		task.Version++
+		if err := putTask(bucket, task); err != nil {
+			task.Version--
+			return err
+		}
+
+		if err := s.recordEvents(tx, evts); err != nil {
+			task.Version--
+			return err
+		}
+
+		index := tx.Bucket(taskIndexBucket)
+		if err := deleteIndexEntries(index, stored); err != nil {
+			return err
+		}
+		return putIndexEntries(index, task)
+	})
+}
+
+// Delete removes a task by ID
+func (s *BoltTaskStore) Delete(taskID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(tasksBucket)
+		data := bucket.Get([]byte(taskID))
+		if data == nil {
+			return fmt.Errorf("%w: %s", ErrTaskNotFound, taskID)
+		}
+
+		stored, err := decodeTask(data)
+		if err != nil {
+			return err
+		}
+		if err := deleteIndexEntries(tx.Bucket(taskIndexBucket), stored); err != nil {
+			return err
+		}
+		return bucket.Delete([]byte(taskID))
+	})
+}
+
+// SetEventRecorder makes the store record the events of every task change in
+// the transaction storing it. It must be called before the store is used.
+func (s *BoltTaskStore) SetEventRecorder(recorder EventRecorder) {
+	s.recorder = recorder
+}
+
+// recordsEvents reports whether the store records the events of task changes
+func (s *BoltTaskStore) recordsEvents() bool {
+	return s.recorder != nil
+}
+
+// recordEvents hands the events of a task change to the event recorder
+func (s *BoltTaskStore) recordEvents(tx *bolt.Tx, evts []events.Event) error {
+	if s.recorder == nil || len(evts) == 0 {
+		return nil
+	}
+	if err := s.recorder.RecordEvents(tx, evts); err != nil {
+		return fmt.Errorf("failed to record events: %w", err)
+	}
+	return nil
+}
+
+// DB returns the underlying BoltDB handle so that other stores can keep
+// their data in the same file
+func (s *BoltTaskStore) DB() *bolt.DB {
+	return s.db
+}
+
+// Close closes the underlying BoltDB file
+func (s *BoltTaskStore) Close() error {
+	return s.db.Close()
+}
+
+// putTask encodes a task and writes it to the bucket
+func putTask(bucket *bolt.Bucket, task *models.Task) error {
+	data, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("failed to marshal task: %w", err)
+	}
+	return bucket.Put([]byte(task.ID), data)
+}
+
+// decodeTask decodes a stored task
+func decodeTask(data []byte) (*models.Task, error) {
+	var task models.Task
+	if err := json.Unmarshal(data, &task); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal task: %w", err)
+	}
+	return &task, nil
+}
+
+// indexPrefix is the common prefix of the keys of a partition of a sort
+// index
+func indexPrefix(field, partition string) []byte {
+	return []byte(field + "\x00" + partition + "\x00")
+}
+
+// indexKey encodes an index entry so that keys sort in the entry order. The
+// sign bit of the value is flipped to order negative values first.
+func indexKey(prefix []byte, entry indexEntry) []byte {
+	key := make([]byte, len(prefix)+8+len(entry.id))
+	copy(key, prefix)
+	binary.BigEndian.PutUint64(key[len(prefix):], uint64(entry.value)^(1<<63))
+	copy(key[len(prefix)+8:], entry.id)
+	return key
+}
+
+// decodeIndexKey decodes the entry of an index key
+func decodeIndexKey(prefix, key []byte) indexEntry {
+	value := binary.BigEndian.Uint64(key[len(prefix):])
+	return indexEntry{value: int64(value ^ (1 << 63)), id: string(key[len(prefix)+8:])}
+}
+
+// indexKeys returns the keys listing the task in every sort index
+func indexKeys(task *models.Task) [][]byte {
+	var keys [][]byte
+	for _, field := range sortFields {
+		entry := indexEntry{value: sortValue(task, field), id: task.ID}
+		for _, partition := range indexPartitions(task) {
+			keys = append(keys, indexKey(indexPrefix(field, partition), entry))
+		}
+	}
+	return keys
+}
+
+// putIndexEntries lists the task in every sort index
+func putIndexEntries(index *bolt.Bucket, task *models.Task) error {
+	for _, key := range indexKeys(task) {
+		if err := index.Put(key, nil); err != nil {
+			return fmt.Errorf("failed to index task: %w", err)
+		}
+	}
+	return nil
+}
+
+// deleteIndexEntries drops the task from every sort index
+func deleteIndexEntries(index *bolt.Bucket, task *models.Task) error {
+	for _, key := range indexKeys(task) {
+		if err := index.Delete(key); err != nil {
+			return fmt.Errorf("failed to unindex task: %w", err)
+		}
+	}
+	return nil
+}
+
+// boltIterator walks a partition of a sort index with a bolt cursor
+type boltIterator struct {
+	cursor     *bolt.Cursor
+	prefix     []byte
+	key        []byte
+	descending bool
+}
+
+// openBoltIndex positions a cursor right after the given entry, or at the
+// first entry of the partition in iteration order
+func openBoltIndex(index *bolt.Bucket, field, partition string, after *indexEntry, descending bool) *boltIterator {
+	it := &boltIterator{cursor: index.Cursor(), prefix: indexPrefix(field, partition), descending: descending}
+
+	if !descending {
+		if after == nil {
+			it.key, _ = it.cursor.Seek(it.prefix)
+			return it
+		}
+		start := indexKey(it.prefix, *after)
+		it.key, _ = it.cursor.Seek(start)
+		if bytes.Equal(it.key, start) {
+			it.key, _ = it.cursor.Next()
+		}
+		return it
+	}
+
+	// Moving backwards starts from the last key below the bound, which is
+	// the end of the partition when there is no entry to start after. The
+	// prefix ends with a zero byte, so bumping it bounds the partition.
+	var bound []byte
+	if after == nil {
+		bound = bytes.Clone(it.prefix)
+		bound[len(bound)-1] = 1
+	} else {
+		bound = indexKey(it.prefix, *after)
+	}
+	if key, _ := it.cursor.Seek(bound); key == nil {
+		it.key, _ = it.cursor.Last()
+	} else {
+		it.key, _ = it.cursor.Prev()
+	}
+	return it
+}
+
+func (it *boltIterator) next() (indexEntry, bool) {
+	if it.key == nil || !bytes.HasPrefix(it.key, it.prefix) {
+		return indexEntry{}, false
+	}
+	entry := decodeIndexKey(it.prefix, it.key)
+	if it.descending {
+		it.key, _ = it.cursor.Prev()
+	} else {
+		it.key, _ = it.cursor.Next()
+	}
+	return entry, true
+}
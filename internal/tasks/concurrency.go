@@ -0,0 +1,188 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+
+	"go-fred/internal/models"
+)
+
+// ErrConcurrencyKeyNotFound is returned when no task holds or waits for a
+// concurrency key
+var ErrConcurrencyKeyNotFound = errors.New("concurrency key not found")
+
+// concurrencyKey tracks the tasks holding a key and the ones waiting for it
+type concurrencyKey struct {
+	limit   int
+	holders []string
+	waiting []*keyWaiter
+}
+
+// keyWaiter is a task waiting for a concurrency key. admit queues the task
+// once it holds the key.
+type keyWaiter struct {
+	taskID string
+	admit  func()
+}
+
+// concurrencyKeys limits how many tasks sharing a concurrency key run at
+// once. Tasks beyond the limit wait for the key in the order they arrived.
+type concurrencyKeys struct {
+	mu   sync.Mutex
+	keys map[string]*concurrencyKey
+}
+
+// newConcurrencyKeys creates an empty set of concurrency keys
+func newConcurrencyKeys() *concurrencyKeys {
+	return &concurrencyKeys{keys: make(map[string]*concurrencyKey)}
+}
+
+// acquire gives the key to the task if fewer than limit tasks hold it and
+// nobody is waiting, and reports whether it did. Otherwise the task waits
+// and admit is called once it holds the key. The latest limit given for a
+// key applies to it.
+func (k *concurrencyKeys) acquire(key, taskID string, limit int, admit func()) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	entry, exists := k.keys[key]
+	if !exists {
+		entry = &concurrencyKey{}
+		k.keys[key] = entry
+	}
+	entry.limit = limit
+
+	if len(entry.waiting) == 0 && len(entry.holders) < entry.limit {
+		entry.holders = append(entry.holders, taskID)
+		return true
+	}
+	entry.waiting = append(entry.waiting, &keyWaiter{taskID: taskID, admit: admit})
+	return false
+}
+
+// release takes the key from the task, if it holds it, and hands it to the
+// tasks waiting next
+func (k *concurrencyKeys) release(key, taskID string) {
+	k.mu.Lock()
+	entry, exists := k.keys[key]
+	if !exists {
+		k.mu.Unlock()
+		return
+	}
+
+	index := slices.Index(entry.holders, taskID)
+	if index < 0 {
+		k.mu.Unlock()
+		return
+	}
+	entry.holders = slices.Delete(entry.holders, index, index+1)
+
+	var admitted []*keyWaiter
+	for len(entry.waiting) > 0 && len(entry.holders) < entry.limit {
+		waiter := entry.waiting[0]
+		entry.waiting = entry.waiting[1:]
+		entry.holders = append(entry.holders, waiter.taskID)
+		admitted = append(admitted, waiter)
+	}
+	k.prune(key, entry)
+	k.mu.Unlock()
+
+	for _, waiter := range admitted {
+		waiter.admit()
+	}
+}
+
+// cancel stops the task from waiting for the key and reports whether it was
+// still waiting
+func (k *concurrencyKeys) cancel(key, taskID string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	entry, exists := k.keys[key]
+	if !exists {
+		return false
+	}
+
+	index := slices.IndexFunc(entry.waiting, func(w *keyWaiter) bool { return w.taskID == taskID })
+	if index < 0 {
+		return false
+	}
+	entry.waiting = slices.Delete(entry.waiting, index, index+1)
+	k.prune(key, entry)
+	return true
+}
+
+// prune forgets a key nobody holds or waits for. The caller must hold k.mu.
+func (k *concurrencyKeys) prune(key string, entry *concurrencyKey) {
+	if len(entry.holders) == 0 && len(entry.waiting) == 0 {
+		delete(k.keys, key)
+	}
+}
+
+// stats returns a snapshot of a key, or nil if it is not in use
+func (k *concurrencyKeys) stats(key string) *models.ConcurrencyKeyStats {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	entry, exists := k.keys[key]
+	if !exists {
+		return nil
+	}
+
+	stats := &models.ConcurrencyKeyStats{
+		Key:     key,
+		Limit:   entry.limit,
+		Running: append([]string{}, entry.holders...),
+		Waiting: make([]string, len(entry.waiting)),
+	}
+	for i, waiter := range entry.waiting {
+		stats.Waiting[i] = waiter.taskID
+	}
+	return stats
+}
+
+// names returns the keys in use in alphabetical order
+func (k *concurrencyKeys) names() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	names := make([]string, 0, len(k.keys))
+	for key := range k.keys {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ListConcurrencyKeys returns the concurrency keys currently held or waited
+// for, with the tasks holding and waiting for each
+func (tm *TaskManager) ListConcurrencyKeys() []*models.ConcurrencyKeyStats {
+	var stats []*models.ConcurrencyKeyStats
+	for _, key := range tm.concurrency.names() {
+		// A key may have been released in the meantime
+		if keyStats := tm.concurrency.stats(key); keyStats != nil {
+			stats = append(stats, keyStats)
+		}
+	}
+	return stats
+}
+
+// GetConcurrencyKey returns the tasks holding and waiting for a concurrency
+// key
+func (tm *TaskManager) GetConcurrencyKey(key string) (*models.ConcurrencyKeyStats, error) {
+	stats := tm.concurrency.stats(key)
+	if stats == nil {
+		return nil, fmt.Errorf("%w: %s", ErrConcurrencyKeyNotFound, key)
+	}
+	return stats, nil
+}
+
+// releaseKey gives up the concurrency key of the task, if it has one
+func (tm *TaskManager) releaseKey(r *run) {
+	if r.task.ConcurrencyKey != "" {
+		tm.concurrency.release(r.task.ConcurrencyKey, r.task.ID)
+	}
+}
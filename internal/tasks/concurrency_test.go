@@ -0,0 +1,181 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"go-fred/internal/models"
+)
+
+func TestConcurrencyKeysOrder(t *testing.T) {
+	keys := newConcurrencyKeys()
+
+	var admitted []string
+	admit := func(taskID string) func() {
+		return func() { admitted = append(admitted, taskID) }
+	}
+
+	if !keys.acquire("customer-1", "a", 2, admit("a")) {
+		t.Fatal("Expected a to get the key")
+	}
+	if !keys.acquire("customer-1", "b", 2, admit("b")) {
+		t.Fatal("Expected b to get the key within the limit")
+	}
+	for _, taskID := range []string{"c", "d", "e"} {
+		if keys.acquire("customer-1", taskID, 2, admit(taskID)) {
+			t.Fatalf("Expected %s to wait for the key", taskID)
+		}
+	}
+
+	// Other keys are independent
+	if !keys.acquire("customer-2", "x", 1, admit("x")) {
+		t.Error("Expected x to get its own key")
+	}
+
+	stats := keys.stats("customer-1")
+	if !slices.Equal(stats.Running, []string{"a", "b"}) || !slices.Equal(stats.Waiting, []string{"c", "d", "e"}) {
+		t.Errorf("Expected a, b running and c, d, e waiting, got %+v", stats)
+	}
+
+	// A waiting task can give up its place
+	if !keys.cancel("customer-1", "d") {
+		t.Error("Expected d to be waiting")
+	}
+	if keys.cancel("customer-1", "a") {
+		t.Error("Expected cancel not to apply to a holder")
+	}
+
+	// Releasing hands the key on in arrival order
+	keys.release("customer-1", "a")
+	keys.release("customer-1", "b")
+	if !slices.Equal(admitted, []string{"c", "e"}) {
+		t.Errorf("Expected c then e to be admitted, got %v", admitted)
+	}
+
+	// Releasing a key the task does not hold does nothing
+	keys.release("customer-1", "a")
+
+	keys.release("customer-1", "c")
+	keys.release("customer-1", "e")
+	if keys.stats("customer-1") != nil {
+		t.Error("Expected the unused key to be forgotten")
+	}
+}
+
+func TestTaskManagerConcurrencyKey(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+	taskManager := NewTaskManager(registry, &mockPublisher{}, 5)
+
+	var taskIDs []string
+	for i := 0; i < 3; i++ {
+		task, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{
+			Type:           "sleep",
+			Input:          map[string]interface{}{"duration": 0.1},
+			ConcurrencyKey: "customer-1",
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if task.ConcurrencyLimit != 1 {
+			t.Errorf("Expected default concurrency limit 1, got %d", task.ConcurrencyLimit)
+		}
+		if err := taskManager.ExecuteTaskAsync(context.Background(), task.ID); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		taskIDs = append(taskIDs, task.ID)
+	}
+
+	// Only the first task runs although workers are free
+	stats, err := taskManager.GetConcurrencyKey("customer-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !slices.Equal(stats.Running, taskIDs[:1]) || !slices.Equal(stats.Waiting, taskIDs[1:]) {
+		t.Errorf("Expected %v running and %v waiting, got %+v", taskIDs[:1], taskIDs[1:], stats)
+	}
+	if keys := taskManager.ListConcurrencyKeys(); len(keys) != 1 {
+		t.Errorf("Expected 1 concurrency key, got %d", len(keys))
+	}
+
+	// The tasks run one after the other, in order
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := taskManager.GetConcurrencyKey("customer-1"); errors.Is(err, ErrConcurrencyKeyNotFound) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var previous *models.Task
+	for _, taskID := range taskIDs {
+		task, _ := taskManager.GetTask(taskID)
+		if task.Status != models.TaskStatusCompleted {
+			t.Fatalf("Expected task %s to be completed, got %s", taskID, task.Status)
+		}
+		if previous != nil && task.StartedAt.Before(*previous.CompletedAt) {
+			t.Errorf("Expected task %s to start after the previous one completed", taskID)
+		}
+		previous = task
+	}
+}
+
+func TestTaskManagerCancelTaskWaitingForKey(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+	taskManager := NewTaskManager(registry, &mockPublisher{}, 5)
+
+	var taskIDs []string
+	for i := 0; i < 2; i++ {
+		task, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{
+			Type:           "sleep",
+			Input:          map[string]interface{}{"duration": 0.2},
+			ConcurrencyKey: "customer-1",
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := taskManager.ExecuteTaskAsync(context.Background(), task.ID); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		taskIDs = append(taskIDs, task.ID)
+	}
+
+	if err := taskManager.CancelTask(taskIDs[1]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The cancelled task no longer waits for the key
+	time.Sleep(20 * time.Millisecond)
+	stats, err := taskManager.GetConcurrencyKey("customer-1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(stats.Waiting) != 0 {
+		t.Errorf("Expected no waiting tasks, got %v", stats.Waiting)
+	}
+
+	task, _ := taskManager.GetTask(taskIDs[1])
+	if task.Status != models.TaskStatusCancelled {
+		t.Errorf("Expected task to be cancelled, got %s", task.Status)
+	}
+}
+
+func TestTaskManagerConcurrencyKeyValidation(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+	taskManager := NewTaskManager(registry, &mockPublisher{}, 5)
+
+	requests := []*models.TaskRequest{
+		{Type: "echo", ConcurrencyKey: "customer-1", ConcurrencyLimit: -1},
+		{Type: "echo", ConcurrencyLimit: 2},
+	}
+	for _, req := range requests {
+		if _, err := taskManager.CreateTaskFromRequest(req); err == nil {
+			t.Errorf("Expected error for %+v", req)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package tasks
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// delayedTask is an entry of the delay queue
+type delayedTask struct {
+	taskID string
+	runAt  time.Time
+	index  int
+}
+
+// delayedHeap is a min-heap of delayed tasks ordered by due time
+type delayedHeap []*delayedTask
+
+func (h delayedHeap) Len() int           { return len(h) }
+func (h delayedHeap) Less(i, j int) bool { return h[i].runAt.Before(h[j].runAt) }
+
+func (h delayedHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *delayedHeap) Push(x interface{}) {
+	entry := x.(*delayedTask)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *delayedHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return entry
+}
+
+// delayQueue holds tasks waiting for their run time and hands each one to
+// the dispatch function once it is due. A single timer is armed for the
+// earliest entry.
+type delayQueue struct {
+	mu       sync.Mutex
+	heap     delayedHeap
+	entries  map[string]*delayedTask
+	timer    *time.Timer
+	dispatch func(taskID string)
+}
+
+// newDelayQueue creates a delay queue that calls dispatch for due tasks
+func newDelayQueue(dispatch func(taskID string)) *delayQueue {
+	return &delayQueue{
+		entries:  make(map[string]*delayedTask),
+		dispatch: dispatch,
+	}
+}
+
+// schedule adds the task to the queue, or moves it if it is already queued
+func (q *delayQueue) schedule(taskID string, runAt time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if entry, exists := q.entries[taskID]; exists {
+		entry.runAt = runAt
+		heap.Fix(&q.heap, entry.index)
+	} else {
+		entry := &delayedTask{taskID: taskID, runAt: runAt}
+		heap.Push(&q.heap, entry)
+		q.entries[taskID] = entry
+	}
+	q.arm()
+}
+
+// remove drops the task from the queue, if it is queued
+func (q *delayQueue) remove(taskID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entry, exists := q.entries[taskID]
+	if !exists {
+		return
+	}
+	heap.Remove(&q.heap, entry.index)
+	delete(q.entries, taskID)
+	q.arm()
+}
+
+// len returns the number of queued tasks
+func (q *delayQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.heap)
+}
+
+// arm sets the timer to the earliest due time. The caller must hold q.mu.
+func (q *delayQueue) arm() {
+	if len(q.heap) == 0 {
+		if q.timer != nil {
+			q.timer.Stop()
+		}
+		return
+	}
+
+	delay := time.Until(q.heap[0].runAt)
+	if q.timer == nil {
+		q.timer = time.AfterFunc(delay, q.fire)
+		return
+	}
+	q.timer.Reset(delay)
+}
+
+// fire pops every due task and dispatches it
+func (q *delayQueue) fire() {
+	q.mu.Lock()
+	now := time.Now()
+	var due []string
+	for len(q.heap) > 0 && !q.heap[0].runAt.After(now) {
+		entry := heap.Pop(&q.heap).(*delayedTask)
+		delete(q.entries, entry.taskID)
+		due = append(due, entry.taskID)
+	}
+	q.arm()
+	q.mu.Unlock()
+
+	for _, taskID := range due {
+		q.dispatch(taskID)
+	}
+}
@@ -0,0 +1,48 @@
+package tasks
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDelayQueueOrder(t *testing.T) {
+	var mu sync.Mutex
+	var dispatched []string
+
+	queue := newDelayQueue(func(taskID string) {
+		mu.Lock()
+		defer mu.Unlock()
+		dispatched = append(dispatched, taskID)
+	})
+
+	now := time.Now()
+	queue.schedule("third", now.Add(150*time.Millisecond))
+	queue.schedule("first", now.Add(50*time.Millisecond))
+	queue.schedule("second", now.Add(100*time.Millisecond))
+	queue.schedule("removed", now.Add(75*time.Millisecond))
+	queue.remove("removed")
+
+	// Moving a task replaces its entry
+	queue.schedule("moved", now.Add(time.Hour))
+	queue.schedule("moved", now.Add(125*time.Millisecond))
+
+	time.Sleep(300 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	expected := []string{"first", "second", "moved", "third"}
+	if len(dispatched) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, dispatched)
+	}
+	for i, taskID := range expected {
+		if dispatched[i] != taskID {
+			t.Errorf("Expected %v, got %v", expected, dispatched)
+			break
+		}
+	}
+	if queue.len() != 0 {
+		t.Errorf("Expected empty queue, got %d entries", queue.len())
+	}
+}
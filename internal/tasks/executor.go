@@ -2,10 +2,13 @@ package tasks
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log"
 	"sync"
 	"time"
 
+	"go-fred/internal/config"
 	"go-fred/internal/events"
 	"go-fred/internal/models"
 )
@@ -60,73 +63,407 @@ func (r *ExecutorRegistry) GetSupportedTypes() []string {
 	return types
 }
 
+var (
+	// ErrTaskCancelled is returned when an execution is aborted through CancelTask
+	ErrTaskCancelled = errors.New("task cancelled")
+	// ErrTaskTimedOut is returned when an execution exceeds its timeout
+	ErrTaskTimedOut = errors.New("task timed out")
+	// ErrTaskNotScheduled is returned when rescheduling a task that is not
+	// waiting for its run time
+	ErrTaskNotScheduled = errors.New("task is not scheduled")
+	// ErrInvalidRequest is matched by the errors of task requests that can
+	// never succeed as they are, such as an unknown task type or a negative
+	// timeout
+	ErrInvalidRequest = errors.New("invalid task request")
+)
+
+// invalidRequestError wraps a validation error so that it matches
+// ErrInvalidRequest while keeping its message
+type invalidRequestError struct {
+	err error
+}
+
+func (e *invalidRequestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *invalidRequestError) Unwrap() []error {
+	return []error{e.err, ErrInvalidRequest}
+}
+
+// Recovery policies for tasks found in running state on startup
+const (
+	RecoveryPolicyFail    = "fail"
+	RecoveryPolicyRequeue = "requeue"
+	RecoveryPolicyLeave   = "leave"
+)
+
+// execution tracks an in-flight run of a task, from the moment it is
+// submitted until the executor returns
+type execution struct {
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// run is an execution of a task on its way through the worker pool: it is
+// queued, run by a worker, and queued again after the backoff of each
+// retried attempt until it finishes
+type run struct {
+	ctx  context.Context
+	task *models.Task
+	// done receives the outcome of synchronous executions
+	done chan error
+	// started is closed once the first attempt has started or the execution
+	// has ended
+	started   chan struct{}
+	startOnce sync.Once
+}
+
+// markStarted reports the start of the execution to a waiting caller
+func (r *run) markStarted() {
+	if r.started != nil {
+		r.startOnce.Do(func() { close(r.started) })
+	}
+}
+
+// finish reports the outcome of the execution to a waiting caller
+func (r *run) finish(err error) {
+	r.markStarted()
+	if r.done != nil {
+		r.done <- err
+	}
+}
+
 // TaskManager manages task execution and storage
 type TaskManager struct {
-	registry     *ExecutorRegistry
-	eventPub     events.Publisher
-	tasks        map[string]*models.Task
-	mu           sync.RWMutex
+	registry      *ExecutorRegistry
+	eventPub      events.Publisher
+	store         TaskStore
+	executions    map[string]*execution
+	mu            sync.Mutex
 	maxConcurrent int
-	semaphore    chan struct{}
+	queues        map[string]*taskQueue
+	timeout       time.Duration
+	maxTimeout    time.Duration
+	retryPolicies map[string]*models.RetryPolicy
+	delayed       *delayQueue
+	concurrency   *concurrencyKeys
+	listeners     []func(task *models.Task)
+	waiters       *finishWaiters
+
+	idempotency          idempotencyStore
+	idempotencyRetention time.Duration
+	idempotencyLocks     *keyLocks
+
+	// closed is closed by Close to stop the background loops
+	closed    chan struct{}
+	closeOnce sync.Once
 }
 
-// NewTaskManager creates a new task manager
+// NewTaskManager creates a new task manager backed by an in-memory store
 func NewTaskManager(registry *ExecutorRegistry, eventPub events.Publisher, maxConcurrent int) *TaskManager {
-	return &TaskManager{
+	return NewTaskManagerWithStore(registry, eventPub, NewMemoryTaskStore(), maxConcurrent)
+}
+
+// NewTaskManagerWithStore creates a new task manager backed by the given store
+func NewTaskManagerWithStore(registry *ExecutorRegistry, eventPub events.Publisher, store TaskStore, maxConcurrent int) *TaskManager {
+	return NewTaskManagerWithConfig(registry, eventPub, store, &config.TasksConfig{MaxConcurrent: maxConcurrent})
+}
+
+// NewTaskManagerWithConfig creates a new task manager backed by the given
+// store and configured from the tasks configuration
+func NewTaskManagerWithConfig(registry *ExecutorRegistry, eventPub events.Publisher, store TaskStore, cfg *config.TasksConfig) *TaskManager {
+	tm := &TaskManager{
 		registry:      registry,
 		eventPub:      eventPub,
-		tasks:         make(map[string]*models.Task),
-		maxConcurrent: maxConcurrent,
-		semaphore:     make(chan struct{}, maxConcurrent),
+		store:         store,
+		executions:    make(map[string]*execution),
+		maxConcurrent: cfg.MaxConcurrent,
+		queues:        newTaskQueues(cfg),
+		timeout:       time.Duration(cfg.TimeoutSeconds) * time.Second,
+		maxTimeout:    time.Duration(cfg.MaxTimeoutSeconds) * time.Second,
+		retryPolicies: newRetryPolicies(cfg.Retry),
+		concurrency:   newConcurrencyKeys(),
+		waiters:       newFinishWaiters(),
+
+		idempotency:          newIdempotencyStore(store),
+		idempotencyRetention: time.Duration(cfg.IdempotencyRetentionSeconds) * time.Second,
+		idempotencyLocks:     newKeyLocks(),
+		closed:               make(chan struct{}),
 	}
+	if tm.idempotencyRetention <= 0 {
+		tm.idempotencyRetention = DefaultIdempotencyRetention
+	}
+	go tm.purgeIdempotencyKeys(min(tm.idempotencyRetention, idempotencyPurgeInterval))
+	tm.delayed = newDelayQueue(tm.dispatchScheduled)
+	return tm
 }
 
 // CreateTask creates a new task
 func (tm *TaskManager) CreateTask(taskType string, input map[string]interface{}, isAsync bool) (*models.Task, error) {
+	return tm.CreateTaskFromRequest(&models.TaskRequest{
+		Type:  taskType,
+		Input: input,
+		Async: isAsync,
+	})
+}
+
+// CreateTaskFromRequest creates a new task from an API request. A request
+// with an idempotency key that already created a task returns that task.
+func (tm *TaskManager) CreateTaskFromRequest(req *models.TaskRequest) (*models.Task, error) {
+	task, _, err := tm.CreateTaskIdempotent(req)
+	return task, err
+}
+
+// createTask validates the request and creates a new task
+func (tm *TaskManager) createTask(req *models.TaskRequest) (*models.Task, error) {
+	task, err := tm.newTask(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := tm.storeTask(task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// newTask validates the request and builds the task it asks for, without
+// storing it. Its errors match ErrInvalidRequest.
+func (tm *TaskManager) newTask(req *models.TaskRequest) (*models.Task, error) {
+	task, err := tm.buildTask(req)
+	if err != nil {
+		return nil, &invalidRequestError{err: err}
+	}
+	return task, nil
+}
+
+// buildTask validates the request and builds the task it asks for
+func (tm *TaskManager) buildTask(req *models.TaskRequest) (*models.Task, error) {
 	// Check if executor exists for this task type
-	_, err := tm.registry.GetExecutor(taskType)
+	_, err := tm.registry.GetExecutor(req.Type)
 	if err != nil {
 		return nil, err
 	}
 
-	task := models.NewTask(taskType, input, isAsync)
+	if req.Timeout < 0 {
+		return nil, fmt.Errorf("timeout must not be negative")
+	}
+	if req.Priority < 0 || req.Priority > models.MaxPriority {
+		return nil, fmt.Errorf("priority must be between 0 and %d", models.MaxPriority)
+	}
 
-	tm.mu.Lock()
-	tm.tasks[task.ID] = task
-	tm.mu.Unlock()
+	// A retry policy given with the request replaces the one of the task type
+	retry := tm.retryPolicies[req.Type]
+	if req.Retry != nil {
+		if err := validateRetryPolicy(req.Retry); err != nil {
+			return nil, err
+		}
+		retry = req.Retry
+	}
 
-	// Publish task created event
-	ctx := context.Background()
-	events.PublishTaskCreated(ctx, tm.eventPub, task.ID, taskType, isAsync)
+	runAt, err := resolveRunAt(req.RunAt, req.Delay)
+	if err != nil {
+		return nil, err
+	}
+
+	queue, err := tm.resolveQueue(req.Type, req.Queue)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.ConcurrencyLimit < 0 {
+		return nil, fmt.Errorf("concurrency_limit must not be negative")
+	}
+	if req.ConcurrencyLimit > 0 && req.ConcurrencyKey == "" {
+		return nil, fmt.Errorf("concurrency_limit requires a concurrency_key")
+	}
+
+	if err := models.ValidateLabels(req.Labels); err != nil {
+		return nil, err
+	}
+	if err := models.ValidateMetadata(req.Metadata); err != nil {
+		return nil, err
+	}
+	if req.CallbackURL != "" {
+		if err := models.ValidateWebhookURL(req.CallbackURL); err != nil {
+			return nil, err
+		}
+	}
+
+	task := models.NewTask(req.Type, req.Input, req.Async)
+	task.Queue = queue
+	task.Priority = req.Priority
+	task.IdempotencyKey = req.IdempotencyKey
+	task.Labels = req.Labels
+	task.Metadata = req.Metadata
+	task.CallbackURL = req.CallbackURL
+	if req.ConcurrencyKey != "" {
+		task.ConcurrencyKey = req.ConcurrencyKey
+		task.ConcurrencyLimit = max(req.ConcurrencyLimit, 1)
+	}
+	task.Timeout = int(tm.resolveTimeout(time.Duration(req.Timeout) * time.Second).Seconds())
+	if retry != nil {
+		policy := *retry
+		task.Retry = &policy
+	}
+	if runAt != nil {
+		task.Schedule(*runAt)
+	}
 
 	return task, nil
 }
 
-// GetTask retrieves a task by ID
-func (tm *TaskManager) GetTask(taskID string) (*models.Task, error) {
-	tm.mu.RLock()
-	defer tm.mu.RUnlock()
+// storeTask stores a task built by newTask and announces it
+func (tm *TaskManager) storeTask(task *models.Task) error {
+	ctx := eventContext(context.Background(), task)
+	scheduled := task.Status == models.TaskStatusScheduled && task.RunAt != nil
 
-	task, exists := tm.tasks[taskID]
-	if !exists {
-		return nil, fmt.Errorf("task not found: %s", taskID)
+	var created changeEvents
+	events.PublishTaskCreated(ctx, &created, task.ID, task.Type, task.IsAsync)
+	if scheduled {
+		events.PublishTaskScheduled(ctx, &created, task.ID, *task.RunAt)
 	}
-	return task, nil
+	if err := tm.insertTask(ctx, task, created); err != nil {
+		return fmt.Errorf("failed to store task: %w", err)
+	}
+
+	// Hand postponed tasks to the delay queue
+	if scheduled {
+		tm.delayed.schedule(task.ID, *task.RunAt)
+	}
+
+	return nil
+}
+
+// resolveRunAt returns the time a postponed task is due, or nil if it is not
+// postponed
+func resolveRunAt(runAt *time.Time, delay int) (*time.Time, error) {
+	if delay < 0 {
+		return nil, fmt.Errorf("delay must not be negative")
+	}
+	if runAt != nil && delay > 0 {
+		return nil, fmt.Errorf("run_at and delay are mutually exclusive")
+	}
+	if delay > 0 {
+		at := time.Now().Add(time.Duration(delay) * time.Second)
+		return &at, nil
+	}
+	return runAt, nil
+}
+
+// RescheduleTask moves a scheduled task to a new run time
+func (tm *TaskManager) RescheduleTask(taskID string, req *models.RescheduleRequest) (*models.Task, error) {
+	runAt, err := resolveRunAt(req.RunAt, req.Delay)
+	if err != nil {
+		return nil, err
+	}
+	if runAt == nil {
+		return nil, fmt.Errorf("run_at or delay is required")
+	}
+
+	for {
+		task, err := tm.GetTask(taskID)
+		if err != nil {
+			return nil, err
+		}
+
+		if task.Status != models.TaskStatusScheduled {
+			return nil, fmt.Errorf("%w: %s", ErrTaskNotScheduled, taskID)
+		}
+
+		task.Schedule(*runAt)
+		ctx := eventContext(context.Background(), task)
+		var rescheduled changeEvents
+		events.PublishTaskScheduled(ctx, &rescheduled, taskID, *runAt)
+		if err := tm.updateTask(ctx, task, rescheduled); err != nil {
+			// The task was dispatched or changed in the meantime; look again
+			if errors.Is(err, ErrVersionConflict) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to reschedule task: %w", err)
+		}
+		tm.delayed.schedule(taskID, *runAt)
+
+		return task, nil
+	}
+}
+
+// dispatchScheduled executes a scheduled task once it is due. Tasks that
+// were cancelled, executed or moved to a later time in the meantime are left
+// alone.
+func (tm *TaskManager) dispatchScheduled(taskID string) {
+	for {
+		task, err := tm.GetTask(taskID)
+		if err != nil {
+			log.Printf("Failed to dispatch scheduled task %s: %v", taskID, err)
+			return
+		}
+
+		if task.Status != models.TaskStatusScheduled || (task.RunAt != nil && task.RunAt.After(time.Now())) {
+			return
+		}
+
+		task.Release()
+		if err := tm.store.Update(task); err != nil {
+			if errors.Is(err, ErrVersionConflict) {
+				continue
+			}
+			log.Printf("Failed to dispatch scheduled task %s: %v", taskID, err)
+			return
+		}
+
+		// The task was accepted long ago, so it is not held to the queue depth
+		if err := tm.executeAsync(taskID, false, false); err != nil {
+			log.Printf("Failed to execute scheduled task %s: %v", taskID, err)
+		}
+		return
+	}
+}
+
+// resolveTimeout returns the timeout a task runs with: the requested one or
+// the configured default, capped by the configured maximum. Zero means no
+// timeout.
+func (tm *TaskManager) resolveTimeout(requested time.Duration) time.Duration {
+	timeout := requested
+	if timeout == 0 {
+		timeout = tm.timeout
+	}
+	if tm.maxTimeout > 0 && (timeout == 0 || timeout > tm.maxTimeout) {
+		timeout = tm.maxTimeout
+	}
+	return timeout
+}
+
+// ValidateTaskType returns an error if no executor handles the task type
+func (tm *TaskManager) ValidateTaskType(taskType string) error {
+	_, err := tm.registry.GetExecutor(taskType)
+	return err
+}
+
+// GetTask retrieves a task by ID
+func (tm *TaskManager) GetTask(taskID string) (*models.Task, error) {
+	return tm.store.Get(taskID)
 }
 
 // ListTasks returns all tasks
 func (tm *TaskManager) ListTasks() []*models.Task {
-	tm.mu.RLock()
-	defer tm.mu.RUnlock()
-
-	tasks := make([]*models.Task, 0, len(tm.tasks))
-	for _, task := range tm.tasks {
-		tasks = append(tasks, task)
+	tasks, err := tm.store.List()
+	if err != nil {
+		log.Printf("Failed to list tasks: %v", err)
+		return []*models.Task{}
 	}
 	return tasks
 }
 
-// ExecuteTask executes a task synchronously
+// QueryTasks returns a page of the tasks matching the query. Queries that
+// cannot be run fail with ErrInvalidQuery or ErrInvalidCursor.
+func (tm *TaskManager) QueryTasks(query *models.TaskQuery) (*TaskPage, error) {
+	return tm.store.Query(query)
+}
+
+// ExecuteTask executes a task synchronously. The task waits for a free
+// worker of its queue like any other; ErrQueueFull is returned when the
+// queue is full and ErrQueueDraining when it is being drained.
 func (tm *TaskManager) ExecuteTask(ctx context.Context, taskID string) error {
 	task, err := tm.GetTask(taskID)
 	if err != nil {
@@ -137,19 +474,37 @@ func (tm *TaskManager) ExecuteTask(ctx context.Context, taskID string) error {
 		return fmt.Errorf("task %s is already finished", taskID)
 	}
 
-	// Acquire semaphore
-	select {
-	case tm.semaphore <- struct{}{}:
-		defer func() { <-tm.semaphore }()
-	case <-ctx.Done():
-		return ctx.Err()
+	r := &run{task: task, done: make(chan error, 1)}
+	if _, err := tm.startExecution(ctx, r, true); err != nil {
+		return err
 	}
 
-	return tm.executeTaskInternal(ctx, task)
+	return <-r.done
 }
 
-// ExecuteTaskAsync executes a task asynchronously
+// ExecuteTaskAsync executes a task asynchronously. ErrQueueFull is returned
+// when the queue is full and ErrQueueDraining when it is being drained.
 func (tm *TaskManager) ExecuteTaskAsync(ctx context.Context, taskID string) error {
+	return tm.executeAsync(taskID, true, false)
+}
+
+// SubmitTaskAsync executes a task asynchronously like ExecuteTaskAsync, but
+// without holding it to the queue depth or turning it away from a draining
+// queue, for tasks accepted earlier such as the runs of a schedule
+func (tm *TaskManager) SubmitTaskAsync(ctx context.Context, taskID string) error {
+	return tm.executeAsync(taskID, false, false)
+}
+
+// StartTaskAsync executes a task asynchronously like ExecuteTaskAsync, but
+// returns once a free worker has started it. A task left waiting for a worker
+// or its concurrency key is returned right away.
+func (tm *TaskManager) StartTaskAsync(ctx context.Context, taskID string) error {
+	return tm.executeAsync(taskID, true, true)
+}
+
+// executeAsync queues a task for asynchronous execution, optionally holding
+// it to the queue depth limit and waiting for a free worker to start it
+func (tm *TaskManager) executeAsync(taskID string, bounded, waitStart bool) error {
 	task, err := tm.GetTask(taskID)
 	if err != nil {
 		return err
@@ -159,69 +514,569 @@ func (tm *TaskManager) ExecuteTaskAsync(ctx context.Context, taskID string) erro
 		return fmt.Errorf("task %s is already finished", taskID)
 	}
 
-	// Start execution in background
-	go func() {
-		// Acquire semaphore
-		tm.semaphore <- struct{}{}
-		defer func() { <-tm.semaphore }()
-
-		// Create new context for background execution
-		bgCtx := context.Background()
-		tm.executeTaskInternal(bgCtx, task)
-	}()
+	// Detach from the caller's context so the task outlives the request;
+	// it can still be aborted through CancelTask
+	r := &run{task: task}
+	if waitStart {
+		r.started = make(chan struct{})
+	}
+	dispatched, err := tm.startExecution(context.Background(), r, bounded)
+	if err != nil {
+		return err
+	}
 
+	if waitStart && dispatched {
+		<-r.started
+	}
 	return nil
 }
 
-// executeTaskInternal performs the actual task execution
-func (tm *TaskManager) executeTaskInternal(ctx context.Context, task *models.Task) error {
+// startExecution registers an execution of the task and queues its first
+// attempt. It reports whether the attempt went straight to a free worker.
+func (tm *TaskManager) startExecution(ctx context.Context, r *run, bounded bool) (bool, error) {
+	execCtx, err := tm.beginExecution(ctx, r.task.ID)
+	if err != nil {
+		return false, err
+	}
+
+	r.ctx = execCtx
+	dispatched, err := tm.submit(r, bounded)
+	if err != nil {
+		tm.endExecution(r.task.ID)
+		return false, err
+	}
+	return dispatched, nil
+}
+
+// beginExecution registers an in-flight execution of the task and returns
+// the context it must run with
+func (tm *TaskManager) beginExecution(ctx context.Context, taskID string) (context.Context, error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if _, exists := tm.executions[taskID]; exists {
+		return nil, fmt.Errorf("task %s is already running", taskID)
+	}
+
+	execCtx, cancel := context.WithCancel(ctx)
+	tm.executions[taskID] = &execution{cancel: cancel}
+	return execCtx, nil
+}
+
+// endExecution unregisters the execution of the task and reports whether it
+// was aborted through CancelTask
+func (tm *TaskManager) endExecution(taskID string) bool {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	exec, exists := tm.executions[taskID]
+	if !exists {
+		return false
+	}
+	delete(tm.executions, taskID)
+	exec.cancel()
+	return exec.cancelled
+}
+
+// abortExecution cancels the in-flight execution of the task, if any
+func (tm *TaskManager) abortExecution(taskID string) bool {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	exec, exists := tm.executions[taskID]
+	if !exists {
+		return false
+	}
+	exec.cancelled = true
+	exec.cancel()
+	return true
+}
+
+// submit queues the next attempt of the execution once the task holds its
+// concurrency key. If the context is done while the attempt still waits for
+// the key, the execution is abandoned. It reports whether the attempt went
+// straight to a free worker.
+func (tm *TaskManager) submit(r *run, bounded bool) (bool, error) {
+	key := r.task.ConcurrencyKey
+	if key == "" {
+		return tm.enqueue(r, bounded)
+	}
+
+	admitted := tm.concurrency.acquire(key, r.task.ID, r.task.ConcurrencyLimit, func() {
+		// The task already waited its turn, so it is not held to the queue
+		// depth
+		if _, err := tm.enqueue(r, false); err != nil {
+			log.Printf("Failed to queue task %s: %v", r.task.ID, err)
+			tm.releaseKey(r)
+			tm.endExecution(r.task.ID)
+			r.finish(err)
+		}
+	})
+	if !admitted {
+		context.AfterFunc(r.ctx, func() {
+			if tm.concurrency.cancel(key, r.task.ID) {
+				r.finish(tm.abandonExecution(r.ctx, r.task))
+			}
+		})
+		return false, nil
+	}
+
+	dispatched, err := tm.enqueue(r, bounded)
+	if err != nil {
+		tm.releaseKey(r)
+		return false, err
+	}
+	return dispatched, nil
+}
+
+// enqueue hands the next attempt of the execution to the worker pool of its
+// queue. If the context is done while the attempt still waits for a worker,
+// the execution is abandoned. It reports whether the attempt went straight
+// to a free worker.
+func (tm *TaskManager) enqueue(r *run, bounded bool) (bool, error) {
+	pool := tm.queueFor(r.task).pool
+
+	j := &job{priority: r.task.Priority}
+	j.run = func() { tm.runAttempt(r) }
+	if err := pool.submit(j, bounded); err != nil {
+		return false, err
+	}
+	if j.dispatched {
+		return true, nil
+	}
+
+	context.AfterFunc(r.ctx, func() {
+		if pool.remove(j) {
+			tm.releaseKey(r)
+			r.finish(tm.abandonExecution(r.ctx, r.task))
+		}
+	})
+	return false, nil
+}
+
+// runAttempt runs one attempt of the execution on a worker and queues the
+// next one if the attempt is to be retried
+func (tm *TaskManager) runAttempt(r *run) {
+	// Stop here if the execution was aborted, even when a worker picked the
+	// attempt up at the same time
+	if r.ctx.Err() != nil {
+		tm.releaseKey(r)
+		r.finish(tm.abandonExecution(r.ctx, r.task))
+		return
+	}
+
+	// The key is given up between attempts, so a retried task waits for it
+	// again behind the others
+	delay, retry, err := tm.executeTaskInternal(r)
+	tm.releaseKey(r)
+	if !retry {
+		r.finish(err)
+		return
+	}
+	tm.retryAfter(r, delay)
+}
+
+// retryAfter queues the next attempt of the execution once the backoff delay
+// has passed, without holding a worker in the meantime
+func (tm *TaskManager) retryAfter(r *run, delay time.Duration) {
+	timer := time.AfterFunc(delay, func() {
+		// The task was already accepted, so it is not held to the queue depth
+		if _, err := tm.submit(r, false); err != nil {
+			log.Printf("Failed to queue retry of task %s: %v", r.task.ID, err)
+			r.finish(tm.abortRetry(r, err))
+		}
+	})
+
+	context.AfterFunc(r.ctx, func() {
+		if timer.Stop() {
+			r.finish(tm.abandonExecution(r.ctx, r.task))
+		}
+	})
+}
+
+// abortRetry ends an execution whose next attempt could not be queued. A
+// task left behind by a closed task manager stays retrying, for RecoverTasks
+// to pick up on restart; any other task fails.
+func (tm *TaskManager) abortRetry(r *run, err error) error {
+	if tm.endExecution(r.task.ID) {
+		return ErrTaskCancelled
+	}
+	if errors.Is(err, ErrPoolClosed) {
+		return err
+	}
+
+	task := r.task
+	task.Fail(err)
+	eventCtx := eventContext(context.WithoutCancel(r.ctx), task)
+	var failed changeEvents
+	events.PublishTaskFailed(eventCtx, &failed, task.ID, taskDuration(task), err)
+	if updateErr := tm.updateTask(eventCtx, task, failed); updateErr != nil {
+		return fmt.Errorf("failed to store task result: %w", updateErr)
+	}
+	tm.notifyFinished(task)
+	return err
+}
+
+// Close stops the workers once their current attempt is done. Tasks still
+// waiting in the queues are left as they are.
+func (tm *TaskManager) Close() {
+	for _, queue := range tm.queues {
+		queue.pool.close()
+	}
+	tm.closeOnce.Do(func() { close(tm.closed) })
+}
+
+// abandonExecution ends an execution whose context was done before its next
+// attempt could start
+func (tm *TaskManager) abandonExecution(ctx context.Context, task *models.Task) error {
+	if tm.endExecution(task.ID) {
+		return ErrTaskCancelled
+	}
+	if task.Status != models.TaskStatusRetrying {
+		return ctx.Err()
+	}
+
+	// The caller gave up while the task was waiting to be retried
+	task.Fail(ctx.Err())
+	eventCtx := eventContext(context.WithoutCancel(ctx), task)
+	var failed changeEvents
+	events.PublishTaskFailed(eventCtx, &failed, task.ID, taskDuration(task), ctx.Err())
+	if err := tm.updateTask(eventCtx, task, failed); err != nil {
+		return fmt.Errorf("failed to store task result: %w", err)
+	}
+	tm.notifyFinished(task)
+	return ctx.Err()
+}
+
+// executeTaskInternal performs a single execution attempt. It reports
+// whether the attempt failed and is to be retried, and after which delay.
+func (tm *TaskManager) executeTaskInternal(r *run) (time.Duration, bool, error) {
+	ctx, task := r.ctx, r.task
 	startTime := time.Now()
 
+	// Events must still go out once the execution context is cancelled
+	eventCtx := eventContext(context.WithoutCancel(ctx), task)
+
 	// Mark task as started
 	task.Start()
-	events.PublishTaskStarted(ctx, tm.eventPub, task.ID)
+	var started changeEvents
+	events.PublishTaskStarted(eventCtx, &started, task.ID)
+	if err := tm.updateTask(eventCtx, task, started); err != nil {
+		if tm.endExecution(task.ID) {
+			return 0, false, ErrTaskCancelled
+		}
+		return 0, false, fmt.Errorf("failed to start task: %w", err)
+	}
+	r.markStarted()
 
-	// Get executor for task type
-	executor, err := tm.registry.GetExecutor(task.Type)
-	if err != nil {
-		task.Fail(err)
-		events.PublishTaskFailed(ctx, tm.eventPub, task.ID, time.Since(startTime), err)
-		return err
+	// Bound the run by the task timeout
+	runCtx := ctx
+	timeout := tm.resolveTimeout(time.Duration(task.Timeout) * time.Second)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeoutCause(ctx, timeout, ErrTaskTimedOut)
+		defer cancel()
 	}
 
-	// Execute the task
-	err = executor.Execute(ctx, task)
+	// Get executor for task type and run the task
+	executor, err := tm.registry.GetExecutor(task.Type)
+	if err == nil {
+		err = runExecutor(runCtx, executor, task)
+	}
 
 	duration := time.Since(startTime)
+	timedOut := err != nil && errors.Is(context.Cause(runCtx), ErrTaskTimedOut)
+
+	// Schedule another attempt unless the execution was aborted
+	if err != nil && !timedOut && ctx.Err() == nil && shouldRetry(task.Retry, task.Attempt(), err) {
+		delay := retryDelay(task.Retry, task.Attempt())
+		task.ScheduleRetry(err, time.Now().Add(delay))
+		var retrying changeEvents
+		events.PublishTaskRetryScheduled(eventCtx, &retrying, task.ID, task.Attempt(), delay, err)
+		if updateErr := tm.updateTask(eventCtx, task, retrying); updateErr != nil {
+			if tm.endExecution(task.ID) {
+				return 0, false, ErrTaskCancelled
+			}
+			return 0, false, fmt.Errorf("failed to store task result: %w", updateErr)
+		}
+		return delay, true, err
+	}
+
+	// CancelTask has already recorded the cancellation, whatever the
+	// executor returned
+	if tm.endExecution(task.ID) {
+		return 0, false, ErrTaskCancelled
+	}
+
+	if timedOut {
+		task.TimeOut(timeout)
+		var timedOut changeEvents
+		events.PublishTaskTimedOut(eventCtx, &timedOut, task.ID, duration, timeout)
+		if updateErr := tm.updateTask(eventCtx, task, timedOut); updateErr != nil {
+			return 0, false, fmt.Errorf("failed to store task result: %w", updateErr)
+		}
+		tm.notifyFinished(task)
+		return 0, false, ErrTaskTimedOut
+	}
 
 	if err != nil {
 		task.Fail(err)
-		events.PublishTaskFailed(ctx, tm.eventPub, task.ID, duration, err)
-		return err
+		var failed changeEvents
+		events.PublishTaskFailed(eventCtx, &failed, task.ID, duration, err)
+		if updateErr := tm.updateTask(eventCtx, task, failed); updateErr != nil {
+			return 0, false, fmt.Errorf("failed to store task result: %w", updateErr)
+		}
+		tm.notifyFinished(task)
+		return 0, false, err
 	}
 
 	// Task completed successfully
 	task.Complete(task.Output)
-	events.PublishTaskCompleted(ctx, tm.eventPub, task.ID, duration, task.Output)
+	var completed changeEvents
+	events.PublishTaskCompleted(eventCtx, &completed, task.ID, duration, task.Output)
+	if err := tm.updateTask(eventCtx, task, completed); err != nil {
+		return 0, false, fmt.Errorf("failed to store task result: %w", err)
+	}
+	tm.notifyFinished(task)
 
-	return nil
+	return 0, false, nil
 }
 
-// CancelTask cancels a running task
-func (tm *TaskManager) CancelTask(taskID string) error {
-	task, err := tm.GetTask(taskID)
-	if err != nil {
+// runExecutor runs the executor and stops waiting for it once the context is
+// done, so an executor that ignores its context cannot outlive its deadline.
+// The executor works on a copy of the task whose output is only taken over
+// when it returns in time.
+func runExecutor(ctx context.Context, executor TaskExecutor, task *models.Task) error {
+	run := task.Clone()
+	done := make(chan error, 1)
+	go func() {
+		done <- executor.Execute(ctx, run)
+	}()
+
+	select {
+	case err := <-done:
+		task.Output = run.Output
 		return err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	if task.IsFinished() {
-		return fmt.Errorf("task %s is already finished", taskID)
+// CancelTask cancels a pending or running task. An in-flight execution is
+// aborted through its context and its outcome is discarded, so the task
+// stays cancelled.
+func (tm *TaskManager) CancelTask(taskID string) error {
+	for {
+		task, err := tm.GetTask(taskID)
+		if err != nil {
+			return err
+		}
+
+		if task.IsFinished() {
+			return fmt.Errorf("task %s is already finished", taskID)
+		}
+
+		tm.abortExecution(taskID)
+
+		task.Cancel()
+		ctx := eventContext(context.Background(), task)
+		var cancelled changeEvents
+		events.PublishTaskCancelled(ctx, &cancelled, taskID)
+		if err := tm.updateTask(ctx, task, cancelled); err != nil {
+			// The execution moved the task on in the meantime; look again
+			if errors.Is(err, ErrVersionConflict) {
+				continue
+			}
+			return fmt.Errorf("failed to cancel task: %w", err)
+		}
+		tm.delayed.remove(taskID)
+		tm.notifyFinished(task)
+
+		return nil
+	}
+}
+
+// CancelTasks cancels every unfinished task matching the label selector and
+// returns the IDs of the tasks it cancelled. Tasks that finish in the
+// meantime are left out.
+func (tm *TaskManager) CancelTasks(selector models.LabelSelector) ([]string, error) {
+	query := &models.TaskQuery{
+		Statuses: []models.TaskStatus{models.TaskStatusPending, models.TaskStatusScheduled, models.TaskStatusRunning, models.TaskStatusRetrying},
+		Labels:   selector,
+		Limit:    MaxQueryLimit,
+	}
+
+	// Collect the tasks first, as cancelling moves them between the status
+	// partitions being paged through
+	var taskIDs []string
+	for {
+		page, err := tm.store.Query(query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tasks: %w", err)
+		}
+		for _, task := range page.Tasks {
+			taskIDs = append(taskIDs, task.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+
+	cancelled := []string{}
+	for _, taskID := range taskIDs {
+		if err := tm.CancelTask(taskID); err != nil {
+			if task, getErr := tm.GetTask(taskID); getErr != nil || task.IsFinished() {
+				continue
+			}
+			return cancelled, err
+		}
+		cancelled = append(cancelled, taskID)
+	}
+	return cancelled, nil
+}
+
+// OnTaskFinished registers a function that is called whenever a task reaches
+// a finished state. Listeners run on their own goroutine with a copy of the
+// task, so they may call back into the task manager.
+func (tm *TaskManager) OnTaskFinished(listener func(task *models.Task)) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.listeners = append(tm.listeners, listener)
+}
+
+// notifyFinished wakes the callers waiting for the task and hands it to the
+// registered listeners
+func (tm *TaskManager) notifyFinished(task *models.Task) {
+	tm.waiters.wake(task.ID)
+
+	tm.mu.Lock()
+	listeners := tm.listeners
+	tm.mu.Unlock()
+
+	for _, listener := range listeners {
+		go listener(task.Clone())
+	}
+}
+
+// taskDuration returns the recorded duration of a finished task
+func taskDuration(task *models.Task) time.Duration {
+	if task.Duration == nil {
+		return 0
+	}
+	return *task.Duration
+}
+
+// RecoverTasks handles tasks left running or waiting for a retry by a
+// previous process, typically after a restart with a durable store. The
+// policy decides whether they are marked failed, re-queued for asynchronous
+// execution or left alone. Scheduled tasks are handed back to the delay
+// queue whatever the policy; those that became due in the meantime run
+// right away.
+func (tm *TaskManager) RecoverTasks(policy string) error {
+	if policy == "" {
+		policy = RecoveryPolicyFail
 	}
 
-	task.Cancel()
+	switch policy {
+	case RecoveryPolicyFail, RecoveryPolicyRequeue, RecoveryPolicyLeave:
+	default:
+		return fmt.Errorf("unsupported recovery policy: %s", policy)
+	}
+
+	tasks, err := tm.store.List()
+	if err != nil {
+		return fmt.Errorf("failed to list tasks: %w", err)
+	}
 
 	ctx := context.Background()
-	events.PublishTaskCancelled(ctx, tm.eventPub, taskID)
+	for _, task := range tasks {
+		if task.Status == models.TaskStatusScheduled && task.RunAt != nil {
+			tm.delayed.schedule(task.ID, *task.RunAt)
+			continue
+		}
+
+		if policy == RecoveryPolicyLeave {
+			continue
+		}
+		if task.Status != models.TaskStatusRunning && task.Status != models.TaskStatusRetrying {
+			continue
+		}
+
+		switch policy {
+		case RecoveryPolicyFail:
+			interruptErr := errors.New("task interrupted by server restart")
+			task.Fail(interruptErr)
+			eventCtx := eventContext(ctx, task)
+			var failed changeEvents
+			events.PublishTaskFailed(eventCtx, &failed, task.ID, taskDuration(task), interruptErr)
+			if err := tm.updateTask(eventCtx, task, failed); err != nil {
+				return fmt.Errorf("failed to recover task %s: %w", task.ID, err)
+			}
+			tm.notifyFinished(task)
+		case RecoveryPolicyRequeue:
+			task.Requeue()
+			if err := tm.store.Update(task); err != nil {
+				return fmt.Errorf("failed to recover task %s: %w", task.ID, err)
+			}
+			if err := tm.executeAsync(task.ID, false, false); err != nil {
+				return fmt.Errorf("failed to requeue task %s: %w", task.ID, err)
+			}
+		}
+
+		log.Printf("Recovered interrupted task %s (policy: %s)", task.ID, policy)
+	}
+
+	return nil
+}
+
+// eventContext returns a context adding the labels and type of the task to
+// the events published with it
+func eventContext(ctx context.Context, task *models.Task) context.Context {
+	return events.ContextWithTaskType(events.ContextWithLabels(ctx, task.Labels), task.Type)
+}
 
+// changeEvents is a publisher collecting the events describing a task change,
+// so that they are stored with the change and published once it is
+type changeEvents []events.Event
+
+func (c *changeEvents) Publish(ctx context.Context, event events.Event) error {
+	*c = append(*c, event)
+	return nil
+}
+
+func (c *changeEvents) Close() error {
+	return nil
+}
+
+// insertTask stores a new task with the events announcing it, then publishes
+// the events
+func (tm *TaskManager) insertTask(ctx context.Context, task *models.Task, evts changeEvents) error {
+	if err := tm.store.Create(task, evts...); err != nil {
+		return err
+	}
+	tm.publishChange(ctx, evts)
 	return nil
 }
+
+// updateTask stores a task change with the events describing it, then
+// publishes the events
+func (tm *TaskManager) updateTask(ctx context.Context, task *models.Task, evts changeEvents) error {
+	if err := tm.store.Update(task, evts...); err != nil {
+		return err
+	}
+	tm.publishChange(ctx, evts)
+	return nil
+}
+
+// publishChange publishes the events of a stored task change. Events the
+// store recorded with the change are published as stored, so that the outbox
+// only relays them.
+func (tm *TaskManager) publishChange(ctx context.Context, evts changeEvents) {
+	if store, ok := tm.store.(*BoltTaskStore); ok && store.recordsEvents() {
+		ctx = events.ContextWithStored(ctx)
+	}
+	for _, event := range evts {
+		tm.eventPub.Publish(ctx, event)
+	}
+}
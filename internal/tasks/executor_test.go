@@ -3,6 +3,7 @@ package tasks
 import (
 	"context"
 	"errors"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,9 +14,12 @@ import (
 // mockPublisher is a mock event publisher for testing
 type mockPublisher struct {
 	events []events.Event
+	mu     sync.Mutex
 }
 
 func (m *mockPublisher) Publish(ctx context.Context, event events.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.events = append(m.events, event)
 	return nil
 }
@@ -25,10 +29,14 @@ func (m *mockPublisher) Close() error {
 }
 
 func (m *mockPublisher) GetEvents() []events.Event {
-	return m.events
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]events.Event(nil), m.events...)
 }
 
 func (m *mockPublisher) ClearEvents() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.events = nil
 }
 
@@ -14,7 +14,11 @@ type EchoExecutor struct{}
 // Execute implements the TaskExecutor interface
 func (e *EchoExecutor) Execute(ctx context.Context, task *models.Task) error {
 	// Simulate some work
-	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(100 * time.Millisecond):
+	}
 
 	// Echo the input as output
 	task.Output = map[string]interface{}{
@@ -36,12 +40,12 @@ type SleepExecutor struct{}
 // Execute implements the TaskExecutor interface
 func (s *SleepExecutor) Execute(ctx context.Context, task *models.Task) error {
 	// Get sleep duration from input
-	duration, ok := task.Input["duration"].(float64)
+	duration, ok := toFloat64(task.Input["duration"])
 	if !ok {
-		return fmt.Errorf("duration must be a number")
+		return NonRetryable(fmt.Errorf("duration must be a number"))
 	}
 
-	sleepDuration := time.Duration(duration) * time.Second
+	sleepDuration := time.Duration(duration * float64(time.Second))
 
 	// Check for context cancellation
 	select {
@@ -91,17 +95,17 @@ func (m *MathExecutor) Execute(ctx context.Context, task *models.Task) error {
 	// Get operation and operands from input
 	operation, ok := task.Input["operation"].(string)
 	if !ok {
-		return fmt.Errorf("operation must be a string")
+		return NonRetryable(fmt.Errorf("operation must be a string"))
 	}
 
-	a, ok := task.Input["a"].(float64)
+	a, ok := toFloat64(task.Input["a"])
 	if !ok {
-		return fmt.Errorf("a must be a number")
+		return NonRetryable(fmt.Errorf("a must be a number"))
 	}
 
-	b, ok := task.Input["b"].(float64)
+	b, ok := toFloat64(task.Input["b"])
 	if !ok {
-		return fmt.Errorf("b must be a number")
+		return NonRetryable(fmt.Errorf("b must be a number"))
 	}
 
 	var result float64
@@ -115,11 +119,11 @@ func (m *MathExecutor) Execute(ctx context.Context, task *models.Task) error {
 		result = a * b
 	case "divide":
 		if b == 0 {
-			return fmt.Errorf("division by zero")
+			return NonRetryable(fmt.Errorf("division by zero"))
 		}
 		result = a / b
 	default:
-		return fmt.Errorf("unsupported operation: %s", operation)
+		return NonRetryable(fmt.Errorf("unsupported operation: %s", operation))
 	}
 
 	task.Output = map[string]interface{}{
@@ -137,6 +141,25 @@ func (m *MathExecutor) GetSupportedTypes() []string {
 	return []string{"math"}
 }
 
+// toFloat64 converts a numeric input value to float64. JSON-decoded input
+// always carries float64, but tasks created in-process may use Go integers.
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
 // RegisterDefaultExecutors registers the default task executors
 func RegisterDefaultExecutors(registry *ExecutorRegistry) {
 	registry.Register("echo", &EchoExecutor{})
@@ -0,0 +1,321 @@
+package tasks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go-fred/internal/models"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrIdempotencyKeyReused is returned when an idempotency key is used again
+// with a different request
+var ErrIdempotencyKeyReused = errors.New("idempotency key reused with a different request")
+
+// DefaultIdempotencyRetention is how long an idempotency key is remembered
+// unless configured otherwise
+const DefaultIdempotencyRetention = 24 * time.Hour
+
+// idempotencyPurgeInterval is how often expired idempotency keys are dropped,
+// unless the retention is shorter
+const idempotencyPurgeInterval = time.Hour
+
+var idempotencyBucket = []byte("idempotency_keys")
+
+// idempotencyRecord remembers the task created for an idempotency key
+type idempotencyRecord struct {
+	Key         string    `json:"key"`
+	TaskID      string    `json:"task_id"`
+	Fingerprint string    `json:"fingerprint"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// idempotencyStore keeps idempotency records until they expire
+type idempotencyStore interface {
+	// get returns the record of a key, or nil if there is none or it expired
+	get(key string, now time.Time) (*idempotencyRecord, error)
+	put(record *idempotencyRecord) error
+	delete(key string) error
+	// purge drops the records that expired
+	purge(now time.Time) error
+}
+
+// newIdempotencyStore keeps idempotency records next to the tasks, so that
+// they are durable whenever tasks are
+func newIdempotencyStore(taskStore TaskStore) idempotencyStore {
+	if boltStore, ok := taskStore.(*BoltTaskStore); ok {
+		store, err := newBoltIdempotencyStore(boltStore.DB())
+		if err == nil {
+			return store
+		}
+		log.Printf("Keeping idempotency keys in memory: %v", err)
+	}
+	return &memoryIdempotencyStore{records: make(map[string]*idempotencyRecord)}
+}
+
+// memoryIdempotencyStore keeps idempotency records in process memory
+type memoryIdempotencyStore struct {
+	records map[string]*idempotencyRecord
+	mu      sync.Mutex
+}
+
+func (s *memoryIdempotencyStore) get(key string, now time.Time) (*idempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, exists := s.records[key]
+	if !exists || !now.Before(record.ExpiresAt) {
+		return nil, nil
+	}
+	copied := *record
+	return &copied, nil
+}
+
+func (s *memoryIdempotencyStore) put(record *idempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copied := *record
+	s.records[record.Key] = &copied
+	return nil
+}
+
+func (s *memoryIdempotencyStore) delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+	return nil
+}
+
+func (s *memoryIdempotencyStore) purge(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, record := range s.records {
+		if !now.Before(record.ExpiresAt) {
+			delete(s.records, key)
+		}
+	}
+	return nil
+}
+
+// boltIdempotencyStore persists idempotency records in the task store file
+type boltIdempotencyStore struct {
+	db *bolt.DB
+}
+
+func newBoltIdempotencyStore(db *bolt.DB) (*boltIdempotencyStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(idempotencyBucket)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize bolt idempotency store: %w", err)
+	}
+	return &boltIdempotencyStore{db: db}, nil
+}
+
+func (s *boltIdempotencyStore) get(key string, now time.Time) (*idempotencyRecord, error) {
+	var record *idempotencyRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(idempotencyBucket).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		record = &idempotencyRecord{}
+		return json.Unmarshal(data, record)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read idempotency key: %w", err)
+	}
+	if record == nil || !now.Before(record.ExpiresAt) {
+		return nil, nil
+	}
+	return record, nil
+}
+
+func (s *boltIdempotencyStore) put(record *idempotencyRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal idempotency key: %w", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(idempotencyBucket).Put([]byte(record.Key), data)
+	})
+}
+
+func (s *boltIdempotencyStore) delete(key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(idempotencyBucket).Delete([]byte(key))
+	})
+}
+
+func (s *boltIdempotencyStore) purge(now time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(idempotencyBucket)
+		var expired [][]byte
+		err := bucket.ForEach(func(key, data []byte) error {
+			var record idempotencyRecord
+			if err := json.Unmarshal(data, &record); err != nil || !now.Before(record.ExpiresAt) {
+				expired = append(expired, append([]byte(nil), key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// requestFingerprint identifies the payload of a task request, so that a
+// reused idempotency key can be told apart from a retried request
+func requestFingerprint(req *models.TaskRequest) (string, error) {
+	payload := *req
+	payload.IdempotencyKey = ""
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to fingerprint request: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// keyLocks hands out a lock per key, dropped once nobody holds or waits
+// for it
+type keyLocks struct {
+	locks map[string]*keyLock
+	mu    sync.Mutex
+}
+
+// keyLock is the lock of a key and the number of its holders and waiters
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newKeyLocks() *keyLocks {
+	return &keyLocks{locks: make(map[string]*keyLock)}
+}
+
+// lock locks the key and returns the function unlocking it
+func (l *keyLocks) lock(key string) func() {
+	l.mu.Lock()
+	lock, exists := l.locks[key]
+	if !exists {
+		lock = &keyLock{}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
+
+// purgeIdempotencyKeys drops the expired idempotency keys every interval
+// until the task manager is closed
+func (tm *TaskManager) purgeIdempotencyKeys(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-tm.closed:
+			return
+		case now := <-ticker.C:
+			if err := tm.idempotency.purge(now); err != nil {
+				log.Printf("Failed to purge idempotency keys: %v", err)
+			}
+		}
+	}
+}
+
+// CreateTaskIdempotent creates a task like CreateTaskFromRequest. If the
+// request carries an idempotency key that created a task within the
+// retention window, that task is returned instead and created is false. A
+// key reused with a different request fails with ErrIdempotencyKeyReused.
+func (tm *TaskManager) CreateTaskIdempotent(req *models.TaskRequest) (task *models.Task, created bool, err error) {
+	if req.IdempotencyKey == "" {
+		task, err := tm.createTask(req)
+		return task, err == nil, err
+	}
+
+	fingerprint, err := requestFingerprint(req)
+	if err != nil {
+		return nil, false, &invalidRequestError{err: err}
+	}
+
+	// Requests with the same key are serialized so that concurrent retries
+	// cannot both create a task
+	unlock := tm.idempotencyLocks.lock(req.IdempotencyKey)
+	defer unlock()
+
+	now := time.Now()
+	record, err := tm.idempotency.get(req.IdempotencyKey, now)
+	if err != nil {
+		return nil, false, err
+	}
+	if record != nil {
+		if record.Fingerprint != fingerprint {
+			return nil, false, fmt.Errorf("%w: %s", ErrIdempotencyKeyReused, req.IdempotencyKey)
+		}
+		task, err := tm.store.Get(record.TaskID)
+		if err == nil {
+			return task, false, nil
+		}
+		if !errors.Is(err, ErrTaskNotFound) {
+			return nil, false, err
+		}
+		// The task is gone, so the key no longer protects anything
+	}
+
+	task, err = tm.newTask(req)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// The key is stored before the task, so that a task is never left
+	// without the key that protects it from being created twice
+	record = &idempotencyRecord{
+		Key:         req.IdempotencyKey,
+		TaskID:      task.ID,
+		Fingerprint: fingerprint,
+		ExpiresAt:   now.Add(tm.idempotencyRetention),
+	}
+	if err := tm.idempotency.put(record); err != nil {
+		return nil, false, fmt.Errorf("failed to store idempotency key: %w", err)
+	}
+
+	if err := tm.storeTask(task); err != nil {
+		if deleteErr := tm.idempotency.delete(req.IdempotencyKey); deleteErr != nil {
+			log.Printf("Failed to drop idempotency key %s: %v", req.IdempotencyKey, deleteErr)
+		}
+		return nil, false, err
+	}
+
+	return task, true, nil
+}
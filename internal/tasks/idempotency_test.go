@@ -0,0 +1,245 @@
+package tasks
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"go-fred/internal/config"
+	"go-fred/internal/events"
+	"go-fred/internal/models"
+)
+
+func TestCreateTaskIdempotent(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+
+	mockPub := &mockPublisher{}
+	taskManager := NewTaskManager(registry, mockPub, 5)
+
+	req := &models.TaskRequest{
+		Type:           "echo",
+		Input:          map[string]interface{}{"message": "hello"},
+		IdempotencyKey: "order-42",
+	}
+
+	task, created, err := taskManager.CreateTaskIdempotent(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !created {
+		t.Error("Expected the first request to create a task")
+	}
+	if task.IdempotencyKey != "order-42" {
+		t.Errorf("Expected task to carry the idempotency key, got %q", task.IdempotencyKey)
+	}
+
+	// A retried request returns the same task without creating another one
+	retried, created, err := taskManager.CreateTaskIdempotent(&models.TaskRequest{
+		Type:           "echo",
+		Input:          map[string]interface{}{"message": "hello"},
+		IdempotencyKey: "order-42",
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if created || retried.ID != task.ID {
+		t.Errorf("Expected the original task %s, got %s (created %v)", task.ID, retried.ID, created)
+	}
+
+	// The key cannot be reused for a different request
+	_, _, err = taskManager.CreateTaskIdempotent(&models.TaskRequest{
+		Type:           "echo",
+		Input:          map[string]interface{}{"message": "goodbye"},
+		IdempotencyKey: "order-42",
+	})
+	if !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("Expected ErrIdempotencyKeyReused, got %v", err)
+	}
+
+	if tasks := taskManager.ListTasks(); len(tasks) != 1 {
+		t.Errorf("Expected 1 task, got %d", len(tasks))
+	}
+	if count := countEvents(mockPub.GetEvents(), events.EventTypeTaskCreated); count != 1 {
+		t.Errorf("Expected 1 task created event, got %d", count)
+	}
+}
+
+func TestCreateTaskIdempotentConcurrent(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+	taskManager := NewTaskManager(registry, &mockPublisher{}, 5)
+
+	var wg sync.WaitGroup
+	ids := make([]string, 10)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			task, _, err := taskManager.CreateTaskIdempotent(&models.TaskRequest{Type: "echo", IdempotencyKey: "same"})
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+				return
+			}
+			ids[i] = task.ID
+		}(i)
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		if id != ids[0] {
+			t.Fatalf("Expected every request to get task %s, got %s", ids[0], id)
+		}
+	}
+}
+
+func TestCreateTaskIdempotentRetention(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+	taskManager := NewTaskManagerWithConfig(registry, &mockPublisher{}, NewMemoryTaskStore(), &config.TasksConfig{
+		MaxConcurrent:               5,
+		IdempotencyRetentionSeconds: 1,
+	})
+
+	req := &models.TaskRequest{Type: "echo", IdempotencyKey: "short-lived"}
+	first, _, err := taskManager.CreateTaskIdempotent(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	// Once the key expired, it creates a new task
+	second, created, err := taskManager.CreateTaskIdempotent(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !created || second.ID == first.ID {
+		t.Errorf("Expected a new task after the retention window, got %s (created %v)", second.ID, created)
+	}
+}
+
+func TestIdempotencyKeysSurviveRestart(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+
+	store := newTestBoltStore(t)
+	req := &models.TaskRequest{Type: "echo", IdempotencyKey: "durable"}
+
+	first, _, err := NewTaskManagerWithStore(registry, &mockPublisher{}, store, 5).CreateTaskIdempotent(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// A new task manager on the same store still knows the key
+	second, created, err := NewTaskManagerWithStore(registry, &mockPublisher{}, store, 5).CreateTaskIdempotent(req)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if created || second.ID != first.ID {
+		t.Errorf("Expected the original task %s, got %s (created %v)", first.ID, second.ID, created)
+	}
+}
+
+// failingCreateStore is a task store whose Create fails
+type failingCreateStore struct {
+	*MemoryTaskStore
+}
+
+func (s *failingCreateStore) Create(task *models.Task, evts ...events.Event) error {
+	return errors.New("disk full")
+}
+
+func TestCreateTaskIdempotentStoreFailure(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+	taskManager := NewTaskManagerWithStore(registry, &mockPublisher{}, &failingCreateStore{NewMemoryTaskStore()}, 5)
+	defer taskManager.Close()
+
+	req := &models.TaskRequest{Type: "echo", IdempotencyKey: "order-7"}
+	_, _, err := taskManager.CreateTaskIdempotent(req)
+	if err == nil {
+		t.Fatal("Expected error when the task cannot be stored")
+	}
+	if errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("Expected a store failure not to be an invalid request, got %v", err)
+	}
+
+	// The key does not point at a task that was never created
+	record, err := taskManager.idempotency.get("order-7", time.Now())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if record != nil {
+		t.Errorf("Expected the idempotency key to be dropped, got %+v", record)
+	}
+}
+
+func TestCreateTaskInvalidRequest(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+	taskManager := NewTaskManager(registry, &mockPublisher{}, 5)
+	defer taskManager.Close()
+
+	invalid := []*models.TaskRequest{
+		{Type: "unknown"},
+		{Type: "echo", Priority: -1},
+		{Type: "echo", Delay: 10, RunAt: &time.Time{}},
+		{Type: "echo", IdempotencyKey: "order-8", Timeout: -1},
+	}
+	for _, req := range invalid {
+		_, _, err := taskManager.CreateTaskIdempotent(req)
+		if !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("Expected %v for %+v, got %v", ErrInvalidRequest, req, err)
+		}
+	}
+
+	// The message of the validation error is kept
+	_, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{Type: "unknown"})
+	if err == nil || err.Error() != "no executor found for task type: unknown" {
+		t.Errorf("Expected the validation message, got %v", err)
+	}
+}
+
+func TestKeyLocks(t *testing.T) {
+	locks := newKeyLocks()
+
+	unlockA := locks.lock("a")
+
+	// Other keys are not held up
+	done := make(chan struct{})
+	go func() {
+		locks.lock("b")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected key b to be locked while key a is held")
+	}
+
+	// The same key waits for the holder
+	locked := make(chan struct{})
+	released := make(chan struct{})
+	go func() {
+		unlock := locks.lock("a")
+		close(locked)
+		unlock()
+		close(released)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("Expected key a to wait for its holder")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlockA()
+	<-released
+
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	if len(locks.locks) != 0 {
+		t.Errorf("Expected released locks to be dropped, got %d", len(locks.locks))
+	}
+}
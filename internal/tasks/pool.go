@@ -0,0 +1,242 @@
+package tasks
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+
+	"go-fred/internal/models"
+)
+
+var (
+	// ErrQueueFull is returned when a task is submitted to a queue that has
+	// reached its maximum depth
+	ErrQueueFull = errors.New("task queue is full")
+	// ErrPoolClosed is returned when a task is submitted after the task
+	// manager was closed
+	ErrPoolClosed = errors.New("worker pool is closed")
+	// ErrQueueDraining is returned when a task is submitted to a queue that
+	// is being drained
+	ErrQueueDraining = errors.New("task queue is draining")
+)
+
+// job is a queued attempt of a task execution
+type job struct {
+	priority int
+	rank     int64
+	seq      uint64
+	index    int
+	run      func()
+	// dispatched is set when the job went straight to a free worker
+	dispatched bool
+}
+
+// jobHeap is a priority queue of jobs: higher rank first, then first in
+// first out
+type jobHeap []*job
+
+func (h jobHeap) Len() int { return len(h) }
+
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].rank != h[j].rank {
+		return h[i].rank > h[j].rank
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *jobHeap) Push(x interface{}) {
+	j := x.(*job)
+	j.index = len(*h)
+	*h = append(*h, j)
+}
+
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	j := old[n-1]
+	old[n-1] = nil
+	j.index = -1
+	*h = old[:n-1]
+	return j
+}
+
+// workerPool runs queued jobs on a fixed number of workers.
+//
+// With aging enabled a job gains one priority level for every aging interval
+// it waits. As every job ages at the same pace, this amounts to ranking jobs
+// by priority times the interval minus their enqueue time, which keeps the
+// heap order stable while waiting jobs overtake fresher ones of higher
+// priority.
+//
+// A paused pool keeps accepting jobs but its workers do not pick any up. A
+// draining pool rejects new jobs with ErrQueueDraining while its workers
+// finish the queued ones.
+type workerPool struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	jobs     jobHeap
+	seq      uint64
+	workers  int
+	maxDepth int
+	aging    time.Duration
+	state    string
+	closed   bool
+
+	running   int
+	submitted int64
+	rejected  int64
+	processed int64
+}
+
+// newWorkerPool starts a pool of workers. A maxDepth of zero leaves the
+// queue unbounded and an aging of zero disables aging.
+func newWorkerPool(workers, maxDepth int, aging time.Duration) *workerPool {
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &workerPool{
+		workers:  workers,
+		maxDepth: maxDepth,
+		aging:    aging,
+		state:    models.QueueStateActive,
+	}
+	p.cond = sync.NewCond(&p.mu)
+
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// submit queues a job. Bounded submissions fail with ErrQueueFull once the
+// queue has reached its maximum depth.
+func (p *workerPool) submit(j *job, bounded bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+	if bounded && p.state == models.QueueStateDraining {
+		p.rejected++
+		return ErrQueueDraining
+	}
+	if bounded && p.maxDepth > 0 && len(p.jobs) >= p.maxDepth {
+		p.rejected++
+		return ErrQueueFull
+	}
+
+	p.submitted++
+
+	// Hand the job straight to a free worker when nothing is waiting
+	if p.state == models.QueueStateActive && len(p.jobs) == 0 && p.running < p.workers {
+		p.running++
+		j.dispatched = true
+		go p.runJob(j)
+		return nil
+	}
+
+	p.seq++
+	j.seq = p.seq
+	j.rank = int64(j.priority)
+	if p.aging > 0 {
+		j.rank = int64(j.priority)*int64(p.aging) - time.Now().UnixNano()
+	}
+	heap.Push(&p.jobs, j)
+	p.cond.Signal()
+	return nil
+}
+
+// remove takes a job out of the queue and reports whether it was still
+// waiting for a worker
+func (p *workerPool) remove(j *job) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if j.index < 0 || j.index >= len(p.jobs) || p.jobs[j.index] != j {
+		return false
+	}
+	heap.Remove(&p.jobs, j.index)
+	return true
+}
+
+// depth returns the number of jobs waiting for a worker
+func (p *workerPool) depth() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.jobs)
+}
+
+// setState pauses, resumes or drains the pool
+func (p *workerPool) setState(state string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.state = state
+	p.cond.Broadcast()
+}
+
+// stats returns a snapshot of the pool
+func (p *workerPool) stats() models.QueueStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return models.QueueStats{
+		State:       p.state,
+		Concurrency: p.workers,
+		MaxDepth:    p.maxDepth,
+		Waiting:     len(p.jobs),
+		Running:     p.running,
+		Submitted:   p.submitted,
+		Rejected:    p.rejected,
+		Processed:   p.processed,
+	}
+}
+
+// close stops the workers once their current job is done. Jobs still in the
+// queue are not run.
+func (p *workerPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	p.cond.Broadcast()
+}
+
+// work runs jobs until the pool is closed
+func (p *workerPool) work() {
+	for {
+		p.mu.Lock()
+		for (len(p.jobs) == 0 || p.state == models.QueueStatePaused || p.running >= p.workers) && !p.closed {
+			p.cond.Wait()
+		}
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		j := heap.Pop(&p.jobs).(*job)
+		p.running++
+		p.mu.Unlock()
+
+		p.runJob(j)
+	}
+}
+
+// runJob runs a job that holds one of the worker slots and frees the slot
+func (p *workerPool) runJob(j *job) {
+	j.run()
+
+	p.mu.Lock()
+	p.running--
+	p.processed++
+	p.cond.Signal()
+	p.mu.Unlock()
+}
@@ -0,0 +1,150 @@
+package tasks
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingPool returns a single-worker pool whose worker is held until the
+// returned release function is called, and the order in which jobs ran
+func recordingPool(maxDepth int, aging time.Duration) (*workerPool, func(priority int, name string) *job, func() []string) {
+	pool := newWorkerPool(1, maxDepth, aging)
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+
+	newJob := func(priority int, name string) *job {
+		wg.Add(1)
+		return &job{priority: priority, run: func() {
+			defer wg.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, name)
+		}}
+	}
+
+	result := func() []string {
+		wg.Wait()
+		mu.Lock()
+		defer mu.Unlock()
+		return order
+	}
+	return pool, newJob, result
+}
+
+// blockWorker occupies the only worker of the pool until release is closed
+func blockWorker(t *testing.T, pool *workerPool) chan struct{} {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if err := pool.submit(&job{run: func() {
+		close(started)
+		<-release
+	}}, false); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	<-started
+	return release
+}
+
+func TestWorkerPoolPriorityOrder(t *testing.T) {
+	pool, newJob, result := recordingPool(0, 0)
+	defer pool.close()
+
+	release := blockWorker(t, pool)
+	pool.submit(newJob(0, "low-1"), true)
+	pool.submit(newJob(5, "high-1"), true)
+	pool.submit(newJob(0, "low-2"), true)
+	pool.submit(newJob(5, "high-2"), true)
+	pool.submit(newJob(1, "medium"), true)
+	close(release)
+
+	expected := []string{"high-1", "high-2", "medium", "low-1", "low-2"}
+	order := result()
+	for i, name := range expected {
+		if i >= len(order) || order[i] != name {
+			t.Fatalf("Expected %v, got %v", expected, order)
+		}
+	}
+}
+
+func TestWorkerPoolAging(t *testing.T) {
+	pool, newJob, result := recordingPool(0, 10*time.Millisecond)
+	defer pool.close()
+
+	release := blockWorker(t, pool)
+	pool.submit(newJob(0, "old-low"), true)
+
+	// After waiting five aging intervals the low priority job outranks a
+	// fresh job of priority 2
+	time.Sleep(50 * time.Millisecond)
+	pool.submit(newJob(2, "new-high"), true)
+	close(release)
+
+	order := result()
+	if len(order) != 2 || order[0] != "old-low" {
+		t.Errorf("Expected aged job to run first, got %v", order)
+	}
+}
+
+func TestWorkerPoolMaxDepth(t *testing.T) {
+	pool, newJob, result := recordingPool(2, 0)
+	defer pool.close()
+
+	release := blockWorker(t, pool)
+	if err := pool.submit(newJob(0, "first"), true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := pool.submit(newJob(0, "second"), true); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := pool.submit(&job{run: func() {}}, true); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+
+	// Unbounded submissions are accepted anyway
+	if err := pool.submit(newJob(0, "third"), false); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if depth := pool.depth(); depth != 3 {
+		t.Errorf("Expected depth 3, got %d", depth)
+	}
+
+	close(release)
+	if order := result(); len(order) != 3 {
+		t.Errorf("Expected 3 jobs to run, got %v", order)
+	}
+}
+
+func TestWorkerPoolRemove(t *testing.T) {
+	pool, newJob, result := recordingPool(0, 0)
+	defer pool.close()
+
+	release := blockWorker(t, pool)
+	removed := &job{run: func() { t.Error("Expected removed job not to run") }}
+	pool.submit(removed, true)
+	pool.submit(newJob(0, "kept"), true)
+
+	if !pool.remove(removed) {
+		t.Error("Expected queued job to be removed")
+	}
+	if pool.remove(removed) {
+		t.Error("Expected job to be removed only once")
+	}
+	close(release)
+
+	if order := result(); len(order) != 1 || order[0] != "kept" {
+		t.Errorf("Expected only the kept job to run, got %v", order)
+	}
+}
+
+func TestWorkerPoolClose(t *testing.T) {
+	pool := newWorkerPool(2, 0, 0)
+	pool.close()
+
+	if err := pool.submit(&job{run: func() {}}, false); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Expected ErrPoolClosed, got %v", err)
+	}
+}
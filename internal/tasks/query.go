@@ -0,0 +1,408 @@
+package tasks
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"go-fred/internal/models"
+)
+
+// Page sizes of task queries
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
+var (
+	// ErrInvalidQuery is returned when a query cannot be run as given
+	ErrInvalidQuery = errors.New("invalid query")
+	// ErrInvalidCursor is returned when a query cursor cannot be decoded or
+	// does not match the sort order of the query
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// TaskPage is a page of tasks matching a query. Total counts the tasks
+// matching the query across all pages; NextCursor is empty on the last page.
+type TaskPage struct {
+	Tasks      []*models.Task
+	Total      int
+	NextCursor string
+}
+
+// sortFields are the fields every task is indexed by
+var sortFields = []string{models.TaskSortCreatedAt, models.TaskSortStartedAt, models.TaskSortDuration}
+
+// indexEntry is the position of a task in a sort index: ordered by value,
+// then by ID so that the order is stable
+type indexEntry struct {
+	value int64
+	id    string
+}
+
+// compare orders index entries
+func (e indexEntry) compare(other indexEntry) int {
+	switch {
+	case e.value < other.value:
+		return -1
+	case e.value > other.value:
+		return 1
+	}
+	switch {
+	case e.id < other.id:
+		return -1
+	case e.id > other.id:
+		return 1
+	}
+	return 0
+}
+
+// sortValue returns the value a task is ordered by in the given field
+func sortValue(task *models.Task, field string) int64 {
+	switch field {
+	case models.TaskSortStartedAt:
+		if task.StartedAt != nil {
+			return task.StartedAt.UnixNano()
+		}
+	case models.TaskSortDuration:
+		if task.Duration != nil {
+			return int64(*task.Duration)
+		}
+	case models.TaskSortCreatedAt:
+		return task.CreatedAt.UnixNano()
+	}
+	return 0
+}
+
+// indexPartitions returns the partitions of the sort indexes a task is
+// listed in: all tasks, tasks of its status and tasks of its type
+func indexPartitions(task *models.Task) []string {
+	return []string{"all", "status=" + string(task.Status), "type=" + task.Type}
+}
+
+// queryPartitions returns the narrowest set of partitions that holds every
+// task matching the query
+func queryPartitions(query *models.TaskQuery) []string {
+	var partitions []string
+	switch {
+	case len(query.Statuses) > 0:
+		for _, status := range query.Statuses {
+			partitions = append(partitions, "status="+string(status))
+		}
+	case len(query.Types) > 0:
+		for _, taskType := range query.Types {
+			partitions = append(partitions, "type="+taskType)
+		}
+	default:
+		partitions = []string{"all"}
+	}
+	slices.Sort(partitions)
+	return slices.Compact(partitions)
+}
+
+// indexIterator walks a partition of a sort index
+type indexIterator interface {
+	next() (indexEntry, bool)
+}
+
+// openIndex opens an iterator over a partition of a sort index, starting
+// right after the given entry (or at the beginning when it is nil) and
+// moving backwards when descending
+type openIndex func(field, partition string, after *indexEntry, descending bool) indexIterator
+
+// loadTask loads a task listed in an index
+type loadTask func(taskID string) (*models.Task, error)
+
+// queryCursor is the decoded form of the opaque cursor handed to clients
+type queryCursor struct {
+	SortBy     string `json:"s"`
+	Descending bool   `json:"d,omitempty"`
+	Value      int64  `json:"v"`
+	ID         string `json:"i"`
+}
+
+// encodeCursor encodes the position of the last task of a page
+func encodeCursor(query *models.TaskQuery, entry indexEntry) string {
+	data, _ := json.Marshal(queryCursor{SortBy: query.SortBy, Descending: query.Descending, Value: entry.value, ID: entry.id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor decodes a cursor and checks it belongs to the query's order
+func decodeCursor(query *models.TaskQuery) (*indexEntry, error) {
+	data, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor queryCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID == "" {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.SortBy != query.SortBy || cursor.Descending != query.Descending {
+		return nil, fmt.Errorf("%w: cursor does not match the sort order", ErrInvalidCursor)
+	}
+	return &indexEntry{value: cursor.Value, id: cursor.ID}, nil
+}
+
+// normalizeQuery validates the query and fills in its defaults
+func normalizeQuery(query *models.TaskQuery) (*models.TaskQuery, error) {
+	normalized := *query
+	if normalized.SortBy == "" {
+		normalized.SortBy = models.TaskSortCreatedAt
+	}
+	if !slices.Contains(sortFields, normalized.SortBy) {
+		return nil, fmt.Errorf("%w: unsupported sort field %q", ErrInvalidQuery, normalized.SortBy)
+	}
+
+	switch {
+	case normalized.Limit < 0:
+		return nil, fmt.Errorf("%w: limit must not be negative", ErrInvalidQuery)
+	case normalized.Limit == 0:
+		normalized.Limit = DefaultQueryLimit
+	case normalized.Limit > MaxQueryLimit:
+		normalized.Limit = MaxQueryLimit
+	}
+	return &normalized, nil
+}
+
+// runQuery walks the sort index of the query's sort field, merging the
+// partitions that hold the candidate tasks, and returns the page of matching
+// tasks after the cursor along with the number of tasks matching the query
+func runQuery(query *models.TaskQuery, open openIndex, load loadTask) (*TaskPage, error) {
+	query, err := normalizeQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor *indexEntry
+	if query.Cursor != "" {
+		if cursor, err = decodeCursor(query); err != nil {
+			return nil, err
+		}
+	}
+
+	// Start at the bound of the creation time range when sorting by
+	// creation time; the tasks before the cursor are still counted
+	start := queryStart(query)
+	partitions := queryPartitions(query)
+	iterators := make([]indexIterator, len(partitions))
+	heads := make([]*indexEntry, len(partitions))
+	for i, partition := range partitions {
+		iterators[i] = open(query.SortBy, partition, start, query.Descending)
+		heads[i] = nextEntry(iterators[i])
+	}
+
+	page := &TaskPage{Tasks: []*models.Task{}}
+	var last indexEntry
+	for {
+		// Take the next entry across all partitions
+		best := -1
+		for i, head := range heads {
+			if head == nil {
+				continue
+			}
+			if best < 0 {
+				best = i
+				continue
+			}
+			order := head.compare(*heads[best])
+			if (order < 0 && !query.Descending) || (order > 0 && query.Descending) {
+				best = i
+			}
+		}
+		if best < 0 || pastCreatedRange(query, *heads[best]) {
+			return page, nil
+		}
+		entry := *heads[best]
+		heads[best] = nextEntry(iterators[best])
+
+		task, err := load(entry.id)
+		if err != nil {
+			return nil, err
+		}
+		if !matchesQuery(task, query) {
+			continue
+		}
+		page.Total++
+
+		switch {
+		case cursor != nil && !afterCursor(query, entry, *cursor):
+		case len(page.Tasks) < query.Limit:
+			page.Tasks = append(page.Tasks, task)
+			last = entry
+		case page.NextCursor == "":
+			page.NextCursor = encodeCursor(query, last)
+		}
+	}
+}
+
+// nextEntry advances an iterator, returning nil at its end
+func nextEntry(iterator indexIterator) *indexEntry {
+	entry, ok := iterator.next()
+	if !ok {
+		return nil
+	}
+	return &entry
+}
+
+// queryStart returns the index entry a query starts after: the bound of
+// its creation time range when sorting by creation time
+func queryStart(query *models.TaskQuery) *indexEntry {
+	if query.SortBy != models.TaskSortCreatedAt {
+		return nil
+	}
+
+	// Task IDs are never empty, so an entry without ID sits right before
+	// every task of its creation time
+	if !query.Descending && query.CreatedAfter != nil {
+		return &indexEntry{value: query.CreatedAfter.UnixNano()}
+	}
+	if query.Descending && query.CreatedBefore != nil {
+		return &indexEntry{value: query.CreatedBefore.UnixNano()}
+	}
+	return nil
+}
+
+// afterCursor reports whether an entry comes after the cursor in the order
+// of the query
+func afterCursor(query *models.TaskQuery, entry, cursor indexEntry) bool {
+	if query.Descending {
+		return entry.compare(cursor) < 0
+	}
+	return entry.compare(cursor) > 0
+}
+
+// pastCreatedRange reports whether a query sorted by creation time has
+// walked past the end of its creation time range
+func pastCreatedRange(query *models.TaskQuery, entry indexEntry) bool {
+	if query.SortBy != models.TaskSortCreatedAt {
+		return false
+	}
+	if !query.Descending && query.CreatedBefore != nil {
+		return entry.value >= query.CreatedBefore.UnixNano()
+	}
+	if query.Descending && query.CreatedAfter != nil {
+		return entry.value < query.CreatedAfter.UnixNano()
+	}
+	return false
+}
+
+// matchesQuery reports whether a task matches every filter of the query
+func matchesQuery(task *models.Task, query *models.TaskQuery) bool {
+	if len(query.Statuses) > 0 && !slices.Contains(query.Statuses, task.Status) {
+		return false
+	}
+	if len(query.Types) > 0 && !slices.Contains(query.Types, task.Type) {
+		return false
+	}
+	if query.Async != nil && task.IsAsync != *query.Async {
+		return false
+	}
+	if !query.Labels.Matches(task.Labels) {
+		return false
+	}
+	if query.CreatedAfter != nil && task.CreatedAt.Before(*query.CreatedAfter) {
+		return false
+	}
+	if query.CreatedBefore != nil && !task.CreatedAt.Before(*query.CreatedBefore) {
+		return false
+	}
+	if query.CompletedAfter != nil || query.CompletedBefore != nil {
+		if task.CompletedAt == nil {
+			return false
+		}
+		if query.CompletedAfter != nil && task.CompletedAt.Before(*query.CompletedAfter) {
+			return false
+		}
+		if query.CompletedBefore != nil && !task.CompletedAt.Before(*query.CompletedBefore) {
+			return false
+		}
+	}
+	return true
+}
+
+// memoryIndex keeps the sort indexes of the in-memory store as sorted slices
+type memoryIndex struct {
+	entries map[string][]indexEntry
+}
+
+// newMemoryIndex creates empty sort indexes
+func newMemoryIndex() *memoryIndex {
+	return &memoryIndex{entries: make(map[string][]indexEntry)}
+}
+
+// add lists the task in every sort index
+func (idx *memoryIndex) add(task *models.Task) {
+	for _, field := range sortFields {
+		entry := indexEntry{value: sortValue(task, field), id: task.ID}
+		for _, partition := range indexPartitions(task) {
+			key := field + "/" + partition
+			entries := idx.entries[key]
+			i, _ := slices.BinarySearchFunc(entries, entry, indexEntry.compare)
+			idx.entries[key] = slices.Insert(entries, i, entry)
+		}
+	}
+}
+
+// remove drops the task from every sort index
+func (idx *memoryIndex) remove(task *models.Task) {
+	for _, field := range sortFields {
+		entry := indexEntry{value: sortValue(task, field), id: task.ID}
+		for _, partition := range indexPartitions(task) {
+			key := field + "/" + partition
+			entries := idx.entries[key]
+			i, found := slices.BinarySearchFunc(entries, entry, indexEntry.compare)
+			if !found {
+				continue
+			}
+			if entries = slices.Delete(entries, i, i+1); len(entries) == 0 {
+				delete(idx.entries, key)
+			} else {
+				idx.entries[key] = entries
+			}
+		}
+	}
+}
+
+// open returns an iterator over a partition of a sort index
+func (idx *memoryIndex) open(field, partition string, after *indexEntry, descending bool) indexIterator {
+	entries := idx.entries[field+"/"+partition]
+
+	pos := 0
+	if descending {
+		pos = len(entries) - 1
+	}
+	if after != nil {
+		i, found := slices.BinarySearchFunc(entries, *after, indexEntry.compare)
+		switch {
+		case descending:
+			pos = i - 1
+		case found:
+			pos = i + 1
+		default:
+			pos = i
+		}
+	}
+	return &sliceIterator{entries: entries, pos: pos, descending: descending}
+}
+
+// sliceIterator walks a sorted slice of index entries
+type sliceIterator struct {
+	entries    []indexEntry
+	pos        int
+	descending bool
+}
+
+func (it *sliceIterator) next() (indexEntry, bool) {
+	if it.pos < 0 || it.pos >= len(it.entries) {
+		return indexEntry{}, false
+	}
+	entry := it.entries[it.pos]
+	if it.descending {
+		it.pos--
+	} else {
+		it.pos++
+	}
+	return entry, true
+}
@@ -0,0 +1,220 @@
+package tasks
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-fred/internal/models"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// seedQueryTasks stores five tasks created a second apart. Even tasks are
+// echo tasks that completed, taking longer the later they were created; odd
+// tasks are async math tasks still pending.
+func seedQueryTasks(t *testing.T, store TaskStore) ([]*models.Task, time.Time) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var tasks []*models.Task
+	for i := 0; i < 5; i++ {
+		task := models.NewTask("math", nil, true)
+		task.CreatedAt = base.Add(time.Duration(i) * time.Second)
+		if i%2 == 0 {
+			task.Type = "echo"
+			task.IsAsync = false
+			started := task.CreatedAt
+			completed := started.Add(time.Duration(5-i) * time.Minute)
+			duration := completed.Sub(started)
+			task.Status = models.TaskStatusCompleted
+			task.StartedAt = &started
+			task.CompletedAt = &completed
+			task.Duration = &duration
+		}
+		if err := store.Create(task); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, base
+}
+
+func queryIDs(t *testing.T, store TaskStore, query *models.TaskQuery) ([]string, string) {
+	t.Helper()
+	page, err := store.Query(query)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	ids := make([]string, len(page.Tasks))
+	for i, task := range page.Tasks {
+		ids[i] = task.ID
+	}
+	return ids, page.NextCursor
+}
+
+func expectIDs(t *testing.T, got []string, want ...*models.Task) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d tasks, got %d", len(want), len(got))
+	}
+	for i, task := range want {
+		if got[i] != task.ID {
+			t.Errorf("Expected task %d to be %s, got %s", i, task.ID, got[i])
+		}
+	}
+}
+
+func TestTaskStoreQuery(t *testing.T) {
+	stores := map[string]func(t *testing.T) TaskStore{
+		"memory": func(t *testing.T) TaskStore { return NewMemoryTaskStore() },
+		"bolt":   func(t *testing.T) TaskStore { return newTestBoltStore(t) },
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			tasks, base := seedQueryTasks(t, store)
+
+			// Tasks are listed by creation time by default
+			ids, cursor := queryIDs(t, store, &models.TaskQuery{})
+			expectIDs(t, ids, tasks...)
+			if cursor != "" {
+				t.Errorf("Expected no cursor on the last page, got %s", cursor)
+			}
+
+			ids, _ = queryIDs(t, store, &models.TaskQuery{Descending: true})
+			expectIDs(t, ids, tasks[4], tasks[3], tasks[2], tasks[1], tasks[0])
+
+			ids, _ = queryIDs(t, store, &models.TaskQuery{Statuses: []models.TaskStatus{models.TaskStatusPending}})
+			expectIDs(t, ids, tasks[1], tasks[3])
+
+			// Several statuses merge their partitions in order
+			ids, _ = queryIDs(t, store, &models.TaskQuery{Statuses: []models.TaskStatus{models.TaskStatusPending, models.TaskStatusCompleted}})
+			expectIDs(t, ids, tasks...)
+
+			ids, _ = queryIDs(t, store, &models.TaskQuery{Types: []string{"echo"}})
+			expectIDs(t, ids, tasks[0], tasks[2], tasks[4])
+
+			async := true
+			ids, _ = queryIDs(t, store, &models.TaskQuery{Types: []string{"echo", "math"}, Async: &async})
+			expectIDs(t, ids, tasks[1], tasks[3])
+
+			// Creation ranges include their start and exclude their end
+			after, before := base.Add(time.Second), base.Add(3*time.Second)
+			ids, _ = queryIDs(t, store, &models.TaskQuery{CreatedAfter: &after, CreatedBefore: &before})
+			expectIDs(t, ids, tasks[1], tasks[2])
+			ids, _ = queryIDs(t, store, &models.TaskQuery{CreatedAfter: &after, CreatedBefore: &before, Descending: true})
+			expectIDs(t, ids, tasks[2], tasks[1])
+
+			completedAfter := base.Add(3 * time.Minute)
+			ids, _ = queryIDs(t, store, &models.TaskQuery{CompletedAfter: &completedAfter})
+			expectIDs(t, ids, tasks[0], tasks[2])
+
+			ids, _ = queryIDs(t, store, &models.TaskQuery{SortBy: models.TaskSortDuration, Types: []string{"echo"}})
+			expectIDs(t, ids, tasks[4], tasks[2], tasks[0])
+
+			// Tasks without a start time sort first, so they come last here
+			ids, _ = queryIDs(t, store, &models.TaskQuery{SortBy: models.TaskSortStartedAt, Descending: true, Limit: 3})
+			expectIDs(t, ids, tasks[4], tasks[2], tasks[0])
+
+			// Pages continue from the cursor until the last one
+			var paged []string
+			query := &models.TaskQuery{Limit: 2, Descending: true}
+			for pages := 0; ; pages++ {
+				if pages > 3 {
+					t.Fatalf("Expected paging to end, got %v", paged)
+				}
+				ids, cursor := queryIDs(t, store, query)
+				paged = append(paged, ids...)
+				if cursor == "" {
+					break
+				}
+				query.Cursor = cursor
+			}
+			expectIDs(t, paged, tasks[4], tasks[3], tasks[2], tasks[1], tasks[0])
+
+			// Every page counts all the tasks matching the query
+			rangeQuery := &models.TaskQuery{Limit: 1, CreatedAfter: &after, CreatedBefore: &before}
+			for _, expected := range []*models.Task{tasks[1], tasks[2]} {
+				page, err := store.Query(rangeQuery)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if len(page.Tasks) != 1 || page.Tasks[0].ID != expected.ID || page.Total != 2 {
+					t.Errorf("Expected task %s of 2, got %d tasks of %d", expected.ID, len(page.Tasks), page.Total)
+				}
+				rangeQuery.Cursor = page.NextCursor
+			}
+			if rangeQuery.Cursor != "" {
+				t.Errorf("Expected no cursor on the last page, got %s", rangeQuery.Cursor)
+			}
+
+			// A full last page has no cursor
+			ids, cursor = queryIDs(t, store, &models.TaskQuery{Limit: 2, Statuses: []models.TaskStatus{models.TaskStatusPending}})
+			expectIDs(t, ids, tasks[1], tasks[3])
+			if cursor != "" {
+				t.Errorf("Expected no cursor on the last page, got %s", cursor)
+			}
+
+			// Cursors only continue queries of the same order
+			_, cursor = queryIDs(t, store, &models.TaskQuery{Limit: 1})
+			if _, err := store.Query(&models.TaskQuery{Limit: 1, Cursor: cursor, Descending: true}); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("Expected ErrInvalidCursor, got %v", err)
+			}
+			if _, err := store.Query(&models.TaskQuery{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("Expected ErrInvalidCursor, got %v", err)
+			}
+			if _, err := store.Query(&models.TaskQuery{SortBy: "name"}); !errors.Is(err, ErrInvalidQuery) {
+				t.Errorf("Expected ErrInvalidQuery, got %v", err)
+			}
+
+			// Updates move tasks between partitions and deletes drop them
+			task, err := store.Get(tasks[1].ID)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			task.Cancel()
+			if err := store.Update(task); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if err := store.Delete(tasks[3].ID); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			ids, _ = queryIDs(t, store, &models.TaskQuery{Statuses: []models.TaskStatus{models.TaskStatusPending}})
+			expectIDs(t, ids)
+			ids, _ = queryIDs(t, store, &models.TaskQuery{Statuses: []models.TaskStatus{models.TaskStatusCancelled}})
+			expectIDs(t, ids, tasks[1])
+			ids, _ = queryIDs(t, store, &models.TaskQuery{})
+			expectIDs(t, ids, tasks[0], tasks[1], tasks[2], tasks[4])
+		})
+	}
+}
+
+func TestBoltTaskStoreBuildsMissingIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.db")
+	store, err := NewBoltTaskStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	tasks, _ := seedQueryTasks(t, store)
+
+	// Simulate a file written before tasks were indexed
+	err = store.DB().Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket(taskIndexBucket)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store.Close()
+
+	reopened, err := NewBoltTaskStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer reopened.Close()
+
+	ids, _ := queryIDs(t, reopened, &models.TaskQuery{Types: []string{"math"}})
+	expectIDs(t, ids, tasks[1], tasks[3])
+}
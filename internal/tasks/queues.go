@@ -0,0 +1,154 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"go-fred/internal/config"
+	"go-fred/internal/models"
+)
+
+var (
+	// ErrQueueNotFound is returned when a queue is not configured
+	ErrQueueNotFound = errors.New("queue not found")
+	// ErrTaskTypeNotAllowed is returned when a task is put into a queue that
+	// does not accept its type
+	ErrTaskTypeNotAllowed = errors.New("task type not allowed in queue")
+)
+
+// taskQueue is a named queue with its own workers
+type taskQueue struct {
+	name      string
+	taskTypes []string
+	pool      *workerPool
+}
+
+// allows reports whether the queue accepts tasks of the given type
+func (q *taskQueue) allows(taskType string) bool {
+	return len(q.taskTypes) == 0 || slices.Contains(q.taskTypes, taskType)
+}
+
+// stats returns a snapshot of the queue
+func (q *taskQueue) stats() *models.QueueStats {
+	stats := q.pool.stats()
+	stats.Name = q.name
+	stats.TaskTypes = q.taskTypes
+	return &stats
+}
+
+// newTaskQueues starts the configured queues. The default queue takes the
+// global concurrency and depth limits unless it is configured explicitly.
+func newTaskQueues(cfg *config.TasksConfig) map[string]*taskQueue {
+	aging := time.Duration(cfg.PriorityAgingSeconds) * time.Second
+
+	queues := make(map[string]*taskQueue, len(cfg.Queues)+1)
+	for name, queueCfg := range cfg.Queues {
+		queues[name] = &taskQueue{
+			name:      name,
+			taskTypes: queueCfg.TaskTypes,
+			pool:      newWorkerPool(queueCfg.Concurrency, queueCfg.MaxDepth, aging),
+		}
+	}
+
+	if _, exists := queues[models.DefaultQueue]; !exists {
+		queues[models.DefaultQueue] = &taskQueue{
+			name: models.DefaultQueue,
+			pool: newWorkerPool(cfg.MaxConcurrent, cfg.MaxQueueDepth, aging),
+		}
+	}
+	return queues
+}
+
+// resolveQueue returns the queue a new task goes to: the requested one, or
+// else the first queue that lists the task type, or else the default queue
+func (tm *TaskManager) resolveQueue(taskType, requested string) (string, error) {
+	if requested != "" {
+		queue, exists := tm.queues[requested]
+		if !exists {
+			return "", fmt.Errorf("%w: %s", ErrQueueNotFound, requested)
+		}
+		if !queue.allows(taskType) {
+			return "", fmt.Errorf("%w: %s does not accept %s", ErrTaskTypeNotAllowed, requested, taskType)
+		}
+		return requested, nil
+	}
+
+	for _, name := range tm.queueNames() {
+		if slices.Contains(tm.queues[name].taskTypes, taskType) {
+			return name, nil
+		}
+	}
+
+	if !tm.queues[models.DefaultQueue].allows(taskType) {
+		return "", fmt.Errorf("%w: no queue accepts %s", ErrTaskTypeNotAllowed, taskType)
+	}
+	return models.DefaultQueue, nil
+}
+
+// queueFor returns the queue of the task, falling back to the default queue
+// for tasks whose queue is no longer configured
+func (tm *TaskManager) queueFor(task *models.Task) *taskQueue {
+	if queue, exists := tm.queues[task.Queue]; exists {
+		return queue
+	}
+	return tm.queues[models.DefaultQueue]
+}
+
+// queueNames returns the names of all queues in alphabetical order
+func (tm *TaskManager) queueNames() []string {
+	names := make([]string, 0, len(tm.queues))
+	for name := range tm.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ListQueues returns the stats of all queues
+func (tm *TaskManager) ListQueues() []*models.QueueStats {
+	names := tm.queueNames()
+	stats := make([]*models.QueueStats, len(names))
+	for i, name := range names {
+		stats[i] = tm.queues[name].stats()
+	}
+	return stats
+}
+
+// GetQueue returns the stats of a queue
+func (tm *TaskManager) GetQueue(name string) (*models.QueueStats, error) {
+	queue, exists := tm.queues[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrQueueNotFound, name)
+	}
+	return queue.stats(), nil
+}
+
+// PauseQueue stops the workers of a queue from starting tasks. Tasks can
+// still be queued and running tasks are not interrupted.
+func (tm *TaskManager) PauseQueue(name string) (*models.QueueStats, error) {
+	return tm.setQueueState(name, models.QueueStatePaused)
+}
+
+// ResumeQueue lets a paused or draining queue accept and start tasks again
+func (tm *TaskManager) ResumeQueue(name string) (*models.QueueStats, error) {
+	return tm.setQueueState(name, models.QueueStateActive)
+}
+
+// DrainQueue makes a queue reject new tasks while its workers finish the
+// ones already queued
+func (tm *TaskManager) DrainQueue(name string) (*models.QueueStats, error) {
+	return tm.setQueueState(name, models.QueueStateDraining)
+}
+
+// setQueueState changes the state of a queue
+func (tm *TaskManager) setQueueState(name, state string) (*models.QueueStats, error) {
+	queue, exists := tm.queues[name]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrQueueNotFound, name)
+	}
+	queue.pool.setState(state)
+	return queue.stats(), nil
+}
@@ -0,0 +1,174 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-fred/internal/config"
+	"go-fred/internal/models"
+)
+
+func setupQueueTaskManager(t *testing.T) *TaskManager {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+
+	cfg := &config.TasksConfig{
+		MaxConcurrent: 2,
+		Queues: map[string]config.QueueConfig{
+			"slow":  {Concurrency: 1, MaxDepth: 1, TaskTypes: []string{"sleep"}},
+			"quick": {Concurrency: 2, TaskTypes: []string{"math", "echo"}},
+			"any":   {Concurrency: 1},
+		},
+	}
+	taskManager := NewTaskManagerWithConfig(registry, &mockPublisher{}, NewMemoryTaskStore(), cfg)
+	t.Cleanup(taskManager.Close)
+	return taskManager
+}
+
+func TestTaskManagerQueueRouting(t *testing.T) {
+	taskManager := setupQueueTaskManager(t)
+
+	tests := []struct {
+		name          string
+		request       models.TaskRequest
+		expectedQueue string
+		expectedErr   error
+	}{
+		{"by task type", models.TaskRequest{Type: "sleep"}, "slow", nil},
+		{"first listing queue", models.TaskRequest{Type: "echo"}, "quick", nil},
+		{"unlisted type", models.TaskRequest{Type: "error"}, models.DefaultQueue, nil},
+		{"requested queue", models.TaskRequest{Type: "sleep", Queue: "any"}, "any", nil},
+		{"unknown queue", models.TaskRequest{Type: "echo", Queue: "missing"}, "", ErrQueueNotFound},
+		{"type not allowed", models.TaskRequest{Type: "sleep", Queue: "quick"}, "", ErrTaskTypeNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task, err := taskManager.CreateTaskFromRequest(&tt.request)
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("Expected %v, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if task.Queue != tt.expectedQueue {
+				t.Errorf("Expected queue %s, got %s", tt.expectedQueue, task.Queue)
+			}
+		})
+	}
+}
+
+func TestTaskManagerQueuesRunIndependently(t *testing.T) {
+	taskManager := setupQueueTaskManager(t)
+
+	// Fill the slow queue's only worker
+	slow, _ := taskManager.CreateTask("sleep", map[string]interface{}{"duration": 5.0}, true)
+	if err := taskManager.ExecuteTaskAsync(context.Background(), slow.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer taskManager.CancelTask(slow.ID)
+	time.Sleep(20 * time.Millisecond)
+
+	// A quick task is not held up by it
+	quick, _ := taskManager.CreateTask("math", map[string]interface{}{"operation": "add", "a": 1.0, "b": 2.0}, false)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := taskManager.ExecuteTask(ctx, quick.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	stats, err := taskManager.GetQueue("slow")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stats.Running != 1 || stats.Concurrency != 1 {
+		t.Errorf("Expected 1 running task on 1 worker, got %+v", stats)
+	}
+
+	stats, _ = taskManager.GetQueue("quick")
+	if stats.Processed != 1 {
+		t.Errorf("Expected 1 processed task, got %+v", stats)
+	}
+
+	if queues := taskManager.ListQueues(); len(queues) != 4 {
+		t.Errorf("Expected 4 queues including the default one, got %d", len(queues))
+	}
+	if _, err := taskManager.GetQueue("missing"); !errors.Is(err, ErrQueueNotFound) {
+		t.Errorf("Expected ErrQueueNotFound, got %v", err)
+	}
+}
+
+func TestTaskManagerPauseResumeQueue(t *testing.T) {
+	taskManager := setupQueueTaskManager(t)
+
+	if _, err := taskManager.PauseQueue("quick"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	task, _ := taskManager.CreateTask("echo", map[string]interface{}{}, true)
+	if err := taskManager.ExecuteTaskAsync(context.Background(), task.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	paused, _ := taskManager.GetTask(task.ID)
+	if paused.Status != models.TaskStatusPending {
+		t.Errorf("Expected task to wait in the paused queue, got %s", paused.Status)
+	}
+	stats, _ := taskManager.GetQueue("quick")
+	if stats.State != models.QueueStatePaused || stats.Waiting != 1 {
+		t.Errorf("Expected paused queue with 1 waiting task, got %+v", stats)
+	}
+
+	if _, err := taskManager.ResumeQueue("quick"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	resumed, _ := taskManager.GetTask(task.ID)
+	if resumed.Status != models.TaskStatusCompleted {
+		t.Errorf("Expected task to complete after resume, got %s", resumed.Status)
+	}
+}
+
+func TestTaskManagerDrainQueue(t *testing.T) {
+	taskManager := setupQueueTaskManager(t)
+
+	if _, err := taskManager.PauseQueue("quick"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	queued, _ := taskManager.CreateTask("echo", map[string]interface{}{}, true)
+	if err := taskManager.ExecuteTaskAsync(context.Background(), queued.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	stats, err := taskManager.DrainQueue("quick")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stats.State != models.QueueStateDraining {
+		t.Errorf("Expected draining queue, got %s", stats.State)
+	}
+
+	// New tasks are rejected while queued ones still run
+	rejected, _ := taskManager.CreateTask("echo", map[string]interface{}{}, true)
+	if err := taskManager.ExecuteTaskAsync(context.Background(), rejected.ID); !errors.Is(err, ErrQueueDraining) {
+		t.Errorf("Expected ErrQueueDraining, got %v", err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	drained, _ := taskManager.GetTask(queued.ID)
+	if drained.Status != models.TaskStatusCompleted {
+		t.Errorf("Expected queued task to complete while draining, got %s", drained.Status)
+	}
+
+	stats, _ = taskManager.GetQueue("quick")
+	if stats.Waiting != 0 || stats.Rejected != 1 {
+		t.Errorf("Expected empty queue with 1 rejected task, got %+v", stats)
+	}
+}
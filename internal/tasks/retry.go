@@ -0,0 +1,116 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"go-fred/internal/backoff"
+	"go-fred/internal/config"
+	"go-fred/internal/models"
+)
+
+// ErrNonRetryable marks errors that fail a task straight away, whatever its
+// retry policy says
+var ErrNonRetryable = errors.New("non-retryable error")
+
+const (
+	defaultRetryDelay    = time.Second
+	defaultMaxRetryDelay = time.Minute
+)
+
+// nonRetryableError wraps an error so that it matches ErrNonRetryable while
+// keeping its message
+type nonRetryableError struct {
+	err error
+}
+
+func (e *nonRetryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *nonRetryableError) Unwrap() []error {
+	return []error{e.err, ErrNonRetryable}
+}
+
+// NonRetryable wraps err so that the task fails without further attempts
+func NonRetryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &nonRetryableError{err: err}
+}
+
+// newRetryPolicies converts the configured retry policies, keyed by task type
+func newRetryPolicies(cfg map[string]config.RetryConfig) map[string]*models.RetryPolicy {
+	policies := make(map[string]*models.RetryPolicy, len(cfg))
+	for taskType, retry := range cfg {
+		policies[taskType] = &models.RetryPolicy{
+			MaxAttempts: retry.MaxAttempts,
+			Backoff:     retry.Backoff,
+			DelayMs:     retry.DelayMs,
+			MaxDelayMs:  retry.MaxDelayMs,
+			RetryOn:     retry.RetryOn,
+		}
+	}
+	return policies
+}
+
+// validateRetryPolicy checks a retry policy given with a task request
+func validateRetryPolicy(policy *models.RetryPolicy) error {
+	if policy.MaxAttempts < 0 {
+		return fmt.Errorf("retry max_attempts must not be negative")
+	}
+	if policy.DelayMs < 0 || policy.MaxDelayMs < 0 {
+		return fmt.Errorf("retry delays must not be negative")
+	}
+
+	switch policy.Backoff {
+	case models.BackoffFixed, models.BackoffExponential, models.BackoffJittered, "":
+		return nil
+	default:
+		return fmt.Errorf("unsupported retry backoff: %s", policy.Backoff)
+	}
+}
+
+// shouldRetry reports whether a failed attempt is to be followed by another
+// one under the given policy
+func shouldRetry(policy *models.RetryPolicy, attempt int, err error) bool {
+	if policy == nil || attempt >= policy.MaxAttempts {
+		return false
+	}
+	if errors.Is(err, ErrNonRetryable) {
+		return false
+	}
+	if len(policy.RetryOn) == 0 {
+		return true
+	}
+
+	for _, match := range policy.RetryOn {
+		if strings.Contains(err.Error(), match) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryDelay returns how long to wait after the given failed attempt
+func retryDelay(policy *models.RetryPolicy, attempt int) time.Duration {
+	delay := config.DurationOr(policy.DelayMs, time.Millisecond, defaultRetryDelay)
+	maxDelay := max(config.DurationOr(policy.MaxDelayMs, time.Millisecond, defaultMaxRetryDelay), delay)
+
+	if policy.Backoff == models.BackoffFixed {
+		return delay
+	}
+
+	// Exponential backoff doubles the delay after every attempt
+	delay = backoff.Exponential(delay, maxDelay, attempt)
+
+	// Jittered backoff spreads retries uniformly up to the exponential delay
+	if policy.Backoff == models.BackoffJittered {
+		delay = time.Duration(rand.Int63n(int64(delay) + 1))
+	}
+	return delay
+}
@@ -0,0 +1,273 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go-fred/internal/config"
+	"go-fred/internal/events"
+	"go-fred/internal/models"
+)
+
+func TestNonRetryable(t *testing.T) {
+	err := NonRetryable(fmt.Errorf("division by zero"))
+
+	if !errors.Is(err, ErrNonRetryable) {
+		t.Error("Expected error to match ErrNonRetryable")
+	}
+	if err.Error() != "division by zero" {
+		t.Errorf("Expected message 'division by zero', got '%s'", err.Error())
+	}
+	if NonRetryable(nil) != nil {
+		t.Error("Expected nil for nil error")
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	policy := &models.RetryPolicy{MaxAttempts: 3}
+	filtered := &models.RetryPolicy{MaxAttempts: 3, RetryOn: []string{"connection refused"}}
+
+	tests := []struct {
+		name     string
+		policy   *models.RetryPolicy
+		attempt  int
+		err      error
+		expected bool
+	}{
+		{"no policy", nil, 1, errors.New("boom"), false},
+		{"attempts left", policy, 1, errors.New("boom"), true},
+		{"last attempt", policy, 3, errors.New("boom"), false},
+		{"non-retryable error", policy, 1, NonRetryable(errors.New("boom")), false},
+		{"matching error", filtered, 1, errors.New("dial: connection refused"), true},
+		{"other error", filtered, 1, errors.New("boom"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := shouldRetry(tt.policy, tt.attempt, tt.err); result != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	fixed := &models.RetryPolicy{Backoff: models.BackoffFixed, DelayMs: 100}
+	exponential := &models.RetryPolicy{Backoff: models.BackoffExponential, DelayMs: 100, MaxDelayMs: 500}
+
+	tests := []struct {
+		name     string
+		policy   *models.RetryPolicy
+		attempt  int
+		expected time.Duration
+	}{
+		{"fixed", fixed, 3, 100 * time.Millisecond},
+		{"exponential first attempt", exponential, 1, 100 * time.Millisecond},
+		{"exponential third attempt", exponential, 3, 400 * time.Millisecond},
+		{"exponential capped", exponential, 5, 500 * time.Millisecond},
+		{"default delay", &models.RetryPolicy{}, 1, defaultRetryDelay},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if delay := retryDelay(tt.policy, tt.attempt); delay != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, delay)
+			}
+		})
+	}
+
+	// Jittered delays stay within the exponential delay
+	jittered := &models.RetryPolicy{Backoff: models.BackoffJittered, DelayMs: 100}
+	for i := 0; i < 20; i++ {
+		if delay := retryDelay(jittered, 2); delay < 0 || delay > 200*time.Millisecond {
+			t.Errorf("Expected jittered delay within [0, 200ms], got %v", delay)
+		}
+	}
+}
+
+func TestTaskManagerRetries(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+
+	mockPub := &mockPublisher{}
+	taskManager := NewTaskManager(registry, mockPub, 5)
+
+	task, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{
+		Type:  "error",
+		Input: map[string]interface{}{"message": "boom"},
+		Retry: &models.RetryPolicy{MaxAttempts: 3, Backoff: models.BackoffFixed, DelayMs: 10},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := taskManager.ExecuteTask(context.Background(), task.ID); err == nil {
+		t.Error("Expected error from failing task")
+	}
+
+	failedTask, err := taskManager.GetTask(task.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if failedTask.Status != models.TaskStatusFailed {
+		t.Errorf("Expected status 'failed', got %s", failedTask.Status)
+	}
+	if failedTask.Attempt() != 3 {
+		t.Errorf("Expected 3 attempts, got %d", failedTask.Attempt())
+	}
+	for i, attempt := range failedTask.Attempts {
+		if attempt.Number != i+1 || attempt.Error != "boom" || attempt.CompletedAt == nil {
+			t.Errorf("Unexpected attempt %d: %+v", i+1, attempt)
+		}
+	}
+
+	publishedEvents := mockPub.GetEvents()
+	if count := countEvents(publishedEvents, events.EventTypeTaskRetryScheduled); count != 2 {
+		t.Errorf("Expected 2 task.retry_scheduled events, got %d", count)
+	}
+	if count := countEvents(publishedEvents, events.EventTypeTaskFailed); count != 1 {
+		t.Errorf("Expected 1 task.failed event, got %d", count)
+	}
+}
+
+func TestTaskManagerNonRetryableError(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+
+	cfg := &config.TasksConfig{
+		MaxConcurrent: 5,
+		Retry: map[string]config.RetryConfig{
+			"math": {MaxAttempts: 3, DelayMs: 10},
+		},
+	}
+	taskManager := NewTaskManagerWithConfig(registry, &mockPublisher{}, NewMemoryTaskStore(), cfg)
+
+	task, err := taskManager.CreateTask("math", map[string]interface{}{"operation": "divide", "a": 1.0, "b": 0.0}, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if task.Retry == nil || task.Retry.MaxAttempts != 3 {
+		t.Fatalf("Expected retry policy from config, got %+v", task.Retry)
+	}
+
+	taskManager.ExecuteTask(context.Background(), task.ID)
+
+	failedTask, err := taskManager.GetTask(task.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if failedTask.Status != models.TaskStatusFailed {
+		t.Errorf("Expected status 'failed', got %s", failedTask.Status)
+	}
+	if failedTask.Attempt() != 1 {
+		t.Errorf("Expected 1 attempt, got %d", failedTask.Attempt())
+	}
+}
+
+func TestTaskManagerCancelDuringRetryBackoff(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+
+	mockPub := &mockPublisher{}
+	taskManager := NewTaskManager(registry, mockPub, 5)
+
+	task, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{
+		Type:  "error",
+		Retry: &models.RetryPolicy{MaxAttempts: 3, Backoff: models.BackoffFixed, DelayMs: 5000},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := taskManager.ExecuteTaskAsync(context.Background(), task.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	retryingTask, err := taskManager.GetTask(task.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if retryingTask.Status != models.TaskStatusRetrying {
+		t.Fatalf("Expected status 'retrying', got %s", retryingTask.Status)
+	}
+	if retryingTask.NextAttemptAt == nil {
+		t.Error("Expected NextAttemptAt to be set")
+	}
+
+	if err := taskManager.CancelTask(task.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	cancelledTask, err := taskManager.GetTask(task.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cancelledTask.Status != models.TaskStatusCancelled {
+		t.Errorf("Expected status 'cancelled', got %s", cancelledTask.Status)
+	}
+	if cancelledTask.Attempt() != 1 {
+		t.Errorf("Expected 1 attempt, got %d", cancelledTask.Attempt())
+	}
+	if count := countEvents(mockPub.GetEvents(), events.EventTypeTaskCancelled); count != 1 {
+		t.Errorf("Expected 1 task.cancelled event, got %d", count)
+	}
+}
+
+func TestTaskManagerInvalidRetryPolicy(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+	taskManager := NewTaskManager(registry, &mockPublisher{}, 5)
+
+	_, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{
+		Type:  "echo",
+		Retry: &models.RetryPolicy{MaxAttempts: 3, Backoff: "linear"},
+	})
+	if err == nil {
+		t.Error("Expected error for unsupported backoff")
+	}
+}
+
+func TestTaskManagerCloseDuringRetryBackoff(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+	taskManager := NewTaskManager(registry, &mockPublisher{}, 5)
+
+	task, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{
+		Type:  "error",
+		Retry: &models.RetryPolicy{MaxAttempts: 3, Backoff: models.BackoffFixed, DelayMs: 100},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- taskManager.ExecuteTask(context.Background(), task.ID) }()
+
+	time.Sleep(50 * time.Millisecond)
+	taskManager.Close()
+
+	// The retry cannot be queued any more, which ends the execution
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrPoolClosed) {
+			t.Errorf("Expected ErrPoolClosed, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected ExecuteTask to return once the retry could not be queued")
+	}
+
+	// The task is left for recovery on restart
+	retryingTask, err := taskManager.GetTask(task.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if retryingTask.Status != models.TaskStatusRetrying {
+		t.Errorf("Expected status 'retrying', got %s", retryingTask.Status)
+	}
+}
@@ -0,0 +1,165 @@
+package tasks
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"go-fred/internal/config"
+	"go-fred/internal/events"
+	"go-fred/internal/models"
+)
+
+var (
+	// ErrTaskNotFound is returned when a task does not exist in the store
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrTaskExists is returned when creating a task whose ID is already stored
+	ErrTaskExists = errors.New("task already exists")
+	// ErrVersionConflict is returned when updating a task that was modified concurrently
+	ErrVersionConflict = errors.New("task version conflict")
+)
+
+// TaskStore defines the interface for task persistence.
+//
+// Every stored task carries a version. Update only succeeds when the version
+// of the given task matches the stored one, and bumps it on success, so that
+// concurrent writers cannot silently overwrite each other.
+//
+// Create and Update are given the events describing the change. A store with
+// an event recorder stores them in the transaction storing the change; the
+// others leave them to the task manager, which publishes them in any case.
+type TaskStore interface {
+	Create(task *models.Task, evts ...events.Event) error
+	Get(taskID string) (*models.Task, error)
+	List() ([]*models.Task, error)
+	// Query returns a page of the tasks matching the query, looked up
+	// through the store's indexes
+	Query(query *models.TaskQuery) (*TaskPage, error)
+	Update(task *models.Task, evts ...events.Event) error
+	Delete(taskID string) error
+	Close() error
+}
+
+// NewTaskStore creates a new task store based on configuration
+func NewTaskStore(cfg *config.StoreConfig) (TaskStore, error) {
+	switch cfg.Driver {
+	case "bolt":
+		store, err := NewBoltTaskStore(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	case "memory", "":
+		return NewMemoryTaskStore(), nil
+	default:
+		return nil, fmt.Errorf("unsupported task store: %s", cfg.Driver)
+	}
+}
+
+// MemoryTaskStore keeps tasks in process memory
+type MemoryTaskStore struct {
+	tasks map[string]*models.Task
+	index *memoryIndex
+	mu    sync.RWMutex
+}
+
+// NewMemoryTaskStore creates a new in-memory task store
+func NewMemoryTaskStore() *MemoryTaskStore {
+	return &MemoryTaskStore{
+		tasks: make(map[string]*models.Task),
+		index: newMemoryIndex(),
+	}
+}
+
+// Create stores a new task. The events are left to the task manager.
+func (s *MemoryTaskStore) Create(task *models.Task, evts ...events.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.tasks[task.ID]; exists {
+		return fmt.Errorf("%w: %s", ErrTaskExists, task.ID)
+	}
+
+	task.Version = 1
+	s.tasks[task.ID] = task.Clone()
+	s.index.add(task)
+	return nil
+}
+
+// Get retrieves a task by ID
+func (s *MemoryTaskStore) Get(taskID string) (*models.Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	task, exists := s.tasks[taskID]
+	if !exists {
+		return nil, fmt.Errorf("%w: %s", ErrTaskNotFound, taskID)
+	}
+	return task.Clone(), nil
+}
+
+// List returns all stored tasks
+func (s *MemoryTaskStore) List() ([]*models.Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tasks := make([]*models.Task, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		tasks = append(tasks, task.Clone())
+	}
+	return tasks, nil
+}
+
+// Query returns a page of the tasks matching the query
+func (s *MemoryTaskStore) Query(query *models.TaskQuery) (*TaskPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return runQuery(query, s.index.open, func(taskID string) (*models.Task, error) {
+		task, exists := s.tasks[taskID]
+		if !exists {
+			return nil, fmt.Errorf("%w: %s", ErrTaskNotFound, taskID)
+		}
+		return task.Clone(), nil
+	})
+}
+
+// Update replaces a stored task if its version matches. The events are left
+// to the task manager.
+func (s *MemoryTaskStore) Update(task *models.Task, evts ...events.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, exists := s.tasks[task.ID]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, task.ID)
+	}
+	if stored.Version != task.Version {
+		return fmt.Errorf("%w: %s (expected version %d, got %d)", ErrVersionConflict, task.ID, stored.Version, task.Version)
+	}
+
+	task.Version++
+	s.tasks[task.ID] = task.Clone()
+	s.index.remove(stored)
+	s.index.add(task)
+	return nil
+}
+
+// Delete removes a task by ID
+func (s *MemoryTaskStore) Delete(taskID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, exists := s.tasks[taskID]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, taskID)
+	}
+	delete(s.tasks, taskID)
+	s.index.remove(stored)
+	return nil
+}
+
+// Close does nothing for the in-memory store
+func (s *MemoryTaskStore) Close() error {
+	return nil
+}
@@ -0,0 +1,367 @@
+package tasks
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-fred/internal/config"
+	"go-fred/internal/events"
+	"go-fred/internal/models"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestBoltStore(t *testing.T) *BoltTaskStore {
+	store, err := NewBoltTaskStore(filepath.Join(t.TempDir(), "tasks.db"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestTaskStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) TaskStore{
+		"memory": func(t *testing.T) TaskStore { return NewMemoryTaskStore() },
+		"bolt":   func(t *testing.T) TaskStore { return newTestBoltStore(t) },
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+
+			task := models.NewTask("echo", map[string]interface{}{"message": "hello"}, false)
+			if err := store.Create(task); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if task.Version != 1 {
+				t.Errorf("Expected version 1 after create, got %d", task.Version)
+			}
+
+			// Creating the same task twice fails
+			if err := store.Create(task); !errors.Is(err, ErrTaskExists) {
+				t.Errorf("Expected ErrTaskExists, got %v", err)
+			}
+
+			retrieved, err := store.Get(task.ID)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if retrieved.ID != task.ID || retrieved.Input["message"] != "hello" {
+				t.Errorf("Expected stored task to match, got %+v", retrieved)
+			}
+
+			// Update bumps the version
+			retrieved.Start()
+			if err := store.Update(retrieved); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if retrieved.Version != 2 {
+				t.Errorf("Expected version 2 after update, got %d", retrieved.Version)
+			}
+
+			// Updating a stale copy is rejected
+			task.Cancel()
+			if err := store.Update(task); !errors.Is(err, ErrVersionConflict) {
+				t.Errorf("Expected ErrVersionConflict, got %v", err)
+			}
+
+			current, err := store.Get(task.ID)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if current.Status != models.TaskStatusRunning {
+				t.Errorf("Expected status 'running', got %s", current.Status)
+			}
+
+			tasks, err := store.List()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(tasks) != 1 {
+				t.Errorf("Expected 1 task, got %d", len(tasks))
+			}
+
+			if err := store.Delete(task.ID); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if _, err := store.Get(task.ID); !errors.Is(err, ErrTaskNotFound) {
+				t.Errorf("Expected ErrTaskNotFound, got %v", err)
+			}
+			if err := store.Delete(task.ID); !errors.Is(err, ErrTaskNotFound) {
+				t.Errorf("Expected ErrTaskNotFound, got %v", err)
+			}
+			if err := store.Update(task); !errors.Is(err, ErrTaskNotFound) {
+				t.Errorf("Expected ErrTaskNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMemoryTaskStoreReturnsCopies(t *testing.T) {
+	store := NewMemoryTaskStore()
+
+	task := models.NewTask("echo", map[string]interface{}{}, false)
+	if err := store.Create(task); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Modifying the returned task must not change the stored one
+	retrieved, _ := store.Get(task.ID)
+	retrieved.Start()
+	retrieved.Input["mutated"] = true
+
+	stored, _ := store.Get(task.ID)
+	if stored.Status != models.TaskStatusPending {
+		t.Errorf("Expected status 'pending', got %s", stored.Status)
+	}
+	if _, ok := stored.Input["mutated"]; ok {
+		t.Error("Expected stored input to be unchanged")
+	}
+}
+
+func TestBoltTaskStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tasks.db")
+
+	store, err := NewBoltTaskStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	task := models.NewTask("math", map[string]interface{}{"operation": "add", "a": 1.0, "b": 2.0}, true)
+	if err := store.Create(task); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	task.Start()
+	task.Complete(map[string]interface{}{"result": 3.0})
+	if err := store.Update(task); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	store.Close()
+
+	// Reopen the file and check the task survived
+	store, err = NewBoltTaskStore(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer store.Close()
+
+	restored, err := store.Get(task.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if restored.Status != models.TaskStatusCompleted {
+		t.Errorf("Expected status 'completed', got %s", restored.Status)
+	}
+	if restored.Output["result"] != 3.0 {
+		t.Errorf("Expected result 3, got %v", restored.Output["result"])
+	}
+	if restored.Version != 2 {
+		t.Errorf("Expected version 2, got %d", restored.Version)
+	}
+	if !restored.IsAsync {
+		t.Error("Expected IsAsync true")
+	}
+}
+
+// eventRecorder records the events of task changes, and fails while failing
+// is set
+type eventRecorder struct {
+	events  []events.Event
+	failing bool
+}
+
+func (r *eventRecorder) RecordEvents(tx *bolt.Tx, evts []events.Event) error {
+	if r.failing {
+		return errors.New("outbox unavailable")
+	}
+	r.events = append(r.events, evts...)
+	return nil
+}
+
+func TestBoltTaskStoreRecordsEvents(t *testing.T) {
+	store := newTestBoltStore(t)
+	recorder := &eventRecorder{}
+	store.SetEventRecorder(recorder)
+
+	task := models.NewTask("echo", map[string]interface{}{}, true)
+	created := events.NewEventBuilder(events.EventTypeTaskCreated).WithTaskID(task.ID).Build()
+	if err := store.Create(task, created); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(recorder.events) != 1 || recorder.events[0].ID != created.ID {
+		t.Fatalf("Expected the created event to be recorded, got %+v", recorder.events)
+	}
+
+	// The events of a rejected change are not recorded
+	stale := task.Clone()
+	task.Start()
+	if err := store.Update(task, events.NewEventBuilder(events.EventTypeTaskStarted).Build()); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	stale.Cancel()
+	if err := store.Update(stale, events.NewEventBuilder(events.EventTypeTaskCancelled).Build()); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Expected ErrVersionConflict, got %v", err)
+	}
+	if len(recorder.events) != 2 || recorder.events[1].Type != events.EventTypeTaskStarted {
+		t.Errorf("Expected the created and started events, got %+v", recorder.events)
+	}
+
+	// and the change is not stored when its events cannot be
+	recorder.failing = true
+	task.Complete(nil)
+	if err := store.Update(task, events.NewEventBuilder(events.EventTypeTaskCompleted).Build()); err == nil {
+		t.Fatal("Expected error when the events cannot be recorded")
+	}
+	if task.Version != 2 {
+		t.Errorf("Expected the rejected task to keep version 2, got %d", task.Version)
+	}
+	stored, err := store.Get(task.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stored.Status != models.TaskStatusRunning || stored.Version != 2 {
+		t.Errorf("Expected the running task at version 2, got %s at version %d", stored.Status, stored.Version)
+	}
+}
+
+func TestNewTaskStore(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      *config.StoreConfig
+		expectError bool
+	}{
+		{"memory store", &config.StoreConfig{Driver: "memory"}, false},
+		{"empty driver defaults to memory", &config.StoreConfig{}, false},
+		{"bolt store", &config.StoreConfig{Driver: "bolt", Path: filepath.Join(t.TempDir(), "tasks.db")}, false},
+		{"bolt store without path", &config.StoreConfig{Driver: "bolt"}, true},
+		{"unsupported store", &config.StoreConfig{Driver: "unsupported"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewTaskStore(tt.config)
+
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected error but got none")
+				}
+				if store != nil {
+					t.Errorf("Expected nil store but got %v", store)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			store.Close()
+		})
+	}
+}
+
+func TestTaskManagerRecoverTasks(t *testing.T) {
+	tests := []struct {
+		policy         string
+		expectedStatus models.TaskStatus
+	}{
+		{RecoveryPolicyFail, models.TaskStatusFailed},
+		{RecoveryPolicyRequeue, models.TaskStatusCompleted},
+		{RecoveryPolicyLeave, models.TaskStatusRunning},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			registry := NewExecutorRegistry()
+			RegisterDefaultExecutors(registry)
+
+			// Simulate a task left running by a previous process
+			store := NewMemoryTaskStore()
+			task := models.NewTask("math", map[string]interface{}{"operation": "add", "a": 1.0, "b": 2.0}, true)
+			store.Create(task)
+			task.Start()
+			store.Update(task)
+
+			mockPub := &mockPublisher{}
+			taskManager := NewTaskManagerWithStore(registry, mockPub, store, 5)
+
+			if err := taskManager.RecoverTasks(tt.policy); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			// Re-queued tasks run in the background
+			deadline := time.Now().Add(time.Second)
+			for {
+				recovered, err := taskManager.GetTask(task.ID)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if recovered.Status == tt.expectedStatus {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("Expected status %s, got %s", tt.expectedStatus, recovered.Status)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestTaskManagerRecoverScheduledTasks(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+
+	// Simulate tasks scheduled by a previous process, one of them overdue
+	store := NewMemoryTaskStore()
+	overdue := models.NewTask("echo", map[string]interface{}{}, true)
+	overdue.Schedule(time.Now().Add(-time.Minute))
+	store.Create(overdue)
+	future := models.NewTask("echo", map[string]interface{}{}, true)
+	future.Schedule(time.Now().Add(time.Hour))
+	store.Create(future)
+
+	taskManager := NewTaskManagerWithStore(registry, &mockPublisher{}, store, 5)
+
+	// Scheduled tasks are recovered whatever the policy
+	if err := taskManager.RecoverTasks(RecoveryPolicyLeave); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		recovered, err := taskManager.GetTask(overdue.ID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if recovered.Status == models.TaskStatusCompleted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected overdue task to run, got status %s", recovered.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	recovered, err := taskManager.GetTask(future.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if recovered.Status != models.TaskStatusScheduled {
+		t.Errorf("Expected future task to stay scheduled, got status %s", recovered.Status)
+	}
+	if taskManager.delayed.len() != 1 {
+		t.Errorf("Expected 1 task in the delay queue, got %d", taskManager.delayed.len())
+	}
+}
+
+func TestTaskManagerRecoverTasksInvalidPolicy(t *testing.T) {
+	registry := NewExecutorRegistry()
+	taskManager := NewTaskManager(registry, &mockPublisher{}, 5)
+
+	if err := taskManager.RecoverTasks("invalid"); err == nil {
+		t.Error("Expected error for invalid recovery policy")
+	}
+}
@@ -2,9 +2,13 @@ package tasks
 
 import (
 	"context"
+	"errors"
+	"slices"
 	"testing"
 	"time"
 
+	"go-fred/internal/config"
+	"go-fred/internal/events"
 	"go-fred/internal/models"
 )
 
@@ -48,13 +52,13 @@ func TestTaskManagerCreateTask(t *testing.T) {
 	}
 
 	// Test that event was published
-	events := mockPub.GetEvents()
-	if len(events) != 1 {
-		t.Errorf("Expected 1 event, got %d", len(events))
+	publishedEvents := mockPub.GetEvents()
+	if len(publishedEvents) != 1 {
+		t.Errorf("Expected 1 event, got %d", len(publishedEvents))
 	}
 
-	if events[0].Type != events.EventTypeTaskCreated {
-		t.Errorf("Expected event type %s, got %s", events.EventTypeTaskCreated, events[0].Type)
+	if publishedEvents[0].Type != events.EventTypeTaskCreated {
+		t.Errorf("Expected event type %s, got %s", events.EventTypeTaskCreated, publishedEvents[0].Type)
 	}
 }
 
@@ -185,14 +189,14 @@ func TestTaskManagerExecuteTask(t *testing.T) {
 	}
 
 	// Check that events were published
-	events := mockPub.GetEvents()
-	if len(events) < 3 { // created, started, completed
-		t.Errorf("Expected at least 3 events, got %d", len(events))
+	publishedEvents := mockPub.GetEvents()
+	if len(publishedEvents) < 3 { // created, started, completed
+		t.Errorf("Expected at least 3 events, got %d", len(publishedEvents))
 	}
 
 	// Check event types
 	eventTypes := make(map[string]bool)
-	for _, event := range events {
+	for _, event := range publishedEvents {
 		eventTypes[event.Type] = true
 	}
 
@@ -312,14 +316,14 @@ func TestTaskManagerCancelTask(t *testing.T) {
 	}
 
 	// Check that event was published
-	events := mockPub.GetEvents()
-	if len(events) < 2 { // created, cancelled
-		t.Errorf("Expected at least 2 events, got %d", len(events))
+	publishedEvents := mockPub.GetEvents()
+	if len(publishedEvents) < 2 { // created, cancelled
+		t.Errorf("Expected at least 2 events, got %d", len(publishedEvents))
 	}
 
 	// Check for cancelled event
 	foundCancelled := false
-	for _, event := range events {
+	for _, event := range publishedEvents {
 		if event.Type == events.EventTypeTaskCancelled {
 			foundCancelled = true
 			break
@@ -331,6 +335,406 @@ func TestTaskManagerCancelTask(t *testing.T) {
 	}
 }
 
+// countEvents returns how many published events have the given type
+func countEvents(publishedEvents []events.Event, eventType string) int {
+	count := 0
+	for _, event := range publishedEvents {
+		if event.Type == eventType {
+			count++
+		}
+	}
+	return count
+}
+
+func TestTaskManagerCancelRunningTask(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+
+	mockPub := &mockPublisher{}
+	taskManager := NewTaskManager(registry, mockPub, 5)
+
+	task, err := taskManager.CreateTask("sleep", map[string]interface{}{"duration": 5.0}, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- taskManager.ExecuteTask(context.Background(), task.ID)
+	}()
+
+	// Wait for the task to start
+	time.Sleep(50 * time.Millisecond)
+
+	if err := taskManager.CancelTask(task.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// The executor must be aborted well before the sleep finishes
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrTaskCancelled) {
+			t.Errorf("Expected ErrTaskCancelled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected execution to be aborted")
+	}
+
+	cancelledTask, err := taskManager.GetTask(task.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cancelledTask.Status != models.TaskStatusCancelled {
+		t.Errorf("Expected status 'cancelled', got %s", cancelledTask.Status)
+	}
+
+	publishedEvents := mockPub.GetEvents()
+	if count := countEvents(publishedEvents, events.EventTypeTaskCancelled); count != 1 {
+		t.Errorf("Expected 1 task.cancelled event, got %d", count)
+	}
+	if count := countEvents(publishedEvents, events.EventTypeTaskFailed); count != 0 {
+		t.Errorf("Expected no task.failed event, got %d", count)
+	}
+}
+
+func TestTaskManagerCancelQueuedTask(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+
+	mockPub := &mockPublisher{}
+	taskManager := NewTaskManager(registry, mockPub, 1)
+
+	// Occupy the only execution slot
+	blocker, err := taskManager.CreateTask("sleep", map[string]interface{}{"duration": 5.0}, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := taskManager.ExecuteTaskAsync(context.Background(), blocker.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	task, err := taskManager.CreateTask("echo", map[string]interface{}{}, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := taskManager.ExecuteTaskAsync(context.Background(), task.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Executing a task that is already waiting is rejected
+	if err := taskManager.ExecuteTaskAsync(context.Background(), task.ID); err == nil {
+		t.Error("Expected error for task that is already running")
+	}
+
+	if err := taskManager.CancelTask(task.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := taskManager.CancelTask(blocker.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	cancelledTask, err := taskManager.GetTask(task.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cancelledTask.Status != models.TaskStatusCancelled {
+		t.Errorf("Expected status 'cancelled', got %s", cancelledTask.Status)
+	}
+	if cancelledTask.StartedAt != nil {
+		t.Error("Expected queued task never to start")
+	}
+
+	// Both executions have been released
+	taskManager.mu.Lock()
+	inFlight := len(taskManager.executions)
+	taskManager.mu.Unlock()
+	if inFlight != 0 {
+		t.Errorf("Expected no in-flight executions, got %d", inFlight)
+	}
+}
+
+func TestTaskManagerDelayedTask(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+
+	mockPub := &mockPublisher{}
+	taskManager := NewTaskManager(registry, mockPub, 5)
+
+	runAt := time.Now().Add(100 * time.Millisecond)
+	task, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{
+		Type:  "echo",
+		Input: map[string]interface{}{"message": "later"},
+		RunAt: &runAt,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if task.Status != models.TaskStatusScheduled {
+		t.Errorf("Expected status 'scheduled', got %s", task.Status)
+	}
+	if countEvents(mockPub.GetEvents(), events.EventTypeTaskScheduled) != 1 {
+		t.Error("Expected a task.scheduled event")
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	executedTask, err := taskManager.GetTask(task.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if executedTask.Status != models.TaskStatusCompleted {
+		t.Errorf("Expected status 'completed', got %s", executedTask.Status)
+	}
+	if executedTask.StartedAt == nil || executedTask.StartedAt.Before(runAt) {
+		t.Errorf("Expected task to start after %v, got %v", runAt, executedTask.StartedAt)
+	}
+}
+
+func TestTaskManagerDelayedTaskValidation(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+
+	taskManager := NewTaskManager(registry, &mockPublisher{}, 5)
+
+	runAt := time.Now().Add(time.Minute)
+	if _, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{Type: "echo", RunAt: &runAt, Delay: 60}); err == nil {
+		t.Error("Expected error for run_at combined with delay")
+	}
+	if _, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{Type: "echo", Delay: -1}); err == nil {
+		t.Error("Expected error for negative delay")
+	}
+}
+
+func TestTaskManagerRescheduleTask(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+
+	taskManager := NewTaskManager(registry, &mockPublisher{}, 5)
+
+	task, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{Type: "echo", Delay: 3600})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Bring the task forward
+	runAt := time.Now().Add(50 * time.Millisecond)
+	if _, err := taskManager.RescheduleTask(task.ID, &models.RescheduleRequest{RunAt: &runAt}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := taskManager.RescheduleTask(task.ID, &models.RescheduleRequest{}); err == nil {
+		t.Error("Expected error for reschedule without run time")
+	}
+
+	time.Sleep(250 * time.Millisecond)
+
+	executedTask, err := taskManager.GetTask(task.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if executedTask.Status != models.TaskStatusCompleted {
+		t.Errorf("Expected status 'completed', got %s", executedTask.Status)
+	}
+
+	// Only scheduled tasks can be moved
+	_, err = taskManager.RescheduleTask(task.ID, &models.RescheduleRequest{Delay: 60})
+	if !errors.Is(err, ErrTaskNotScheduled) {
+		t.Errorf("Expected ErrTaskNotScheduled, got %v", err)
+	}
+}
+
+func TestTaskManagerCancelScheduledTask(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+
+	taskManager := NewTaskManager(registry, &mockPublisher{}, 5)
+
+	runAt := time.Now().Add(50 * time.Millisecond)
+	task, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{Type: "echo", RunAt: &runAt})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if err := taskManager.CancelTask(task.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if taskManager.delayed.len() != 0 {
+		t.Error("Expected cancelled task to leave the delay queue")
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	cancelledTask, err := taskManager.GetTask(task.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cancelledTask.Status != models.TaskStatusCancelled {
+		t.Errorf("Expected status 'cancelled', got %s", cancelledTask.Status)
+	}
+	if cancelledTask.StartedAt != nil {
+		t.Error("Expected cancelled task never to start")
+	}
+}
+
+func TestTaskManagerQueueFull(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+
+	cfg := &config.TasksConfig{MaxConcurrent: 1, MaxQueueDepth: 1}
+	taskManager := NewTaskManagerWithConfig(registry, &mockPublisher{}, NewMemoryTaskStore(), cfg)
+	defer taskManager.Close()
+
+	// One task runs, one waits and the third does not fit
+	ids := make([]string, 3)
+	for i := range ids {
+		task, err := taskManager.CreateTask("sleep", map[string]interface{}{"duration": 5.0}, true)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		ids[i] = task.ID
+	}
+
+	if err := taskManager.ExecuteTaskAsync(context.Background(), ids[0]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if err := taskManager.ExecuteTaskAsync(context.Background(), ids[1]); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	err := taskManager.ExecuteTaskAsync(context.Background(), ids[2])
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("Expected ErrQueueFull, got %v", err)
+	}
+
+	// The rejected task can be executed again later
+	rejected, _ := taskManager.GetTask(ids[2])
+	if rejected.Status != models.TaskStatusPending {
+		t.Errorf("Expected rejected task to stay pending, got %s", rejected.Status)
+	}
+	taskManager.mu.Lock()
+	_, registered := taskManager.executions[ids[2]]
+	taskManager.mu.Unlock()
+	if registered {
+		t.Error("Expected rejected task to have no execution")
+	}
+
+	for _, id := range ids[:2] {
+		taskManager.CancelTask(id)
+	}
+}
+
+func TestTaskManagerPriority(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+
+	taskManager := NewTaskManager(registry, &mockPublisher{}, 1)
+	defer taskManager.Close()
+
+	if _, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{Type: "echo", Priority: models.MaxPriority + 1}); err == nil {
+		t.Error("Expected error for priority above the maximum")
+	}
+
+	// Occupy the only worker
+	blocker, _ := taskManager.CreateTask("sleep", map[string]interface{}{"duration": 0.1}, true)
+	taskManager.ExecuteTaskAsync(context.Background(), blocker.ID)
+	time.Sleep(20 * time.Millisecond)
+
+	low, _ := taskManager.CreateTaskFromRequest(&models.TaskRequest{Type: "echo", Async: true})
+	high, _ := taskManager.CreateTaskFromRequest(&models.TaskRequest{Type: "echo", Async: true, Priority: 10})
+	taskManager.ExecuteTaskAsync(context.Background(), low.ID)
+	taskManager.ExecuteTaskAsync(context.Background(), high.ID)
+
+	time.Sleep(300 * time.Millisecond)
+
+	lowTask, _ := taskManager.GetTask(low.ID)
+	highTask, _ := taskManager.GetTask(high.ID)
+	if lowTask.StartedAt == nil || highTask.StartedAt == nil {
+		t.Fatal("Expected both tasks to run")
+	}
+	if !highTask.StartedAt.Before(*lowTask.StartedAt) {
+		t.Error("Expected high priority task to start first")
+	}
+}
+
+func TestTaskManagerExecuteTaskTimeout(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+
+	mockPub := &mockPublisher{}
+	cfg := &config.TasksConfig{MaxConcurrent: 5, TimeoutSeconds: 300, MaxTimeoutSeconds: 600}
+	taskManager := NewTaskManagerWithConfig(registry, mockPub, NewMemoryTaskStore(), cfg)
+
+	task, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{
+		Type:    "sleep",
+		Input:   map[string]interface{}{"duration": 5.0},
+		Timeout: 1,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	start := time.Now()
+	err = taskManager.ExecuteTask(context.Background(), task.ID)
+	if !errors.Is(err, ErrTaskTimedOut) {
+		t.Errorf("Expected ErrTaskTimedOut, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected execution to stop after the timeout, took %v", elapsed)
+	}
+
+	timedOutTask, err := taskManager.GetTask(task.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if timedOutTask.Status != models.TaskStatusTimedOut {
+		t.Errorf("Expected status 'timed_out', got %s", timedOutTask.Status)
+	}
+
+	publishedEvents := mockPub.GetEvents()
+	if count := countEvents(publishedEvents, events.EventTypeTaskTimedOut); count != 1 {
+		t.Errorf("Expected 1 task.timed_out event, got %d", count)
+	}
+	if count := countEvents(publishedEvents, events.EventTypeTaskFailed); count != 0 {
+		t.Errorf("Expected no task.failed event, got %d", count)
+	}
+}
+
+func TestTaskManagerTaskTimeoutResolution(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+
+	cfg := &config.TasksConfig{MaxConcurrent: 5, TimeoutSeconds: 300, MaxTimeoutSeconds: 600}
+	taskManager := NewTaskManagerWithConfig(registry, &mockPublisher{}, NewMemoryTaskStore(), cfg)
+
+	tests := []struct {
+		name     string
+		timeout  int
+		expected int
+	}{
+		{"default timeout", 0, 300},
+		{"per-task timeout", 30, 30},
+		{"capped timeout", 3600, 600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{Type: "echo", Timeout: tt.timeout})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if task.Timeout != tt.expected {
+				t.Errorf("Expected timeout %d, got %d", tt.expected, task.Timeout)
+			}
+		})
+	}
+
+	if _, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{Type: "echo", Timeout: -1}); err == nil {
+		t.Error("Expected error for negative timeout")
+	}
+}
+
 func TestTaskManagerCancelTaskNonExistent(t *testing.T) {
 	registry := NewExecutorRegistry()
 	RegisterDefaultExecutors(registry)
@@ -410,10 +814,105 @@ func TestTaskManagerConcurrentExecution(t *testing.T) {
 		}
 	}
 
-	// Check that execution was limited by semaphore
+	// Check that execution was limited by the worker pool
 	// With max 2 concurrent and 3 tasks, total time should be at least 200ms
 	duration := time.Since(start)
 	if duration < 200*time.Millisecond {
 		t.Errorf("Expected at least 200ms duration due to concurrency limit, got %v", duration)
 	}
 }
+
+func TestTaskManagerLabels(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+
+	mockPub := &mockPublisher{}
+	taskManager := NewTaskManager(registry, mockPub, 5)
+
+	task, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{
+		Type:     "echo",
+		Input:    map[string]interface{}{"message": "hello"},
+		Labels:   map[string]string{"env": "prod", "team": "a"},
+		Metadata: map[string]interface{}{"request_id": "req-1"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if task.Labels["env"] != "prod" || task.Metadata["request_id"] != "req-1" {
+		t.Errorf("Expected labels and metadata on the task, got %v and %v", task.Labels, task.Metadata)
+	}
+
+	if err := taskManager.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Every event about the task carries its labels and type
+	publishedEvents := mockPub.GetEvents()
+	if len(publishedEvents) < 3 { // created, started, completed
+		t.Fatalf("Expected at least 3 events, got %d", len(publishedEvents))
+	}
+	for _, event := range publishedEvents {
+		if event.Labels["env"] != "prod" || event.Labels["team"] != "a" {
+			t.Errorf("Expected %s event to carry the task labels, got %v", event.Type, event.Labels)
+		}
+		if event.Data["task_type"] != "echo" {
+			t.Errorf("Expected %s event to carry the task type, got %v", event.Type, event.Data["task_type"])
+		}
+	}
+
+	_, err = taskManager.CreateTaskFromRequest(&models.TaskRequest{
+		Type:   "echo",
+		Labels: map[string]string{"not a key": "prod"},
+	})
+	if err == nil {
+		t.Error("Expected error for an invalid label key")
+	}
+}
+
+func TestTaskManagerCancelTasks(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+	taskManager := NewTaskManager(registry, &mockPublisher{}, 5)
+
+	create := func(labels map[string]string) *models.Task {
+		task, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{Type: "echo", Labels: labels})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return task
+	}
+	prodA := create(map[string]string{"env": "prod", "team": "a"})
+	prodB := create(map[string]string{"env": "prod", "team": "b"})
+	dev := create(map[string]string{"env": "dev", "team": "a"})
+	finished := create(map[string]string{"env": "prod", "team": "a"})
+	if err := taskManager.ExecuteTask(context.Background(), finished.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	selector, err := models.ParseLabelSelector("env=prod,team in (a,b)")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cancelled, err := taskManager.CancelTasks(selector)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(cancelled) != 2 || !slices.Contains(cancelled, prodA.ID) || !slices.Contains(cancelled, prodB.ID) {
+		t.Errorf("Expected the two unfinished prod tasks to be cancelled, got %v", cancelled)
+	}
+
+	for taskID, expected := range map[string]models.TaskStatus{
+		prodA.ID:    models.TaskStatusCancelled,
+		prodB.ID:    models.TaskStatusCancelled,
+		dev.ID:      models.TaskStatusPending,
+		finished.ID: models.TaskStatusCompleted,
+	} {
+		task, err := taskManager.GetTask(taskID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if task.Status != expected {
+			t.Errorf("Expected task %s to be %s, got %s", taskID, expected, task.Status)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package tasks
+
+import (
+	"context"
+	"sync"
+
+	"go-fred/internal/models"
+)
+
+// finishWaiters wakes the callers waiting for tasks to finish
+type finishWaiters struct {
+	mu      sync.Mutex
+	waiters map[string][]chan struct{}
+}
+
+// newFinishWaiters creates an empty set of waiters
+func newFinishWaiters() *finishWaiters {
+	return &finishWaiters{waiters: make(map[string][]chan struct{})}
+}
+
+// add registers a waiter for the task. The returned channel is closed once
+// the task finishes.
+func (w *finishWaiters) add(taskID string) chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	ch := make(chan struct{})
+	w.waiters[taskID] = append(w.waiters[taskID], ch)
+	return ch
+}
+
+// remove drops a waiter that gave up
+func (w *finishWaiters) remove(taskID string, ch chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	waiters := w.waiters[taskID]
+	for i, waiter := range waiters {
+		if waiter == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(w.waiters, taskID)
+	} else {
+		w.waiters[taskID] = waiters
+	}
+}
+
+// wake releases every waiter of the task
+func (w *finishWaiters) wake(taskID string) {
+	w.mu.Lock()
+	waiters := w.waiters[taskID]
+	delete(w.waiters, taskID)
+	w.mu.Unlock()
+
+	for _, ch := range waiters {
+		close(ch)
+	}
+}
+
+// WaitForTask blocks until the task reaches a finished state and returns
+// it. When the context is done first, the task is returned as it is then,
+// together with the context's error.
+func (tm *TaskManager) WaitForTask(ctx context.Context, taskID string) (*models.Task, error) {
+	// Register before looking at the task, so that a task finishing in
+	// between still wakes us
+	finished := tm.waiters.add(taskID)
+	defer tm.waiters.remove(taskID, finished)
+
+	task, err := tm.GetTask(taskID)
+	if err != nil || task.IsFinished() {
+		return task, err
+	}
+
+	select {
+	case <-finished:
+		return tm.GetTask(taskID)
+	case <-ctx.Done():
+		task, err := tm.GetTask(taskID)
+		if err != nil {
+			return nil, err
+		}
+		return task, ctx.Err()
+	}
+}
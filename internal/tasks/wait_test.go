@@ -0,0 +1,95 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-fred/internal/models"
+)
+
+func TestTaskManagerWaitForTask(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+	taskManager := NewTaskManager(registry, &mockPublisher{}, 5)
+	defer taskManager.Close()
+
+	task, err := taskManager.CreateTask("sleep", map[string]interface{}{"duration": 0.1}, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := taskManager.ExecuteTaskAsync(context.Background(), task.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	finished, err := taskManager.WaitForTask(ctx, task.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if finished.Status != models.TaskStatusCompleted {
+		t.Errorf("Expected status 'completed', got %s", finished.Status)
+	}
+
+	// A finished task is returned right away
+	finished, err = taskManager.WaitForTask(context.Background(), task.ID)
+	if err != nil || finished.Status != models.TaskStatusCompleted {
+		t.Errorf("Expected the completed task, got %v and %v", finished, err)
+	}
+
+	if _, err := taskManager.WaitForTask(context.Background(), "missing"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("Expected ErrTaskNotFound, got %v", err)
+	}
+}
+
+func TestTaskManagerWaitForTaskTimeout(t *testing.T) {
+	registry := NewExecutorRegistry()
+	RegisterDefaultExecutors(registry)
+	taskManager := NewTaskManager(registry, &mockPublisher{}, 5)
+	defer taskManager.Close()
+
+	task, err := taskManager.CreateTask("echo", map[string]interface{}{}, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	current, err := taskManager.WaitForTask(ctx, task.ID)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if current == nil || current.Status != models.TaskStatusPending {
+		t.Errorf("Expected the pending task, got %v", current)
+	}
+
+	// Waiters that gave up are forgotten
+	taskManager.waiters.mu.Lock()
+	waiting := len(taskManager.waiters.waiters)
+	taskManager.waiters.mu.Unlock()
+	if waiting != 0 {
+		t.Errorf("Expected no waiters left, got %d", waiting)
+	}
+
+	// Cancellation wakes waiters too
+	done := make(chan *models.Task)
+	go func() {
+		task, _ := taskManager.WaitForTask(context.Background(), task.ID)
+		done <- task
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := taskManager.CancelTask(task.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	select {
+	case cancelled := <-done:
+		if cancelled.Status != models.TaskStatusCancelled {
+			t.Errorf("Expected status 'cancelled', got %s", cancelled.Status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the waiter to be woken by the cancellation")
+	}
+}
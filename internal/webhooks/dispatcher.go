@@ -0,0 +1,285 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-fred/internal/backoff"
+	"go-fred/internal/config"
+	"go-fred/internal/events"
+	"go-fred/internal/models"
+	"go-fred/internal/tasks"
+)
+
+// Request timeout and retries of the deliveries, unless configured
+const (
+	defaultTimeout     = 10 * time.Second
+	defaultMaxAttempts = 5
+	defaultDelay       = time.Second
+	defaultMaxDelay    = time.Minute
+)
+
+// finishedEvents maps the terminal task statuses to the event sent with them
+var finishedEvents = map[models.TaskStatus]string{
+	models.TaskStatusCompleted: events.EventTypeTaskCompleted,
+	models.TaskStatusFailed:    events.EventTypeTaskFailed,
+	models.TaskStatusCancelled: events.EventTypeTaskCancelled,
+	models.TaskStatusTimedOut:  events.EventTypeTaskTimedOut,
+}
+
+// Dispatcher posts finished tasks to their callback URL and to the matching
+// webhook subscriptions, signed like the requests of the webhook event
+// publisher. Failed deliveries are retried with exponential backoff, and
+// every attempt is recorded in the delivery store.
+type Dispatcher struct {
+	taskManager *tasks.TaskManager
+	store       DeliveryStore
+	config      config.WebhooksConfig
+	client      *http.Client
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+	mu          sync.Mutex
+	closed      bool
+}
+
+// NewDispatcher creates a dispatcher delivering the tasks finished by the
+// task manager
+func NewDispatcher(taskManager *tasks.TaskManager, store DeliveryStore, cfg *config.WebhooksConfig) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		taskManager: taskManager,
+		store:       store,
+		config:      *cfg,
+		client:      &http.Client{Timeout: config.DurationOr(cfg.TimeoutSeconds, time.Second, defaultTimeout)},
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+	taskManager.OnTaskFinished(d.handleTaskFinished)
+	return d
+}
+
+// Start resumes the deliveries left pending by a previous process
+func (d *Dispatcher) Start() {
+	deliveries, err := d.store.ListPending()
+	if err != nil {
+		log.Printf("Failed to list pending webhook deliveries: %v", err)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		task, err := d.taskManager.GetTask(delivery.TaskID)
+		if err != nil {
+			log.Printf("Failed to resume webhook delivery %s: %v", delivery.ID, err)
+			continue
+		}
+		d.dispatch(delivery, task)
+	}
+}
+
+// Deliveries returns the deliveries of a task, oldest first
+func (d *Dispatcher) Deliveries(taskID string) ([]*models.WebhookDelivery, error) {
+	if _, err := d.taskManager.GetTask(taskID); err != nil {
+		return nil, err
+	}
+	return d.store.ListByTask(taskID)
+}
+
+// Close stops the deliveries in progress and waits for them. Deliveries not
+// finished yet stay pending and are resumed by the next Start.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	d.closed = true
+	d.mu.Unlock()
+
+	d.cancel()
+	d.wg.Wait()
+}
+
+// handleTaskFinished creates a delivery for the callback URL of the task and
+// for every subscription matching it
+func (d *Dispatcher) handleTaskFinished(task *models.Task) {
+	event, ok := finishedEvents[task.Status]
+	if !ok {
+		return
+	}
+
+	var deliveries []*models.WebhookDelivery
+	if task.CallbackURL != "" {
+		deliveries = append(deliveries, models.NewWebhookDelivery(task.ID, event, task.CallbackURL, models.DeliverySourceCallback))
+	}
+	for _, subscription := range d.config.Subscriptions {
+		if matchesSubscription(subscription, task) {
+			deliveries = append(deliveries, models.NewWebhookDelivery(task.ID, event, subscription.URL, models.DeliverySourceSubscription))
+		}
+	}
+
+	for _, delivery := range deliveries {
+		if err := d.store.Create(delivery); err != nil {
+			log.Printf("Failed to store webhook delivery for task %s: %v", task.ID, err)
+			continue
+		}
+		d.dispatch(delivery, task)
+	}
+}
+
+// dispatch makes the delivery in the background, unless the dispatcher is
+// closed, in which case it stays pending
+func (d *Dispatcher) dispatch(delivery *models.WebhookDelivery, task *models.Task) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		d.deliver(delivery, task)
+	}()
+}
+
+// deliver attempts the delivery until the receiver accepts it, the attempts
+// run out or the dispatcher is closed
+func (d *Dispatcher) deliver(delivery *models.WebhookDelivery, task *models.Task) {
+	body, err := json.Marshal(models.WebhookPayload{
+		Event:      delivery.Event,
+		DeliveryID: delivery.ID,
+		Task:       task,
+	})
+	if err != nil {
+		log.Printf("Failed to marshal webhook delivery %s: %v", delivery.ID, err)
+		return
+	}
+	secret := d.secret(delivery)
+
+	for {
+		if delivery.NextAttemptAt != nil {
+			timer := time.NewTimer(time.Until(*delivery.NextAttemptAt))
+			select {
+			case <-d.ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+
+		attempt := d.attempt(delivery, body, secret)
+		if d.ctx.Err() != nil {
+			// Interrupted by Close; the attempt is made again on resume
+			return
+		}
+
+		delivery.Attempts = append(delivery.Attempts, attempt)
+		delivery.NextAttemptAt = nil
+		switch {
+		case attempt.Error == "":
+			delivery.Status = models.DeliveryStatusSucceeded
+		case len(delivery.Attempts) >= d.maxAttempts():
+			delivery.Status = models.DeliveryStatusFailed
+		default:
+			nextAttempt := time.Now().Add(d.backoff(len(delivery.Attempts)))
+			delivery.NextAttemptAt = &nextAttempt
+		}
+		if delivery.IsFinished() {
+			now := time.Now()
+			delivery.CompletedAt = &now
+		}
+
+		if err := d.store.Update(delivery); err != nil {
+			log.Printf("Failed to update webhook delivery %s: %v", delivery.ID, err)
+		}
+		if delivery.IsFinished() {
+			return
+		}
+	}
+}
+
+// attempt posts the body once and records the outcome. Any response other
+// than 2xx is a failure.
+func (d *Dispatcher) attempt(delivery *models.WebhookDelivery, body []byte, secret string) models.DeliveryAttempt {
+	start := time.Now()
+	attempt := models.DeliveryAttempt{
+		Number:      len(delivery.Attempts) + 1,
+		AttemptedAt: start,
+	}
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	timestamp := start.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(events.WebhookHeaderEvent, delivery.Event)
+	req.Header.Set(events.WebhookHeaderDelivery, delivery.ID)
+	req.Header.Set(events.WebhookHeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	if secret != "" {
+		req.Header.Set(events.WebhookHeaderSignature, events.SignWebhook(secret, timestamp, body))
+	}
+
+	resp, err := d.client.Do(req)
+	attempt.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, events.MaxWebhookResponseSize))
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("unexpected status %s", resp.Status)
+	}
+	return attempt
+}
+
+// secret returns the secret signing a delivery: that of its subscription, or
+// the global secret
+func (d *Dispatcher) secret(delivery *models.WebhookDelivery) string {
+	if delivery.Source == models.DeliverySourceSubscription {
+		for _, subscription := range d.config.Subscriptions {
+			if subscription.URL == delivery.URL && subscription.Secret != "" {
+				return subscription.Secret
+			}
+		}
+	}
+	return d.config.Secret
+}
+
+// maxAttempts returns the number of attempts before a delivery is given up
+func (d *Dispatcher) maxAttempts() int {
+	if d.config.MaxAttempts > 0 {
+		return d.config.MaxAttempts
+	}
+	return defaultMaxAttempts
+}
+
+// backoff returns the delay before the attempt following the given one,
+// doubling with every attempt up to the maximum delay
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := config.DurationOr(d.config.DelayMs, time.Millisecond, defaultDelay)
+	maxDelay := config.DurationOr(d.config.MaxDelayMs, time.Millisecond, defaultMaxDelay)
+	return backoff.Exponential(delay, maxDelay, attempt)
+}
+
+// matchesSubscription reports whether a finished task is sent to a
+// subscription
+func matchesSubscription(subscription config.WebhookSubscription, task *models.Task) bool {
+	if len(subscription.TaskTypes) > 0 && !slices.Contains(subscription.TaskTypes, task.Type) {
+		return false
+	}
+	if len(subscription.Statuses) > 0 && !slices.Contains(subscription.Statuses, string(task.Status)) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,200 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go-fred/internal/config"
+	"go-fred/internal/events"
+	"go-fred/internal/models"
+	"go-fred/internal/tasks"
+)
+
+// receiver records the requests of an httptest webhook receiver
+type receiver struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	status   int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	status := r.status
+	r.mu.Unlock()
+	w.WriteHeader(status)
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+func newTestDispatcher(t *testing.T, cfg *config.WebhooksConfig) (*tasks.TaskManager, *Dispatcher) {
+	t.Helper()
+	registry := tasks.NewExecutorRegistry()
+	tasks.RegisterDefaultExecutors(registry)
+	taskManager := tasks.NewTaskManager(registry, events.NewNoOpPublisher(), 2)
+	t.Cleanup(taskManager.Close)
+
+	dispatcher := NewDispatcher(taskManager, NewMemoryDeliveryStore(), cfg)
+	t.Cleanup(dispatcher.Close)
+	return taskManager, dispatcher
+}
+
+// waitForDeliveries waits until the deliveries of a task are all finished
+func waitForDeliveries(t *testing.T, dispatcher *Dispatcher, taskID string, n int) []*models.WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, err := dispatcher.Deliveries(taskID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		finished := 0
+		for _, delivery := range deliveries {
+			if delivery.IsFinished() {
+				finished++
+			}
+		}
+		if len(deliveries) == n && finished == n {
+			return deliveries
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d deliveries, got %+v", n, deliveries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDispatcherSubscriptions(t *testing.T) {
+	all := &receiver{status: http.StatusOK}
+	allServer := httptest.NewServer(all)
+	defer allServer.Close()
+	failures := &receiver{status: http.StatusOK}
+	failuresServer := httptest.NewServer(failures)
+	defer failuresServer.Close()
+
+	taskManager, dispatcher := newTestDispatcher(t, &config.WebhooksConfig{
+		Secret: "global",
+		Subscriptions: []config.WebhookSubscription{
+			{URL: allServer.URL},
+			{URL: failuresServer.URL, Secret: "own", TaskTypes: []string{"echo"}, Statuses: []string{"failed"}},
+		},
+	})
+
+	task, err := taskManager.CreateTask("echo", map[string]interface{}{"message": "hello"}, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := taskManager.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	deliveries := waitForDeliveries(t, dispatcher, task.ID, 1)
+	if deliveries[0].URL != allServer.URL || deliveries[0].Status != models.DeliveryStatusSucceeded {
+		t.Errorf("Expected a successful delivery to the catch-all subscription, got %+v", deliveries[0])
+	}
+	if failures.count() != 0 {
+		t.Errorf("Expected no delivery for a completed task to the failures subscription, got %d", failures.count())
+	}
+
+	// Subscriptions without a secret of their own are signed with the global one
+	request := all.requests[0]
+	timestamp, _ := strconv.ParseInt(request.Header.Get(events.WebhookHeaderTimestamp), 10, 64)
+	if got := request.Header.Get(events.WebhookHeaderSignature); got != events.SignWebhook("global", timestamp, all.bodies[0]) {
+		t.Errorf("Expected the body signed with the global secret, got %s", got)
+	}
+	if request.Header.Get(events.WebhookHeaderDelivery) != deliveries[0].ID {
+		t.Errorf("Expected delivery ID %s, got %s", deliveries[0].ID, request.Header.Get(events.WebhookHeaderDelivery))
+	}
+}
+
+func TestDispatcherGivesUp(t *testing.T) {
+	failing := &receiver{status: http.StatusInternalServerError}
+	server := httptest.NewServer(failing)
+	defer server.Close()
+
+	taskManager, dispatcher := newTestDispatcher(t, &config.WebhooksConfig{MaxAttempts: 3, DelayMs: 5})
+
+	task, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{Type: "echo", CallbackURL: server.URL})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := taskManager.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	delivery := waitForDeliveries(t, dispatcher, task.ID, 1)[0]
+	if delivery.Status != models.DeliveryStatusFailed || len(delivery.Attempts) != 3 {
+		t.Fatalf("Expected a failed delivery after 3 attempts, got %+v", delivery)
+	}
+	for i, attempt := range delivery.Attempts {
+		if attempt.Number != i+1 || attempt.StatusCode != http.StatusInternalServerError || attempt.Error == "" {
+			t.Errorf("Unexpected attempt %+v", attempt)
+		}
+	}
+	// Without a secret deliveries are not signed
+	if failing.requests[0].Header.Get(events.WebhookHeaderSignature) != "" {
+		t.Error("Expected no signature without a secret")
+	}
+}
+
+func TestDispatcherResumesPendingDeliveries(t *testing.T) {
+	ok := &receiver{status: http.StatusOK}
+	server := httptest.NewServer(ok)
+	defer server.Close()
+
+	taskManager, dispatcher := newTestDispatcher(t, &config.WebhooksConfig{Secret: "secret"})
+	dispatcher.Close()
+
+	// Tasks finishing after Close keep their deliveries pending
+	task, err := taskManager.CreateTaskFromRequest(&models.TaskRequest{Type: "echo", CallbackURL: server.URL})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := taskManager.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	resumed := NewDispatcher(taskManager, dispatcher.store, &config.WebhooksConfig{Secret: "secret"})
+	defer resumed.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pending, _ := dispatcher.store.ListPending()
+		if len(pending) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the pending delivery")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	resumed.Start()
+
+	delivery := waitForDeliveries(t, resumed, task.ID, 1)[0]
+	if delivery.Status != models.DeliveryStatusSucceeded || ok.count() != 1 {
+		t.Errorf("Expected the pending delivery to be made once, got %+v", delivery)
+	}
+}
+
+func TestDispatcherBackoff(t *testing.T) {
+	dispatcher := &Dispatcher{config: config.WebhooksConfig{DelayMs: 100, MaxDelayMs: 500}}
+
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 500 * time.Millisecond, 500 * time.Millisecond}
+	for i, want := range expected {
+		if got := dispatcher.backoff(i + 1); got != want {
+			t.Errorf("Expected backoff %v after attempt %d, got %v", want, i+1, got)
+		}
+	}
+}
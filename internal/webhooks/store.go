@@ -0,0 +1,96 @@
+package webhooks
+
+import (
+	"errors"
+	"sort"
+
+	"go-fred/internal/models"
+	"go-fred/internal/records"
+	"go-fred/internal/tasks"
+)
+
+var (
+	// ErrDeliveryNotFound is returned when a delivery does not exist in the store
+	ErrDeliveryNotFound = errors.New("delivery not found")
+	// ErrDeliveryExists is returned when creating a delivery whose ID is already stored
+	ErrDeliveryExists = errors.New("delivery already exists")
+)
+
+// deliveryKind stores deliveries by task and ID, so that the deliveries of
+// a task are read with a prefix scan. Updates overwrite the stored delivery.
+var deliveryKind = records.Kind[models.WebhookDelivery]{
+	Name:        "delivery",
+	Bucket:      []byte("webhook_deliveries"),
+	Key:         func(delivery *models.WebhookDelivery) string { return delivery.TaskID + "/" + delivery.ID },
+	Clone:       (*models.WebhookDelivery).Clone,
+	ErrNotFound: ErrDeliveryNotFound,
+	ErrExists:   ErrDeliveryExists,
+}
+
+// DeliveryStore defines the interface for webhook delivery persistence
+type DeliveryStore interface {
+	Create(delivery *models.WebhookDelivery) error
+	Update(delivery *models.WebhookDelivery) error
+	// ListByTask returns the deliveries of a task, oldest first
+	ListByTask(taskID string) ([]*models.WebhookDelivery, error)
+	// ListPending returns the deliveries that are neither succeeded nor
+	// given up
+	ListPending() ([]*models.WebhookDelivery, error)
+}
+
+// NewDeliveryStore creates a delivery store next to the given task store, so
+// that deliveries are durable whenever tasks are
+func NewDeliveryStore(taskStore tasks.TaskStore) (DeliveryStore, error) {
+	store, err := records.NewStore(taskStore, deliveryKind)
+	if err != nil {
+		return nil, err
+	}
+	return &recordDeliveryStore{store}, nil
+}
+
+// NewMemoryDeliveryStore creates a new in-memory delivery store
+func NewMemoryDeliveryStore() DeliveryStore {
+	return &recordDeliveryStore{records.NewMemoryStore(deliveryKind)}
+}
+
+// recordDeliveryStore keeps deliveries in a record store
+type recordDeliveryStore struct {
+	records.Store[models.WebhookDelivery]
+}
+
+// ListByTask returns the deliveries of a task, oldest first
+func (s *recordDeliveryStore) ListByTask(taskID string) ([]*models.WebhookDelivery, error) {
+	deliveries, err := s.ListPrefix(taskID + "/")
+	if err != nil {
+		return nil, err
+	}
+	sortDeliveries(deliveries)
+	return deliveries, nil
+}
+
+// ListPending returns the deliveries still to be made
+func (s *recordDeliveryStore) ListPending() ([]*models.WebhookDelivery, error) {
+	all, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []*models.WebhookDelivery
+	for _, delivery := range all {
+		if !delivery.IsFinished() {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sortDeliveries(deliveries)
+	return deliveries, nil
+}
+
+// sortDeliveries orders deliveries by creation time
+func sortDeliveries(deliveries []*models.WebhookDelivery) {
+	sort.Slice(deliveries, func(i, j int) bool {
+		if deliveries[i].CreatedAt.Equal(deliveries[j].CreatedAt) {
+			return deliveries[i].ID < deliveries[j].ID
+		}
+		return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+	})
+}
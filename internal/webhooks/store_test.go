@@ -0,0 +1,94 @@
+package webhooks
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-fred/internal/models"
+	"go-fred/internal/records"
+	"go-fred/internal/records/recordstest"
+	"go-fred/internal/tasks"
+)
+
+func TestDeliveryRecords(t *testing.T) {
+	newStore := func(taskStore tasks.TaskStore) (records.Store[models.WebhookDelivery], error) {
+		return records.NewStore(taskStore, deliveryKind)
+	}
+	recordstest.TestStores(t, deliveryKind, newStore, recordstest.Records[models.WebhookDelivery]{
+		New: func() *models.WebhookDelivery {
+			return models.NewWebhookDelivery("task-1", "task.completed", "https://a.example.com", models.DeliverySourceCallback)
+		},
+		Modify:   func(delivery *models.WebhookDelivery) { delivery.Status = models.DeliveryStatusSucceeded },
+		Modified: func(delivery *models.WebhookDelivery) bool { return delivery.Status == models.DeliveryStatusSucceeded },
+	})
+}
+
+func TestDeliveryStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) DeliveryStore{
+		"memory": func(t *testing.T) DeliveryStore { return NewMemoryDeliveryStore() },
+		"bolt": func(t *testing.T) DeliveryStore {
+			taskStore, err := tasks.NewBoltTaskStore(filepath.Join(t.TempDir(), "tasks.db"))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			t.Cleanup(func() { taskStore.Close() })
+
+			store, err := NewDeliveryStore(taskStore)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			return store
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+
+			first := models.NewWebhookDelivery("task-1", "task.completed", "https://a.example.com", models.DeliverySourceCallback)
+			second := models.NewWebhookDelivery("task-1", "task.completed", "https://b.example.com", models.DeliverySourceSubscription)
+			second.CreatedAt = first.CreatedAt.Add(time.Millisecond)
+			other := models.NewWebhookDelivery("task-10", "task.failed", "https://a.example.com", models.DeliverySourceCallback)
+			for _, delivery := range []*models.WebhookDelivery{second, first, other} {
+				if err := store.Create(delivery); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+			if err := store.Create(first); !errors.Is(err, ErrDeliveryExists) {
+				t.Errorf("Expected ErrDeliveryExists, got %v", err)
+			}
+
+			first.Status = models.DeliveryStatusSucceeded
+			first.Attempts = append(first.Attempts, models.DeliveryAttempt{Number: 1, StatusCode: 200})
+			if err := store.Update(first); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			deliveries, err := store.ListByTask("task-1")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(deliveries) != 2 || deliveries[0].ID != first.ID || deliveries[1].ID != second.ID {
+				t.Fatalf("Expected the 2 deliveries of task-1 oldest first, got %+v", deliveries)
+			}
+			if deliveries[0].Status != models.DeliveryStatusSucceeded || len(deliveries[0].Attempts) != 1 {
+				t.Errorf("Expected the updated delivery, got %+v", deliveries[0])
+			}
+
+			pending, err := store.ListPending()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(pending) != 2 || pending[0].ID != second.ID && pending[1].ID != second.ID {
+				t.Errorf("Expected 2 pending deliveries, got %+v", pending)
+			}
+
+			missing := models.NewWebhookDelivery("task-2", "task.completed", "https://a.example.com", models.DeliverySourceCallback)
+			if err := store.Update(missing); !errors.Is(err, ErrDeliveryNotFound) {
+				t.Errorf("Expected ErrDeliveryNotFound, got %v", err)
+			}
+		})
+	}
+}
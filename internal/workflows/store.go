@@ -0,0 +1,48 @@
+package workflows
+
+import (
+	"errors"
+
+	"go-fred/internal/models"
+	"go-fred/internal/records"
+	"go-fred/internal/tasks"
+)
+
+var (
+	// ErrWorkflowNotFound is returned when a workflow does not exist in the store
+	ErrWorkflowNotFound = errors.New("workflow not found")
+	// ErrWorkflowExists is returned when creating a workflow whose ID is already stored
+	ErrWorkflowExists = errors.New("workflow already exists")
+	// ErrVersionConflict is returned when updating a workflow that was modified concurrently
+	ErrVersionConflict = errors.New("workflow version conflict")
+)
+
+// workflowKind stores workflows by ID, guarding updates by their version
+var workflowKind = records.Kind[models.Workflow]{
+	Name:               "workflow",
+	Bucket:             []byte("workflows"),
+	Key:                func(workflow *models.Workflow) string { return workflow.ID },
+	Version:            func(workflow *models.Workflow) *int64 { return &workflow.Version },
+	Clone:              (*models.Workflow).Clone,
+	ErrNotFound:        ErrWorkflowNotFound,
+	ErrExists:          ErrWorkflowExists,
+	ErrVersionConflict: ErrVersionConflict,
+}
+
+// WorkflowStore defines the interface for workflow persistence. Like
+// tasks.TaskStore, updates are guarded by the workflow version.
+type WorkflowStore = records.Store[models.Workflow]
+
+// MemoryWorkflowStore keeps workflows in process memory
+type MemoryWorkflowStore = records.MemoryStore[models.Workflow]
+
+// NewWorkflowStore creates a workflow store next to the given task store, so
+// that workflows are durable whenever tasks are
+func NewWorkflowStore(taskStore tasks.TaskStore) (WorkflowStore, error) {
+	return records.NewStore(taskStore, workflowKind)
+}
+
+// NewMemoryWorkflowStore creates a new in-memory workflow store
+func NewMemoryWorkflowStore() *MemoryWorkflowStore {
+	return records.NewMemoryStore(workflowKind)
+}
@@ -0,0 +1,28 @@
+package workflows
+
+import (
+	"testing"
+
+	"go-fred/internal/models"
+	"go-fred/internal/records/recordstest"
+)
+
+func TestWorkflowStores(t *testing.T) {
+	recordstest.TestStores(t, workflowKind, NewWorkflowStore, recordstest.Records[models.Workflow]{
+		New: func() *models.Workflow {
+			return models.NewWorkflow(&models.WorkflowRequest{
+				Name: "pipeline",
+				Nodes: []models.WorkflowNodeRequest{
+					{ID: "a", TaskRequest: models.TaskRequest{Type: "echo"}},
+					{ID: "b", TaskRequest: models.TaskRequest{Type: "echo"}, DependsOn: []models.WorkflowDependency{{Node: "a"}}},
+				},
+			})
+		},
+		Modify: func(workflow *models.Workflow) { workflow.Node("a").Status = models.NodeStatusCompleted },
+		Modified: func(workflow *models.Workflow) bool {
+			dep := workflow.Node("b").DependsOn[0]
+			return workflow.Node("a").Status == models.NodeStatusCompleted &&
+				dep.Node == "a" && dep.Condition == models.DependencyOnSuccess
+		},
+	})
+}
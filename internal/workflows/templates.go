@@ -0,0 +1,277 @@
+package workflows
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// templatePattern matches a {{ ... }} reference inside a string input value
+var templatePattern = regexp.MustCompile(`\{\{\s*([^{}]*?)\s*\}\}`)
+
+// reference points into the output of an upstream node, as in
+// {{ tasks.fetch.output.items[0].url }}. The JSONPath form
+// {{ $.tasks.fetch.output.items[0].url }} is accepted as well.
+type reference struct {
+	raw  string
+	node string
+	// path holds map keys (string) and list indexes (int) into the output
+	path []interface{}
+}
+
+// outputLookup returns the output of the task of a completed upstream node
+type outputLookup func(nodeID string) (map[string]interface{}, error)
+
+// parseReference parses the expression between the braces of a template
+func parseReference(expr string) (*reference, error) {
+	segments, err := parsePath(strings.TrimPrefix(expr, "$."))
+	if err != nil {
+		return nil, fmt.Errorf("invalid reference %q: %w", expr, err)
+	}
+
+	if len(segments) < 3 || segments[0] != "tasks" || segments[2] != "output" {
+		return nil, fmt.Errorf("invalid reference %q: expected tasks.<node>.output", expr)
+	}
+	node, ok := segments[1].(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid reference %q: expected a node id after tasks", expr)
+	}
+
+	return &reference{raw: expr, node: node, path: segments[3:]}, nil
+}
+
+// parsePath splits a path such as output.items[0]['content-type'] into map
+// keys and list indexes
+func parsePath(path string) ([]interface{}, error) {
+	var segments []interface{}
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case '.':
+			i++
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated bracket")
+			}
+			inner := path[i+1 : i+end]
+			i += end + 1
+
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				segments = append(segments, inner[1:len(inner)-1])
+				continue
+			}
+			index, err := strconv.Atoi(inner)
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid index [%s]", inner)
+			}
+			segments = append(segments, index)
+			continue
+		default:
+			end := strings.IndexAny(path[i:], ".[")
+			if end < 0 {
+				end = len(path) - i
+			}
+			segments = append(segments, path[i:i+end])
+			i += end
+			continue
+		}
+
+		// A dot must be followed by a key
+		if i >= len(path) || path[i] == '.' || path[i] == '[' {
+			return nil, fmt.Errorf("empty key")
+		}
+	}
+
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+	return segments, nil
+}
+
+// templateReferences returns every reference found in the input
+func templateReferences(input map[string]interface{}) ([]*reference, error) {
+	var refs []*reference
+	err := walkStrings(input, func(s string) error {
+		for _, match := range templatePattern.FindAllStringSubmatch(s, -1) {
+			ref, err := parseReference(match[1])
+			if err != nil {
+				return err
+			}
+			refs = append(refs, ref)
+		}
+		return nil
+	})
+	return refs, err
+}
+
+// walkStrings calls fn for every string in a decoded JSON value
+func walkStrings(value interface{}, fn func(s string) error) error {
+	switch v := value.(type) {
+	case string:
+		return fn(v)
+	case map[string]interface{}:
+		for _, item := range v {
+			if err := walkStrings(item, fn); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := walkStrings(item, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveInput returns a copy of the input with every template replaced by
+// the referenced upstream output. A string that consists of a single
+// template takes the referenced value as is; templates embedded in a longer
+// string are replaced by their text form.
+func resolveInput(input map[string]interface{}, lookup outputLookup) (map[string]interface{}, error) {
+	if input == nil {
+		return nil, nil
+	}
+	resolved, err := resolveValue(input, lookup)
+	if err != nil {
+		return nil, err
+	}
+	return resolved.(map[string]interface{}), nil
+}
+
+// resolveValue resolves the templates in a decoded JSON value
+func resolveValue(value interface{}, lookup outputLookup) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return resolveString(v, lookup)
+	case map[string]interface{}:
+		resolved := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			value, err := resolveValue(item, lookup)
+			if err != nil {
+				return nil, err
+			}
+			resolved[key] = value
+		}
+		return resolved, nil
+	case []interface{}:
+		resolved := make([]interface{}, len(v))
+		for i, item := range v {
+			value, err := resolveValue(item, lookup)
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = value
+		}
+		return resolved, nil
+	}
+	return value, nil
+}
+
+// resolveString resolves the templates in a string value
+func resolveString(s string, lookup outputLookup) (interface{}, error) {
+	matches := templatePattern.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s, nil
+	}
+
+	// A lone template keeps the type of the referenced value
+	if len(matches) == 1 && matches[0][0] == 0 && matches[0][1] == len(s) {
+		return resolveReference(s[matches[0][2]:matches[0][3]], lookup)
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, match := range matches {
+		value, err := resolveReference(s[match[2]:match[3]], lookup)
+		if err != nil {
+			return nil, err
+		}
+		text, err := templateText(value)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString(s[last:match[0]])
+		b.WriteString(text)
+		last = match[1]
+	}
+	b.WriteString(s[last:])
+	return b.String(), nil
+}
+
+// resolveReference looks up the value a reference points to
+func resolveReference(expr string, lookup outputLookup) (interface{}, error) {
+	ref, err := parseReference(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := lookup(ref.node)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve %s: %w", ref.raw, err)
+	}
+
+	value, err := normalizeOutput(output)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve %s: %w", ref.raw, err)
+	}
+	walked := "tasks." + ref.node + ".output"
+	for _, segment := range ref.path {
+		switch key := segment.(type) {
+		case string:
+			fields, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("cannot resolve %s: %s is not an object", ref.raw, walked)
+			}
+			value, ok = fields[key]
+			if !ok {
+				return nil, fmt.Errorf("cannot resolve %s: %s has no field %q", ref.raw, walked, key)
+			}
+			walked += "." + key
+		case int:
+			items, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("cannot resolve %s: %s is not a list", ref.raw, walked)
+			}
+			if key >= len(items) {
+				return nil, fmt.Errorf("cannot resolve %s: %s has no index %d", ref.raw, walked, key)
+			}
+			value = items[key]
+			walked += fmt.Sprintf("[%d]", key)
+		}
+	}
+	return value, nil
+}
+
+// normalizeOutput returns the JSON form of a task output. Outputs of tasks
+// run in this process may hold Go values such as []string or structs, which
+// the paths of references walk like the decoded JSON a stored task holds.
+func normalizeOutput(output map[string]interface{}) (interface{}, error) {
+	data, err := json.Marshal(output)
+	if err != nil {
+		return nil, fmt.Errorf("output is not JSON: %w", err)
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, fmt.Errorf("output is not JSON: %w", err)
+	}
+	return value, nil
+}
+
+// templateText renders a value embedded in a longer string
+func templateText(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	return fmt.Sprint(value), nil
+}
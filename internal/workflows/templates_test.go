@@ -0,0 +1,168 @@
+package workflows
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		expr     string
+		node     string
+		path     []interface{}
+		expected string
+	}{
+		{expr: "tasks.fetch.output.url", node: "fetch", path: []interface{}{"url"}},
+		{expr: "$.tasks.fetch.output.items[0].url", node: "fetch", path: []interface{}{"items", 0, "url"}},
+		{expr: "tasks.fetch.output['content-type']", node: "fetch", path: []interface{}{"content-type"}},
+		{expr: "tasks.fetch-data.output", node: "fetch-data", path: []interface{}{}},
+		{expr: "tasks.fetch.input.url", expected: "expected tasks.<node>.output"},
+		{expr: "fetch.output.url", expected: "expected tasks.<node>.output"},
+		{expr: "tasks.fetch.output.items[x]", expected: "invalid index"},
+		{expr: "tasks.fetch.output.items[0", expected: "unterminated bracket"},
+		{expr: "tasks.fetch.output..url", expected: "empty key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			ref, err := parseReference(tt.expr)
+			if tt.expected != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expected) {
+					t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if ref.node != tt.node {
+				t.Errorf("Expected node %s, got %s", tt.node, ref.node)
+			}
+			if len(ref.path) != len(tt.path) || (len(tt.path) > 0 && !reflect.DeepEqual(ref.path, tt.path)) {
+				t.Errorf("Expected path %v, got %v", tt.path, ref.path)
+			}
+		})
+	}
+}
+
+func TestResolveInput(t *testing.T) {
+	outputs := map[string]map[string]interface{}{
+		"fetch": {
+			"url":    "https://example.com/data.csv",
+			"size":   float64(42),
+			"items":  []interface{}{map[string]interface{}{"id": "first"}},
+			"header": map[string]interface{}{"format": "csv"},
+		},
+	}
+	lookup := func(nodeID string) (map[string]interface{}, error) {
+		output, exists := outputs[nodeID]
+		if !exists {
+			return nil, fmt.Errorf("node %s did not complete (failed)", nodeID)
+		}
+		return output, nil
+	}
+
+	input := map[string]interface{}{
+		"url":     "{{ tasks.fetch.output.url }}",
+		"size":    "{{tasks.fetch.output.size}}",
+		"first":   "{{ $.tasks.fetch.output.items[0].id }}",
+		"summary": "{{ tasks.fetch.output.size }} bytes from {{ tasks.fetch.output.url }}",
+		"header":  "header: {{ tasks.fetch.output.header }}",
+		"nested":  []interface{}{map[string]interface{}{"url": "{{ tasks.fetch.output.url }}"}},
+		"plain":   "no templates here",
+		"count":   float64(3),
+	}
+
+	resolved, err := resolveInput(input, lookup)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"url":     "https://example.com/data.csv",
+		"size":    float64(42),
+		"first":   "first",
+		"summary": "42 bytes from https://example.com/data.csv",
+		"header":  `header: {"format":"csv"}`,
+		"nested":  []interface{}{map[string]interface{}{"url": "https://example.com/data.csv"}},
+		"plain":   "no templates here",
+		"count":   float64(3),
+	}
+	if !reflect.DeepEqual(resolved, expected) {
+		t.Errorf("Expected %v, got %v", expected, resolved)
+	}
+
+	// The template input is left untouched
+	if input["url"] != "{{ tasks.fetch.output.url }}" {
+		t.Errorf("Expected input to be left untouched, got %v", input["url"])
+	}
+}
+
+func TestResolveInputGoTypedOutput(t *testing.T) {
+	type file struct {
+		Name string `json:"name"`
+		Size int    `json:"size"`
+	}
+	lookup := func(nodeID string) (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"urls":    []string{"https://example.com/a", "https://example.com/b"},
+			"files":   []file{{Name: "a.csv", Size: 42}},
+			"headers": map[string][]string{"content-type": {"text/csv"}},
+		}, nil
+	}
+
+	input := map[string]interface{}{
+		"url":   "{{ tasks.fetch.output.urls[1] }}",
+		"name":  "{{ tasks.fetch.output.files[0].name }}",
+		"size":  "{{ tasks.fetch.output.files[0].size }}",
+		"type":  "{{ tasks.fetch.output.headers['content-type'][0] }}",
+		"files": "{{ tasks.fetch.output.files }}",
+	}
+
+	resolved, err := resolveInput(input, lookup)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"url":   "https://example.com/b",
+		"name":  "a.csv",
+		"size":  float64(42),
+		"type":  "text/csv",
+		"files": []interface{}{map[string]interface{}{"name": "a.csv", "size": float64(42)}},
+	}
+	if !reflect.DeepEqual(resolved, expected) {
+		t.Errorf("Expected %v, got %v", expected, resolved)
+	}
+}
+
+func TestResolveInputErrors(t *testing.T) {
+	lookup := func(nodeID string) (map[string]interface{}, error) {
+		if nodeID != "fetch" {
+			return nil, fmt.Errorf("node %s did not complete (failed)", nodeID)
+		}
+		return map[string]interface{}{"url": "https://example.com", "items": []interface{}{}}, nil
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{"{{ tasks.fetch.output.missing }}", `tasks.fetch.output has no field "missing"`},
+		{"{{ tasks.fetch.output.url.host }}", "tasks.fetch.output.url is not an object"},
+		{"{{ tasks.fetch.output.items[2] }}", "tasks.fetch.output.items has no index 2"},
+		{"{{ tasks.fetch.output.url[0] }}", "tasks.fetch.output.url is not a list"},
+		{"{{ tasks.parse.output.rows }}", "node parse did not complete"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			_, err := resolveInput(map[string]interface{}{"value": "prefix " + tt.template}, lookup)
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
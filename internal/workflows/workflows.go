@@ -0,0 +1,460 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go-fred/internal/events"
+	"go-fred/internal/models"
+	"go-fred/internal/tasks"
+)
+
+// ErrWorkflowFinished is returned when cancelling a workflow that has already finished
+var ErrWorkflowFinished = errors.New("workflow is already finished")
+
+// Manager runs workflows: it starts the task of every node once its
+// dependencies are met and follows the tasks through the task manager
+type Manager struct {
+	taskManager *tasks.TaskManager
+	store       WorkflowStore
+	eventPub    events.Publisher
+	mu          sync.Mutex
+	// taskIndex maps the tasks of running nodes to their workflow
+	taskIndex map[string]string
+}
+
+// NewManager creates a new workflow manager
+func NewManager(taskManager *tasks.TaskManager, store WorkflowStore, eventPub events.Publisher) *Manager {
+	m := &Manager{
+		taskManager: taskManager,
+		store:       store,
+		eventPub:    eventPub,
+		taskIndex:   make(map[string]string),
+	}
+	taskManager.OnTaskFinished(m.handleTaskFinished)
+	return m
+}
+
+// Start picks up the workflows left running by a previous process and
+// catches up with tasks that finished in the meantime
+func (m *Manager) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	workflows, err := m.store.List()
+	if err != nil {
+		log.Printf("Failed to list workflows: %v", err)
+		return
+	}
+
+	for _, workflow := range workflows {
+		if workflow.IsFinished() {
+			continue
+		}
+		for _, node := range workflow.Nodes {
+			if node.TaskID != "" && !node.IsFinished() {
+				m.taskIndex[node.TaskID] = workflow.ID
+			}
+		}
+		if err := m.advance(workflow); err != nil {
+			log.Printf("Failed to advance workflow %s: %v", workflow.ID, err)
+		}
+	}
+}
+
+// CreateWorkflow creates a new workflow and starts the nodes without
+// dependencies
+func (m *Manager) CreateWorkflow(req *models.WorkflowRequest) (*models.Workflow, error) {
+	workflow := models.NewWorkflow(req)
+	if err := m.validate(workflow); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.store.Create(workflow); err != nil {
+		return nil, fmt.Errorf("failed to store workflow: %w", err)
+	}
+
+	events.PublishWorkflowEvent(context.Background(), m.eventPub, events.EventTypeWorkflowCreated, workflow.ID)
+
+	if err := m.advance(workflow); err != nil {
+		return nil, err
+	}
+	return workflow, nil
+}
+
+// GetWorkflow retrieves a workflow by ID
+func (m *Manager) GetWorkflow(workflowID string) (*models.Workflow, error) {
+	return m.store.Get(workflowID)
+}
+
+// ListWorkflows returns all workflows
+func (m *Manager) ListWorkflows() []*models.Workflow {
+	workflows, err := m.store.List()
+	if err != nil {
+		log.Printf("Failed to list workflows: %v", err)
+		return []*models.Workflow{}
+	}
+	return workflows
+}
+
+// CancelWorkflow cancels a running workflow: the tasks of its running nodes
+// are cancelled and nodes that have not started yet never will
+func (m *Manager) CancelWorkflow(workflowID string) (*models.Workflow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	workflow, err := m.store.Get(workflowID)
+	if err != nil {
+		return nil, err
+	}
+	if workflow.IsFinished() {
+		return nil, fmt.Errorf("%w: %s", ErrWorkflowFinished, workflowID)
+	}
+
+	for i := range workflow.Nodes {
+		node := &workflow.Nodes[i]
+		if node.IsFinished() {
+			continue
+		}
+		if node.TaskID == "" {
+			node.Status = models.NodeStatusCancelled
+			continue
+		}
+
+		// The task may have finished just now, in which case the node keeps
+		// its outcome
+		if err := m.taskManager.CancelTask(node.TaskID); err != nil {
+			log.Printf("Failed to cancel task %s of workflow %s: %v", node.TaskID, workflowID, err)
+		}
+		if !m.syncNode(workflow, node) {
+			node.Status = models.NodeStatusCancelled
+		}
+		delete(m.taskIndex, node.TaskID)
+	}
+
+	workflow.Finish(models.WorkflowStatusCancelled)
+	if err := m.store.Update(workflow); err != nil {
+		return nil, fmt.Errorf("failed to update workflow: %w", err)
+	}
+
+	events.PublishWorkflowEvent(context.Background(), m.eventPub, events.EventTypeWorkflowCancelled, workflowID)
+
+	return workflow, nil
+}
+
+// handleTaskFinished advances the workflow of a node whose task finished
+func (m *Manager) handleTaskFinished(task *models.Task) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	workflowID, exists := m.taskIndex[task.ID]
+	if !exists {
+		return
+	}
+	delete(m.taskIndex, task.ID)
+
+	workflow, err := m.store.Get(workflowID)
+	if err != nil {
+		log.Printf("Failed to load workflow %s: %v", workflowID, err)
+		return
+	}
+	if workflow.IsFinished() {
+		return
+	}
+
+	if err := m.advance(workflow); err != nil {
+		log.Printf("Failed to advance workflow %s: %v", workflowID, err)
+	}
+}
+
+// advance brings the nodes of the workflow up to date with their tasks,
+// starts the nodes whose dependencies are met, skips the ones whose
+// dependencies can no longer be met and finishes the workflow once every
+// node has finished. The caller must hold m.mu.
+func (m *Manager) advance(workflow *models.Workflow) error {
+	ctx := context.Background()
+	changed := false
+
+	// Starting or skipping a node may unblock others right away
+	for progressed := true; progressed; {
+		progressed = false
+
+		for i := range workflow.Nodes {
+			node := &workflow.Nodes[i]
+			if node.IsFinished() {
+				continue
+			}
+
+			if node.TaskID != "" {
+				if m.syncNode(workflow, node) {
+					progressed = true
+				}
+				continue
+			}
+
+			ready, blocked := dependenciesMet(workflow, node)
+			switch {
+			case blocked:
+				node.Status = models.NodeStatusSkipped
+				events.PublishWorkflowNodeSkipped(ctx, m.eventPub, workflow.ID, node.ID, "dependency condition not met")
+				progressed = true
+			case ready:
+				m.startNode(workflow, node)
+				progressed = true
+			}
+		}
+
+		if progressed {
+			changed = true
+		}
+	}
+
+	if status, finished := finalStatus(workflow); finished {
+		workflow.Finish(status)
+		changed = true
+
+		eventType := events.EventTypeWorkflowCompleted
+		if status == models.WorkflowStatusFailed {
+			eventType = events.EventTypeWorkflowFailed
+		}
+		events.PublishWorkflowEvent(ctx, m.eventPub, eventType, workflow.ID)
+	}
+
+	if !changed {
+		return nil
+	}
+
+	workflow.UpdatedAt = time.Now()
+	if err := m.store.Update(workflow); err != nil {
+		return fmt.Errorf("failed to update workflow: %w", err)
+	}
+	return nil
+}
+
+// startNode creates and executes the task of a node. A node whose task
+// cannot be created or executed fails.
+func (m *Manager) startNode(workflow *models.Workflow, node *models.WorkflowNode) {
+	ctx := context.Background()
+
+	// Templates in the input are resolved against the upstream outputs now,
+	// and the task keeps the resolved input
+	req := node.TaskRequest
+	input, err := resolveInput(node.Input, m.upstreamOutput(workflow))
+	if err != nil {
+		m.failNode(workflow, node, err)
+		return
+	}
+	req.Input = input
+
+	task, err := m.taskManager.CreateTaskFromRequest(&req)
+	if err != nil {
+		m.failNode(workflow, node, err)
+		return
+	}
+	node.TaskID = task.ID
+	node.Status = models.NodeStatus(task.Status)
+	m.taskIndex[task.ID] = workflow.ID
+
+	// Delayed tasks are started by the task manager when due
+	if task.Status != models.TaskStatusScheduled {
+		if err := m.taskManager.ExecuteTaskAsync(ctx, task.ID); err != nil {
+			delete(m.taskIndex, task.ID)
+			if cancelErr := m.taskManager.CancelTask(task.ID); cancelErr != nil {
+				log.Printf("Failed to cancel task %s of workflow %s: %v", task.ID, workflow.ID, cancelErr)
+			}
+			m.failNode(workflow, node, err)
+			return
+		}
+	}
+
+	events.PublishWorkflowNodeStarted(ctx, m.eventPub, workflow.ID, node.ID, task.ID)
+}
+
+// upstreamOutput looks up the outputs of the workflow's completed nodes
+func (m *Manager) upstreamOutput(workflow *models.Workflow) outputLookup {
+	return func(nodeID string) (map[string]interface{}, error) {
+		node := workflow.Node(nodeID)
+		if node == nil {
+			return nil, fmt.Errorf("unknown node %s", nodeID)
+		}
+		if !node.Succeeded() {
+			return nil, fmt.Errorf("node %s did not complete (%s)", nodeID, node.Status)
+		}
+
+		task, err := m.taskManager.GetTask(node.TaskID)
+		if err != nil {
+			return nil, err
+		}
+		return task.Output, nil
+	}
+}
+
+// failNode marks a node as failed without a finished task behind it
+func (m *Manager) failNode(workflow *models.Workflow, node *models.WorkflowNode, err error) {
+	node.Status = models.NodeStatusFailed
+	node.Error = err.Error()
+	events.PublishWorkflowNodeFinished(context.Background(), m.eventPub, workflow.ID, node.ID, node.TaskID, string(node.Status))
+}
+
+// syncNode takes over the status of the node's task and reports whether the
+// node finished
+func (m *Manager) syncNode(workflow *models.Workflow, node *models.WorkflowNode) bool {
+	task, err := m.taskManager.GetTask(node.TaskID)
+	if err != nil {
+		m.failNode(workflow, node, err)
+		return true
+	}
+
+	node.Status = models.NodeStatus(task.Status)
+	node.Error = task.Error
+	if !node.IsFinished() {
+		return false
+	}
+
+	events.PublishWorkflowNodeFinished(context.Background(), m.eventPub, workflow.ID, node.ID, node.TaskID, string(node.Status))
+	return true
+}
+
+// dependenciesMet reports whether every dependency of the node is met, or
+// whether one of them can no longer be met
+func dependenciesMet(workflow *models.Workflow, node *models.WorkflowNode) (ready, blocked bool) {
+	ready = true
+	for _, dep := range node.DependsOn {
+		upstream := workflow.Node(dep.Node)
+		if !upstream.IsFinished() {
+			ready = false
+			continue
+		}
+
+		switch dep.Condition {
+		case models.DependencyOnSuccess:
+			blocked = blocked || !upstream.Succeeded()
+		case models.DependencyOnFailure:
+			blocked = blocked || !upstream.Failed()
+		}
+	}
+	return ready && !blocked, blocked
+}
+
+// finalStatus returns the status of a workflow whose nodes have all
+// finished: failed if any node failed, completed otherwise
+func finalStatus(workflow *models.Workflow) (models.WorkflowStatus, bool) {
+	status := models.WorkflowStatusCompleted
+	for i := range workflow.Nodes {
+		node := &workflow.Nodes[i]
+		if !node.IsFinished() {
+			return "", false
+		}
+		if node.Failed() {
+			status = models.WorkflowStatusFailed
+		}
+	}
+	return status, true
+}
+
+// validate checks that the nodes of a workflow form a valid DAG
+func (m *Manager) validate(workflow *models.Workflow) error {
+	nodes := make(map[string]*models.WorkflowNode, len(workflow.Nodes))
+	for i := range workflow.Nodes {
+		node := &workflow.Nodes[i]
+		if node.ID == "" {
+			return fmt.Errorf("every node needs an id")
+		}
+		if _, exists := nodes[node.ID]; exists {
+			return fmt.Errorf("duplicate node id: %s", node.ID)
+		}
+		if err := m.taskManager.ValidateTaskType(node.Type); err != nil {
+			return fmt.Errorf("node %s: %w", node.ID, err)
+		}
+		nodes[node.ID] = node
+	}
+
+	// Count incoming edges while checking them
+	inDegree := make(map[string]int, len(nodes))
+	dependents := make(map[string][]string, len(nodes))
+	for _, node := range workflow.Nodes {
+		for _, dep := range node.DependsOn {
+			if _, exists := nodes[dep.Node]; !exists {
+				return fmt.Errorf("node %s depends on unknown node %s", node.ID, dep.Node)
+			}
+			if dep.Node == node.ID {
+				return fmt.Errorf("node %s depends on itself", node.ID)
+			}
+			switch dep.Condition {
+			case models.DependencyOnSuccess, models.DependencyOnFailure, models.DependencyAlways:
+			default:
+				return fmt.Errorf("node %s: unsupported dependency condition: %s", node.ID, dep.Condition)
+			}
+			inDegree[node.ID]++
+			dependents[dep.Node] = append(dependents[dep.Node], node.ID)
+		}
+	}
+
+	// Kahn's algorithm: every node can be ordered unless there is a cycle
+	var ready []string
+	for id := range nodes {
+		if inDegree[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+	ordered := 0
+	for len(ready) > 0 {
+		id := ready[len(ready)-1]
+		ready = ready[:len(ready)-1]
+		ordered++
+		for _, dependent := range dependents[id] {
+			inDegree[dependent]--
+			if inDegree[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	if ordered != len(nodes) {
+		return fmt.Errorf("workflow nodes contain a dependency cycle")
+	}
+
+	// Templates may only reference nodes that finish before the node starts
+	for _, node := range workflow.Nodes {
+		refs, err := templateReferences(node.Input)
+		if err != nil {
+			return fmt.Errorf("node %s: %w", node.ID, err)
+		}
+		if len(refs) == 0 {
+			continue
+		}
+
+		upstream := ancestors(workflow, node.ID)
+		for _, ref := range refs {
+			if !upstream[ref.node] {
+				return fmt.Errorf("node %s: %s does not reference an upstream node", node.ID, ref.raw)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ancestors returns the IDs of every node the given node depends on,
+// directly or transitively
+func ancestors(workflow *models.Workflow, nodeID string) map[string]bool {
+	seen := make(map[string]bool)
+	pending := []string{nodeID}
+	for len(pending) > 0 {
+		node := workflow.Node(pending[len(pending)-1])
+		pending = pending[:len(pending)-1]
+		for _, dep := range node.DependsOn {
+			if !seen[dep.Node] {
+				seen[dep.Node] = true
+				pending = append(pending, dep.Node)
+			}
+		}
+	}
+	return seen
+}
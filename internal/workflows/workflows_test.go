@@ -0,0 +1,422 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-fred/internal/events"
+	"go-fred/internal/models"
+	"go-fred/internal/tasks"
+)
+
+// mockPublisher is a mock event publisher for testing
+type mockPublisher struct {
+	events []events.Event
+	mu     sync.Mutex
+}
+
+func (m *mockPublisher) Publish(ctx context.Context, event events.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, event)
+	return nil
+}
+
+func (m *mockPublisher) Close() error {
+	return nil
+}
+
+// countEvents returns how many published events have the given type
+func (m *mockPublisher) countEvents(eventType string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, event := range m.events {
+		if event.Type == eventType {
+			count++
+		}
+	}
+	return count
+}
+
+func setupTestManager() (*Manager, *tasks.TaskManager, *mockPublisher) {
+	registry := tasks.NewExecutorRegistry()
+	tasks.RegisterDefaultExecutors(registry)
+
+	mockPub := &mockPublisher{}
+	taskManager := tasks.NewTaskManager(registry, mockPub, 5)
+
+	return NewManager(taskManager, NewMemoryWorkflowStore(), mockPub), taskManager, mockPub
+}
+
+// mathNode returns a node adding two numbers, or failing on a division by
+// zero when fail is set
+func mathNode(id string, fail bool, dependsOn ...models.WorkflowDependency) models.WorkflowNodeRequest {
+	input := map[string]interface{}{"operation": "add", "a": 1, "b": 2}
+	if fail {
+		input = map[string]interface{}{"operation": "divide", "a": 1, "b": 0}
+	}
+	return models.WorkflowNodeRequest{
+		ID:          id,
+		TaskRequest: models.TaskRequest{Type: "math", Input: input},
+		DependsOn:   dependsOn,
+	}
+}
+
+// waitForWorkflow waits until the workflow finishes
+func waitForWorkflow(t *testing.T, manager *Manager, workflowID string) *models.Workflow {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		workflow, err := manager.GetWorkflow(workflowID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if workflow.IsFinished() {
+			return workflow
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected workflow %s to finish, got %s", workflowID, workflow.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWorkflowRunsDiamond(t *testing.T) {
+	manager, taskManager, mockPub := setupTestManager()
+
+	workflow, err := manager.CreateWorkflow(&models.WorkflowRequest{
+		Name: "diamond",
+		Nodes: []models.WorkflowNodeRequest{
+			mathNode("a", false),
+			mathNode("b", false, models.WorkflowDependency{Node: "a"}),
+			mathNode("c", false, models.WorkflowDependency{Node: "a"}),
+			mathNode("d", false, models.WorkflowDependency{Node: "b"}, models.WorkflowDependency{Node: "c"}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Only the root node starts right away
+	if workflow.Node("a").TaskID == "" {
+		t.Error("Expected root node to be started")
+	}
+	for _, id := range []string{"b", "c", "d"} {
+		if node := workflow.Node(id); node.Status != models.NodeStatusWaiting {
+			t.Errorf("Expected node %s to be waiting, got %s", id, node.Status)
+		}
+	}
+
+	workflow = waitForWorkflow(t, manager, workflow.ID)
+	if workflow.Status != models.WorkflowStatusCompleted {
+		t.Fatalf("Expected workflow to be completed, got %s", workflow.Status)
+	}
+
+	// Every node ran after its dependencies
+	finished := make(map[string]time.Time)
+	for _, node := range workflow.Nodes {
+		if node.Status != models.NodeStatusCompleted {
+			t.Errorf("Expected node %s to be completed, got %s", node.ID, node.Status)
+		}
+		task, err := taskManager.GetTask(node.TaskID)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		finished[node.ID] = *task.CompletedAt
+	}
+	for _, node := range workflow.Nodes {
+		task, _ := taskManager.GetTask(node.TaskID)
+		for _, dep := range node.DependsOn {
+			if task.StartedAt.Before(finished[dep.Node]) {
+				t.Errorf("Expected node %s to start after %s finished", node.ID, dep.Node)
+			}
+		}
+	}
+
+	if count := mockPub.countEvents(events.EventTypeWorkflowNodeStarted); count != 4 {
+		t.Errorf("Expected 4 node started events, got %d", count)
+	}
+	if count := mockPub.countEvents(events.EventTypeWorkflowCompleted); count != 1 {
+		t.Errorf("Expected 1 workflow completed event, got %d", count)
+	}
+}
+
+func TestWorkflowDependencyConditions(t *testing.T) {
+	manager, _, mockPub := setupTestManager()
+
+	workflow, err := manager.CreateWorkflow(&models.WorkflowRequest{
+		Nodes: []models.WorkflowNodeRequest{
+			mathNode("fetch", true),
+			mathNode("process", false, models.WorkflowDependency{Node: "fetch"}),
+			mathNode("report", false, models.WorkflowDependency{Node: "process"}),
+			mathNode("alert", false, models.WorkflowDependency{Node: "fetch", Condition: models.DependencyOnFailure}),
+			mathNode("cleanup", false, models.WorkflowDependency{Node: "fetch", Condition: models.DependencyAlways}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	workflow = waitForWorkflow(t, manager, workflow.ID)
+	if workflow.Status != models.WorkflowStatusFailed {
+		t.Errorf("Expected workflow to be failed, got %s", workflow.Status)
+	}
+
+	expected := map[string]models.NodeStatus{
+		"fetch":   models.NodeStatusFailed,
+		"process": models.NodeStatusSkipped,
+		"report":  models.NodeStatusSkipped,
+		"alert":   models.NodeStatusCompleted,
+		"cleanup": models.NodeStatusCompleted,
+	}
+	for id, status := range expected {
+		if node := workflow.Node(id); node.Status != status {
+			t.Errorf("Expected node %s to be %s, got %s", id, status, node.Status)
+		}
+	}
+	if workflow.Node("fetch").Error == "" {
+		t.Error("Expected failed node to carry the task error")
+	}
+
+	if count := mockPub.countEvents(events.EventTypeWorkflowNodeSkipped); count != 2 {
+		t.Errorf("Expected 2 node skipped events, got %d", count)
+	}
+	if count := mockPub.countEvents(events.EventTypeWorkflowFailed); count != 1 {
+		t.Errorf("Expected 1 workflow failed event, got %d", count)
+	}
+}
+
+func TestWorkflowPassesOutputsDownstream(t *testing.T) {
+	manager, taskManager, _ := setupTestManager()
+
+	workflow, err := manager.CreateWorkflow(&models.WorkflowRequest{
+		Nodes: []models.WorkflowNodeRequest{
+			mathNode("first", false),
+			{
+				ID: "second",
+				TaskRequest: models.TaskRequest{Type: "math", Input: map[string]interface{}{
+					"operation": "multiply",
+					"a":         "{{ tasks.first.output.result }}",
+					"b":         10,
+				}},
+				DependsOn: []models.WorkflowDependency{{Node: "first"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	workflow = waitForWorkflow(t, manager, workflow.ID)
+	if workflow.Status != models.WorkflowStatusCompleted {
+		t.Fatalf("Expected workflow to be completed, got %s", workflow.Status)
+	}
+
+	// The task keeps the resolved input while the node keeps the template
+	node := workflow.Node("second")
+	task, err := taskManager.GetTask(node.TaskID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if task.Input["a"] != float64(3) {
+		t.Errorf("Expected resolved input 3, got %v", task.Input["a"])
+	}
+	if task.Output["result"] != float64(30) {
+		t.Errorf("Expected result 30, got %v", task.Output["result"])
+	}
+	if node.Input["a"] != "{{ tasks.first.output.result }}" {
+		t.Errorf("Expected node to keep the template, got %v", node.Input["a"])
+	}
+}
+
+func TestWorkflowMissingOutputFailsNode(t *testing.T) {
+	manager, _, _ := setupTestManager()
+
+	workflow, err := manager.CreateWorkflow(&models.WorkflowRequest{
+		Nodes: []models.WorkflowNodeRequest{
+			mathNode("first", false),
+			{
+				ID:          "second",
+				TaskRequest: models.TaskRequest{Type: "echo", Input: map[string]interface{}{"message": "{{ tasks.first.output.url }}"}},
+				DependsOn:   []models.WorkflowDependency{{Node: "first"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	workflow = waitForWorkflow(t, manager, workflow.ID)
+	if workflow.Status != models.WorkflowStatusFailed {
+		t.Errorf("Expected workflow to be failed, got %s", workflow.Status)
+	}
+
+	node := workflow.Node("second")
+	if node.Status != models.NodeStatusFailed || node.TaskID != "" {
+		t.Errorf("Expected node to fail without a task, got %s (task %q)", node.Status, node.TaskID)
+	}
+	if !strings.Contains(node.Error, `tasks.first.output has no field "url"`) {
+		t.Errorf("Expected missing field error, got %q", node.Error)
+	}
+}
+
+func TestCancelWorkflow(t *testing.T) {
+	manager, taskManager, mockPub := setupTestManager()
+
+	workflow, err := manager.CreateWorkflow(&models.WorkflowRequest{
+		Nodes: []models.WorkflowNodeRequest{
+			{ID: "wait", TaskRequest: models.TaskRequest{Type: "sleep", Input: map[string]interface{}{"duration": 5}}},
+			mathNode("after", false, models.WorkflowDependency{Node: "wait", Condition: models.DependencyAlways}),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	cancelled, err := manager.CancelWorkflow(workflow.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if cancelled.Status != models.WorkflowStatusCancelled {
+		t.Errorf("Expected workflow to be cancelled, got %s", cancelled.Status)
+	}
+	for _, node := range cancelled.Nodes {
+		if node.Status != models.NodeStatusCancelled {
+			t.Errorf("Expected node %s to be cancelled, got %s", node.ID, node.Status)
+		}
+	}
+
+	task, err := taskManager.GetTask(cancelled.Node("wait").TaskID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if task.Status != models.TaskStatusCancelled {
+		t.Errorf("Expected node task to be cancelled, got %s", task.Status)
+	}
+
+	// The cancellation of the task does not start the downstream node
+	time.Sleep(50 * time.Millisecond)
+	stored, err := manager.GetWorkflow(workflow.ID)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stored.Node("after").TaskID != "" {
+		t.Error("Expected downstream node not to start")
+	}
+
+	if _, err := manager.CancelWorkflow(workflow.ID); !errors.Is(err, ErrWorkflowFinished) {
+		t.Errorf("Expected ErrWorkflowFinished, got %v", err)
+	}
+	if count := mockPub.countEvents(events.EventTypeWorkflowCancelled); count != 1 {
+		t.Errorf("Expected 1 workflow cancelled event, got %d", count)
+	}
+}
+
+func TestCreateWorkflowValidation(t *testing.T) {
+	manager, _, _ := setupTestManager()
+
+	tests := []struct {
+		name     string
+		nodes    []models.WorkflowNodeRequest
+		expected string
+	}{
+		{
+			name:     "duplicate node",
+			nodes:    []models.WorkflowNodeRequest{mathNode("a", false), mathNode("a", false)},
+			expected: "duplicate node id",
+		},
+		{
+			name:     "unknown dependency",
+			nodes:    []models.WorkflowNodeRequest{mathNode("a", false, models.WorkflowDependency{Node: "missing"})},
+			expected: "unknown node",
+		},
+		{
+			name:     "self dependency",
+			nodes:    []models.WorkflowNodeRequest{mathNode("a", false, models.WorkflowDependency{Node: "a"})},
+			expected: "depends on itself",
+		},
+		{
+			name: "cycle",
+			nodes: []models.WorkflowNodeRequest{
+				mathNode("a", false, models.WorkflowDependency{Node: "b"}),
+				mathNode("b", false, models.WorkflowDependency{Node: "a"}),
+			},
+			expected: "cycle",
+		},
+		{
+			name:     "unsupported condition",
+			nodes:    []models.WorkflowNodeRequest{mathNode("a", false), mathNode("b", false, models.WorkflowDependency{Node: "a", Condition: "sometimes"})},
+			expected: "unsupported dependency condition",
+		},
+		{
+			name:     "invalid template",
+			nodes:    []models.WorkflowNodeRequest{{ID: "a", TaskRequest: models.TaskRequest{Type: "echo", Input: map[string]interface{}{"message": "{{ tasks.a.input }}"}}}},
+			expected: "invalid reference",
+		},
+		{
+			name: "template of a node that is not upstream",
+			nodes: []models.WorkflowNodeRequest{
+				mathNode("a", false),
+				{ID: "b", TaskRequest: models.TaskRequest{Type: "echo", Input: map[string]interface{}{"message": "{{ tasks.a.output.result }}"}}},
+			},
+			expected: "does not reference an upstream node",
+		},
+		{
+			name:     "unknown task type",
+			nodes:    []models.WorkflowNodeRequest{{ID: "a", TaskRequest: models.TaskRequest{Type: "unknown"}}},
+			expected: "node a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := manager.CreateWorkflow(&models.WorkflowRequest{Nodes: tt.nodes})
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("Expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+
+	if workflows := manager.ListWorkflows(); len(workflows) != 0 {
+		t.Errorf("Expected no workflows to be stored, got %d", len(workflows))
+	}
+}
+
+func TestManagerStartResumesWorkflows(t *testing.T) {
+	manager, taskManager, _ := setupTestManager()
+
+	// A workflow whose root task finished while no manager was watching
+	task, err := taskManager.CreateTask("math", map[string]interface{}{"operation": "add", "a": 1, "b": 2}, false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := taskManager.ExecuteTask(context.Background(), task.ID); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	workflow := models.NewWorkflow(&models.WorkflowRequest{
+		Nodes: []models.WorkflowNodeRequest{
+			mathNode("a", false),
+			mathNode("b", false, models.WorkflowDependency{Node: "a"}),
+		},
+	})
+	workflow.Nodes[0].TaskID = task.ID
+	workflow.Nodes[0].Status = models.NodeStatusRunning
+	if err := manager.store.Create(workflow); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	manager.Start()
+
+	workflow = waitForWorkflow(t, manager, workflow.ID)
+	if workflow.Status != models.WorkflowStatusCompleted {
+		t.Errorf("Expected workflow to be completed, got %s", workflow.Status)
+	}
+}